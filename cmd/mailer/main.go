@@ -1,10 +1,14 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"html/template"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/anschwa/giftopotamus/giftex"
 	"github.com/aws/aws-sdk-go/aws"
@@ -12,20 +16,87 @@ import (
 )
 
 func main() {
+	seed := flag.Int64("seed", 0, "draw with this seed so it can be replayed (default: a cryptographically secure draw)")
+	repair := flag.String("repair", "", "update the results in this file (csv, tsv, json, or xlsx) instead of drawing again")
+	historyPath := flag.String("history", "", "read and update the dated history of past gift exchanges in this CSV")
+	year := flag.Int("year", time.Now().Year(), "year of this gift exchange")
+	bothWays := flag.Bool("repeat-both-ways", false, "nobody has the person who had them recently either")
+	extraGifts := flag.Int("extra-gifts", 0, "most extra gifts each person who only receives can get")
+	swap := flag.Bool("swap", false, "pair everybody up to swap gifts with each other")
+	var groupRules []giftex.GroupRule
+	flag.Func("group-rule", `everybody in one group gives to another, e.g., "Team A -> Team B" (may be repeated)`, func(s string) error {
+		rule, err := giftex.ParseGroupRule(s)
+		groupRules = append(groupRules, rule)
+		return err
+	})
+	var rules []giftex.Rule
+	flag.Func("rule", "forbid pairs with a rule, e.g., same-last-name (may be repeated; see giftex.RuleNames)", func(s string) error {
+		rule, err := giftex.ParseRule(s)
+		rules = append(rules, rule)
+		return err
+	})
+	var schema giftex.Schema
+	flag.Func("schema", "file describing the layout of the CSV, e.g., other headers (see giftex.ParseSchema)", func(path string) (err error) {
+		schema, err = giftex.ReadSchemaFromFile(path)
+		return err
+	})
+	flag.Parse()
+
 	// Read CSV and generate gift exchange results
-	db, err := giftex.ReadCSVFromFile("example.csv")
+	db, err := schema.ReadCSVFromFile("example.csv")
 	if err != nil {
 		panic(err)
 	}
 
-	opts := &giftex.GiftExchangeOptions{MaxPrevious: 2}
-	ge, err := giftex.NewGiftExchange(db.Participants, opts)
+	participants := db.Participants
+	opts := &giftex.GiftExchangeOptions{MaxPrevious: 2, Seed: *seed, ExtraGifts: *extraGifts, Swap: *swap, GroupRules: groupRules, Rules: rules}
+	if *historyPath != "" {
+		opts.History = loadHistory(*historyPath, participants, *year)
+		opts.Year = *year
+		opts.RepeatYears = 2
+		opts.RepeatBothWays = *bothWays
+	}
+
+	if *repair != "" {
+		repairDraw(schema, db, opts, *repair, *historyPath)
+		return
+	}
+
+	ge, err := giftex.NewGiftExchange(participants, opts)
+
+	// Offer to relax the constraints until an assignment is possible.
+	// Only the draw is relaxed: the results and history below are
+	// saved from the original records so no past matches are lost.
+	drawPM, drawOpts := participants, opts
+	for errors.Is(err, giftex.ErrNoSolution) {
+		fmt.Println(err)
+
+		relax, ok := chooseRelaxation(drawPM, drawOpts)
+		if !ok {
+			fmt.Println("Aborting")
+			os.Exit(1)
+		}
+
+		drawPM, drawOpts = relax.Apply(drawPM, drawOpts)
+		ge, err = giftex.NewGiftExchange(drawPM, drawOpts)
+	}
+
 	if err != nil {
 		panic(err)
 	}
 
 	// Print results and ask for confirmation before sending mail
 	fmt.Println(ge)
+	if ge.Swap {
+		fmt.Println("Pairs:")
+		for _, pair := range ge.PairNames() {
+			fmt.Println("  " + pair)
+		}
+	}
+	if ge.Seed != 0 {
+		fmt.Println("Seed:", ge.Seed)
+		fmt.Println("Commitment:", ge.Commitment)
+	}
 	if !confirm() {
 		fmt.Println("Aborting")
 		os.Exit(1)
@@ -37,14 +108,18 @@ func main() {
 		panic(err)
 	}
 
-	if err := db.WriteCSV(f, ge.Assignment); err != nil {
+	if err := db.WriteCSV(f, ge); err != nil {
 		panic(err)
 	}
 
+	if *historyPath != "" {
+		saveHistory(*historyPath, opts.History.Add(opts.Year, participants, ge))
+	}
+
 	// Send emails
 	mailer := &fakeMailer{}
 	svc := giftex.NewEmailService(sender, subject, textTmpl, htmlTmpl, textBulkTmpl, htmlBulkTmpl, mailer)
-	failed, err := svc.SendEmails(db.Participants, ge.Assignment)
+	failed, err := svc.SendEmails(participants, ge)
 
 	if err != nil {
 		fmt.Println("Error! Some emails failed to send")
@@ -63,22 +138,44 @@ const (
 
 	textTemplate = `Welcome to the gift exchange!
 
-You have {{.AssignedName}}
-`
+{{if not .Swap}}You have {{.AssignedName}}
+{{- else if eq .GiverName .AssignedName}}You're swapping gifts with {{.AssignedName}}
+{{- else}}You give a gift to {{.AssignedName}} and get one from {{.GiverName}}{{end}}
+{{range .Assigned}}{{if .Attributes}}
+About {{.Name}}:
+{{range $name, $value := .Attributes}}  {{$name}}: {{$value}}
+{{end}}{{end}}{{end}}
+{{- if .Commitment}}
+Draw commitment: {{.Commitment}}
+{{end}}`
 	htmlTemplate = `Welcome to the gift exchange!<br/><br/>
-You have {{.AssignedName}}
-`
+{{if not .Swap}}You have {{.AssignedName}}
+{{- else if eq .GiverName .AssignedName}}You're swapping gifts with {{.AssignedName}}
+{{- else}}You give a gift to {{.AssignedName}} and get one from {{.GiverName}}{{end}}
+{{range .Assigned}}{{if .Attributes}}<br/><br/>About {{.Name}}:
+{{range $name, $value := .Attributes}}<br/>{{$name}}: {{$value}}
+{{end}}{{end}}{{end}}
+{{- if .Commitment}}<br/><br/>Draw commitment: {{.Commitment}}
+{{end}}`
 
 	textBulkTemplate = `Welcome to the gift exchange!
 {{range .Entries}}
-{{.SubjectName}} has {{.AssignedName}}
+{{if not .Swap}}{{.SubjectName}} has {{.AssignedName}}
+{{- else if eq .GiverName .AssignedName}}{{.SubjectName}} swaps gifts with {{.AssignedName}}
+{{- else}}{{.SubjectName}} gives a gift to {{.AssignedName}} and gets one from {{.GiverName}}{{end}}
 {{- end -}}
-`
+{{if .Commitment}}
+
+Draw commitment: {{.Commitment}}
+{{end}}`
 	htmlBulkTemplate = `Welcome to the gift exchange!<br/><br/>
 {{range .Entries}}
-{{.SubjectName}} has {{.AssignedName}}<br/>
+{{if not .Swap}}{{.SubjectName}} has {{.AssignedName}}
+{{- else if eq .GiverName .AssignedName}}{{.SubjectName}} swaps gifts with {{.AssignedName}}
+{{- else}}{{.SubjectName}} gives a gift to {{.AssignedName}} and gets one from {{.GiverName}}{{end}}<br/>
 {{- end -}}
-`
+{{if .Commitment}}<br/>Draw commitment: {{.Commitment}}
+{{end}}`
 )
 
 var (
@@ -89,6 +186,100 @@ var (
 	htmlBulkTmpl = template.Must(template.New("htmlBulk").Parse(htmlBulkTemplate))
 )
 
+// repairDraw updates the results of an earlier draw for whoever joined
+// or dropped out since, and only emails the people whose recipient
+// changed.
+func repairDraw(schema giftex.Schema, db *giftex.GiftExchangeDB, opts *giftex.GiftExchangeOptions, path, historyPath string) {
+	oldDB, err := schema.ReadFile(path)
+	if err != nil {
+		panic(err)
+	}
+
+	has, err := oldDB.Has()
+	if err != nil {
+		panic(err)
+	}
+
+	old := make(giftex.Assignment, len(has))
+	for giver, recipients := range has {
+		if len(recipients) > 0 {
+			old[giver] = recipients[0]
+		}
+	}
+
+	// The repaired draw can't be replayed from a seed
+	opts.Seed = 0
+
+	ge, renotify, err := giftex.RepairGiftExchange(oldDB.Participants, old, db.Participants, opts)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(ge)
+	fmt.Println("Re-notify:")
+	for _, id := range renotify {
+		fmt.Println(" ", db.Participants[id].Name)
+	}
+
+	if !confirm() {
+		fmt.Println("Aborting")
+		os.Exit(1)
+	}
+
+	f, err := os.Create("results.csv")
+	if err != nil {
+		panic(err)
+	}
+
+	if err := db.WriteCSV(f, ge); err != nil {
+		panic(err)
+	}
+
+	if historyPath != "" {
+		saveHistory(historyPath, opts.History.Add(opts.Year, db.Participants, ge))
+	}
+
+	mailer := &fakeMailer{}
+	svc := giftex.NewEmailService(sender, subject, textTmpl, htmlTmpl, textBulkTmpl, htmlBulkTmpl, mailer)
+	if failed, err := svc.SendEmailsTo(db.Participants, ge, renotify); err != nil {
+		fmt.Println("Error! Some emails failed to send")
+		for fail := range failed {
+			fmt.Println(fail)
+		}
+
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// loadHistory reads the history CSV at path. The first time it's used,
+// the history is made from everybody's previous column instead,
+// assuming one entry per year up until last year.
+func loadHistory(path string, pm giftex.ParticipantMap, year int) giftex.History {
+	h, err := giftex.ReadHistoryFromFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return giftex.HistoryFromPrevious(pm, year-1)
+	}
+
+	if err != nil {
+		panic(err)
+	}
+
+	return h
+}
+
+func saveHistory(path string, h giftex.History) {
+	f, err := os.Create(path)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	if err := h.WriteCSV(f); err != nil {
+		panic(err)
+	}
+}
+
 func confirm() bool {
 	var yes string
 	fmt.Print("Continue? (Yes/No) ")
@@ -97,6 +288,31 @@ func confirm() bool {
 	return strings.ToLower(yes) == "yes"
 }
 
+// chooseRelaxation lists the suggested changes that would make the
+// gift exchange possible and asks which one to apply.
+func chooseRelaxation(pm giftex.ParticipantMap, opts *giftex.GiftExchangeOptions) (giftex.Relaxation, bool) {
+	suggestions := giftex.SuggestRelaxations(pm, opts)
+	if len(suggestions) == 0 {
+		return giftex.Relaxation{}, false
+	}
+
+	fmt.Println("\nSuggestions:")
+	for i, s := range suggestions {
+		fmt.Printf("  %d. %s\n", i+1, strings.Join(s.Describe(pm), "; "))
+	}
+
+	var choice string
+	fmt.Printf("Apply a suggestion and retry? (1-%d/No) ", len(suggestions))
+	fmt.Scanln(&choice)
+
+	i, err := strconv.Atoi(choice)
+	if err != nil || i < 1 || i > len(suggestions) {
+		return giftex.Relaxation{}, false
+	}
+
+	return suggestions[i-1], true
+}
+
 type fakeMailer struct{}
 
 func (m *fakeMailer) Send(e giftex.Email) error {
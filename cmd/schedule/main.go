@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/anschwa/giftopotamus/giftex"
+)
+
+func main() {
+	var opts giftex.GiftExchangeOptions
+	years := flag.Int("years", 0, "years to plan, or as many as possible when 0")
+	rounds := flag.Bool("rounds", false, "plan rounds within one event instead of years")
+	firstYear := flag.Int("first-year", time.Now().Year(), "year of the first gift exchange in the schedule")
+	out := flag.String("o", "schedule.csv", "where to write the schedule")
+	historyPath := flag.String("history", "", "dated history of past gift exchanges to avoid repeating")
+	flag.IntVar(&opts.MaxPrevious, "max-previous", 2, "years to wait before repeating a match")
+	flag.IntVar(&opts.RepeatYears, "repeat-years", 2, "years to wait before repeating a match from -history")
+	flag.BoolVar(&opts.RepeatBothWays, "repeat-both-ways", false, "nobody has the person who had them recently either")
+	flag.BoolVar(&opts.SymmetricRestrictions, "symmetric-restrictions", false, "restrictions go both ways")
+	flag.Func("rule", "forbid pairs with a rule, e.g., same-last-name (may be repeated)", func(s string) error {
+		rule, err := giftex.ParseRule(s)
+		opts.Rules = append(opts.Rules, rule)
+		return err
+	})
+	var schema giftex.Schema
+	flag.Func("schema", "file describing the layout of the CSV, e.g., other headers (see giftex.ParseSchema)", func(path string) (err error) {
+		schema, err = giftex.ReadSchemaFromFile(path)
+		return err
+	})
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: ./schedule [options] PARTICIPANTS.csv (or .tsv, .json, .xlsx)")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	db, err := schema.ReadFile(flag.Arg(0))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *historyPath != "" {
+		h, err := giftex.ReadHistoryFromFile(*historyPath)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		opts.History = h
+		opts.Year = *firstYear
+	}
+
+	max, err := giftex.MaxRounds(db.Participants, &opts)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	unit := "years"
+	if *rounds {
+		unit = "rounds"
+	}
+	fmt.Printf("At most %d %s can be planned without repeating a match\n", max, unit)
+
+	n := *years
+	if n == 0 {
+		n = max
+	}
+
+	s, err := giftex.NewSchedule(db.Participants, n, &opts)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// Label every column with its year unless these are rounds
+	var labels []string
+	if !*rounds {
+		for i := 0; i < n; i++ {
+			labels = append(labels, strconv.Itoa(*firstYear+i))
+		}
+	}
+
+	fmt.Println(s)
+
+	f, err := os.Create(*out)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	if err := s.WriteCSV(f, labels); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
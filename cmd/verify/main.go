@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/anschwa/giftopotamus/giftex"
+)
+
+func main() {
+	var opts giftex.GiftExchangeOptions
+	flag.Int64Var(&opts.Seed, "seed", 0, "seed revealed by the organizer")
+	var reveal giftex.Reveal
+	flag.StringVar(&reveal.Secret, "secret", "", "secret revealed by the organizer of a committed draw")
+	flag.StringVar(&reveal.Source, "source", "", "where the entropy of a committed draw came from")
+	flag.StringVar(&reveal.Entropy, "entropy", "", "entropy a committed draw was revealed with")
+	flag.IntVar(&opts.MaxPrevious, "max-previous", 2, "years to wait before repeating a match")
+	flag.IntVar(&opts.GiftsPerPerson, "gifts-per-person", 1, "gifts everyone gives and receives")
+	flag.BoolVar(&opts.SingleCycle, "single-cycle", false, "everyone forms one big circle")
+	flag.BoolVar(&opts.NoMutualPairs, "no-mutual-pairs", false, "nobody gives to the person giving to them")
+	flag.IntVar(&opts.MinCycleLength, "min-cycle-length", 0, "fewest people in each loop of givers")
+	flag.BoolVar(&opts.SymmetricRestrictions, "symmetric-restrictions", false, "restrictions go both ways")
+	flag.BoolVar(&opts.Swap, "swap", false, "everybody was paired up to swap gifts")
+	flag.IntVar(&opts.ExtraGifts, "extra-gifts", 0, "most extra gifts each person who only receives can get")
+	flag.Func("group-rule", `everybody in one group gave to another, e.g., "Team A -> Team B" (may be repeated)`, func(s string) error {
+		rule, err := giftex.ParseGroupRule(s)
+		opts.GroupRules = append(opts.GroupRules, rule)
+		return err
+	})
+	flag.Func("rule", "a rule used by the draw, e.g., same-last-name (may be repeated)", func(s string) error {
+		rule, err := giftex.ParseRule(s)
+		opts.Rules = append(opts.Rules, rule)
+		return err
+	})
+	historyPath := flag.String("history", "", "dated history of past gift exchanges")
+	flag.IntVar(&opts.Year, "year", 0, "year of the gift exchange when using -history")
+	flag.IntVar(&opts.RepeatYears, "repeat-years", 2, "years to wait before repeating a match from -history")
+	flag.BoolVar(&opts.RepeatBothWays, "repeat-both-ways", false, "nobody has the person who had them recently either")
+	var schema giftex.Schema
+	flag.Func("schema", "file describing the layout of the CSV, e.g., other headers (see giftex.ParseSchema)", func(path string) (err error) {
+		schema, err = giftex.ReadSchemaFromFile(path)
+		return err
+	})
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: ./verify -seed SEED [options] RESULTS.csv")
+		fmt.Fprintln(flag.CommandLine.Output(), "   or: ./verify -secret SECRET -source SOURCE -entropy ENTROPY [options] RESULTS.csv")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if reveal.Secret != "" {
+		opts.Reveal = &reveal
+	}
+
+	if flag.NArg() != 1 || (opts.Seed == 0 && opts.Reveal == nil) || (*historyPath != "" && opts.Year == 0) {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if *historyPath != "" {
+		h, err := giftex.ReadHistoryFromFile(*historyPath)
+		if err != nil {
+			fmt.Println("Unable to read history:", err)
+			os.Exit(1)
+		}
+
+		opts.History = h
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		fmt.Println("Unable to open results:", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	if err := schema.VerifyCSV(f, &opts); err != nil {
+		fmt.Println("Verification failed:", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("OK: the results follow from the commitment and seed")
+}
@@ -0,0 +1,80 @@
+package giftex
+
+import (
+	"sort"
+)
+
+// IsAttributeColumn reports whether ReadCSV keeps the column called
+// header as an attribute, e.g., "Shirt Size", rather than reading it
+// itself.
+func IsAttributeColumn(header string) bool {
+	return Schema{}.IsAttribute(header)
+}
+
+// IsAttribute reports whether a CSV read with s keeps the column
+// called header as an attribute.
+func (s Schema) IsAttribute(header string) bool {
+	_, ok := s.Column(header)
+	return trim(header) != "" && !ok
+}
+
+// Attribute returns the value of p's attribute called name, ignoring
+// case, or "" if p doesn't have it.
+func (p Participant) Attribute(name string) string {
+	if v, ok := p.Attributes[name]; ok {
+		return v
+	}
+
+	name = trimLower(name)
+	for k, v := range p.Attributes {
+		if trimLower(k) == name {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// AttributeKey returns a key function for SameValue that reads the
+// attribute called name.
+func AttributeKey(name string) func(Participant) string {
+	return func(p Participant) string {
+		return p.Attribute(name)
+	}
+}
+
+// AttributeNames lists every attribute anybody in pm has, sorted and
+// ignoring case. Each is named the way it was first written.
+func AttributeNames(pm ParticipantMap) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, id := range drawOrder(pm) {
+		for k := range pm[id].Attributes {
+			if key := trimLower(k); !seen[key] {
+				seen[key] = true
+				names = append(names, trim(k))
+			}
+		}
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		return trimLower(names[i]) < trimLower(names[j])
+	})
+
+	return names
+}
+
+// commitAttributes copies p's attributes for the commitment, ignoring
+// the case of their names.
+func commitAttributes(p Participant) map[string]string {
+	if len(p.Attributes) == 0 {
+		return nil
+	}
+
+	attrs := make(map[string]string, len(p.Attributes))
+	for k, v := range p.Attributes {
+		attrs[trimLower(k)] = trim(v)
+	}
+
+	return attrs
+}
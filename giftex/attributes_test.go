@@ -0,0 +1,137 @@
+package giftex
+
+import (
+	"fmt"
+	"html/template"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func Example_attributes() {
+	db, err := ReadCSVFromFile("testdata/attributes.csv")
+	if err != nil {
+		panic(err)
+	}
+
+	// Shipping is expensive, so everybody gives to somebody in their
+	// own city
+	rule, err := ParseRule("different:city")
+	if err != nil {
+		panic(err)
+	}
+
+	ge, err := NewGiftExchange(db.Participants, &GiftExchangeOptions{Rules: []Rule{rule}})
+	if err != nil {
+		panic(err)
+	}
+
+	for _, id := range drawOrder(db.Participants) {
+		recipient := db.Participants[ge.Assignment[id]]
+		fmt.Printf("%s -> %s (%s)\n", db.Participants[id].Name, recipient.Name, recipient.Attribute("shirt size"))
+	}
+
+	// Output:
+	// Ann -> Bob (L)
+	// Bob -> Ann (M)
+	// Cat -> Dan (XL)
+	// Dan -> Cat (S)
+}
+
+func TestReadCSV_attributes(t *testing.T) {
+	db, err := ReadCSVFromFile("testdata/attributes.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, id := range drawOrder(db.Participants) {
+		p := db.Participants[id]
+		got = append(got, fmt.Sprintf("%s:%v", p.Name, p.Attributes))
+	}
+
+	want := "[Ann:map[City:Boston Shirt Size:M] Bob:map[Allergies:peanuts City:Boston Shirt Size:L] Cat:map[City:Denver Shirt Size:S] Dan:map[City:Denver Shirt Size:XL]]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("want: %s; got: %v", want, got)
+	}
+
+	if names := AttributeNames(db.Participants); fmt.Sprint(names) != "[Allergies City Shirt Size]" {
+		t.Errorf("wrong attribute names: %v", names)
+	}
+
+	for header, want := range map[string]bool{
+		"Shirt Size":      true,
+		"budget":          true,
+		" Name ":          false,
+		"department":      false,
+		"always gives to": false,
+		"commitment":      false,
+		"":                false,
+	} {
+		if got := IsAttributeColumn(header); want != got {
+			t.Errorf("%q: want: %v; got: %v", header, want, got)
+		}
+	}
+}
+
+func TestCommit_attributes(t *testing.T) {
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo", Attributes: map[string]string{"City": "Boston"}},
+		1: {ID: 1, Name: "bar"},
+		2: {ID: 2, Name: "baz"},
+	}
+
+	without := ParticipantMap{0: {ID: 0, Name: "foo"}, 1: pm[1], 2: pm[2]}
+
+	// Attributes don't matter to a draw without rules, so old
+	// commitments still verify
+	if Commit(pm, &GiftExchangeOptions{Seed: 1}) != Commit(without, &GiftExchangeOptions{Seed: 1}) {
+		t.Error("attributes changed the commitment without any rules")
+	}
+
+	rule, err := ParseRule("same:city=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &GiftExchangeOptions{Seed: 1, Rules: []Rule{rule}}
+	if Commit(pm, opts) == Commit(without, opts) {
+		t.Error("attributes didn't change the commitment with rules")
+	}
+}
+
+type testMailer struct {
+	sent []Email
+}
+
+func (m *testMailer) Send(e Email) error {
+	m.sent = append(m.sent, e)
+	return nil
+}
+
+func TestSendEmails_attributes(t *testing.T) {
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo", Email: "foo@example.com", Attributes: map[string]string{"Shirt Size": "M"}},
+		1: {ID: 1, Name: "bar", Email: "bar@example.com", Attributes: map[string]string{"Shirt Size": "L", "Allergies": "peanuts"}},
+	}
+
+	tmpl := template.Must(template.New("").Parse(
+		`{{.SubjectName}} has {{.AssignedName}}:{{range .Assigned}}{{range $k, $v := .Attributes}} {{$k}}={{$v}}{{end}}{{end}}`))
+
+	m := &testMailer{}
+	svc := NewEmailService("giftex", "Gift Exchange", tmpl, tmpl, tmpl, tmpl, m)
+	if _, err := svc.SendEmails(pm, Assignment{0: 1, 1: 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, e := range m.sent {
+		got = append(got, e.Text)
+	}
+
+	sort.Strings(got)
+	want := "bar has foo: Shirt Size=M|foo has bar: Allergies=peanuts Shirt Size=L"
+	if s := strings.Join(got, "|"); s != want {
+		t.Errorf("want: %s; got: %s", want, s)
+	}
+}
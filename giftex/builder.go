@@ -0,0 +1,325 @@
+package giftex
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrEmptyID       = errors.New("Error: participants need an ID")
+	ErrEmptyName     = errors.New("Error: participants need a name")
+	ErrDuplicateID   = errors.New("Error: a participant with this ID was already added")
+	ErrDuplicateName = errors.New("Error: a participant with this name was already added")
+	ErrUnknownID     = errors.New("Error: no participant has this ID")
+)
+
+// IDError is an error caused by the participant with external ID, so
+// callers can tell which of their records needs fixing. Use
+// errors.Is to check what went wrong, e.g., ErrUnknownID.
+type IDError struct {
+	ID  string
+	Err error
+}
+
+func (e *IDError) Error() string {
+	return fmt.Sprintf("%v: %q", e.Err, e.ID)
+}
+
+func (e *IDError) Unwrap() error {
+	return e.Err
+}
+
+// Builder puts together a gift exchange one step at a time, for
+// programs that keep their participants somewhere other than a CSV.
+// Everybody is identified by a stable external ID, such as a user ID
+// from another service, and Draw and Verify take care of numbering
+// them, so the same people can be drawn, stored, and verified later
+// without ever handling a Pid.
+//
+// A Builder isn't safe for concurrent use.
+type Builder struct {
+	// Options are used by Draw, Commit, and Verify. History is
+	// usually set with AddHistory instead.
+	Options GiftExchangeOptions
+
+	entries []*builderEntry
+	byID    map[string]*builderEntry
+	byName  map[string]*builderEntry
+}
+
+// builderEntry is a participant along with everybody they exclude,
+// are pinned to, and so on, by external ID. pid is their place in
+// entries.
+type builderEntry struct {
+	id  string
+	pid Pid
+	p   Participant
+
+	restrictions, previous, wishes, pinned, allowed []string
+}
+
+func NewBuilder() *Builder {
+	return &Builder{
+		byID:   make(map[string]*builderEntry),
+		byName: make(map[string]*builderEntry),
+	}
+}
+
+// Add adds participant p with external ID id. Names have to be unique
+// too since they identify people in History and commitments. The
+// fields of p that list other participants, such as Restrictions, are
+// filled in by Exclude, Pin, Allow, Wish, and AddPrevious instead.
+func (b *Builder) Add(id string, p Participant) error {
+	name := trimLower(p.Name)
+	switch {
+	case trim(id) == "":
+		return ErrEmptyID
+	case name == "":
+		return &IDError{ID: id, Err: ErrEmptyName}
+	case b.byID[id] != nil:
+		return &IDError{ID: id, Err: ErrDuplicateID}
+	case b.byName[name] != nil:
+		return &IDError{ID: id, Err: ErrDuplicateName}
+	}
+
+	p.Restrictions, p.Previous, p.Wishes, p.Pinned, p.Allowed = nil, nil, nil, nil, nil
+	e := &builderEntry{id: id, pid: Pid(len(b.entries)), p: p}
+	b.entries = append(b.entries, e)
+	b.byID[id] = e
+	b.byName[name] = e
+	return nil
+}
+
+// Remove takes participant id out of the gift exchange, along with
+// every mention of them by other participants.
+func (b *Builder) Remove(id string) error {
+	e, err := b.entry(id)
+	if err != nil {
+		return err
+	}
+
+	// Everybody after id moves up one
+	b.entries = append(b.entries[:e.pid], b.entries[e.pid+1:]...)
+	for _, x := range b.entries[e.pid:] {
+		x.pid--
+	}
+
+	delete(b.byID, id)
+	delete(b.byName, trimLower(e.p.Name))
+
+	without := func(ids []string) []string {
+		kept := ids[:0]
+		for _, x := range ids {
+			if x != id {
+				kept = append(kept, x)
+			}
+		}
+
+		return kept
+	}
+
+	for _, x := range b.entries {
+		x.restrictions = without(x.restrictions)
+		x.previous = without(x.previous)
+		x.wishes = without(x.wishes)
+		x.pinned = without(x.pinned)
+		x.allowed = without(x.allowed)
+	}
+
+	return nil
+}
+
+// Exclude keeps giver from giving to any of recipients.
+func (b *Builder) Exclude(giver string, recipients ...string) error {
+	return b.addIDs(giver, recipients, func(e *builderEntry) *[]string { return &e.restrictions })
+}
+
+// Pin makes giver always give to recipients. See Participant.Pinned.
+func (b *Builder) Pin(giver string, recipients ...string) error {
+	return b.addIDs(giver, recipients, func(e *builderEntry) *[]string { return &e.pinned })
+}
+
+// Allow limits giver to giving to one of recipients. Calling it again
+// adds to the list. See Participant.Allowed.
+func (b *Builder) Allow(giver string, recipients ...string) error {
+	return b.addIDs(giver, recipients, func(e *builderEntry) *[]string { return &e.allowed })
+}
+
+// Wish adds recipients to giver's wish list. See CostOptions.
+func (b *Builder) Wish(giver string, recipients ...string) error {
+	return b.addIDs(giver, recipients, func(e *builderEntry) *[]string { return &e.wishes })
+}
+
+// AddPrevious records who giver had in past gift exchanges, oldest
+// first, just like Participant.Previous.
+func (b *Builder) AddPrevious(giver string, recipients ...string) error {
+	return b.addIDs(giver, recipients, func(e *builderEntry) *[]string { return &e.previous })
+}
+
+// AddHistory records that giver gave to recipient in year. Once there
+// is any History, it replaces everybody's previous list.
+func (b *Builder) AddHistory(year int, giver, recipient string) error {
+	g, err := b.entry(giver)
+	if err != nil {
+		return err
+	}
+
+	r, err := b.entry(recipient)
+	if err != nil {
+		return err
+	}
+
+	b.Options.History = append(b.Options.History, HistoryRecord{Year: year, Giver: g.p.Name, Recipient: r.p.Name})
+	return nil
+}
+
+// addIDs appends recipients to the list of giver picked by list.
+// Nothing is added unless every ID is known.
+func (b *Builder) addIDs(giver string, recipients []string, list func(e *builderEntry) *[]string) error {
+	e, err := b.entry(giver)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range recipients {
+		if _, err := b.entry(id); err != nil {
+			return err
+		}
+	}
+
+	ids := list(e)
+	for _, id := range recipients {
+		if !containsString(*ids, id) {
+			*ids = append(*ids, id)
+		}
+	}
+
+	return nil
+}
+
+func (b *Builder) entry(id string) (*builderEntry, error) {
+	e, ok := b.byID[id]
+	if !ok {
+		return nil, &IDError{ID: id, Err: ErrUnknownID}
+	}
+
+	return e, nil
+}
+
+// Participants returns everybody added so far, numbered in the order
+// they were added. Use ID and Pid to convert between the two.
+func (b *Builder) Participants() ParticipantMap {
+	toPids := func(ids []string) []Pid {
+		if len(ids) == 0 {
+			return nil
+		}
+
+		list := make([]Pid, len(ids))
+		for i, id := range ids {
+			list[i] = b.byID[id].pid
+		}
+
+		return list
+	}
+
+	pm := make(ParticipantMap, len(b.entries))
+	for _, e := range b.entries {
+		p := e.p
+		p.ID = e.pid
+		p.Restrictions = toPids(e.restrictions)
+		p.Previous = toPids(e.previous)
+		p.Wishes = toPids(e.wishes)
+		p.Pinned = toPids(e.pinned)
+		p.Allowed = toPids(e.allowed)
+		pm[p.ID] = p
+	}
+
+	return pm
+}
+
+// ID returns the external ID of participant pid in Participants.
+func (b *Builder) ID(pid Pid) (string, bool) {
+	if pid < 0 || int(pid) >= len(b.entries) {
+		return "", false
+	}
+
+	return b.entries[pid].id, true
+}
+
+// Pid returns the number of participant id in Participants.
+func (b *Builder) Pid(id string) (Pid, error) {
+	e, err := b.entry(id)
+	if err != nil {
+		return 0, err
+	}
+
+	return e.pid, nil
+}
+
+// Draw draws a gift exchange for everybody added so far using
+// Options. When it isn't possible, the error explains why, e.g., a
+// *NoSolutionError, *PinError, or *GroupCapacityError.
+func (b *Builder) Draw() (*GiftExchange, error) {
+	opts := b.Options
+	return NewGiftExchange(b.Participants(), &opts)
+}
+
+// Commit returns the commitment of a draw with Options. See Commit.
+func (b *Builder) Commit() string {
+	opts := b.Options
+	return Commit(b.Participants(), &opts)
+}
+
+// Recipients lists who everybody gives to in results by external ID.
+func (b *Builder) Recipients(results Results) map[string][]string {
+	recipients := make(map[string][]string, len(b.entries))
+	for giver, ids := range results.Multi() {
+		id, ok := b.ID(giver)
+		if !ok {
+			continue
+		}
+
+		for _, recipient := range ids {
+			if rid, ok := b.ID(recipient); ok {
+				recipients[id] = append(recipients[id], rid)
+			}
+		}
+	}
+
+	return recipients
+}
+
+// Verify checks that recipients, written as external IDs like the
+// output of Recipients, are exactly what drawing again with
+// Options.Seed produces, and that commitment matches. See Verify.
+func (b *Builder) Verify(commitment string, recipients map[string][]string) error {
+	results := make(MultiAssignment, len(recipients))
+	for giver, ids := range recipients {
+		g, err := b.Pid(giver)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			r, err := b.Pid(id)
+			if err != nil {
+				return err
+			}
+
+			results[g] = append(results[g], r)
+		}
+	}
+
+	opts := b.Options
+	return Verify(b.Participants(), &opts, commitment, results)
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,196 @@
+package giftex
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func Example_builder() {
+	b := NewBuilder()
+	b.Options.Seed = 2024
+
+	for _, u := range []struct{ id, name, group string }{
+		{"u1", "foo", "smith"},
+		{"u2", "bar", "smith"},
+		{"u3", "baz", ""},
+		{"u4", "qux", ""},
+	} {
+		if err := b.Add(u.id, Participant{Name: u.name, Group: u.group}); err != nil {
+			panic(err)
+		}
+	}
+
+	// foo always gives to qux, and baz can't give to foo
+	if err := b.Pin("u1", "u4"); err != nil {
+		panic(err)
+	}
+
+	if err := b.Exclude("u3", "u1"); err != nil {
+		panic(err)
+	}
+
+	ge, err := b.Draw()
+	if err != nil {
+		panic(err)
+	}
+
+	recipients := b.Recipients(ge)
+	fmt.Println("u1 gives to", recipients["u1"])
+	fmt.Println("u3 gives to", recipients["u3"])
+
+	// Anybody can check the published results once the seed is out
+	fmt.Println(b.Verify(ge.Commitment, recipients))
+
+	// Output:
+	// u1 gives to [u4]
+	// u3 gives to [u2]
+	// <nil>
+}
+
+func TestBuilder_errors(t *testing.T) {
+	b := NewBuilder()
+	if err := b.Add("u1", Participant{Name: "foo"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+		id   string
+	}{
+		{name: "Empty ID", err: b.Add(" ", Participant{Name: "bar"}), want: ErrEmptyID},
+		{name: "Empty name", err: b.Add("u2", Participant{}), want: ErrEmptyName, id: "u2"},
+		{name: "Duplicate ID", err: b.Add("u1", Participant{Name: "bar"}), want: ErrDuplicateID, id: "u1"},
+		{name: "Duplicate name", err: b.Add("u2", Participant{Name: " FOO "}), want: ErrDuplicateName, id: "u2"},
+		{name: "Unknown giver", err: b.Exclude("u9", "u1"), want: ErrUnknownID, id: "u9"},
+		{name: "Unknown recipient", err: b.Pin("u1", "u8"), want: ErrUnknownID, id: "u8"},
+		{name: "Unknown history", err: b.AddHistory(2023, "u1", "u7"), want: ErrUnknownID, id: "u7"},
+		{name: "Unknown remove", err: b.Remove("u6"), want: ErrUnknownID, id: "u6"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !errors.Is(tt.err, tt.want) {
+				t.Fatalf("want: %v; got: %v", tt.want, tt.err)
+			}
+
+			var idErr *IDError
+			if errors.As(tt.err, &idErr) != (tt.id != "") || (idErr != nil && idErr.ID != tt.id) {
+				t.Errorf("want IDError for %q; got: %v", tt.id, tt.err)
+			}
+		})
+	}
+
+	// Nothing is added when one of the IDs is unknown
+	if pm := b.Participants(); len(pm) != 1 || len(pm[0].Pinned) > 0 {
+		t.Errorf("unexpected participants: %v", pm)
+	}
+
+	// Draw explains why a gift exchange isn't possible
+	if _, err := b.Draw(); !errors.Is(err, ErrNoSolution) {
+		t.Errorf("want: %v; got: %v", ErrNoSolution, err)
+	}
+
+	b.Options.Seed = 1
+	if err := b.Verify("", map[string][]string{"u1": {"u5"}}); !errors.Is(err, ErrUnknownID) {
+		t.Errorf("want: %v; got: %v", ErrUnknownID, err)
+	}
+}
+
+func TestBuilder_Participants(t *testing.T) {
+	b := NewBuilder()
+	for i, name := range []string{"foo", "bar", "baz", "qux"} {
+		if err := b.Add(fmt.Sprint("u", i), Participant{Name: name, Restrictions: []Pid{3}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	steps := []error{
+		b.Exclude("u0", "u1", "u2"),
+		b.Exclude("u0", "u1"),
+		b.Allow("u1", "u2", "u3"),
+		b.Wish("u2", "u0"),
+		b.AddPrevious("u3", "u2", "u0"),
+		b.AddHistory(2023, "u1", "u3"),
+		b.Remove("u2"),
+	}
+
+	for _, err := range steps {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// baz is gone, so everybody after them moves up
+	pm := b.Participants()
+	var got []string
+	for id := Pid(0); int(id) < len(pm); id++ {
+		p := pm[id]
+		got = append(got, fmt.Sprintf("%d:%s:%v:%v:%v:%v", p.ID, p.Name, p.Restrictions, p.Allowed, p.Wishes, p.Previous))
+	}
+
+	want := "[0:foo:[1]:[]:[]:[] 1:bar:[]:[2]:[]:[] 2:qux:[]:[]:[]:[0]]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("want: %s; got: %v", want, got)
+	}
+
+	if id, ok := b.ID(2); !ok || id != "u3" {
+		t.Errorf("want: u3; got: %q", id)
+	}
+
+	if pid, err := b.Pid("u3"); err != nil || pid != 2 {
+		t.Errorf("want: 2; got: %d, %v", pid, err)
+	}
+
+	if h := fmt.Sprint(b.Options.History); h != "[{2023 bar qux}]" {
+		t.Errorf("unexpected history: %s", h)
+	}
+}
+
+func TestBuilder_Verify(t *testing.T) {
+	b := NewBuilder()
+	for i := 0; i < 6; i++ {
+		if err := b.Add(fmt.Sprint("u", i), Participant{Name: fmt.Sprint("p", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b.Options.Seed = 7
+	b.Options.GiftsPerPerson = 2
+	ge, err := b.Draw()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recipients := b.Recipients(ge)
+	if len(recipients) != 6 {
+		t.Fatalf("want 6 givers; got: %v", recipients)
+	}
+
+	for giver, ids := range recipients {
+		sort.Strings(ids)
+		if len(ids) != 2 || ids[0] == giver || ids[1] == giver {
+			t.Errorf("%s gives to %v", giver, ids)
+		}
+	}
+
+	if err := b.Verify(ge.Commitment, recipients); err != nil {
+		t.Error(err)
+	}
+
+	if b.Commit() != ge.Commitment {
+		t.Error("Commit doesn't match the draw")
+	}
+
+	// Changing the participants changes the commitment
+	if err := b.Exclude("u0", "u1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Verify(ge.Commitment, recipients); !errors.Is(err, ErrCommitmentMismatch) {
+		t.Errorf("want: %v; got: %v", ErrCommitmentMismatch, err)
+	}
+}
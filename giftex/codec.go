@@ -0,0 +1,363 @@
+package giftex
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnknownFormat = errors.New("Error: giftex doesn't read or write files in this format")
+	ErrInvalidJSON   = errors.New("Error: json must be a list of objects, e.g., [{\"name\": \"foo\"}]")
+)
+
+// Codec reads and writes a table in some file format, with the column
+// headers in the first record.
+type Codec interface {
+	Decode(r io.Reader) ([][]string, error)
+	Encode(w io.Writer, records [][]string) error
+}
+
+// Format is a kind of file giftex can read participants from and
+// write results to.
+type Format struct {
+	Name        string // e.g., "csv"
+	Extension   string // e.g., ".csv"
+	ContentType string
+	Codec       Codec
+}
+
+var (
+	CSV  = Format{"csv", ".csv", "text/csv; charset=utf-8", CSVCodec{}}
+	TSV  = Format{"tsv", ".tsv", "text/tab-separated-values; charset=utf-8", CSVCodec{Comma: '\t'}}
+	JSON = Format{"json", ".json", "application/json; charset=utf-8", JSONCodec{}}
+	XLSX = Format{"xlsx", ".xlsx", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", XLSXCodec{}}
+)
+
+var (
+	formatsMu sync.RWMutex
+	formats   = map[string]Format{}
+)
+
+func init() {
+	for _, f := range []Format{CSV, TSV, JSON, XLSX} {
+		RegisterFormat(f)
+	}
+}
+
+// RegisterFormat adds f to the formats known to FormatByName and
+// DetectFormat, replacing any format with the same name.
+func RegisterFormat(f Format) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+
+	formats[trimLower(f.Name)] = f
+}
+
+// Formats lists every registered format, sorted by name.
+func Formats() []Format {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	list := make([]Format, 0, len(formats))
+	for _, f := range formats {
+		list = append(list, f)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
+
+// FormatByName returns the format called name, e.g., "xlsx", or with
+// the extension name, e.g., ".xlsx".
+func FormatByName(name string) (Format, error) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	name = trimLower(name)
+	if f, ok := formats[name]; ok {
+		return f, nil
+	}
+
+	for _, f := range formats {
+		if f.Extension != "" && trimLower(f.Extension) == name {
+			return f, nil
+		}
+	}
+
+	return Format{}, fmt.Errorf("%w: %q", ErrUnknownFormat, name)
+}
+
+// DetectFormat guesses the format of a file from the extension of
+// filename, or else from head, the start of the file. Files that
+// don't look like anything else are read as CSVs.
+func DetectFormat(filename string, head []byte) Format {
+	if ext := filepath.Ext(filename); ext != "" {
+		if f, err := FormatByName(ext); err == nil {
+			return f
+		}
+	}
+
+	if bytes.HasPrefix(head, []byte("PK\x03\x04")) {
+		return XLSX
+	}
+
+	head = bytes.TrimSpace(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")))
+	if bytes.HasPrefix(head, []byte("[")) {
+		return JSON
+	}
+
+	line := head
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+
+	if bytes.Contains(line, []byte("\t")) && !bytes.Contains(line, []byte(",")) {
+		return TSV
+	}
+
+	return CSV
+}
+
+// CSVCodec reads and writes CSVs separated by Comma, or by the
+// delimiter of the Schema when Comma is 0.
+type CSVCodec struct {
+	Comma rune
+}
+
+func (c CSVCodec) Decode(r io.Reader) ([][]string, error) {
+	rr, _ := c.NewReader(r)
+	return readAll(rr)
+}
+
+// NewReader reads a CSV one record at a time. Rows that can't be
+// parsed return a *csv.ParseError, and reading can carry on after
+// them.
+func (c CSVCodec) NewReader(r io.Reader) (RecordReader, error) {
+	csvReader := csv.NewReader(r)
+	csvReader.FieldsPerRecord = -1 // Allow empty columns
+	if c.Comma != 0 {
+		csvReader.Comma = c.Comma
+	}
+
+	return &csvRecordReader{r: csvReader}, nil
+}
+
+type csvRecordReader struct {
+	r       *csv.Reader
+	started bool
+}
+
+func (c *csvRecordReader) Read() ([]string, error) {
+	record, err := c.r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, io.EOF
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Error reading csv: %w", err)
+	}
+
+	// Excel starts CSVs with a byte order mark
+	if !c.started && len(record) > 0 {
+		record[0] = strings.TrimPrefix(record[0], "\ufeff")
+	}
+	c.started = true
+
+	return record, nil
+}
+
+func (c CSVCodec) Encode(w io.Writer, records [][]string) error {
+	b := csv.NewWriter(w)
+	if c.Comma != 0 {
+		b.Comma = c.Comma
+	}
+
+	b.WriteAll(records)
+	return b.Error()
+}
+
+// JSONCodec reads and writes a list of objects keyed by column, e.g.,
+//
+//   [{"name": "foo", "email": "foo@example.com", "restrictions": ["bar"]}]
+//
+// The columns are every key used by any object, in the order they
+// first appear. Lists are joined with commas, true and false are read
+// as "yes" and "no" so they work in the participating column, and
+// null is blank. Everything is written as a string.
+type JSONCodec struct{}
+
+func (JSONCodec) Decode(r io.Reader) ([][]string, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	if err := expectDelim(dec, '['); err != nil {
+		return nil, err
+	}
+
+	cols := make(map[string]int)
+	var headers []string
+	var rows []map[int]string
+	for dec.More() {
+		if err := expectDelim(dec, '{'); err != nil {
+			return nil, err
+		}
+
+		row := make(map[int]string)
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, fmt.Errorf("Error reading json: %w", err)
+			}
+
+			key, _ := tok.(string)
+			var v interface{}
+			if err := dec.Decode(&v); err != nil {
+				return nil, fmt.Errorf("Error reading json: %w", err)
+			}
+
+			col, ok := cols[key]
+			if !ok {
+				col = len(headers)
+				cols[key] = col
+				headers = append(headers, key)
+			}
+
+			value, err := jsonValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %q is %v", ErrInvalidJSON, key, err)
+			}
+			row[col] = value
+		}
+
+		if err := expectDelim(dec, '}'); err != nil {
+			return nil, err
+		}
+
+		rows = append(rows, row)
+	}
+
+	if err := expectDelim(dec, ']'); err != nil {
+		return nil, err
+	}
+
+	records := make([][]string, 0, len(rows)+1)
+	records = append(records, headers)
+	for _, row := range rows {
+		record := make([]string, len(headers))
+		for col, v := range row {
+			record[col] = v
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("Error reading json: %w", err)
+	}
+
+	if d, ok := tok.(json.Delim); !ok || d != want {
+		return ErrInvalidJSON
+	}
+
+	return nil
+}
+
+// jsonValue writes a value from a JSON object as a cell.
+func jsonValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		if v {
+			return "yes", nil
+		}
+		return "no", nil
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := jsonValue(item)
+			if err != nil {
+				return "", err
+			}
+
+			if _, nested := item.([]interface{}); nested {
+				return "", errors.New("a nested list")
+			}
+
+			list = append(list, s)
+		}
+
+		return strings.Join(list, ","), nil
+	default:
+		return "", errors.New("an object")
+	}
+}
+
+func (JSONCodec) Encode(w io.Writer, records [][]string) error {
+	var b bytes.Buffer
+	b.WriteString("[")
+	if len(records) > 0 {
+		headers := records[0]
+		for i, record := range records[1:] {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			b.WriteString("\n  {")
+
+			// Keep the columns in order, which a map wouldn't
+			for col, header := range headers {
+				var value string
+				if col < len(record) {
+					value = record[col]
+				}
+
+				if col > 0 {
+					b.WriteString(", ")
+				}
+
+				writeJSONString(&b, header)
+				b.WriteString(": ")
+				writeJSONString(&b, value)
+			}
+
+			b.WriteString("}")
+		}
+
+		if len(records) > 1 {
+			b.WriteString("\n")
+		}
+	}
+	b.WriteString("]\n")
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// writeJSONString quotes s without escaping HTML, so "Tom & Jerry"
+// stays readable.
+func writeJSONString(b *bytes.Buffer, s string) {
+	enc := json.NewEncoder(b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	b.Truncate(b.Len() - 1) // Encode ends with a newline
+}
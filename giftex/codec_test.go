@@ -0,0 +1,323 @@
+package giftex
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Example_formats() {
+	db, err := Schema{}.ReadFile("testdata/participants.json")
+	if err != nil {
+		panic(err)
+	}
+
+	var xlsx bytes.Buffer
+	if err := db.Write(&xlsx, Assignment{0: 2, 1: 0, 2: 1}, XLSX.Codec); err != nil {
+		panic(err)
+	}
+
+	// The spreadsheet can be read back like any other file
+	results, err := Schema{}.Read(&xlsx, XLSX.Codec)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := CSV.Codec.Encode(os.Stdout, append([][]string{results.headers}, results.records...)); err != nil {
+		panic(err)
+	}
+
+	// Output:
+	// name,email,restrictions,previous,participating,has,Shirt Size
+	// Ann,ann@example.com,Bob,Cat,yes,Cat,M
+	// Bob,bob@example.com,,"Cat,Ann",yes,Ann,
+	// Cat,cat@example.com,,Bob,yes,Bob,S
+	// Dan,dan@example.com,,,no,,
+}
+
+func TestCodecs_roundTrip(t *testing.T) {
+	records := [][]string{
+		{"name", "email", "Notes"},
+		{"Ann", "ann@example.com", "likes \"tea\", <not> coffee"},
+		{"Bob", "", "  spaces  "},
+		{"Tom & Jerry", "tj@example.com", "tab\there\nand a new line"},
+	}
+
+	for _, f := range Formats() {
+		t.Run(f.Name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := f.Codec.Encode(&b, records); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := DetectFormat("", b.Bytes()); got.Name != f.Name {
+				t.Errorf("detected %s as %s", f.Name, got.Name)
+			}
+
+			got, err := f.Codec.Decode(&b)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", records) {
+				t.Errorf("\nwant: %q\n got: %q", records, got)
+			}
+		})
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		filename string
+		head     string
+		want     string
+	}{
+		{"people.csv", "name\temail\n", "csv"},
+		{"people.XLSX", "", "xlsx"},
+		{"people.tsv", "", "tsv"},
+		{"people.txt", "name\temail\nfoo\tfoo@example.com\n", "tsv"},
+		{"people.txt", "name,email\tx\n", "csv"},
+		{"", "\xef\xbb\xbf [{\"name\": \"foo\"}]", "json"},
+		{"", "PK\x03\x04", "xlsx"},
+		{"", "name;email\n", "csv"},
+	}
+
+	for _, tt := range tests {
+		if got := DetectFormat(tt.filename, []byte(tt.head)); got.Name != tt.want {
+			t.Errorf("%q %q: want: %s; got: %s", tt.filename, tt.head, tt.want, got.Name)
+		}
+	}
+
+	if _, err := FormatByName("pdf"); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("want: %v; got: %v", ErrUnknownFormat, err)
+	}
+}
+
+func TestCSVCodec_byteOrderMark(t *testing.T) {
+	db, err := ReadCSV(strings.NewReader("\ufeffname,email,restrictions,previous,participating,has\nfoo,,,,yes,\nbar,,,,yes,\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(db.Participants); n != 2 {
+		t.Errorf("want: 2 participants; got: %d", n)
+	}
+}
+
+func TestJSONCodec_Decode(t *testing.T) {
+	tests := []struct {
+		json string
+		want string
+		err  error
+	}{
+		{
+			json: `[{"name": "foo", "previous": ["bar", "baz"], "participating": true, "budget": 25.5},
+			        {"name": "bar", "email": "bar@example.com", "participating": false, "budget": null}]`,
+			want: `[["name" "previous" "participating" "budget" "email"] ["foo" "bar,baz" "yes" "25.5" ""] ["bar" "" "no" "" "bar@example.com"]]`,
+		},
+		{json: `[]`, want: `[[]]`},
+		{json: `{"name": "foo"}`, err: ErrInvalidJSON},
+		{json: `[["name"], ["foo"]]`, err: ErrInvalidJSON},
+		{json: `[{"name": {"first": "foo"}}]`, err: ErrInvalidJSON},
+	}
+
+	for _, tt := range tests {
+		got, err := JSONCodec{}.Decode(strings.NewReader(tt.json))
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: want: %v; got: %v", tt.json, tt.err, err)
+			continue
+		}
+
+		if tt.err == nil && fmt.Sprintf("%q", got) != tt.want {
+			t.Errorf("%s:\nwant: %s\n got: %q", tt.json, tt.want, got)
+		}
+	}
+}
+
+// zipParts zips up the files in parts.
+func zipParts(t *testing.T, parts map[string]string) *bytes.Buffer {
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for name, body := range parts {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(body))
+	}
+	zw.Close()
+
+	return &b
+}
+
+// TestXLSXCodec_Decode reads a workbook laid out the way Excel writes
+// them, with shared strings and empty cells left out.
+func TestXLSXCodec_Decode(t *testing.T) {
+	parts := map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+			<sheets><sheet name="People" sheetId="1" r:id="rId3"/><sheet name="Other" sheetId="2" r:id="rId4"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+			<Relationship Id="rId4" Target="worksheets/sheet1.xml"/>
+			<Relationship Id="rId3" Target="/xl/worksheets/people.xml"/></Relationships>`,
+		"xl/sharedStrings.xml": `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+			<si><t>name</t></si><si><t>phone</t></si><si><r><t>Ann </t></r><r><t>Lee</t></r></si><si><t>participating</t></si></sst>`,
+		"xl/worksheets/people.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+			<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c><c r="C1" t="s"><v>3</v></c></row>
+			<row r="2"><c r="A2" t="s"><v>2</v></c><c r="C2" t="b"><v>1</v></c></row>
+			<row r="4"><c r="A4" t="inlineStr"><is><t>Bob</t></is></c><c r="B4"><v>5551234567</v></c></row>
+			<row r="5"><c r="B5" t="str"><v></v></c></row>
+			</sheetData></worksheet>`,
+		"xl/worksheets/sheet1.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+			<row r="1"><c r="A1" t="inlineStr"><is><t>wrong sheet</t></is></c></row></sheetData></worksheet>`,
+	}
+
+	got, err := XLSXCodec{}.Decode(zipParts(t, parts))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `[["name" "phone" "participating"] ["Ann Lee" "" "TRUE"] ["Bob" "5551234567" ""]]`
+	if fmt.Sprintf("%q", got) != want {
+		t.Errorf("\nwant: %s\n got: %q", want, got)
+	}
+
+	if _, err := (XLSXCodec{}).Decode(strings.NewReader("name,email\n")); !errors.Is(err, ErrInvalidXLSX) {
+		t.Errorf("want: %v; got: %v", ErrInvalidXLSX, err)
+	}
+}
+
+func TestXLSXRef(t *testing.T) {
+	for _, tt := range []struct {
+		row, col int
+		ref      string
+	}{
+		{0, 0, "A1"}, {2, 1, "B3"}, {9, 25, "Z10"}, {0, 26, "AA1"}, {0, 701, "ZZ1"}, {0, 702, "AAA1"},
+	} {
+		if got := xlsxRef(tt.row, tt.col); got != tt.ref {
+			t.Errorf("%d,%d: want: %s; got: %s", tt.row, tt.col, tt.ref, got)
+		}
+
+		if col, err := xlsxColumn(tt.ref); err != nil || col != tt.col {
+			t.Errorf("%s: want: %d; got: %d, %v", tt.ref, tt.col, col, err)
+		}
+	}
+
+	// Excel stops at column XFD
+	if col, err := xlsxColumn("XFD1"); err != nil || col != 1<<14-1 {
+		t.Errorf("XFD1: want: %d; got: %d, %v", 1<<14-1, col, err)
+	}
+
+	for _, ref := range []string{"1", "a1", "XFE1", "ZZZZZZZZZZZZZZ1", strings.Repeat("Z", 100) + "1"} {
+		if col, err := xlsxColumn(ref); !errors.Is(err, ErrInvalidXLSX) {
+			t.Errorf("%s: want: %v; got: %d, %v", ref, ErrInvalidXLSX, col, err)
+		}
+	}
+
+	// A cell far past the last column can't crash an import
+	var headers strings.Builder
+	for i, h := range []string{"name", "email", "restrictions", "previous", "participating", "has"} {
+		fmt.Fprintf(&headers, `<c r="%s" t="inlineStr"><is><t>%s</t></is></c>`, xlsxRef(0, i), h)
+	}
+
+	sheet := `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+		<row r="1">` + headers.String() + `</row>
+		<row r="2"><c r="ZZZZZZZZZZZZZZ2" t="inlineStr"><is><t>foo</t></is></c></row></sheetData></worksheet>`
+	b := zipParts(t, map[string]string{"xl/worksheets/sheet1.xml": sheet})
+	if _, err := Import(b, &ImportOptions{Codec: XLSXCodec{}}); !errors.Is(err, ErrInvalidXLSX) {
+		t.Errorf("want: %v; got: %v", ErrInvalidXLSX, err)
+	}
+}
+
+// TestXLSXCodec_farRight checks a cell far to the right of the headers
+// can't pad every row out to the last column XLSX allows.
+func TestXLSXCodec_farRight(t *testing.T) {
+	var rows strings.Builder
+	rows.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+		<row><c r="A1" t="inlineStr"><is><t>name</t></is></c><c r="B1" t="inlineStr"><is><t>email</t></is></c></row>`)
+	for i := 2; i < 2000; i++ {
+		fmt.Fprintf(&rows, `<row><c r="A%d" t="inlineStr"><is><t>p%d</t></is></c><c r="XFD%d"><v>1</v></c></row>`, i, i, i)
+	}
+	rows.WriteString(`</sheetData></worksheet>`)
+
+	b := zipParts(t, map[string]string{"xl/worksheets/sheet1.xml": rows.String()})
+	got, err := XLSXCodec{MaxBytes: 32 << 20}.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, record := range got {
+		if len(record) != 2 {
+			t.Fatalf("want 2 columns; got: %d", len(record))
+		}
+	}
+
+	// A header far to the right makes every row wide, which counts
+	// against MaxBytes
+	wide := `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+		<row><c r="A1" t="inlineStr"><is><t>name</t></is></c><c r="XFD1" t="inlineStr"><is><t>far</t></is></c></row>` +
+		strings.Repeat(`<row><c t="inlineStr"><is><t>foo</t></is></c></row>`, 1000) + `</sheetData></worksheet>`
+
+	b = zipParts(t, map[string]string{"xl/worksheets/sheet1.xml": wide})
+	if _, err := (XLSXCodec{MaxBytes: 1 << 20}).Decode(b); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("want: %v; got: %v", ErrTooLarge, err)
+	}
+}
+
+// TestXLSXCodec_MaxBytes checks a workbook that's tiny when zipped
+// can't unpack to more than MaxBytes, wherever the bytes are.
+func TestXLSXCodec_MaxBytes(t *testing.T) {
+	const padding = 8 << 20
+	sheet := `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+		<row r="1"><c r="A1" t="inlineStr"><is><t>name</t></is></c></row>
+		<row r="2"><c r="A2" t="inlineStr"><is><t>foo</t></is></c></row></sheetData></worksheet>`
+	shared := `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		strings.Repeat(" ", padding) + `</sst>`
+
+	// Every row refers to the same long shared string
+	long := `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><si><t>` +
+		strings.Repeat("x", 100<<10) + `</t></si></sst>`
+	var repeated strings.Builder
+	repeated.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for i := 0; i < 100; i++ {
+		repeated.WriteString(`<row><c t="s"><v>0</v></c></row>`)
+	}
+	repeated.WriteString(`</sheetData></worksheet>`)
+
+	tests := []struct {
+		name  string
+		parts map[string]string
+		max   int64
+		err   error
+	}{
+		{name: "small", parts: map[string]string{"xl/worksheets/sheet1.xml": sheet}, max: 1 << 10},
+		{name: "no limit", parts: map[string]string{"xl/worksheets/sheet1.xml": sheet, "xl/sharedStrings.xml": shared}},
+		{name: "shared strings", parts: map[string]string{"xl/worksheets/sheet1.xml": sheet, "xl/sharedStrings.xml": shared}, max: 1 << 20, err: ErrTooLarge},
+		{name: "sheet", parts: map[string]string{"xl/worksheets/sheet1.xml": sheet + strings.Repeat(" ", padding)}, max: 1 << 20, err: ErrTooLarge},
+		{name: "altogether", parts: map[string]string{"xl/worksheets/sheet1.xml": sheet, "xl/sharedStrings.xml": shared}, max: padding + 100, err: ErrTooLarge},
+		{name: "repeated shared strings", parts: map[string]string{"xl/worksheets/sheet1.xml": repeated.String(), "xl/sharedStrings.xml": long}, max: 1 << 20, err: ErrTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := zipParts(t, tt.parts)
+			if b.Len() > 1<<16 {
+				t.Fatalf("workbook is %d bytes zipped", b.Len())
+			}
+
+			_, err := XLSXCodec{MaxBytes: tt.max}.Decode(b)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("want: %v; got: %v", tt.err, err)
+			}
+		})
+	}
+
+	// Import applies MaxBytes to the workbook too
+	b := zipParts(t, map[string]string{"xl/worksheets/sheet1.xml": sheet, "xl/sharedStrings.xml": shared})
+	if _, err := Import(b, &ImportOptions{Codec: XLSX.Codec, MaxBytes: 1 << 20}); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("want: %v; got: %v", ErrTooLarge, err)
+	}
+}
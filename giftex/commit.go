@@ -0,0 +1,318 @@
+package giftex
+
+import (
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrNoSeed             = errors.New("Error: a seed is required to verify a gift exchange")
+	ErrCommitmentMismatch = errors.New("Error: the commitment doesn't match the participants, options, and seed")
+	ErrAssignmentMismatch = errors.New("Error: the assignment doesn't follow from the commitment")
+	ErrNoEntropy          = errors.New("Error: a committed draw can't be revealed without entropy")
+)
+
+// Reveal makes a draw in two steps so the organizer can't re-roll it
+// until they like the results. First, the organizer picks a Secret
+// with NewSecret and a Source of entropy they don't control, e.g., the
+// winning numbers of Saturday's lottery or a word from every
+// participant, and publishes the Commit of the participants and
+// options with Reveal set. Once the Entropy is known, the gift
+// exchange is drawn with the seed made from both, and the Secret and
+// Entropy are published so anybody can Verify it.
+//
+// The commitment depends on the Secret and Source but not the
+// Entropy, so nobody knows the seed before the commitment is shared,
+// and the organizer can't change anything after.
+type Reveal struct {
+	Secret  string // Picked by the organizer before committing
+	Source  string // Where the Entropy comes from
+	Entropy string // Published after the commitment
+}
+
+// NewSecret picks a random secret for Reveal.
+func NewSecret() string {
+	var b [32]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		panic("Error reading crypto/rand: " + err.Error())
+	}
+
+	return hex.EncodeToString(b[:])
+}
+
+// Seed is the seed the gift exchange is drawn with: the first 8 bytes
+// of the SHA-256 hash of Secret, a newline, and Entropy with spaces
+// trimmed from both ends, read as a big-endian number without its top
+// bit, or 1 if that's 0.
+func (r *Reveal) Seed() int64 {
+	sum := sha256.Sum256([]byte(r.Secret + "\n" + strings.TrimSpace(r.Entropy)))
+	if seed := int64(binary.BigEndian.Uint64(sum[:8]) &^ (1 << 63)); seed != 0 {
+		return seed
+	}
+
+	return 1
+}
+
+// commitParticipant is everything about a participant that can change
+// the outcome of a draw. Participants are identified by name so the
+// commitment doesn't depend on the order they were read in.
+type commitParticipant struct {
+	Name         string
+	Group        string   `json:",omitempty"`
+	GivesTo      string   `json:",omitempty"`
+	Restrictions []string `json:",omitempty"`
+	Previous     []string `json:",omitempty"`
+	Wishes       []string `json:",omitempty"`
+	Pinned       []string `json:",omitempty"`
+	Allowed      []string `json:",omitempty"`
+	Role         string   `json:",omitempty"`
+
+	// Attributes only change the commitment when there are Rules,
+	// since nothing else reads them
+	Attributes map[string]string `json:",omitempty"`
+}
+
+type commitData struct {
+	Participants []commitParticipant
+
+	MaxPrevious           int
+	Costs                 *CostOptions `json:",omitempty"`
+	SingleCycle           bool
+	NoMutualPairs         bool
+	MinCycleLength        int
+	SymmetricRestrictions bool
+	GiftsPerPerson        int
+	ExtraGifts            int         `json:",omitempty"`
+	Swap                  bool        `json:",omitempty"`
+	GroupRules            []GroupRule `json:",omitempty"`
+	Rules                 []string    `json:",omitempty"`
+
+	// History only changes the commitment when it's used, so older
+	// commitments can still be verified
+	History        History `json:",omitempty"`
+	Year           int     `json:",omitempty"`
+	RepeatYears    int     `json:",omitempty"`
+	RepeatBothWays bool    `json:",omitempty"`
+
+	Seed int64
+
+	// Only used by draws with a Reveal, see Reveal
+	Secret        string `json:",omitempty"`
+	EntropySource string `json:",omitempty"`
+}
+
+// Commit hashes the participants, constraints, options, and seed of
+// a gift exchange. Any other seed or change to the participants
+// produces a different commitment, and anybody can check the published
+// results with Verify once the seed is revealed.
+//
+// An organizer who picks the seed could still try many of them before
+// publishing the one they like. With Reveal set, the commitment holds
+// its Secret and Source instead of the seed, and can be published
+// before the seed can be worked out by anybody.
+func Commit(pm ParticipantMap, opts *GiftExchangeOptions) string {
+	names := func(ids []Pid, sorted bool) []string {
+		list := make([]string, 0, len(ids))
+		for _, id := range ids {
+			list = append(list, pm[id].Name)
+		}
+
+		if sorted {
+			sort.Strings(list)
+		}
+
+		return list
+	}
+
+	var data commitData
+	for _, id := range drawOrder(pm) {
+		p := pm[id]
+		cp := commitParticipant{
+			Name:         p.Name,
+			Group:        trimLower(p.Group),
+			GivesTo:      trimLower(p.GivesTo),
+			Restrictions: names(p.Restrictions, true),
+			Previous:     names(opts.previous(p), false), // Order matters for MaxPrevious
+			Wishes:       names(p.Wishes, true),
+			Pinned:       names(p.Pinned, true),
+			Allowed:      names(p.Allowed, true),
+		}
+
+		if p.Role != GivesAndReceives {
+			cp.Role = p.Role.String()
+		}
+
+		if len(opts.rules()) > 0 {
+			cp.Attributes = commitAttributes(p)
+		}
+
+		data.Participants = append(data.Participants, cp)
+	}
+
+	if opts != nil {
+		data.MaxPrevious = opts.MaxPrevious
+		data.Costs = opts.Costs
+		data.SingleCycle = opts.SingleCycle
+		data.NoMutualPairs = opts.NoMutualPairs
+		data.MinCycleLength = opts.MinCycleLength
+		data.SymmetricRestrictions = opts.SymmetricRestrictions
+		data.GiftsPerPerson = opts.giftsPerPerson()
+		data.ExtraGifts = opts.ExtraGifts
+		data.Swap = opts.Swap
+		for _, r := range opts.GroupRules {
+			data.GroupRules = append(data.GroupRules, GroupRule{Giver: trimLower(r.Giver), Recipient: trimLower(r.Recipient)})
+		}
+		for _, r := range opts.Rules {
+			data.Rules = append(data.Rules, ruleName(r))
+		}
+		data.RepeatBothWays = opts.RepeatBothWays
+		data.Seed = opts.Seed
+		if opts.Reveal != nil {
+			data.Seed = 0
+			data.Secret = opts.Reveal.Secret
+			data.EntropySource = strings.TrimSpace(opts.Reveal.Source)
+		}
+
+		// Records from this year on are the results, not the input
+		if year := opts.year(); len(opts.History.before(year)) > 0 {
+			data.History = opts.History.before(year).sorted()
+			data.Year = year
+			data.RepeatYears = opts.RepeatYears
+		}
+	}
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		panic("Error encoding commitment: " + err.Error())
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Verify checks that results are exactly what drawing the gift
+// exchange again with opts.Seed, or opts.Reveal, produces, and that
+// the commitment matches the participants, options, and seed.
+func Verify(pm ParticipantMap, opts *GiftExchangeOptions, commitment string, results Results) error {
+	if opts.seed() == 0 {
+		return ErrNoSeed
+	}
+
+	if Commit(pm, opts) != strings.ToLower(strings.TrimSpace(commitment)) {
+		return ErrCommitmentMismatch
+	}
+
+	replay := *opts
+	replay.Rand = nil
+
+	ge, err := NewGiftExchange(pm, &replay)
+	if err != nil {
+		return fmt.Errorf("Error replaying draw: %w", err)
+	}
+
+	want, got := ge.Recipients, results.Multi()
+	if len(want) != len(got) {
+		return ErrAssignmentMismatch
+	}
+
+	for giver, recipients := range want {
+		if !samePids(recipients, got[giver]) {
+			return fmt.Errorf("%w: %s", ErrAssignmentMismatch, pm[giver].Name)
+		}
+	}
+
+	return nil
+}
+
+// VerifyCSV checks the results CSV of a gift exchange written by
+// WriteCSV. The recipients in the "has" column were appended to the
+// "previous" column when the CSV was written, so they are removed
+// again before checking the commitment.
+func VerifyCSV(r io.Reader, opts *GiftExchangeOptions) error {
+	return Schema{}.VerifyCSV(r, opts)
+}
+
+// VerifyCSV works like the VerifyCSV function, but reads a results CSV
+// laid out as described by s.
+func (s Schema) VerifyCSV(r io.Reader, opts *GiftExchangeOptions) error {
+	db, err := s.ReadCSV(r)
+	if err != nil {
+		return err
+	}
+
+	hasCol, ok := db.cols["has"]
+	if !ok {
+		return fmt.Errorf("%w: missing has column", ErrInvalidCSV)
+	}
+
+	commitCol, ok := db.cols["commitment"]
+	if !ok {
+		return fmt.Errorf("%w: missing commitment column", ErrInvalidCSV)
+	}
+
+	prevCol, ok := db.cols["previous"]
+	if !ok {
+		return fmt.Errorf("%w: missing previous column", ErrInvalidCSV)
+	}
+
+	var commitment string
+	for i, row := range db.records {
+		if hasCol >= len(row) {
+			continue
+		}
+
+		has := splitNames(row[hasCol])
+		prev := splitNames(row[prevCol])
+		if len(has) > len(prev) {
+			return fmt.Errorf("%w: %s", ErrAssignmentMismatch, row[db.cols["name"]])
+		}
+
+		row[prevCol] = strings.Join(prev[:len(prev)-len(has)], ",")
+		db.records[i] = row
+
+		if commitCol < len(row) && row[commitCol] != "" {
+			commitment = row[commitCol]
+		}
+	}
+
+	// Read the participants again without this year's results
+	db, err = s.ReadRecords(append([][]string{db.headers}, db.records...))
+	if err != nil {
+		return err
+	}
+
+	results, err := db.Has()
+	if err != nil {
+		return err
+	}
+
+	return Verify(db.Participants, opts, commitment, results)
+}
+
+// samePids reports whether a and b hold the same Pids in any order.
+func samePids(a, b []Pid) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	count := make(map[Pid]int, len(a))
+	for _, id := range a {
+		count[id]++
+	}
+
+	for _, id := range b {
+		if count[id]--; count[id] < 0 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,191 @@
+package giftex
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func Example_verify() {
+	db, err := ReadCSVFromFile("testdata/groups.csv")
+	if err != nil {
+		panic(err)
+	}
+
+	opts := &GiftExchangeOptions{MaxPrevious: 2, Seed: 42}
+	ge, err := NewGiftExchange(db.Participants, opts)
+	if err != nil {
+		panic(err)
+	}
+
+	var b strings.Builder
+	if err := db.WriteCSV(&b, ge); err != nil {
+		panic(err)
+	}
+
+	// Anybody with the results CSV can check the draw once they know the seed
+	fmt.Println(VerifyCSV(strings.NewReader(b.String()), opts))
+
+	opts.Seed = 7
+	fmt.Println(VerifyCSV(strings.NewReader(b.String()), opts))
+
+	// Output:
+	// <nil>
+	// Error: the commitment doesn't match the participants, options, and seed
+}
+
+func TestCommit(t *testing.T) {
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo", Restrictions: []Pid{2}},
+		1: {ID: 1, Name: "bar", Previous: []Pid{2, 0}},
+		2: {ID: 2, Name: "baz", Group: "Smith"},
+	}
+
+	// The same participants read in a different order
+	shuffled := ParticipantMap{
+		0: {ID: 0, Name: "baz", Group: "smith"},
+		1: {ID: 1, Name: "foo", Restrictions: []Pid{0}},
+		2: {ID: 2, Name: "bar", Previous: []Pid{0, 1}},
+	}
+
+	opts := &GiftExchangeOptions{MaxPrevious: 1, Seed: 1}
+	want := Commit(pm, opts)
+	if got := Commit(shuffled, opts); want != got {
+		t.Errorf("order shouldn't matter: want: %s; got: %s", want, got)
+	}
+
+	changed := []struct {
+		name string
+		pm   ParticipantMap
+		opts *GiftExchangeOptions
+	}{
+		{name: "Seed", pm: pm, opts: &GiftExchangeOptions{MaxPrevious: 1, Seed: 2}},
+		{name: "Options", pm: pm, opts: &GiftExchangeOptions{MaxPrevious: 2, Seed: 1}},
+		{name: "Previous order", pm: ParticipantMap{
+			0: pm[0],
+			1: {ID: 1, Name: "bar", Previous: []Pid{0, 2}},
+			2: pm[2],
+		}, opts: opts},
+	}
+
+	for _, tt := range changed {
+		if got := Commit(tt.pm, tt.opts); want == got {
+			t.Errorf("%s should change the commitment", tt.name)
+		}
+	}
+
+	// Replaying the seed gives the same draw in either order
+	ge, err := NewGiftExchange(pm, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relabeled := make(MultiAssignment)
+	for giver, recipients := range ge.Recipients {
+		for _, r := range recipients {
+			relabeled[(giver+1)%3] = append(relabeled[(giver+1)%3], (r+1)%3)
+		}
+	}
+
+	if err := Verify(shuffled, opts, ge.Commitment, relabeled); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	pm := make(ParticipantMap, 6)
+	for i := 0; i < 6; i++ {
+		pm[Pid(i)] = Participant{ID: Pid(i), Name: fmt.Sprint(i)}
+	}
+
+	opts := &GiftExchangeOptions{Seed: 99}
+	ge, err := NewGiftExchange(pm, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Swap two recipients as if the organizer changed the results
+	tampered := make(Assignment, len(ge.Assignment))
+	for giver, recipient := range ge.Assignment {
+		tampered[giver] = recipient
+	}
+	tampered[0], tampered[1] = tampered[1], tampered[0]
+
+	tests := []struct {
+		name       string
+		opts       *GiftExchangeOptions
+		commitment string
+		results    Results
+		want       error
+	}{
+		{name: "Valid", opts: opts, commitment: ge.Commitment, results: ge, want: nil},
+		{name: "No seed", opts: nil, commitment: ge.Commitment, results: ge, want: ErrNoSeed},
+		{name: "Wrong commitment", opts: opts, commitment: Commit(pm, &GiftExchangeOptions{Seed: 1}), results: ge, want: ErrCommitmentMismatch},
+		{name: "Tampered", opts: opts, commitment: ge.Commitment, results: tampered, want: ErrAssignmentMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Verify(pm, tt.opts, tt.commitment, tt.results); !errors.Is(err, tt.want) {
+				t.Errorf("want: %v; got: %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestReveal(t *testing.T) {
+	pm := make(ParticipantMap, 8)
+	for i := 0; i < 8; i++ {
+		pm[Pid(i)] = Participant{ID: Pid(i), Name: fmt.Sprint(i)}
+	}
+
+	// The commitment is published before anybody knows the entropy
+	secret := "2f1c0b5e9d"
+	committed := &GiftExchangeOptions{Reveal: &Reveal{Secret: secret, Source: "Saturday's lottery"}}
+	commitment := Commit(pm, committed)
+
+	if _, err := NewGiftExchange(pm, committed); !errors.Is(err, ErrNoEntropy) {
+		t.Fatalf("want: %v; got: %v", ErrNoEntropy, err)
+	}
+
+	opts := &GiftExchangeOptions{Reveal: &Reveal{Secret: secret, Source: "Saturday's lottery", Entropy: "4 8 15 16 23 42"}}
+	ge, err := NewGiftExchange(pm, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ge.Commitment != commitment {
+		t.Errorf("want: %s; got: %s", commitment, ge.Commitment)
+	}
+
+	if ge.Seed != opts.Reveal.Seed() || ge.Seed == 0 {
+		t.Errorf("want: %d; got: %d", opts.Reveal.Seed(), ge.Seed)
+	}
+
+	other := &Reveal{Secret: secret, Entropy: "4 8 15 16 23 43"}
+	if other.Seed() == ge.Seed {
+		t.Error("the entropy should change the seed")
+	}
+
+	tests := []struct {
+		name   string
+		reveal *Reveal
+		want   error
+	}{
+		{name: "Valid", reveal: opts.Reveal, want: nil},
+		{name: "Wrong secret", reveal: &Reveal{Secret: NewSecret(), Source: "Saturday's lottery", Entropy: "4 8 15 16 23 42"}, want: ErrCommitmentMismatch},
+		{name: "Wrong source", reveal: &Reveal{Secret: secret, Source: "Sunday's lottery", Entropy: "4 8 15 16 23 42"}, want: ErrCommitmentMismatch},
+		{name: "Wrong entropy", reveal: &Reveal{Secret: secret, Source: "Saturday's lottery", Entropy: "1 2 3"}, want: ErrAssignmentMismatch},
+		{name: "No entropy", reveal: &Reveal{Secret: secret, Source: "Saturday's lottery"}, want: ErrNoEntropy},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(pm, &GiftExchangeOptions{Reveal: tt.reveal}, commitment, ge)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("want: %v; got: %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package giftex
+
+import (
+	"math/rand"
+)
+
+// CostOptions enables soft preferences for a gift exchange. Instead
+// of treating every valid assignment the same, each pair is given a
+// cost and we look for an assignment with the lowest total cost.
+// Restrictions and previous assignments within MaxPrevious are still
+// hard constraints that can never be broken.
+type CostOptions struct {
+	// Repeat is the cost of repeating a previous assignment that
+	// MaxPrevious or RepeatYears allows. The cost is divided by how many years ago
+	// the assignment was made, so last year's match costs Repeat and
+	// a match from 3 years ago costs Repeat/3.
+	Repeat int
+
+	// Wish is subtracted from the cost of giving to somebody on the
+	// giver's wish list.
+	Wish int
+
+	// Tolerance is how much more than the cheapest possible total
+	// cost we are willing to accept. Every assignment we might draw
+	// costs at most the cheapest total plus Tolerance.
+	Tolerance int
+}
+
+// costScale spreads costs apart so there is room to add random noise
+// between them without changing which assignments are cheapest.
+const costScale = 1000
+
+// MaxCost is the most a single pair can cost, or save when negative.
+// ParseVerdict rejects larger costs, and anything else that adds up to
+// more is capped at MaxCost, so that even scaled up, the costs of a
+// whole assignment stay far below the cost of a forbidden pair.
+const MaxCost = 100000
+
+// addCost adds c to the cost of a pair, keeping the total within
+// MaxCost either way.
+func addCost(cost, c int64) int64 {
+	limit := func(c int64) int64 {
+		switch {
+		case c > MaxCost:
+			return MaxCost
+		case c < -MaxCost:
+			return -MaxCost
+		}
+
+		return c
+	}
+
+	return limit(limit(cost) + limit(c))
+}
+
+// pairCosts builds the cost of every pair for the participants in pm.
+// Pairs that are forbidden by matrix m cost infCost.
+func pairCosts(m matrix, pm ParticipantMap, opts CostOptions) [][]int64 {
+	n := len(m)
+	cost := make([][]int64, n)
+	for i := range cost {
+		cost[i] = make([]int64, n)
+		for j := range cost[i] {
+			if m[i][j] == 1 {
+				cost[i][j] = infCost
+			}
+		}
+	}
+
+	for id, p := range pm {
+		i := int(id)
+
+		// Walk from the most recent year back so that a repeated
+		// pair is charged for the year it happened most recently
+		charged := make(map[Pid]bool, len(p.Previous))
+		for k := len(p.Previous) - 1; k >= 0; k-- {
+			j := p.Previous[k]
+			if charged[j] || cost[i][j] == infCost {
+				continue
+			}
+
+			yearsAgo := len(p.Previous) - k
+			cost[i][j] = addCost(cost[i][j], int64(opts.Repeat/yearsAgo))
+			charged[j] = true
+		}
+
+		for _, j := range p.Wishes {
+			if cost[i][j] != infCost {
+				cost[i][j] = addCost(cost[i][j], -int64(opts.Wish))
+			}
+		}
+	}
+
+	return cost
+}
+
+// historyCosts adds the cost of repeating matches from opts.History
+// to cost, using the most recent year each pair was matched. Records
+// that RepeatYears still forbids already cost infCost.
+func historyCosts(cost [][]int64, pm ParticipantMap, opts *GiftExchangeOptions) {
+	year := opts.year()
+	latest := make(map[Pair]int)
+	historyPairs(pm, opts, opts.History, func(rec HistoryRecord, giver, recipient Pid) {
+		p := Pair{Giver: giver, Recipient: recipient}
+		if rec.Year < year && rec.Year > latest[p] {
+			latest[p] = rec.Year
+		}
+	})
+
+	for p, y := range latest {
+		i, j := int(p.Giver), int(p.Recipient)
+		if cost[i][j] != infCost {
+			cost[i][j] = addCost(cost[i][j], int64(opts.Costs.Repeat/(year-y)))
+		}
+	}
+}
+
+// assignMinCost picks an assignment at random from those whose total
+// cost is within opts.Tolerance of the cheapest one. It returns the
+// real total cost of the assignment it picked.
+//
+// We add a little random noise to every pair before running
+// Kuhn-Munkres. There are n pairs in an assignment and each adds less
+// than (Tolerance+1)/n, so the noise can never make an assignment win
+// over one that is more than Tolerance cheaper. No two assignments
+// differ by more than 2*MaxCost per pair, so a larger Tolerance is
+// capped at that.
+func assignMinCost(cost [][]int64, opts CostOptions, r *rand.Rand) (Assignment, int64) {
+	n := len(cost)
+	if n == 0 {
+		return Assignment{}, 0
+	}
+
+	tolerance := int64(opts.Tolerance)
+	if limit := 2 * MaxCost * int64(n); tolerance > limit {
+		tolerance = limit
+	}
+
+	noise := (tolerance + 1) * costScale
+	noisy := make([][]int64, n)
+	for i := range cost {
+		noisy[i] = make([]int64, n)
+		for j, c := range cost[i] {
+			if c == infCost {
+				noisy[i][j] = infCost
+				continue
+			}
+
+			noisy[i][j] = c*costScale*int64(n) + r.Int63n(noise)
+		}
+	}
+
+	assign, _ := minCostAssignment(noisy)
+
+	a := make(Assignment, n)
+	var total int64
+	for i, j := range assign {
+		a[Pid(i)] = Pid(j)
+		total += cost[i][j]
+	}
+
+	return a, total
+}
@@ -0,0 +1,181 @@
+package giftex
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func Example_costs() {
+	// foo had baz last year and bar the year before
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo", Previous: []Pid{1, 2}, Wishes: []Pid{3}},
+		1: {ID: 1, Name: "bar", Wishes: []Pid{0}},
+		2: {ID: 2, Name: "baz"},
+		3: {ID: 3, Name: "qux"},
+	}
+
+	opts := &GiftExchangeOptions{
+		MaxPrevious: 1, // Only last year's assignments are off limits
+		Costs:       &CostOptions{Repeat: 12, Wish: 20},
+	}
+
+	ge, err := NewGiftExchange(pm, opts)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(ge)
+	fmt.Println("Cost:", ge.Cost)
+
+	// Output:
+	// Results:
+	// | bar  | foo  |
+	// | baz  | bar  |
+	// | foo  | qux  |
+	// | qux  | baz  |
+	//
+	// Cost: -40
+}
+
+func TestPairCosts(t *testing.T) {
+	pm := ParticipantMap{
+		0: {ID: 0, Previous: []Pid{1, 2, 3, 1}, Wishes: []Pid{2}},
+		1: {ID: 1, Restrictions: []Pid{2}, Wishes: []Pid{2, 3}},
+		2: {ID: 2},
+		3: {ID: 3},
+	}
+
+	m := newMatrix(4)
+	m.AddConstraints(constraints{1: pm[1].Restrictions})
+
+	want := [][]int64{
+		{infCost, 12, 4 - 5, 6},
+		{0, infCost, infCost, -5},
+		{0, 0, infCost, 0},
+		{0, 0, 0, infCost},
+	}
+
+	got := pairCosts(m, pm, CostOptions{Repeat: 12, Wish: 5})
+	if fmt.Sprint(want) != fmt.Sprint(got) {
+		t.Errorf("wrong costs:\nwant: %v\n got: %v", want, got)
+	}
+}
+
+func TestAssignMinCost(t *testing.T) {
+	r := rand.New(rand.NewSource(6))
+
+	for try := 0; try < 200; try++ {
+		n := 2 + r.Intn(5)
+		m := randomMatrix(r, n, 0.2)
+		if !m.CheckConstraints() {
+			continue
+		}
+
+		cost := make([][]int64, n)
+		for i := range cost {
+			cost[i] = make([]int64, n)
+			for j := range cost[i] {
+				cost[i][j] = r.Int63n(10)
+				if m[i][j] == 1 {
+					cost[i][j] = infCost
+				}
+			}
+		}
+
+		opts := CostOptions{Tolerance: r.Intn(4)}
+
+		// Find the cheapest total and every assignment close to it
+		best := infCost
+		totals := make(map[string]int64)
+		for _, p := range bruteForce(m) {
+			var total int64
+			for i, j := range p {
+				total += cost[i][j]
+			}
+
+			totals[fmt.Sprint(p)] = total
+			if total < best {
+				best = total
+			}
+		}
+
+		near, ties := 0, 0
+		for _, total := range totals {
+			if total <= best+int64(opts.Tolerance) {
+				near++
+			}
+
+			if total == best {
+				ties++
+			}
+		}
+
+		seen := make(map[string]bool)
+		for draw := 0; draw < 20*near; draw++ {
+			a, total := assignMinCost(cost, opts, r)
+
+			p := make([]int, n)
+			for i, j := range a {
+				p[i] = int(j)
+			}
+
+			key := fmt.Sprint(p)
+			if want, ok := totals[key]; !ok || want != total {
+				t.Fatalf("invalid assignment %v costing %d", p, total)
+			}
+
+			if total > best+int64(opts.Tolerance) {
+				t.Fatalf("assignment %v costs %d; want at most %d + %d", p, total, best, opts.Tolerance)
+			}
+
+			seen[key] = true
+		}
+
+		// The draw should not always pick the same assignment
+		if ties > 1 && len(seen) < 2 {
+			t.Errorf("only drew %d of %d optimal assignments", len(seen), ties)
+		}
+	}
+}
+
+func TestNewGiftExchange_largeCost(t *testing.T) {
+	// foo may never give to bar, however much everything else costs
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo", Restrictions: []Pid{1}},
+		1: {ID: 1, Name: "bar"},
+		2: {ID: 2, Name: "baz"},
+		3: {ID: 3, Name: "qux"},
+	}
+
+	huge := RuleFunc(func(giver, recipient Participant) Verdict {
+		return Cost(math.MaxInt64)
+	})
+
+	opts := &GiftExchangeOptions{
+		Rules: []Rule{huge, huge},
+		Costs: &CostOptions{Repeat: math.MaxInt32, Tolerance: math.MaxInt32},
+	}
+
+	for seed := int64(1); seed <= 20; seed++ {
+		opts.Seed = seed
+		ge, err := NewGiftExchange(pm, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ge.Assignment[0] == 1 {
+			t.Fatalf("foo gives to bar: %v", ge.Assignment)
+		}
+
+		if ge.Cost != 4*MaxCost {
+			t.Errorf("want a cost of %d; got: %d", 4*MaxCost, ge.Cost)
+		}
+	}
+
+	if _, err := ParseVerdict(fmt.Sprint(MaxCost + 1)); !errors.Is(err, ErrCostTooLarge) {
+		t.Errorf("want ErrCostTooLarge; got: %v", err)
+	}
+}
@@ -0,0 +1,380 @@
+package giftex
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// minCycleLength returns the shortest loop of givers allowed by opts
+// for an exchange with n participants. Nobody can give to themselves,
+// so every loop always has at least 2 people.
+func (opts *GiftExchangeOptions) minCycleLength(n int) int {
+	length := 2
+	if opts == nil {
+		return length
+	}
+
+	if opts.MinCycleLength > length {
+		length = opts.MinCycleLength
+	}
+
+	if opts.NoMutualPairs && length < 3 {
+		length = 3
+	}
+
+	if opts.SingleCycle && length < n {
+		length = n
+	}
+
+	return length
+}
+
+const (
+	// cycleTries is how many random assignments to draw while looking
+	// for one with long enough cycles before searching for one instead.
+	cycleTries = 200
+
+	// cycleSearchSteps limits how long each search may take.
+	cycleSearchSteps = 100000
+	cycleSearchTries = 10
+)
+
+// assignCycles picks an assignment at random from matrix m where
+// every cycle of givers includes at least minLen people. When such
+// assignments are common they are all equally likely to be drawn.
+// Otherwise we fall back to joining short cycles together and then to
+// a randomized search, which can find rare assignments but don't
+// guarantee a uniform draw. The search gives up eventually, so when
+// found is false, exhaustive reports whether there really is no
+// assignment.
+func (m matrix) assignCycles(minLen int, r *rand.Rand) (a Assignment, found, exhaustive bool) {
+	g := m.bipartite()
+	if minLen > len(g) {
+		return nil, false, true
+	}
+
+	// A random assignment is hardly ever a single loop, so loops are
+	// built directly instead
+	if minLen == len(g) {
+		if next, ok := m.assignRing(r); ok {
+			return toAssignment(next), true, false
+		}
+	}
+
+	for try := 0; try < cycleTries; try++ {
+		matchG, ok := g.sample(r)
+		if !ok {
+			return nil, false, true
+		}
+
+		if shortestCycle(matchG) >= minLen {
+			return toAssignment(matchG), true, false
+		}
+
+		if try < cycleSearchTries && m.joinCycles(g, matchG, minLen, r) {
+			return toAssignment(matchG), true, false
+		}
+	}
+
+	for try := 0; try < cycleSearchTries; try++ {
+		matchG, found, exhaustive := g.searchCycles(minLen, r)
+		if found {
+			return toAssignment(matchG), true, false
+		}
+
+		// There is no point in trying again after checking everything
+		if exhaustive {
+			return nil, false, true
+		}
+	}
+
+	return nil, false, false
+}
+
+// assignRing links everybody in m into a single loop by shuffling
+// them and giving to whoever comes next, which draws every loop that
+// m allows with the same chance. It fails when m rules out so many
+// pairs that no shuffle works.
+func (m matrix) assignRing(r *rand.Rand) (next []int, ok bool) {
+	n := len(m)
+	next = make([]int, n)
+
+tries:
+	for try := 0; try < cycleTries; try++ {
+		order := r.Perm(n)
+		for k, i := range order {
+			j := order[(k+1)%n]
+			if m[i][j] != 0 {
+				continue tries
+			}
+
+			next[i] = j
+		}
+
+		return next, true
+	}
+
+	return nil, false
+}
+
+// joinCycles joins the cycles of matchG together until every cycle
+// has at least minLen people. When u gives to x and v gives to w in
+// another cycle, u can give to w and v to x instead, as long as m
+// allows it, which makes a single cycle out of the two. It fails when
+// a short cycle can't be joined with any other.
+func (m matrix) joinCycles(g bipartite, matchG []int, minLen int, r *rand.Rand) bool {
+	n := len(matchG)
+	prev := make([]int, n)
+	for i, j := range matchG {
+		prev[j] = i
+	}
+
+	cycle := make([]int, n)
+	size := make([]int, n)
+	for {
+		// Number the cycles
+		for i := range cycle {
+			cycle[i] = unmatched
+		}
+
+		short := false
+		for i := range matchG {
+			if cycle[i] != unmatched {
+				continue
+			}
+
+			size[i] = 0
+			for j := i; cycle[j] == unmatched; j = matchG[j] {
+				cycle[j] = i
+				size[i]++
+			}
+
+			short = short || size[i] < minLen
+		}
+
+		if !short {
+			return true
+		}
+
+		joined := false
+		for _, u := range r.Perm(n) {
+			if size[cycle[u]] >= minLen {
+				continue
+			}
+
+			choices := g[u]
+			offset := r.Intn(len(choices))
+			for k := range choices {
+				w := choices[(k+offset)%len(choices)]
+				x, v := matchG[u], prev[w]
+				if cycle[w] == cycle[u] || m[v][x] != 0 {
+					continue
+				}
+
+				matchG[u], matchG[v] = w, x
+				prev[w], prev[x] = u, v
+				joined = true
+				break
+			}
+
+			if joined {
+				break
+			}
+		}
+
+		if !joined {
+			return false
+		}
+	}
+}
+
+// searchCycles looks for an assignment with cycles of at least
+// minLen people by trying recipients for one giver at a time in a
+// random order. The search gives up after cycleSearchSteps, so
+// exhaustive reports whether every possibility was checked.
+func (g bipartite) searchCycles(minLen int, r *rand.Rand) (matchG []int, found, exhaustive bool) {
+	n := len(g)
+	matchG = make([]int, n)
+	taken := make([]bool, n)
+
+	// Every assigned pair links two chains of givers together. Only the
+	// ends of each chain need to be tracked: start[i] is the start of
+	// the chain ending with i, while end[j] and size[j] are the end and
+	// number of people in the chain starting with j.
+	start := make([]int, n)
+	end := make([]int, n)
+	size := make([]int, n)
+	for i := range g {
+		matchG[i] = unmatched
+		start[i], end[i], size[i] = i, i, 1
+	}
+
+	// Givers with the fewest choices go first so we fail fast
+	order := r.Perm(n)
+	sort.SliceStable(order, func(a, b int) bool {
+		return len(g[order[a]]) < len(g[order[b]])
+	})
+
+	steps := 0
+	var walk func(k int) bool
+	walk = func(k int) bool {
+		if k == n {
+			return true
+		}
+
+		if steps++; steps > cycleSearchSteps {
+			return false
+		}
+
+		i := order[k]
+		choices := append([]int(nil), g[i]...)
+		r.Shuffle(len(choices), func(a, b int) {
+			choices[a], choices[b] = choices[b], choices[a]
+		})
+
+		for _, j := range choices {
+			if taken[j] {
+				continue
+			}
+
+			s, e := start[i], end[j]
+			if j == s && size[s] < minLen {
+				continue // This would close a cycle that is too short
+			}
+
+			matchG[i], taken[j] = j, true
+
+			if j == s {
+				if walk(k + 1) {
+					return true
+				}
+			} else {
+				oldSize := size[s]
+				end[s], start[e], size[s] = e, s, oldSize+size[j]
+
+				if walk(k + 1) {
+					return true
+				}
+
+				end[s], start[e], size[s] = i, j, oldSize
+			}
+
+			matchG[i], taken[j] = unmatched, false
+		}
+
+		return false
+	}
+
+	found = walk(0)
+	return matchG, found, steps <= cycleSearchSteps
+}
+
+// shortestCycle returns the length of the shortest cycle in matchG.
+func shortestCycle(matchG []int) int {
+	shortest := len(matchG)
+	visited := make([]bool, len(matchG))
+	for i := range matchG {
+		length := 0
+		for j := i; !visited[j]; j = matchG[j] {
+			visited[j] = true
+			length++
+		}
+
+		if length > 0 && length < shortest {
+			shortest = length
+		}
+	}
+
+	return shortest
+}
+
+func toAssignment(matchG []int) Assignment {
+	a := make(Assignment, len(matchG))
+	for i, j := range matchG {
+		a[Pid(i)] = Pid(j)
+	}
+
+	return a
+}
+
+// Cycles returns the cycle decomposition of assignment a. Each cycle
+// lists participants in the order gifts are passed, starting with the
+// smallest Pid, and the cycles are sorted by their first participant.
+// A chain of givers that never loops back is included as it is,
+// starting with the giver nobody gives to.
+func (a Assignment) Cycles() [][]Pid {
+	givers := make([]Pid, 0, len(a))
+	receives := make(map[Pid]bool, len(a))
+	for p, q := range a {
+		givers = append(givers, p)
+		receives[q] = true
+	}
+	sort.Slice(givers, func(i, j int) bool {
+		return givers[i] < givers[j]
+	})
+
+	visited := make(map[Pid]bool, len(a))
+	var cycles [][]Pid
+	follow := func(p Pid) {
+		var cycle []Pid
+		for ok := true; ok && !visited[p]; p, ok = a[p] {
+			visited[p] = true
+			cycle = append(cycle, p)
+		}
+
+		cycles = append(cycles, cycle)
+	}
+
+	// Chains have to be followed from their start, otherwise they
+	// come out in pieces. Whatever is left over are closed cycles.
+	for _, p := range givers {
+		if !receives[p] {
+			follow(p)
+		}
+	}
+
+	for _, p := range givers {
+		if !visited[p] {
+			follow(p)
+		}
+	}
+
+	sort.Slice(cycles, func(i, j int) bool {
+		return cycles[i][0] < cycles[j][0]
+	})
+
+	return cycles
+}
+
+// CycleNames describes the order gifts are passed around each cycle
+// of the gift exchange, e.g., "bar -> baz -> foo -> bar". There is no
+// single order to pass gifts in when everyone gives more than one, so
+// CycleNames returns nil in that case.
+func (ge *GiftExchange) CycleNames() []string {
+	for _, has := range ge.Recipients {
+		if len(has) > 1 {
+			return nil
+		}
+	}
+
+	cycles := ge.Assignment.Cycles()
+	names := make([]string, 0, len(cycles))
+	for _, cycle := range cycles {
+		var b strings.Builder
+		for _, p := range cycle {
+			fmt.Fprintf(&b, "%s -> ", ge.participants[p].Name)
+		}
+
+		// Close the loop unless the chain is broken
+		last := cycle[len(cycle)-1]
+		if next, ok := ge.Assignment[last]; ok {
+			b.WriteString(ge.participants[next].Name)
+		}
+
+		names = append(names, strings.TrimSuffix(b.String(), " -> "))
+	}
+
+	return names
+}
@@ -0,0 +1,214 @@
+package giftex
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func Example_singleCycle() {
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo", Restrictions: []Pid{1}},
+		1: {ID: 1, Name: "bar", Restrictions: []Pid{0, 3}},
+		2: {ID: 2, Name: "baz", Restrictions: []Pid{1, 3}},
+		3: {ID: 3, Name: "qux", Restrictions: []Pid{2}},
+	}
+
+	ge, err := NewGiftExchange(pm, &GiftExchangeOptions{SingleCycle: true})
+	if err != nil {
+		panic(err)
+	}
+
+	for _, c := range ge.CycleNames() {
+		fmt.Println(c)
+	}
+
+	// Output:
+	// foo -> qux -> bar -> baz -> foo
+}
+
+func TestAssignment_Cycles(t *testing.T) {
+	tests := []struct {
+		a    Assignment
+		want string
+	}{
+		{a: Assignment{}, want: "[]"},
+		{a: Assignment{0: 1, 1: 0}, want: "[[0 1]]"},
+		{a: Assignment{0: 2, 1: 3, 2: 0, 3: 1}, want: "[[0 2] [1 3]]"},
+		{a: Assignment{3: 0, 0: 4, 4: 1, 1: 2, 2: 3}, want: "[[0 4 1 2 3]]"},
+		{a: Assignment{0: 1, 1: 2}, want: "[[0 1 2]]"},
+		{a: Assignment{2: 1, 1: 0}, want: "[[2 1 0]]"},
+		{a: Assignment{0: 1, 1: 0, 4: 3, 3: 2}, want: "[[0 1] [4 3 2]]"},
+	}
+
+	for _, tt := range tests {
+		if got := fmt.Sprint(tt.a.Cycles()); tt.want != got {
+			t.Errorf("wrong cycles for %v:\nwant: %s; got: %s", tt.a, tt.want, got)
+		}
+	}
+}
+
+func TestMatrix_assignCycles_bruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+
+	for try := 0; try < 300; try++ {
+		n := 2 + r.Intn(6)
+		m := randomMatrix(r, n, r.Float64()*0.6)
+		minLen := 3 + r.Intn(n)
+
+		var want int
+		for _, p := range bruteForce(m) {
+			if shortestCycle(p) >= minLen {
+				want++
+			}
+		}
+
+		a, ok, exhaustive := m.assignCycles(minLen, r)
+		if !ok && !exhaustive {
+			t.Fatalf("gave up for min length %d and matrix:\n%v", minLen, m)
+		}
+
+		if ok != (want > 0) {
+			t.Fatalf("want solution: %v; got: %v for min length %d and matrix:\n%v", want > 0, ok, minLen, m)
+		}
+
+		if !ok {
+			continue
+		}
+
+		p := make([]int, n)
+		for i, j := range a {
+			p[i] = int(j)
+		}
+
+		if !isPerfect(p, m.bipartite().allowed()) || shortestCycle(p) < minLen {
+			t.Fatalf("invalid assignment %v for min length %d and matrix:\n%v", p, minLen, m)
+		}
+	}
+}
+
+func TestBipartite_searchCycles(t *testing.T) {
+	r := rand.New(rand.NewSource(8))
+
+	// A ring where everyone may only give to their two neighbors
+	// ahead of them has very few single cycles
+	n := 30
+	m := newMatrix(n)
+	for i := range m {
+		for j := range m[i] {
+			if j != (i+1)%n && j != (i+2)%n {
+				m[i][j] = 1
+			}
+		}
+	}
+
+	matchG, found, _ := m.bipartite().searchCycles(n, r)
+	if !found {
+		t.Fatal("want a single cycle")
+	}
+
+	if !isPerfect(matchG, m.bipartite().allowed()) || shortestCycle(matchG) != n {
+		t.Errorf("invalid single cycle: %v", matchG)
+	}
+}
+
+func TestMatrix_assignCycles_uniform(t *testing.T) {
+	r := rand.New(rand.NewSource(9))
+	m := newMatrix(5)
+
+	var single [][]int
+	for _, p := range bruteForce(m) {
+		if shortestCycle(p) == 5 {
+			single = append(single, p)
+		}
+	}
+
+	checkUniform(t, single, func() []int {
+		a, _, _ := m.assignCycles(5, r)
+		p := make([]int, len(a))
+		for i, j := range a {
+			p[i] = int(j)
+		}
+
+		return p
+	})
+}
+
+// ringParticipants returns n participants who may each give to the
+// next person around a ring and to extra others picked at random, so
+// there's always at least one single loop. With at least n extra,
+// anybody may give to anybody.
+func ringParticipants(r *rand.Rand, n, extra int) ParticipantMap {
+	pm := make(ParticipantMap, n)
+	for i := 0; i < n; i++ {
+		allowed := []Pid{Pid((i + 1) % n)}
+		if extra >= n {
+			allowed = nil
+		}
+
+		for k := 0; k < extra && extra < n; k++ {
+			allowed = append(allowed, Pid(r.Intn(n)))
+		}
+
+		pm[Pid(i)] = Participant{ID: Pid(i), Name: fmt.Sprint("p", i), Allowed: allowed}
+	}
+
+	return pm
+}
+
+func TestNewGiftExchange_singleCycleRing(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, tt := range []struct{ n, extra int }{
+		{80, 0},
+		{150, 9},
+		{MaxMatrixParticipants, MaxMatrixParticipants},
+	} {
+		pm := ringParticipants(r, tt.n, tt.extra)
+		for seed := int64(1); seed <= 5; seed++ {
+			ge, err := NewGiftExchange(pm, &GiftExchangeOptions{SingleCycle: true, Seed: seed})
+			if err != nil {
+				t.Fatalf("%d people with %d extra each, seed %d: %v", tt.n, tt.extra, seed, err)
+			}
+
+			if cycles := ge.Assignment.Cycles(); len(cycles) != 1 {
+				t.Fatalf("want a single loop; got %d", len(cycles))
+			}
+		}
+	}
+}
+
+func TestNewGiftExchange_cyclesGaveUp(t *testing.T) {
+	// Two halves that can only give among themselves never make a
+	// single loop, but there are far too many ways to try them all
+	n := 20
+	pm := make(ParticipantMap, n)
+	for i := 0; i < n; i++ {
+		var allowed []Pid
+		for j := i / (n / 2) * (n / 2); j < (i/(n/2)+1)*(n/2); j++ {
+			allowed = append(allowed, Pid(j))
+		}
+
+		pm[Pid(i)] = Participant{ID: Pid(i), Name: fmt.Sprint("p", i), Allowed: allowed}
+	}
+
+	opts := &GiftExchangeOptions{SingleCycle: true}
+	_, err := NewGiftExchange(pm, opts)
+	if !errors.Is(err, ErrGaveUp) || errors.Is(err, ErrNoSolution) {
+		t.Errorf("want ErrGaveUp; got: %v", err)
+	}
+
+	// There may be nothing to fix
+	if r := SuggestRelaxations(pm, opts); len(r) > 0 {
+		t.Errorf("want no suggestions; got: %v", r)
+	}
+}
+
+func BenchmarkNewGiftExchange_singleCycle(b *testing.B) {
+	pm := ringParticipants(rand.New(rand.NewSource(1)), MaxMatrixParticipants, MaxMatrixParticipants)
+	for i := 0; i < b.N; i++ {
+		if _, err := NewGiftExchange(pm, &GiftExchangeOptions{SingleCycle: true, Seed: int64(i + 1)}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
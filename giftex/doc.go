@@ -2,12 +2,135 @@
    Package giftex provides an implementation of the Kuhn-Munkres
    assignment algorithm for creating gift exchanges.
 
-   Kuhn-Munkres, or the "Hungarian Algorithm" allows us to easily
-   determine whether an assignment exists that can satisfy all
-   constraints of the given gift exchange.
+   A gift exchange is modeled as a bipartite graph between givers and
+   recipients, where every pair that isn't restricted is an edge. An
+   assignment exists exactly when this graph has a perfect matching,
+   which we find using the Hopcroft-Karp algorithm.
+
+   Most people only have a few restrictions, so nearly every pair is
+   an edge. Plain gift exchanges only list the pairs that are
+   restricted, look up groups, pins, and allow-lists as they go, and
+   find the matching by letting everybody take the first recipient
+   they're allowed, then fixing up whoever is left, which takes close
+   to linear time and memory for exchanges of 100,000 people. Costs,
+   cycles, roles, swaps, rules, and several gifts per person still
+   look at every pair, so they're limited to
+   MaxMatrixParticipants and fail with ErrTooManyParticipants beyond
+   that.
 
    Once the set of constraints are verified, a final assignment is
-   made at random because a completely deterministic gift exchange
-   would spoil the fun.
+   drawn at random because a completely deterministic gift exchange
+   would spoil the fun. Every valid assignment is equally likely to be
+   drawn, so nobody is more likely to get somebody just because of the
+   order they were entered in. That's exact for small gift exchanges
+   and for ones where a random permutation is usually valid. Large and
+   heavily constrained gift exchanges are shuffled with a Markov chain
+   instead, which is only approximately uniform: it runs for a fixed
+   number of steps and, depending on the constraints, may not be able
+   to reach every assignment.
+
+   Draws use a cryptographically secure source of randomness by
+   default. Setting GiftExchangeOptions.Seed instead makes a draw
+   reproducible, so a disputed draw can be replayed exactly. Seeded
+   draws also come with a Commitment that can be shared before the
+   seed is revealed, and Verify checks the results against it. An
+   organizer who picks the seed can still try many before sharing a
+   commitment, so GiftExchangeOptions.Reveal commits to a secret
+   instead and draws with entropy that's only published afterwards.
+
+   Tight constraints can make a draw easy to guess. PairProbabilities
+   works out how likely each pair is, counting every assignment of
+   small gift exchanges and sampling draws for larger ones, so pairs
+   that are forced or almost certain can be flagged before the draw.
+
+   Participant.Previous only counts entries, so skipping a year or
+   drawing twice in one year throws off MaxPrevious. History keeps
+   dated records of who gave to who instead, and RepeatYears keeps
+   people apart for a number of years, optionally in both directions.
+
+   NewSchedule plans several years, or several rounds of one event,
+   at once without repeating a match. It shares its engine with
+   GiftsPerPerson, and MaxRounds reports how many rounds are possible.
+
+   When somebody drops out or joins late, RepairGiftExchange keeps as
+   many of the old pairs as it can and lists the givers whose recipient
+   changed, so EmailService.SendEmailsTo only has to email them.
+
+   Gift exchanges with soft preferences, such as wish lists or
+   avoiding repeats from a few years ago, can set CostOptions instead.
+   Every pair is then given a cost and Kuhn-Munkres finds an
+   assignment with the lowest total cost, picking at random between
+   assignments that cost about the same.
+
+   When everyone gives more than one gift, GiftsPerPerson rounds are
+   drawn so that no pair ever repeats. If drawing one round after
+   another gets stuck, the rounds are found together as a k-factor of
+   the graph and split back apart into perfect matchings.
+
+   Participant.Role lets some people only give or only receive. The
+   number of givers and recipients no longer has to match, so the
+   gifts are spread as evenly as possible, or only to people who don't
+   give when ExtraGifts is set, and the pairs are found as a subgraph
+   with a bounded number of pairs per person.
+
+   With Swap set, people are paired up to swap gifts with each other.
+   Everybody is on the same side, so the bipartite model doesn't fit
+   and pairs are found as a perfect matching of a general graph using
+   Edmonds' blossom algorithm. With an odd number of people, three of
+   them give to each other in a loop instead. Somebody in the loop is
+   always left out by some maximum matching of the whole graph, so
+   only those people are tried as the start of a loop.
+
+   Groups, such as departments at work, are restrictions on everybody
+   in the group, and GroupRules or Participant.GivesTo make a group
+   give to one other group. The usual reason these are impossible is
+   a group that's too big, so GroupCapacityError checks that first.
+
+   Participant.Pinned forces pairs and Participant.Allowed limits
+   somebody to a short list. Both are restrictions on everybody else,
+   so they fit the same model, but pins take priority over groups and
+   previous matches. Pins that contradict each other are explained by
+   a PinError, and NoSolutionError.Limited lists the pins and
+   allow-lists that keep an assignment from being found.
+
+   Rules look at a giver and a recipient and forbid the pair or give
+   it a cost, so new kinds of constraints don't need changes to the
+   package. Forbidden pairs are restrictions like any other, and costs
+   are added to the costs of CostOptions. RegisterRule makes a rule
+   available to ParseRule by name, which is how the command line and
+   the website configure them.
+
+   Participant.Attributes hold every column of the CSV that isn't
+   read by the package, such as shirt sizes. Nothing uses them except
+   Rules, like "same:city", and the emails, where TmplData.Assigned
+   lets a giver see their recipient's attributes.
+
+   A Schema describes CSVs laid out differently, such as an HR export
+   with other headers, fewer columns, or another delimiter. Headers
+   are resolved to the columns ReadCSV knows before anything is read,
+   so the rest of the package never sees the difference, and WriteCSV
+   keeps the original layout.
+
+   Files don't have to be CSVs either. A Codec turns a file into
+   records and back, and TSV, JSON, and XLSX are built in, so
+   Schema.ReadFile and GiftExchangeDB.Write read and write
+   spreadsheets the same way as CSVs. Other formats can be added with
+   RegisterFormat.
+
+   ReadCSV checks every row as it reads it. Mistakes it can't work
+   around, such as duplicate names, are returned together in a
+   ValidationError, and anything it ignores is kept in the Report of
+   the GiftExchangeDB, each with the row and column it came from.
+
+   Import is what ReadCSV uses underneath. It reads a file one record
+   at a time through a RecordReader, so large files can be limited by
+   size or rows before they've been read completely, and it reports
+   its progress as it goes.
+
+   Programs that don't keep their participants in a CSV can use a
+   Builder instead. It identifies everybody by an external ID, takes
+   care of numbering them, and reports mistakes such as an unknown ID
+   as an IDError, so the same gift exchange can be drawn, stored, and
+   verified later by ID alone.
 */
 package giftex
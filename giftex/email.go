@@ -24,10 +24,45 @@ type Email struct {
 
 type TmplData struct {
 	SubjectName, AssignedName string
+
+	// AssignedNames lists every recipient when participants give more
+	// than one gift. AssignedName joins them together, e.g., "bar and baz".
+	AssignedNames []string
+
+	// Assigned lists every recipient in the same order as
+	// AssignedNames, so emails can include anything else about them,
+	// e.g., {{range .Assigned}}{{.Attribute "shirt size"}}{{end}}
+	Assigned []Participant
+
+	// Swap is true when participants swap gifts with each other, in
+	// which case GiverName is who gives to the subject. It's the same
+	// as AssignedName except for the three people in a loop.
+	Swap      bool
+	GiverName string
+
+	// Commitment lets participants verify a seeded draw later
+	Commitment string
+}
+
+func newTmplData(participants ParticipantMap, subject Participant, assigned []Pid) TmplData {
+	names := make([]string, 0, len(assigned))
+	recipients := make([]Participant, 0, len(assigned))
+	for _, id := range assigned {
+		names = append(names, participants[id].Name)
+		recipients = append(recipients, participants[id])
+	}
+
+	return TmplData{
+		SubjectName:   subject.Name,
+		AssignedName:  joinNames(names),
+		AssignedNames: names,
+		Assigned:      recipients,
+	}
 }
 
 type BulkTmplData struct {
-	Entries []TmplData
+	Entries    []TmplData
+	Commitment string
 }
 
 type EmailService struct {
@@ -77,14 +112,57 @@ type FailedEmail struct {
 	Err   error
 }
 
-// SendEmails will build and send emails using the provided Mailer and return a list of any failed deliveries
-func (svc *EmailService) SendEmails(participants ParticipantMap, results Assignment) ([]FailedEmail, error) {
-	emails := make([]Email, 0, len(results))
+// SendEmails will build and send emails using the provided Mailer and return a list of any failed deliveries.
+// When results is a seeded *GiftExchange, every email includes its Commitment.
+func (svc *EmailService) SendEmails(participants ParticipantMap, results Results) ([]FailedEmail, error) {
+	return svc.sendEmails(participants, results, results.Multi())
+}
+
+// SendEmailsTo works like SendEmails but only emails the givers listed,
+// such as the givers that need to be re-notified after a repair.
+func (svc *EmailService) SendEmailsTo(participants ParticipantMap, results Results, givers []Pid) ([]FailedEmail, error) {
+	all := results.Multi()
+	assignments := make(MultiAssignment, len(givers))
+	for _, id := range givers {
+		if recipients, ok := all[id]; ok {
+			assignments[id] = recipients
+		}
+	}
+
+	return svc.sendEmails(participants, results, assignments)
+}
+
+// sendEmails emails everybody in assignments who they have.
+func (svc *EmailService) sendEmails(participants ParticipantMap, results Results, assignments MultiAssignment) ([]FailedEmail, error) {
+	var commitment string
+	var swap bool
+	givers := make(map[Pid]Pid)
+	if ge, ok := results.(*GiftExchange); ok {
+		commitment = ge.Commitment
+		swap = ge.Swap
+		for giver, recipient := range ge.Assignment {
+			givers[recipient] = giver
+		}
+	}
+
+	// newData fills in the template data shared by every email
+	newData := func(p Participant) TmplData {
+		data := newTmplData(participants, p, assignments[p.ID])
+		data.Commitment = commitment
+		if swap {
+			data.Swap = true
+			data.GiverName = participants[givers[p.ID]].Name
+		}
+
+		return data
+	}
+
+	emails := make([]Email, 0, len(assignments))
 
 	// Find participants using the same email address so we can send
 	// one email with all their assignments in it
 	groupByEmail := make(map[string][]Participant)
-	for pid := range results {
+	for pid := range assignments {
 		p := participants[pid]
 		groupByEmail[p.Email] = append(groupByEmail[p.Email], p)
 	}
@@ -93,12 +171,7 @@ func (svc *EmailService) SendEmails(participants ParticipantMap, results Assignm
 		// Build regular emails
 		if len(entries) == 1 {
 			subject := entries[0]
-			assigned := participants[results[subject.ID]]
-
-			data := TmplData{
-				SubjectName:  subject.Name,
-				AssignedName: assigned.Name,
-			}
+			data := newData(subject)
 
 			mail, err := NewEmail(subject.Email, svc.sender, svc.subject, svc.textTmpl, svc.htmlTmpl, data)
 			if err != nil {
@@ -110,12 +183,9 @@ func (svc *EmailService) SendEmails(participants ParticipantMap, results Assignm
 		}
 
 		// Build bulk emails
-		var data BulkTmplData
+		data := BulkTmplData{Commitment: commitment}
 		for _, p := range entries {
-			data.Entries = append(data.Entries, TmplData{
-				SubjectName:  p.Name,
-				AssignedName: participants[results[p.ID]].Name,
-			})
+			data.Entries = append(data.Entries, newData(p))
 		}
 
 		// Sort entries by name before building email
@@ -0,0 +1,368 @@
+package giftex
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Pair is a single giver -> recipient pairing.
+type Pair struct {
+	Giver, Recipient Pid
+}
+
+// NoSolutionError explains why a gift exchange has no solution.
+//
+// By Hall's marriage theorem, an assignment is impossible exactly
+// when some group of people has fewer options than members. Either
+// the Givers have fewer Recipients to choose from than there are
+// Givers, or when ByRecipient is set, the Recipients have fewer
+// Givers who are allowed to give to them than there are Recipients.
+//
+// Restrictions, Groups, Rules, and Previous list the pairs that keep
+// the group from looking outside of itself, and Limited lists the
+// givers whose pins or allow-lists do the same. Removing some of them
+// is the only way to make the gift exchange possible.
+//
+// Restrictions are the ones participants listed themselves, or their
+// partners did when SymmetricRestrictions is set. Groups are kept
+// apart by sharing a Group, or by Participant.GivesTo or GroupRules,
+// and Rules are forbidden by GiftExchangeOptions.Rules. See
+// DescribeGroup and DescribeRule.
+type NoSolutionError struct {
+	Givers       []Pid
+	Recipients   []Pid
+	ByRecipient  bool
+	Restrictions []Pair
+	Groups       []Pair
+	Rules        []Pair
+	Previous     []Pair
+	Limited      []Pid
+
+	participants ParticipantMap
+	targets      map[Pid]string
+	rules        []Rule
+}
+
+func (e *NoSolutionError) Error() string {
+	givers := e.names(e.Givers)
+	recipients := e.names(e.Recipients)
+
+	switch {
+	case !e.ByRecipient && len(e.Recipients) == 0:
+		return fmt.Sprintf("No Solution: %s %s nobody left to give to", givers, hasOrHave(e.Givers))
+	case !e.ByRecipient:
+		return fmt.Sprintf("No Solution: %s can only give to %s", givers, recipients)
+	case len(e.Givers) == 0:
+		return fmt.Sprintf("No Solution: nobody is able to give to %s", recipients)
+	default:
+		return fmt.Sprintf("No Solution: %s can only receive from %s", recipients, givers)
+	}
+}
+
+// Is allows errors.Is(err, ErrNoSolution) to match a NoSolutionError.
+func (e *NoSolutionError) Is(target error) bool {
+	return target == ErrNoSolution
+}
+
+// Name returns the name of participant id.
+func (e *NoSolutionError) Name(id Pid) string {
+	return e.participants[id].Name
+}
+
+// DescribeLimit explains the pins or allow-list of participant id in
+// plain English, e.g., "foo only gives to one of bar and baz".
+func (e *NoSolutionError) DescribeLimit(id Pid) string {
+	p := e.participants[id]
+	if len(p.Pinned) > 0 {
+		return fmt.Sprintf("%s always gives to %s", p.Name, joinNames(pidNames(e.participants, p.Pinned)))
+	}
+
+	return fmt.Sprintf("%s only gives to %s", p.Name, oneOf(pidNames(e.participants, p.Allowed)))
+}
+
+// DescribeGroup explains why a pair in Groups is kept apart, e.g.,
+// "foo and bar are both in smith" or "foo only gives to somebody in
+// sales".
+func (e *NoSolutionError) DescribeGroup(p Pair) string {
+	giver, recipient := e.participants[p.Giver], e.participants[p.Recipient]
+	if sameGroup(giver, recipient) {
+		return fmt.Sprintf("%s and %s are both in %s", giver.Name, recipient.Name, strings.TrimSpace(giver.Group))
+	}
+
+	// Targets are lowercase, so show how the group is usually written
+	to := e.targets[p.Giver]
+	for _, id := range drawOrder(e.participants) {
+		if x := e.participants[id]; trimLower(x.Group) == to {
+			to = strings.TrimSpace(x.Group)
+			break
+		}
+	}
+
+	return fmt.Sprintf("%s only gives to somebody in %s", giver.Name, to)
+}
+
+// DescribeRule explains which rule forbids a pair in Rules, e.g.,
+// `the rule "same:city" keeps foo from giving to bar`.
+func (e *NoSolutionError) DescribeRule(p Pair) string {
+	giver, recipient := e.participants[p.Giver], e.participants[p.Recipient]
+	for _, r := range e.rules {
+		if s, ok := r.(fmt.Stringer); ok && r.Judge(giver, recipient).Forbidden {
+			return fmt.Sprintf("the rule %q keeps %s from giving to %s", s.String(), giver.Name, recipient.Name)
+		}
+	}
+
+	return fmt.Sprintf("a rule keeps %s from giving to %s", giver.Name, recipient.Name)
+}
+
+func (e *NoSolutionError) names(ids []Pid) string {
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = e.Name(id)
+	}
+
+	return joinNames(names)
+}
+
+// joinNames lists names in plain English, e.g., "foo, bar and baz".
+func joinNames(names []string) string {
+	switch n := len(names); n {
+	case 0:
+		return ""
+	case 1:
+		return names[0]
+	default:
+		return strings.Join(names[:n-1], ", ") + " and " + names[n-1]
+	}
+}
+
+func hasOrHave(ids []Pid) string {
+	if len(ids) == 1 {
+		return "has"
+	}
+
+	return "have"
+}
+
+// explainNoSolution finds a group of participants that violates
+// Hall's condition for the constraints in s. Starting from a giver
+// (or recipient) left out of a maximum matching, every other
+// participant reachable by an alternating path belongs to the same
+// group, and the group always has exactly one more member than it has
+// options. Of the groups found from everybody left out of one maximum
+// matching, the smallest is reported, though a different matching
+// could find a smaller one.
+func explainNoSolution(s sparse, pm ParticipantMap, opts *GiftExchangeOptions, restrictions, previous constraints) *NoSolutionError {
+	n := len(s.rows)
+	matchG, matchR, _ := s.maxMatching()
+
+	// alternate walks from root along the pairs allowed and then back
+	// along the matching until no new participants can be reached.
+	alternate := func(root int, forward bool, match []int) (from, to []bool) {
+		from, to = make([]bool, n), make([]bool, n)
+		from[root] = true
+
+		s.alternate(root, forward, match, func(_, b int) bool {
+			to[b] = true
+			if k := match[b]; k != unmatched {
+				from[k] = true
+			}
+
+			return true
+		})
+
+		return from, to
+	}
+
+	var best *NoSolutionError
+	consider := func(err *NoSolutionError) {
+		if best == nil || len(err.Givers)+len(err.Recipients) < len(best.Givers)+len(best.Recipients) {
+			best = err
+		}
+	}
+
+	for i := range matchG {
+		if matchG[i] != unmatched {
+			continue
+		}
+
+		givers, recipients := alternate(i, true, matchR)
+		consider(&NoSolutionError{
+			Givers:     members(givers),
+			Recipients: members(recipients),
+		})
+	}
+
+	for j := range matchR {
+		if matchR[j] != unmatched {
+			continue
+		}
+
+		recipients, givers := alternate(j, false, matchG)
+		consider(&NoSolutionError{
+			Givers:      members(givers),
+			Recipients:  members(recipients),
+			ByRecipient: true,
+		})
+	}
+
+	if best == nil {
+		return nil
+	}
+
+	// Pairs ruled out by somebody's pins or allow-list can't be
+	// dropped on their own, so they're listed by giver instead
+	limited := make(map[Pid]bool)
+	withoutLimits := func(pairs []Pair) []Pair {
+		kept := pairs[:0]
+		for _, p := range pairs {
+			if limitAllows(pm[p.Giver], p.Recipient) {
+				kept = append(kept, p)
+			} else {
+				limited[p.Giver] = true
+			}
+		}
+
+		return kept
+	}
+
+	best.participants = pm
+	best.targets = groupTargets(pm, opts)
+	best.rules = opts.rules()
+	best.Previous = withoutLimits(blockingPairs(best, previous))
+
+	// Restrictions are merged with rules, so sort them out again by
+	// where they came from. Pairs in the same group are listed with
+	// the rest of the groups below.
+	symmetric := opts != nil && opts.SymmetricRestrictions
+	restricted := func(giver, recipient Pid) bool {
+		return containsPid(pm[giver].Restrictions, recipient) ||
+			(symmetric && containsPid(pm[recipient].Restrictions, giver))
+	}
+
+	groups := groupsAllow(pm, opts)
+	for _, p := range withoutLimits(blockingPairs(best, restrictions)) {
+		switch {
+		case restricted(p.Giver, p.Recipient):
+			best.Restrictions = append(best.Restrictions, p)
+		case groups(p.Giver, p.Recipient):
+			best.Rules = append(best.Rules, p)
+		}
+	}
+
+	// Groups, pins, and allow-lists aren't listed as constraints, so
+	// look for them among every pair leaving the group
+	best.Groups = withoutLimits(blockedPairs(best, n, func(giver, recipient Pid) bool {
+		return !groups(giver, recipient) && !pinned(pm, opts, giver, recipient) && !restricted(giver, recipient)
+	}))
+	for _, p := range blockedPairs(best, n, func(giver, recipient Pid) bool {
+		return !limitAllows(pm[giver], recipient)
+	}) {
+		limited[p.Giver] = true
+	}
+
+	for id := 0; id < n; id++ {
+		if limited[Pid(id)] {
+			best.Limited = append(best.Limited, Pid(id))
+		}
+	}
+
+	return best
+}
+
+// blockingPairs lists the pairs in c that stop the group in err from
+// reaching anybody outside of the group.
+func blockingPairs(err *NoSolutionError, c constraints) []Pair {
+	inGivers := make(map[Pid]bool, len(err.Givers))
+	for _, id := range err.Givers {
+		inGivers[id] = true
+	}
+
+	inRecipients := make(map[Pid]bool, len(err.Recipients))
+	for _, id := range err.Recipients {
+		inRecipients[id] = true
+	}
+
+	var pairs []Pair
+	for giver, exclusions := range c {
+		for _, recipient := range exclusions {
+			if giver == recipient {
+				continue
+			}
+
+			blocking := inGivers[giver] && !inRecipients[recipient]
+			if err.ByRecipient {
+				blocking = inRecipients[recipient] && !inGivers[giver]
+			}
+
+			if blocking {
+				pairs = append(pairs, Pair{Giver: giver, Recipient: recipient})
+			}
+		}
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		a, b := pairs[i], pairs[j]
+		if a.Giver != b.Giver {
+			return a.Giver < b.Giver
+		}
+
+		return a.Recipient < b.Recipient
+	})
+
+	return pairs
+}
+
+// blockedPairs works like blockingPairs, but looks at every pair
+// leaving the group in err among n participants, and lists the ones
+// blocked rules out.
+func blockedPairs(err *NoSolutionError, n int, blocked func(giver, recipient Pid) bool) []Pair {
+	// The other side of the group, which it can't reach outside of
+	options := err.Recipients
+	if err.ByRecipient {
+		options = err.Givers
+	}
+
+	inside := make([]bool, n)
+	for _, id := range options {
+		inside[id] = true
+	}
+
+	var pairs []Pair
+	if !err.ByRecipient {
+		for _, giver := range err.Givers {
+			for recipient := Pid(0); int(recipient) < n; recipient++ {
+				if giver != recipient && !inside[recipient] && blocked(giver, recipient) {
+					pairs = append(pairs, Pair{Giver: giver, Recipient: recipient})
+				}
+			}
+		}
+
+		return pairs
+	}
+
+	for giver := Pid(0); int(giver) < n; giver++ {
+		if inside[giver] {
+			continue
+		}
+
+		for _, recipient := range err.Recipients {
+			if giver != recipient && blocked(giver, recipient) {
+				pairs = append(pairs, Pair{Giver: giver, Recipient: recipient})
+			}
+		}
+	}
+
+	return pairs
+}
+
+func members(set []bool) []Pid {
+	var ids []Pid
+	for i, ok := range set {
+		if ok {
+			ids = append(ids, Pid(i))
+		}
+	}
+
+	return ids
+}
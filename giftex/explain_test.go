@@ -0,0 +1,197 @@
+package giftex
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestExplainNoSolution(t *testing.T) {
+	names := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i"}
+	newParticipants := func() ParticipantMap {
+		pm := make(ParticipantMap, len(names))
+		for i, name := range names {
+			pm[Pid(i)] = Participant{ID: Pid(i), Name: name}
+		}
+
+		return pm
+	}
+
+	tests := []struct {
+		name         string
+		restrictions map[Pid][]Pid
+		previous     map[Pid][]Pid
+		want         string
+		givers       []Pid
+		recipients   []Pid
+		restricted   []Pair
+		prev         []Pair
+	}{
+		{
+			name: "4 givers with 3 recipients",
+			restrictions: map[Pid][]Pid{
+				0: {3, 4, 5, 6, 7, 8},
+				1: {3, 4, 5, 6, 7, 8},
+				2: {3, 4, 6, 7, 8},
+				3: {4, 5, 6, 7, 8},
+			},
+			previous: map[Pid][]Pid{
+				2: {5},
+			},
+			want:       "No Solution: a, b, c and d can only give to a, b and c",
+			givers:     []Pid{0, 1, 2, 3},
+			recipients: []Pid{0, 1, 2},
+			restricted: []Pair{
+				{0, 3}, {0, 4}, {0, 5}, {0, 6}, {0, 7}, {0, 8},
+				{1, 3}, {1, 4}, {1, 5}, {1, 6}, {1, 7}, {1, 8},
+				{2, 3}, {2, 4}, {2, 6}, {2, 7}, {2, 8},
+				{3, 4}, {3, 5}, {3, 6}, {3, 7}, {3, 8},
+			},
+			prev: []Pair{{2, 5}},
+		},
+		{
+			name: "nobody can give to f",
+			restrictions: map[Pid][]Pid{
+				0: {5}, 1: {5}, 2: {5}, 3: {5}, 6: {5}, 7: {5},
+			},
+			previous: map[Pid][]Pid{
+				4: {5}, 8: {5},
+			},
+			want:       "No Solution: nobody is able to give to f",
+			recipients: []Pid{5},
+			restricted: []Pair{{0, 5}, {1, 5}, {2, 5}, {3, 5}, {6, 5}, {7, 5}},
+			prev:       []Pair{{4, 5}, {8, 5}},
+		},
+		{
+			name: "e and f can only receive from d",
+			restrictions: map[Pid][]Pid{
+				0: {4, 5}, 1: {4, 5}, 2: {4, 5}, 4: {5}, 5: {4},
+				6: {4, 5}, 7: {4, 5}, 8: {4, 5},
+			},
+			want:       "No Solution: e and f can only receive from d",
+			givers:     []Pid{3},
+			recipients: []Pid{4, 5},
+			restricted: []Pair{
+				{0, 4}, {0, 5}, {1, 4}, {1, 5}, {2, 4}, {2, 5}, {4, 5}, {5, 4},
+				{6, 4}, {6, 5}, {7, 4}, {7, 5}, {8, 4}, {8, 5},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm := newParticipants()
+			for id, r := range tt.restrictions {
+				p := pm[id]
+				p.Restrictions = r
+				pm[id] = p
+			}
+
+			for id, r := range tt.previous {
+				p := pm[id]
+				p.Previous = r
+				pm[id] = p
+			}
+
+			_, err := NewGiftExchange(pm, nil)
+			if !errors.Is(err, ErrNoSolution) {
+				t.Fatalf("want ErrNoSolution; got: %v", err)
+			}
+
+			var nse *NoSolutionError
+			if !errors.As(err, &nse) {
+				t.Fatalf("want *NoSolutionError; got: %T", err)
+			}
+
+			if got := err.Error(); tt.want != got {
+				t.Errorf("want: %q; got: %q", tt.want, got)
+			}
+
+			for _, c := range []struct {
+				name      string
+				want, got interface{}
+			}{
+				{"givers", tt.givers, nse.Givers},
+				{"recipients", tt.recipients, nse.Recipients},
+				{"restrictions", tt.restricted, nse.Restrictions},
+				{"previous", tt.prev, nse.Previous},
+			} {
+				if want, got := fmt.Sprint(c.want), fmt.Sprint(c.got); want != got {
+					t.Errorf("wrong %s: want: %v; got: %v", c.name, want, got)
+				}
+			}
+		})
+	}
+}
+
+// TestExplainNoSolution_sources checks restrictions are kept apart
+// from groups and rules, since only restrictions are in the table.
+func TestExplainNoSolution_sources(t *testing.T) {
+	same, err := ParseRule("same:city")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nobody can give to d: a restricts d, b is in d's household, and
+	// c lives in the same city
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "a", Restrictions: []Pid{3}},
+		1: {ID: 1, Name: "b", Group: "Smith"},
+		2: {ID: 2, Name: "c", Attributes: map[string]string{"city": "Oslo"}},
+		3: {ID: 3, Name: "d", Group: "smith", Attributes: map[string]string{"city": "oslo"}},
+	}
+
+	_, err = NewGiftExchange(pm, &GiftExchangeOptions{Rules: []Rule{same}})
+	var nse *NoSolutionError
+	if !errors.As(err, &nse) {
+		t.Fatalf("want *NoSolutionError; got: %v", err)
+	}
+
+	for _, c := range []struct {
+		name      string
+		want, got interface{}
+	}{
+		{"restrictions", []Pair{{0, 3}}, nse.Restrictions},
+		{"groups", []Pair{{1, 3}}, nse.Groups},
+		{"rules", []Pair{{2, 3}}, nse.Rules},
+		{"group", "b and d are both in Smith", nse.DescribeGroup(nse.Groups[0])},
+		{"rule", `the rule "same:city=forbid" keeps c from giving to d`, nse.DescribeRule(nse.Rules[0])},
+	} {
+		if want, got := fmt.Sprint(c.want), fmt.Sprint(c.got); want != got {
+			t.Errorf("wrong %s: want: %v; got: %v", c.name, want, got)
+		}
+	}
+
+	// Restrictions listed by the partner count when they go both ways
+	pm[3] = Participant{ID: 3, Name: "d", Restrictions: []Pid{1, 2}}
+	_, err = NewGiftExchange(pm, &GiftExchangeOptions{SymmetricRestrictions: true})
+	if !errors.As(err, &nse) {
+		t.Fatalf("want *NoSolutionError; got: %v", err)
+	}
+
+	if want, got := "[{3 0} {3 1} {3 2}]", fmt.Sprint(nse.Restrictions); want != got || len(nse.Groups)+len(nse.Rules) > 0 {
+		t.Errorf("want restrictions: %s; got: %s, groups: %v, rules: %v", want, got, nse.Groups, nse.Rules)
+	}
+
+	// Giving to another group is explained by the group
+	pm = ParticipantMap{
+		0: {ID: 0, Name: "a", Group: "sales", GivesTo: "Support", Restrictions: []Pid{4}},
+		1: {ID: 1, Name: "b", Group: "sales", GivesTo: "Support", Restrictions: []Pid{4}},
+		2: {ID: 2, Name: "c", Group: "Support"},
+		3: {ID: 3, Name: "d", Group: "dev"},
+		4: {ID: 4, Name: "e", Group: "support"},
+	}
+
+	_, err = NewGiftExchange(pm, nil)
+	if !errors.As(err, &nse) {
+		t.Fatalf("want *NoSolutionError; got: %v", err)
+	}
+
+	if want, got := "[{0 4} {1 4}]", fmt.Sprint(nse.Restrictions); want != got || len(nse.Groups) == 0 {
+		t.Fatalf("want restrictions: %s and some groups; got: %s, groups: %v", want, got, nse.Groups)
+	}
+
+	if want, got := "a only gives to somebody in Support", nse.DescribeGroup(Pair{0, 3}); want != got {
+		t.Errorf("want: %q; got: %q", want, got)
+	}
+}
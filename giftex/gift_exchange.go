@@ -1,10 +1,10 @@
 package giftex
 
 import (
-	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"os"
 	"regexp"
 	"sort"
@@ -14,74 +14,511 @@ import (
 
 type GiftExchange struct {
 	numParticipants int
-	matrix          matrix
 	participants    ParticipantMap
-	constraints     constraints
 	Assignment      Assignment
+
+	// Recipients lists everybody each participant gives to. It only
+	// differs from Assignment when GiftsPerPerson is more than 1, in
+	// which case Assignment holds the first recipient of each giver.
+	Recipients MultiAssignment
+
+	// Cost is the total cost of Assignment when using CostOptions
+	Cost int64
+
+	// Swap is true when participants were paired up to swap gifts
+	// with each other. See GiftExchangeOptions.Swap.
+	Swap bool
+
+	// Seed is the seed the assignment was drawn with, if any
+	Seed int64
+
+	// Commitment is a hash of the participants, options, and Seed or
+	// Reveal secret of a seeded draw. See Commit, Reveal, and Verify.
+	Commitment string
 }
 
 var (
-	ErrInvalidCSV          = errors.New("Error: csv must include headers and at least one entry")
+	ErrInvalidCSV          = errors.New("Error: files must include headers and at least one entry")
 	ErrNoSolution          = errors.New("No Solution: an assignment is not possible for this gift exchange")
 	ErrParticipantNotFound = errors.New("Error: Participant not found in GiftExchangeDB")
+	ErrConflictingOptions  = errors.New("Error: cost, cycle, and gifts per person options can't be combined")
+	ErrTooManyParticipants = errors.New("Error: too many participants for these options")
+
+	// ErrGaveUp means the search for an assignment stopped before
+	// trying everything, so there may still be one.
+	ErrGaveUp = errors.New("Error: gave up looking for an assignment")
 )
 
+// MaxMatrixParticipants is the most participants that can be drawn
+// with options that look at every possible pair: Swap, Costs,
+// SingleCycle, NoMutualPairs, MinCycleLength, GiftsPerPerson, Rules,
+// and roles, as well as RepairGiftExchange, NewSchedule, MaxRounds, and
+// PairProbabilities. These take O(n²) memory, so more participants
+// than this fail with ErrTooManyParticipants. The default draw works
+// with any number of participants.
+const MaxMatrixParticipants = 1000
+
 type GiftExchangeOptions struct {
 	// MaxPrevious is how long to wait until you can pair with someone you had before
 	MaxPrevious int
+
+	// History holds dated records of past gift exchanges. Repeat
+	// rules for History are written in years instead of entries, and
+	// everybody's Previous list is ignored when it's set.
+	History History
+
+	// Year is the year of this gift exchange. When it's zero, the
+	// year after the most recent record in History is used.
+	Year int
+
+	// RepeatYears is how many years to wait before giving to somebody
+	// in History again, e.g., with 2 years, whoever you had in 2022 or
+	// 2023 is off limits in 2024. Zero considers every year.
+	RepeatYears int
+
+	// RepeatBothWays keeps people apart from whoever had them
+	// recently too, not just from who they had.
+	RepeatBothWays bool
+
+	// Costs enables soft preferences using a minimum cost assignment
+	Costs *CostOptions
+
+	// SingleCycle requires everyone to form one big loop so gifts can
+	// be handed around a circle in order.
+	SingleCycle bool
+
+	// NoMutualPairs prevents anyone from giving to the person who
+	// is giving to them.
+	NoMutualPairs bool
+
+	// MinCycleLength is the fewest number of people allowed in each
+	// loop of givers.
+	MinCycleLength int
+
+	// SymmetricRestrictions applies every restriction both ways, so
+	// nobody has to list their partner when their partner lists them.
+	SymmetricRestrictions bool
+
+	// GiftsPerPerson is how many gifts everyone gives and receives.
+	// Nobody gives more than one gift to the same person.
+	GiftsPerPerson int
+
+	// GroupRules make everybody in one group give to somebody in
+	// another group. Everybody already gives outside their own group.
+	GroupRules []GroupRule
+
+	// Rules forbid pairs or give them a cost by looking at the giver
+	// and recipient, e.g., nobody gives to somebody with the same
+	// last name. See Rule and ParseRule.
+	Rules []Rule
+
+	// Swap pairs everybody up to swap gifts with each other directly
+	// instead of passing gifts around in loops. With an odd number of
+	// participants, three of them give to each other in a loop.
+	Swap bool
+
+	// ExtraGifts is the most extra gifts each participant who only
+	// receives can get when there are more givers than recipients.
+	// When it's zero, the extra gifts are spread across everybody who
+	// receives instead. See Participant.Role.
+	ExtraGifts int
+
+	// Rand makes every random choice during the draw. It isn't safe
+	// for concurrent use, so don't share it between gift exchanges
+	// that are drawn at the same time. When Rand is nil, a
+	// cryptographically secure source is used unless Seed is set.
+	Rand *rand.Rand
+
+	// Seed replays a draw. The same participants, options, and seed
+	// always produce the same assignment. Zero means no seed.
+	Seed int64
+
+	// Reveal draws with a seed that nobody knows until after the
+	// commitment has been shared, so the organizer can't re-roll the
+	// draw. It takes priority over Seed. See Reveal.
+	Reveal *Reveal
 }
 
+// NewGiftExchange draws an assignment for the participants in pm. The
+// assignment is drawn uniformly at random from every valid assignment,
+// except for large and heavily constrained gift exchanges, which are
+// only approximately uniform. See the package documentation.
 func NewGiftExchange(pm ParticipantMap, opts *GiftExchangeOptions) (*GiftExchange, error) {
+	s, err := constraintGraph(pm, opts)
+
+	ge := &GiftExchange{
+		numParticipants: len(pm),
+		participants:    pm,
+	}
+
+	if err != nil {
+		return ge, err
+	}
+
+	if opts != nil && opts.Reveal != nil && strings.TrimSpace(opts.Reveal.Entropy) == "" {
+		return nil, ErrNoEntropy
+	}
+
+	random := opts.random()
+	if seed := opts.seed(); opts != nil && opts.Rand == nil && seed != 0 {
+		ge.Seed = seed
+		ge.Commitment = Commit(pm, opts)
+	}
+
+	rounds, cost, err := draw(s, pm, opts, random)
+	if err != nil {
+		return nil, err
+	}
+
+	recipients := mergeRounds(rounds)
+	gives, receives, err := giftBounds(pm, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if !verifyBounded(recipients, s, gives, receives) {
+		return nil, ErrNoSolution
+	}
+
+	if opts.swap() && !s.dense().isSwapAssignment(rounds[0]) {
+		return nil, ErrNoSolution
+	}
+
+	ge.Assignment = rounds[0]
+	ge.Swap = opts.swap()
+	ge.Recipients = recipients
+	ge.Cost = cost
+	return ge, nil
+}
+
+// constraintMatrix builds the matrix of every constraint on the
+// participants in pm. When no assignment is possible, the error
+// explains why.
+func constraintMatrix(pm ParticipantMap, opts *GiftExchangeOptions) (matrix, error) {
+	s, err := constraintGraph(pm, opts)
+	m := s.dense()
+	if hasRoles(pm) {
+		forbidRoles(m, pm)
+	}
+
+	return m, err
+}
+
+// constraintGraph works like constraintMatrix, but only builds the
+// full matrix when roles or swaps need to be checked.
+func constraintGraph(pm ParticipantMap, opts *GiftExchangeOptions) (sparse, error) {
 	n := len(pm)
-	m := newMatrix(n)
+	if err := checkMatrixSize(n, matrixOption(pm, opts)); err != nil {
+		return sparse{}, err
+	}
 
-	var maxPrev int
+	restrictions, previous := splitConstraints(pm, opts)
+	s := newConstraintGraph(pm, opts, restrictions, previous)
+	if err := checkPins(pm, opts); err != nil {
+		return s, err
+	}
+
+	// Not everybody gives and receives, so a perfect matching isn't
+	// what we're looking for
+	if hasRoles(pm) {
+		return s, checkRoles(s.dense(), pm, opts)
+	}
+
+	// Pairs have to be allowed both ways, which a perfect matching of
+	// the bipartite graph doesn't check
+	if opts.swap() {
+		if err := checkSwaps(s.dense()); err != nil {
+			if err := explainGroups(pm, opts); err != nil {
+				return s, err
+			}
+
+			return s, err
+		}
+
+		return s, nil
+	}
+
+	if ok := s.CheckConstraints(); !ok {
+		if err := explainGroups(pm, opts); err != nil {
+			return s, err
+		}
+
+		if err := explainNoSolution(s, pm, opts, restrictions, previous); err != nil {
+			return s, err
+		}
+
+		return s, ErrNoSolution
+	}
+
+	return s, nil
+}
+
+// newConstraintGraph puts the restrictions and previous assignments
+// from splitConstraints together with the groups, pins, and
+// allow-lists of the participants in pm.
+func newConstraintGraph(pm ParticipantMap, opts *GiftExchangeOptions, restrictions, previous constraints) sparse {
+	c := make(constraints, len(pm))
+	for id := range pm {
+		c[id] = append(append([]Pid{}, restrictions[id]...), previous[id]...)
+	}
+
+	s := newSparse(len(pm), c)
+	s.addGroups(pm, opts)
+	return s
+}
+
+// matrixOption describes the option in opts that needs the full
+// matrix, if any.
+func matrixOption(pm ParticipantMap, opts *GiftExchangeOptions) string {
+	switch {
+	case opts.swap():
+		return "swapping gifts"
+	case hasRoles(pm):
+		return "people who only give or only receive"
+	case opts.giftsPerPerson() > 1:
+		return "more than one gift each"
+	case opts != nil && opts.Costs != nil:
+		return "costs"
+	case opts != nil && opts.SingleCycle:
+		return "a single loop"
+	case opts != nil && opts.NoMutualPairs:
+		return "no mutual pairs"
+	case opts.minCycleLength(len(pm)) > 2:
+		return "a minimum loop length"
+	case len(opts.rules()) > 0:
+		return "rules"
+	}
+
+	return ""
+}
+
+// checkMatrixSize fails with ErrTooManyParticipants when what needs
+// the full matrix of n participants and n is too large for it.
+func checkMatrixSize(n int, what string) error {
+	if what == "" || n <= MaxMatrixParticipants {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s works with at most %d people, not %d", ErrTooManyParticipants, what, MaxMatrixParticipants, n)
+}
+
+// draw picks an assignment at random from the constraints in s using
+// opts. There is one round for every gift each participant gives, and
+// cost is the total cost of the assignment when using CostOptions.
+// Only the default draw works from s directly, and every other option
+// builds the full matrix.
+func draw(s sparse, pm ParticipantMap, opts *GiftExchangeOptions, r *rand.Rand) (rounds []Assignment, cost int64, err error) {
+	k := opts.giftsPerPerson()
+	minCycle := opts.minCycleLength(len(pm))
+	hasCosts := opts != nil && opts.Costs != nil
+
+	// Draw from a copy of the matrix sorted by name so seeds can be
+	// replayed after the participants have been shuffled around
+	order := drawOrder(pm)
+	sorted := s.permute(order)
+
+	var a Assignment
+	switch {
+	case (hasCosts || minCycle > 2) && (k > 1 || hasRoles(pm)), hasCosts && minCycle > 2:
+		return nil, 0, ErrConflictingOptions
+
+	case opts.swap() && (hasCosts || minCycle > 2 || k > 1 || hasRoles(pm)):
+		return nil, 0, ErrConflictingOptions
+
+	case opts.swap():
+		var ok bool
+		if a, ok = sorted.dense().assignSwaps(r); !ok {
+			return nil, 0, fmt.Errorf("%w when everybody swaps gifts with a partner", ErrNoSolution)
+		}
+
+	case hasRoles(pm):
+		gives, receives, err := giftBounds(pm, opts)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		m := s.dense()
+		forbidRoles(m, pm)
+
+		var ok bool
+		if rounds, ok = m.permute(order).assignBounded(gives.permute(order), receives.permute(order), r); !ok {
+			return nil, 0, ErrNoSolution
+		}
+
+		for i := range rounds {
+			rounds[i] = rounds[i].relabel(order)
+		}
+
+		return rounds, 0, nil
+
+	case k > 1:
+		var ok bool
+		if rounds, ok = sorted.dense().assignRounds(k, r); !ok {
+			return nil, 0, fmt.Errorf("%w when everyone gives %d gifts", ErrNoSolution, k)
+		}
+
+		for i := range rounds {
+			rounds[i] = rounds[i].relabel(order)
+		}
+
+		return rounds, 0, nil
+
+	case hasCosts:
+		costs := pairCosts(s.dense(), opts.withoutPrevious(pm), *opts.Costs)
+		historyCosts(costs, pm, opts)
+		ruleCosts(costs, pm, opts)
+		costs = permuteCosts(costs, order)
+		a, cost = assignMinCost(costs, *opts.Costs, r)
+
+	case minCycle > 2:
+		var found, exhaustive bool
+		if a, found, exhaustive = sorted.dense().assignCycles(minCycle, r); !found {
+			if !exhaustive {
+				return nil, 0, fmt.Errorf("%w with loops of at least %d people", ErrGaveUp, minCycle)
+			}
+
+			return nil, 0, fmt.Errorf("%w when every loop needs at least %d people", ErrNoSolution, minCycle)
+		}
+
+	default:
+		// Every valid assignment is equally likely to be drawn
+		a = sorted.Assign(r)
+	}
+
+	return []Assignment{a.relabel(order)}, cost, nil
+}
+
+// splitConstraints collects the restrictions and the previous
+// assignments that still apply to each participant in pm. Nobody can
+// be matched with anybody they restrict, or anybody who restricts them
+// when SymmetricRestrictions is set, and rules restrict every pair
+// they forbid. Pins take priority over rules and previous assignments.
+// Previous assignments include recent records from History.
+//
+// Groups, group rules, pins, and allow-lists would restrict far more
+// pairs than anything else, so they aren't listed here. The sparse
+// matrix looks them up instead.
+func splitConstraints(pm ParticipantMap, opts *GiftExchangeOptions) (restrictions, previous constraints) {
+	var symmetric, bothWays bool
 	if opts != nil {
-		maxPrev = opts.MaxPrevious
+		symmetric = opts.SymmetricRestrictions
+		bothWays = opts.RepeatBothWays
 	}
 
-	c := make(constraints, n)
+	restrict := func(giver, recipient Pid) {
+		if giver != recipient && !containsPid(restrictions[giver], recipient) {
+			restrictions[giver] = append(restrictions[giver], recipient)
+		}
+	}
+
+	avoid := func(giver, recipient Pid) {
+		if giver != recipient && !containsPid(previous[giver], recipient) {
+			previous[giver] = append(previous[giver], recipient)
+		}
+	}
+
+	recent := recentPrevious(pm, opts)
+	restrictions = make(constraints, len(pm))
+	previous = make(constraints, len(pm))
 	for id, x := range pm {
-		// Previous assignments older than maxPrev are allowed
-		if n := len(x.Previous); maxPrev > 0 && n > maxPrev {
-			c[id] = append(x.Restrictions, x.Previous[n-maxPrev:]...)
-			continue
+		restrictions[id] = append([]Pid{}, x.Restrictions...)
+		previous[id] = append([]Pid{}, recent[id]...)
+	}
+
+	if bothWays {
+		for id := range pm {
+			for _, r := range recent[id] {
+				if _, ok := pm[r]; ok {
+					avoid(r, id)
+				}
+			}
 		}
+	}
 
-		c[id] = append(x.Restrictions, x.Previous...)
+	history := opts.recentHistory()
+	historyPairs(pm, opts, history, func(_ HistoryRecord, giver, recipient Pid) {
+		avoid(giver, recipient)
+	})
+
+	if symmetric {
+		for id, x := range pm {
+			for _, r := range x.Restrictions {
+				if _, ok := pm[r]; ok {
+					restrict(r, id)
+				}
+			}
+		}
 	}
 
-	ge := &GiftExchange{
-		numParticipants: n,
-		matrix:          m,
-		participants:    pm,
-		constraints:     c,
+	// Rules can forbid almost every pair, so check what's already
+	// restricted with a set
+	if rules := opts.rules(); len(rules) > 0 {
+		for giver, a := range pm {
+			listed := make(map[Pid]bool, len(restrictions[giver]))
+			for _, r := range restrictions[giver] {
+				listed[r] = true
+			}
+
+			for recipient, b := range pm {
+				if giver != recipient && !listed[recipient] && judge(rules, a, b).Forbidden {
+					restrictions[giver] = append(restrictions[giver], recipient)
+				}
+			}
+		}
 	}
 
-	if ok := m.AddConstraints(c); !ok {
-		return ge, ErrNoSolution
+	// Pinned pairs are allowed even if a rule forbids them or they
+	// were matched recently, but not if somebody restricted them
+	unpin := func(giver, recipient Pid) {
+		if containsPid(pm[giver].Restrictions, recipient) || !limitAllows(pm[giver], recipient) ||
+			(symmetric && containsPid(pm[recipient].Restrictions, giver)) {
+			return
+		}
+
+		restrictions[giver] = removePid(restrictions[giver], recipient)
+		previous[giver] = removePid(previous[giver], recipient)
 	}
 
-	// It's possible to "choose wrong" when making an assignment that
-	// will prevent a complete pairing to be made. Let's give
-	// ourselves as many tries as we have participants to choose correctly.
-	var a Assignment
-	var valid bool
-	for i := 0; i < n; i++ {
-		a = m.Assign()
-		if verifyAssignment(a, c) {
-			valid = true
-			break
+	for giver, p := range pm {
+		for _, recipient := range p.Pinned {
+			if _, ok := pm[recipient]; !ok {
+				continue
+			}
+
+			unpin(giver, recipient)
+			if opts.swap() {
+				unpin(recipient, giver)
+			}
 		}
 	}
 
-	if valid {
-		ge.Assignment = a
-	} else {
-		return nil, ErrNoSolution
+	return restrictions, previous
+}
+
+// recentPrevious returns the entries of everybody's Previous list
+// that are within MaxPrevious. Older entries are allowed again.
+func recentPrevious(pm ParticipantMap, opts *GiftExchangeOptions) constraints {
+	var maxPrev int
+	if opts != nil {
+		maxPrev = opts.MaxPrevious
 	}
 
-	return ge, nil
+	recent := make(constraints, len(pm))
+	for id, x := range pm {
+		prev := opts.previous(x)
+		recent[id] = prev
+		if n := len(prev); maxPrev > 0 && n > maxPrev {
+			recent[id] = prev[n-maxPrev:]
+		}
+	}
+
+	return recent
+}
+
+// sameGroup reports whether a and b are members of the same group.
+func sameGroup(a, b Participant) bool {
+	g := trimLower(a.Group)
+	return g != "" && g == trimLower(b.Group)
 }
 
 type ParticipantMap = map[Pid]Participant
@@ -91,15 +528,49 @@ type Participant struct {
 	Email, SMS   string
 	Restrictions []Pid
 	Previous     []Pid
+	Wishes       []Pid // People this participant would like to give to
+
+	// Pinned lists people this participant always gives to, e.g., a
+	// grandparent who always buys for the newest baby. Somebody who is
+	// pinned only gives to the people they're pinned to, even in their
+	// own group or if they had them recently.
+	Pinned []Pid
+
+	// Allowed is a short list of people this participant gives to one
+	// of, e.g., kids who only draw other kids. Everybody is allowed
+	// when it's empty.
+	Allowed []Pid
+
+	// Group is a household or any other set of people who should
+	// never be matched with each other, e.g., "The Smiths" or a
+	// department at work.
+	Group string
+
+	// GivesTo is the group this participant has to give to, if any.
+	// It takes priority over GiftExchangeOptions.GroupRules.
+	GivesTo string
+
+	// Role is whether this participant gives gifts, receives them, or
+	// both. Everybody gives and receives by default.
+	Role Role
+
+	// Attributes are anything else known about this participant, such
+	// as their shirt size or allergies, keyed by column name. Rules
+	// can use them, and givers can see them in their emails.
+	Attributes map[string]string
 }
 
 type GiftExchangeDB struct {
 	cols    map[string]int
 	headers []string
 	records [][]string
+	schema  Schema
 
 	Participants ParticipantMap
 	index        map[Pid]int
+
+	// Report lists any mistakes in the CSV that ReadCSV ignored
+	Report ValidationReport
 }
 
 func (db *GiftExchangeDB) GetParticipant(id Pid) (Participant, error) {
@@ -111,6 +582,19 @@ func (db *GiftExchangeDB) GetParticipant(id Pid) (Participant, error) {
 	return p, nil
 }
 
+// Cell returns what was written in column key of the row the
+// participant with id was read from, e.g., their restrictions before
+// unknown names were dropped. Key is the usual name of a column, such
+// as "group", even when the file called it something else.
+func (db *GiftExchangeDB) Cell(id Pid, key string) string {
+	i, ok := db.index[id]
+	if !ok {
+		return ""
+	}
+
+	return db.cell(i, key)
+}
+
 func ReadCSVFromFile(path string) (*GiftExchangeDB, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -125,70 +609,169 @@ func ReadCSVFromFile(path string) (*GiftExchangeDB, error) {
 // and preserves the original records for writing out as a new CSV later.
 //
 // The following columns are required: name, email, restrictions, previous, participating, has
+//
+// The following columns are optional: sms, wishes, group (or household,
+// department, or team), gives to, role, pinned (or always gives to),
+// allowed (or only gives to)
+//
+// Every other column, such as "shirt size" or "city", is read into
+// Participant.Attributes.
+//
+// Missing columns, blank names, and duplicate names are errors, and
+// ReadCSV returns a *ValidationError listing all of them. Anything
+// else it ignores, such as an unknown name in somebody's
+// restrictions, is listed in the Report of the GiftExchangeDB.
+//
+// Use a Schema to read CSVs with other headers or delimiters, or
+// files in other formats, such as XLSX.
 func ReadCSV(r io.Reader) (*GiftExchangeDB, error) {
-	csvReader := csv.NewReader(r)
-	csvReader.FieldsPerRecord = -1 // Allow empty columns
-	csvReader.Comma = ','
+	return Schema{}.ReadCSV(r)
+}
 
-	records, err := csvReader.ReadAll()
-	if err != nil {
-		return nil, fmt.Errorf("Error reading csv: %w", err)
+// problem describes a mistake in column key of record i.
+func (db *GiftExchangeDB) problem(i int, key, value string, err error) Problem {
+	p := Problem{Row: i + 2, Value: trim(value), Err: err} // Skip header row and count from 1
+	if col, ok := db.cols[key]; ok {
+		p.Column = col + 1
+		p.Header = trim(db.headers[col])
 	}
 
-	if len(records) < 2 {
-		return nil, ErrInvalidCSV
-	}
+	return p
+}
 
-	// The first record contains the column headers
-	cols := make(map[string]int, len(records[0]))
-	for i, v := range records[0] {
-		cols[trimLower(v)] = i
+// cell returns the value in column key of record i, or "" if there
+// is no such column.
+func (db *GiftExchangeDB) cell(i int, key string) string {
+	if col, ok := db.cols[key]; ok && col < len(db.records[i]) {
+		return db.records[i][col]
 	}
 
-	maxSize := len(records) - 1 // Skip header row
+	return ""
+}
+
+// loader fills in a GiftExchangeDB one record at a time, so files
+// can be checked while they're read.
+type loader struct {
+	db *GiftExchangeDB
+
+	names     map[string]Pid  // Participants by lowercase name
+	everybody map[string]bool // Every lowercase name, participating or not
+	nextID    Pid
+}
 
+// newLoader starts a GiftExchangeDB with the column headers, and
+// checks that the required columns are there.
+func newLoader(headers []string, schema Schema) *loader {
 	db := &GiftExchangeDB{
-		cols:         cols,
-		headers:      records[0],
-		records:      records[1:],
-		Participants: make(ParticipantMap, maxSize),
-		index:        make(map[Pid]int, maxSize),
+		cols:         schema.Columns(headers),
+		headers:      headers,
+		schema:       schema,
+		Participants: make(ParticipantMap),
+		index:        make(map[Pid]int),
 	}
 
-	db.loadRecords()
-	return db, nil
+	for _, key := range schema.required() {
+		if _, ok := db.cols[key]; !ok {
+			db.Report.fail(Problem{Row: 1, Value: key, Err: ErrMissingColumn})
+		}
+	}
+
+	return &loader{
+		db:        db,
+		names:     make(map[string]Pid),
+		everybody: make(map[string]bool),
+	}
+}
+
+// skip keeps the place of a record that couldn't be read, so the
+// rows after it are still counted correctly.
+func (l *loader) skip() {
+	l.db.records = append(l.db.records, nil)
 }
 
-func (db *GiftExchangeDB) loadRecords() {
-	numRecords := len(db.records)
-	nameMap := make(map[string]Pid, numRecords)
+// add reads the next record. Names in other columns, such as
+// restrictions, are read by finish once everybody has been added.
+func (l *loader) add(row []string) {
+	db := l.db
+	i := len(db.records)
 
-	var pID Pid
-	for i := 0; i < numRecords; i++ {
-		row := db.records[i]
+	// Short rows are padded so every column can be written out later
+	if len(row) < len(db.headers) {
+		db.Report.warn(Problem{Row: i + 2, Err: ErrShortRow})
+		row = append(row, make([]string, len(db.headers)-len(row))...)
+	}
+	db.records = append(db.records, row)
 
-		// Skip non-participants
-		if participating := trimLower(row[db.cols["participating"]]) == "yes"; !participating {
+	// Names of people who aren't participating aren't mistakes
+	l.everybody[trimLower(db.cell(i, "name"))] = true
+
+	// Skip non-participants. Everybody participates when there's
+	// no participating column.
+	if _, ok := db.cols["participating"]; ok && !db.schema.Participating(db.cell(i, "participating")) {
+		return
+	}
+
+	p := Participant{
+		ID:    l.nextID,
+		Name:  trim(db.cell(i, "name")),
+		Email: trimLower(db.cell(i, "email")),
+		SMS:   onlyDigits(db.cell(i, "sms")),
+	}
+
+	switch _, dup := l.names[trimLower(p.Name)]; {
+	case p.Name == "":
+		db.Report.fail(db.problem(i, "name", "", ErrEmptyName))
+		return
+	case dup:
+		db.Report.fail(db.problem(i, "name", p.Name, ErrDuplicateName))
+		return
+	}
+
+	if p.Email != "" && !validEmail(p.Email) {
+		db.Report.warn(db.problem(i, "email", p.Email, ErrInvalidEmail))
+	}
+
+	// Groups are optional and may be called households,
+	// departments, or teams instead
+	p.Group = trim(db.cell(i, "group"))
+
+	// So is the group somebody has to give to
+	p.GivesTo = trim(db.cell(i, "gives to"))
+
+	// Roles are optional and default to giving and receiving
+	if _, ok := db.cols["role"]; ok {
+		p.Role = ParseRole(db.cell(i, "role"))
+	}
+
+	// Anything else is an attribute
+	for col, header := range db.headers {
+		if !db.schema.IsAttribute(header) {
 			continue
 		}
 
-		p := Participant{
-			ID:    pID,
-			Name:  trim(row[db.cols["name"]]),
-			Email: trimLower(row[db.cols["email"]]),
-			SMS:   onlyDigits(row[db.cols["sms"]]),
+		if v := trim(row[col]); v != "" {
+			if p.Attributes == nil {
+				p.Attributes = make(map[string]string)
+			}
+			p.Attributes[trim(header)] = v
 		}
-
-		db.Participants[p.ID] = p
-		nameMap[trimLower(p.Name)] = p.ID
-		db.index[pID] = i
-		pID++ // Increment pID last so we start with 0
 	}
 
-	// Second pass to fill out constraints
-	for pID, p := range db.Participants {
-		getIDs := func(entry string) []Pid {
-			names := strings.Split(trim(entry), ",")
+	db.Participants[p.ID] = p
+	l.names[trimLower(p.Name)] = p.ID
+	db.index[p.ID] = i
+	l.nextID++
+}
+
+// finish fills out everybody's constraints, and returns the
+// GiftExchangeDB or a *ValidationError if anything was wrong.
+func (l *loader) finish() (*GiftExchangeDB, error) {
+	db := l.db
+	for pID := Pid(0); pID < l.nextID; pID++ {
+		p := db.Participants[pID]
+		idx := db.index[pID]
+		getIDs := func(key string) []Pid {
+			names := strings.Split(trim(db.cell(idx, key)), ",")
 			ids := make([]Pid, 0, len(names))
 
 			for _, n := range names {
@@ -197,52 +780,152 @@ func (db *GiftExchangeDB) loadRecords() {
 				}
 
 				// Ignore names that are not real participants
-				if id, ok := nameMap[trimLower(n)]; ok {
-					ids = append(ids, id)
+				id, ok := l.names[trimLower(n)]
+				if !ok {
+					if !l.everybody[trimLower(n)] {
+						db.Report.warn(db.problem(idx, key, n, ErrUnknownName))
+					}
+					continue
 				}
+
+				ids = append(ids, id)
 			}
 
 			return ids
 		}
 
-		idx := db.index[pID]
-		p.Restrictions = getIDs(db.records[idx][db.cols["restrictions"]])
-		p.Previous = getIDs(db.records[idx][db.cols["previous"]])
+		p.Restrictions = getIDs("restrictions")
+		p.Previous = getIDs("previous")
+		if containsPid(p.Restrictions, pID) {
+			db.Report.warn(db.problem(idx, "restrictions", p.Name, ErrSelfRestriction))
+		}
+
+		// Wish lists are optional
+		if _, ok := db.cols["wishes"]; ok {
+			p.Wishes = getIDs("wishes")
+		}
+
+		// So are pins and allow-lists
+		if _, ok := db.cols["pinned"]; ok {
+			p.Pinned = getIDs("pinned")
+		}
+
+		if _, ok := db.cols["allowed"]; ok {
+			p.Allowed = getIDs("allowed")
+		}
+
 		db.Participants[pID] = p
 	}
+
+	db.Report.sort()
+	if len(db.Report.Errors) > 0 || db.Report.MoreErrors > 0 {
+		return nil, &ValidationError{Report: db.Report}
+	}
+
+	return db, nil
+}
+
+// Has reads who everybody has from the "has" column of a results CSV
+// written by WriteCSV, so an earlier draw can be verified or repaired.
+func (db *GiftExchangeDB) Has() (MultiAssignment, error) {
+	col, ok := db.cols["has"]
+	if !ok {
+		return nil, fmt.Errorf("%w: missing has column", ErrInvalidCSV)
+	}
+
+	nameMap := make(map[string]Pid, len(db.Participants))
+	for id, p := range db.Participants {
+		nameMap[trimLower(p.Name)] = id
+	}
+
+	results := make(MultiAssignment, len(db.Participants))
+	for id := range db.Participants {
+		row := db.records[db.index[id]]
+		if col >= len(row) {
+			continue
+		}
+
+		for _, name := range splitNames(row[col]) {
+			recipient, ok := nameMap[trimLower(name)]
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", ErrParticipantNotFound, trim(name))
+			}
+
+			results[id] = append(results[id], recipient)
+		}
+	}
+
+	return results, nil
 }
 
 // WriteCSV produces a new CSV with the results of a completed gift
 // exchange while also preserving the original CSV's data. The rows
-// are sorted by name.
-func (db *GiftExchangeDB) WriteCSV(w io.Writer, results Assignment) error {
-	b := csv.NewWriter(w)
+// are sorted by name. When somebody gives more than one gift, all of
+// their recipients are listed in the "has" column separated by commas.
+//
+// When results is a seeded *GiftExchange, its Commitment is written to
+// a "commitment" column so the draw can be checked with VerifyCSV.
+func (db *GiftExchangeDB) WriteCSV(w io.Writer, results Results) error {
+	return db.Write(w, results, CSVCodec{})
+}
+
+// Write works like WriteCSV, but writes the file with codec, e.g.,
+// XLSX.Codec. CSVs keep the delimiter of the original unless codec
+// has its own.
+func (db *GiftExchangeDB) Write(w io.Writer, results Results, codec Codec) error {
+	var commitment string
+	if ge, ok := results.(*GiftExchange); ok {
+		commitment = ge.Commitment
+	}
+
+	// Add the columns the results are written to if the schema
+	// allowed them to be missing
+	addCol := func(key string) {
+		if _, ok := db.cols[key]; !ok {
+			db.cols[key] = len(db.headers)
+			db.headers = append(db.headers, key)
+		}
+	}
+
+	addCol("previous")
+	addCol("has")
+	if commitment != "" {
+		addCol("commitment")
+	}
 
-	// Clear out "has" column from previous run
+	// Clear out "has" and "commitment" columns from previous run
 	for i, row := range db.records {
+		for len(row) < len(db.headers) {
+			row = append(row, "")
+		}
+
 		row[db.cols["has"]] = ""
+		if col, ok := db.cols["commitment"]; ok {
+			row[col] = ""
+		}
+
 		db.records[i] = row
 	}
 
 	// Update records
-	for pID, has := range results {
+	for pID, has := range results.Multi() {
 		idx := db.index[pID]
 		row := db.records[idx]
 
-		hasName := db.Participants[has].Name
-		row[db.cols["has"]] = hasName
-
-		// Split string by ',' but ignore empty items
-		splitNames := func(s string) []string {
-			return strings.FieldsFunc(s, func(c rune) bool {
-				return c == ','
-			})
+		hasNames := make([]string, 0, len(has))
+		for _, id := range has {
+			hasNames = append(hasNames, db.Participants[id].Name)
 		}
+		row[db.cols["has"]] = strings.Join(hasNames, ",")
 
 		prev := row[db.cols["previous"]]
-		prev = strings.Join(append(splitNames(prev), hasName), ",")
+		prev = strings.Join(append(splitNames(prev), hasNames...), ",")
 		row[db.cols["previous"]] = prev
 
+		if commitment != "" {
+			row[db.cols["commitment"]] = commitment
+		}
+
 		db.records[idx] = row
 	}
 
@@ -252,18 +935,21 @@ func (db *GiftExchangeDB) WriteCSV(w io.Writer, results Assignment) error {
 		return a[db.cols["name"]] < b[db.cols["name"]]
 	})
 
-	// Write the column headers
-	b.Write(db.headers)
-
-	// Write updated records
-	for _, row := range db.records {
-		b.Write(row)
-	}
+	// Write the column headers followed by the updated records
+	table := make([][]string, 0, len(db.records)+1)
+	table = append(table, db.headers)
+	table = append(table, db.records...)
 
-	b.Flush()
-	return b.Error()
+	return db.schema.codec(codec).Encode(w, table)
+}
 
+// splitNames splits s by ',' but ignores empty items
+func splitNames(s string) []string {
+	return strings.FieldsFunc(s, func(c rune) bool {
+		return c == ','
+	})
 }
+
 func trimLower(s string) string {
 	return strings.TrimSpace(strings.ToLower(s))
 }
@@ -277,6 +963,22 @@ func onlyDigits(s string) string {
 	return re.ReplaceAllString(s, "")
 }
 
+// results returns ge.Recipients, falling back to ge.Assignment for
+// gift exchanges that were put together by hand.
+func (ge *GiftExchange) results() Results {
+	if ge.Recipients != nil {
+		return ge.Recipients
+	}
+
+	return ge.Assignment
+}
+
+// Multi returns everybody each participant gives to, so a
+// GiftExchange can be passed to WriteCSV and SendEmails directly.
+func (ge *GiftExchange) Multi() MultiAssignment {
+	return ge.results().Multi()
+}
+
 func (ge *GiftExchange) String() string {
 	// Build a slice of participants sorted by name
 	type result struct {
@@ -286,12 +988,17 @@ func (ge *GiftExchange) String() string {
 	}
 
 	sorted := make([]result, 0, ge.numParticipants)
-	for aID, bID := range ge.Assignment {
-		a, b := ge.participants[aID], ge.participants[bID]
+	for aID, has := range ge.results().Multi() {
+		names := make([]string, 0, len(has))
+		for _, bID := range has {
+			names = append(names, ge.participants[bID].Name)
+		}
+		sort.Strings(names)
+
 		sorted = append(sorted, result{
 			id:   aID,
-			name: a.Name,
-			has:  b.Name,
+			name: ge.participants[aID].Name,
+			has:  strings.Join(names, ", "),
 		})
 	}
 	sort.SliceStable(sorted, func(i, j int) bool {
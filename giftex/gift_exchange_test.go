@@ -1,6 +1,7 @@
 package giftex
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
@@ -38,8 +39,19 @@ func Example_noSolution() {
 		fmt.Println(err)
 	}
 
+	var nse *NoSolutionError
+	if errors.As(err, &nse) {
+		for _, p := range nse.Restrictions {
+			fmt.Printf("%s can't give to %s\n", nse.Name(p.Giver), nse.Name(p.Recipient))
+		}
+	}
+
+	fmt.Println(errors.Is(err, ErrNoSolution))
+
 	// Output:
-	// No Solution: an assignment is not possible for this gift exchange
+	// No Solution: foo has nobody left to give to
+	// foo can't give to bar
+	// true
 }
 
 func TestNewGiftExchange(t *testing.T) {
@@ -90,6 +102,9 @@ func TestReadCSVFromFile(t *testing.T) {
 			2: {ID: 2, Name: "baz", Email: "baz@example.com", SMS: "5553333333", Restrictions: []Pid{}},
 		},
 		index: map[Pid]int{0: 0, 1: 1, 2: 2},
+		Report: ValidationReport{
+			Warnings: []Problem{{Row: 2, Column: 4, Header: "restrictions", Value: "foo", Err: ErrSelfRestriction}},
+		},
 	}
 
 	db, err := ReadCSVFromFile("testdata/small.csv")
@@ -127,3 +142,37 @@ quux,quux@example.com,5554444444,"foo, bar, baz",,no,
 		t.Errorf("Wrong output:\nwant:\n%v\n\ngot:\n%v", want, got)
 	}
 }
+
+func TestNewConstraintGraph(t *testing.T) {
+	db, err := ReadCSVFromFile("testdata/groups.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts *GiftExchangeOptions
+		want string
+	}{
+		{
+			name: "Groups",
+			opts: nil,
+			want: "1 1 0 0\n1 1 0 0\n1 0 1 0\n0 0 0 1",
+		},
+		{
+			name: "Symmetric restrictions",
+			opts: &GiftExchangeOptions{SymmetricRestrictions: true},
+			want: "1 1 1 0\n1 1 0 0\n1 0 1 0\n0 0 0 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restrictions, previous := splitConstraints(db.Participants, tt.opts)
+			s := newConstraintGraph(db.Participants, tt.opts, restrictions, previous)
+			if got := s.dense().String(); tt.want != got {
+				t.Errorf("want: %s; got: %s", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package giftex
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrInvalidGroupRule = errors.New(`Error: group rules look like "Team A -> Team B"`)
+)
+
+// GroupRule makes everybody in one group give to somebody in another
+// group, e.g., all of Team A gives to Team B. Groups are matched by
+// name, ignoring case.
+type GroupRule struct {
+	Giver, Recipient string
+}
+
+// ParseGroupRule reads a rule written as "Team A -> Team B".
+func ParseGroupRule(s string) (GroupRule, error) {
+	parts := strings.Split(s, "->")
+	if len(parts) != 2 {
+		return GroupRule{}, ErrInvalidGroupRule
+	}
+
+	r := GroupRule{Giver: trim(parts[0]), Recipient: trim(parts[1])}
+	if r.Giver == "" || r.Recipient == "" {
+		return GroupRule{}, ErrInvalidGroupRule
+	}
+
+	return r, nil
+}
+
+func (r GroupRule) String() string {
+	return r.Giver + " -> " + r.Recipient
+}
+
+// groupTargets returns the group each participant in pm has to give
+// to, if any, ignoring case. Participant.GivesTo takes priority over
+// GroupRules, and the first rule for a group wins. Participants who
+// are pinned to somebody don't have to give to a group.
+func groupTargets(pm ParticipantMap, opts *GiftExchangeOptions) map[Pid]string {
+	var rules []GroupRule
+	if opts != nil {
+		rules = opts.GroupRules
+	}
+
+	targets := make(map[Pid]string)
+	for id, p := range pm {
+		// Pins take priority over group rules
+		if len(p.Pinned) > 0 {
+			continue
+		}
+
+		if to := trimLower(p.GivesTo); to != "" {
+			targets[id] = to
+			continue
+		}
+
+		group := trimLower(p.Group)
+		if group == "" {
+			continue
+		}
+
+		for _, r := range rules {
+			if trimLower(r.Giver) == group {
+				targets[id] = trimLower(r.Recipient)
+				break
+			}
+		}
+	}
+
+	return targets
+}
+
+// groupsAllow returns whether groups and group rules let giver give
+// to recipient. Nobody can give to somebody in their own group, or to
+// anybody outside the group they have to give to.
+func groupsAllow(pm ParticipantMap, opts *GiftExchangeOptions) func(giver, recipient Pid) bool {
+	targets := groupTargets(pm, opts)
+	return func(giver, recipient Pid) bool {
+		if sameGroup(pm[giver], pm[recipient]) {
+			return false
+		}
+
+		to, ok := targets[giver]
+		return !ok || to == trimLower(pm[recipient].Group)
+	}
+}
+
+// GroupCapacityError explains that a group is too big for the group
+// rules to be followed. Either a group holds more than half of
+// everybody, so its members can't all give to and receive from people
+// outside it, or more people have to give to the Recipients group
+// than it has members to receive their gifts.
+type GroupCapacityError struct {
+	// Group is the group that's too big. When Recipients is set, it
+	// lists the groups that have to give to Recipients instead.
+	Group string
+
+	// Members is how many people are in Group, or how many have to
+	// give to Recipients
+	Members int
+
+	// Total is the number of participants
+	Total int
+
+	// Recipients is the group that has too few members to receive
+	// everybody's gifts, if any, and Capacity is its size
+	Recipients string
+	Capacity   int
+}
+
+func (e *GroupCapacityError) Error() string {
+	if e.Recipients == "" {
+		return fmt.Sprintf("No Solution: %s has %d of %d people, which is more than half, so they can't all give to somebody outside their group",
+			e.Group, e.Members, e.Total)
+	}
+
+	switch e.Capacity {
+	case 0:
+		return fmt.Sprintf("No Solution: %d %s in %s %s to give to %s, which has nobody in it",
+			e.Members, peopleOrPerson(e.Members), e.Group, hasOrHaveCount(e.Members), e.Recipients)
+	default:
+		return fmt.Sprintf("No Solution: %d %s in %s %s to give to %s, which only has %d %s",
+			e.Members, peopleOrPerson(e.Members), e.Group, hasOrHaveCount(e.Members), e.Recipients, e.Capacity, peopleOrPerson(e.Capacity))
+	}
+}
+
+// Is allows errors.Is(err, ErrNoSolution) to match a GroupCapacityError.
+func (e *GroupCapacityError) Is(target error) bool {
+	return target == ErrNoSolution
+}
+
+func peopleOrPerson(n int) string {
+	if n == 1 {
+		return "person"
+	}
+
+	return "people"
+}
+
+func hasOrHaveCount(n int) string {
+	if n == 1 {
+		return "has"
+	}
+
+	return "have"
+}
+
+// explainGroups checks that every group fits the group rules, which
+// catches the most common reason a company-wide gift exchange is
+// impossible before looking at individual people. The biggest
+// problem is reported first.
+func explainGroups(pm ParticipantMap, opts *GiftExchangeOptions) *GroupCapacityError {
+	n := len(pm)
+	sizes := make(map[string]int)
+	names := make(map[string]string) // The name of each group as it was first written
+	for _, id := range drawOrder(pm) {
+		if g := trimLower(pm[id].Group); g != "" {
+			sizes[g]++
+			if _, ok := names[g]; !ok {
+				names[g] = trim(pm[id].Group)
+			}
+		}
+	}
+
+	// Pinned people can give to somebody in their own group
+	pinned := make(map[string]bool)
+	for _, p := range pm {
+		if len(p.Pinned) > 0 {
+			pinned[trimLower(p.Group)] = true
+		}
+	}
+
+	var biggest string
+	for g, size := range sizes {
+		if 2*size > n && !pinned[g] && (biggest == "" || size > sizes[biggest]) {
+			biggest = g
+		}
+	}
+
+	if biggest != "" {
+		return &GroupCapacityError{Group: names[biggest], Members: sizes[biggest], Total: n}
+	}
+
+	// Groups nobody is in are named the way they were first written
+	// in a rule
+	nameGroup := func(g string) {
+		if _, ok := names[trimLower(g)]; !ok && trim(g) != "" {
+			names[trimLower(g)] = trim(g)
+		}
+	}
+
+	for _, id := range drawOrder(pm) {
+		nameGroup(pm[id].GivesTo)
+	}
+
+	if opts != nil {
+		for _, r := range opts.GroupRules {
+			nameGroup(r.Recipient)
+		}
+	}
+
+	// Count everybody that has to give to each group
+	givers := make(map[string][]Pid)
+	for id, to := range groupTargets(pm, opts) {
+		givers[to] = append(givers[to], id)
+	}
+
+	var worst *GroupCapacityError
+	for to, ids := range givers {
+		if len(ids) <= sizes[to] {
+			continue
+		}
+
+		from := make(map[string]bool)
+		for _, id := range ids {
+			if g := trim(pm[id].Group); g != "" {
+				from[g] = true
+			} else {
+				from[pm[id].Name] = true
+			}
+		}
+
+		list := make([]string, 0, len(from))
+		for g := range from {
+			list = append(list, g)
+		}
+		sort.Strings(list)
+
+		err := &GroupCapacityError{
+			Group:      joinNames(list),
+			Members:    len(ids),
+			Total:      n,
+			Recipients: names[to],
+			Capacity:   sizes[to],
+		}
+
+		if worst == nil || err.Members-err.Capacity > worst.Members-worst.Capacity ||
+			(err.Members-err.Capacity == worst.Members-worst.Capacity && err.Recipients < worst.Recipients) {
+			worst = err
+		}
+	}
+
+	return worst
+}
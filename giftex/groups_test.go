@@ -0,0 +1,184 @@
+package giftex
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func Example_groupRules() {
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo", Group: "Team A"},
+		1: {ID: 1, Name: "bar", Group: "Team A"},
+		2: {ID: 2, Name: "baz", Group: "Team B"},
+		3: {ID: 3, Name: "qux", Group: "Team B"},
+		4: {ID: 4, Name: "quux", Group: "Team C"},
+		5: {ID: 5, Name: "corge", Group: "Team C"},
+	}
+
+	// Team A and Team B give to each other, so Team C has to give
+	// to itself... except nobody can, so it gives to Team A instead
+	opts := &GiftExchangeOptions{
+		GroupRules: []GroupRule{
+			{Giver: "Team A", Recipient: "Team B"},
+			{Giver: "team b", Recipient: "team c"},
+			{Giver: "Team C", Recipient: "Team A"},
+		},
+	}
+
+	ge, err := NewGiftExchange(pm, opts)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, id := range drawOrder(pm) {
+		fmt.Printf("%s gives to somebody in %s\n", pm[id].Name, pm[ge.Assignment[id]].Group)
+	}
+
+	// Output:
+	// bar gives to somebody in Team B
+	// baz gives to somebody in Team C
+	// corge gives to somebody in Team A
+	// foo gives to somebody in Team B
+	// quux gives to somebody in Team A
+	// qux gives to somebody in Team C
+}
+
+func TestParseGroupRule(t *testing.T) {
+	tests := []struct {
+		s    string
+		want GroupRule
+		err  error
+	}{
+		{s: "Team A -> Team B", want: GroupRule{"Team A", "Team B"}},
+		{s: " sales->support ", want: GroupRule{"sales", "support"}},
+		{s: "Team A", err: ErrInvalidGroupRule},
+		{s: "-> Team B", err: ErrInvalidGroupRule},
+		{s: "a -> b -> c", err: ErrInvalidGroupRule},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseGroupRule(tt.s)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("%q: want: %v, %v; got: %v, %v", tt.s, tt.want, tt.err, got, err)
+		}
+	}
+}
+
+func TestReadCSV_departments(t *testing.T) {
+	db, err := ReadCSVFromFile("testdata/departments.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, id := range drawOrder(db.Participants) {
+		p := db.Participants[id]
+		got = append(got, fmt.Sprintf("%s:%s:%s", p.Name, p.Group, p.GivesTo))
+	}
+
+	want := "[bar:sales:Support baz:Marketing: foo:Sales: qux:Support:]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("want: %s; got: %v", want, got)
+	}
+
+	// bar is in Sales with foo and has to give to somebody in Support
+	restrictions, previous := splitConstraints(db.Participants, nil)
+	s := newConstraintGraph(db.Participants, nil, restrictions, previous)
+	if got := s.dense().String(); got != "1 1 0 0\n1 1 1 0\n0 0 1 0\n0 0 0 1" {
+		t.Errorf("unexpected constraints:\n%s", got)
+	}
+}
+
+func TestExplainGroups(t *testing.T) {
+	newPM := func(groups ...string) ParticipantMap {
+		pm := make(ParticipantMap, len(groups))
+		for i, g := range groups {
+			pm[Pid(i)] = Participant{ID: Pid(i), Name: fmt.Sprint("p", i), Group: g}
+		}
+
+		return pm
+	}
+
+	tests := []struct {
+		name  string
+		pm    ParticipantMap
+		rules []GroupRule
+		want  string
+	}{
+		{
+			name: "Fits",
+			pm:   newPM("Sales", "Sales", "Support", "Support", ""),
+		},
+		{
+			name: "More than half",
+			pm:   newPM("Sales", "Sales", "sales", "Support", ""),
+			want: "No Solution: Sales has 3 of 5 people, which is more than half, so they can't all give to somebody outside their group",
+		},
+		{
+			name:  "Too many givers",
+			pm:    newPM("Sales", "Sales", "Support", "Marketing", "Marketing"),
+			rules: []GroupRule{{"Sales", "Support"}, {"Marketing", "Sales"}},
+			want:  "No Solution: 2 people in Sales have to give to Support, which only has 1 person",
+		},
+		{
+			name:  "Missing group",
+			pm:    newPM("Sales", "Support", "Marketing"),
+			rules: []GroupRule{{"Sales", "Legal"}},
+			want:  "No Solution: 1 person in Sales has to give to Legal, which has nobody in it",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &GiftExchangeOptions{GroupRules: tt.rules}
+			err := explainGroups(tt.pm, opts)
+			if err == nil {
+				if tt.want != "" {
+					t.Fatalf("want: %s; got: nil", tt.want)
+				}
+
+				return
+			}
+
+			if got := err.Error(); tt.want != got {
+				t.Errorf("want: %s; got: %s", tt.want, got)
+			}
+
+			// NewGiftExchange explains the same problem
+			_, ngErr := NewGiftExchange(tt.pm, opts)
+			var gce *GroupCapacityError
+			if !errors.As(ngErr, &gce) || !errors.Is(ngErr, ErrNoSolution) {
+				t.Errorf("want GroupCapacityError; got: %v", ngErr)
+			}
+		})
+	}
+}
+
+func TestSuggestRelaxations_groupRules(t *testing.T) {
+	// qux can only give to foo, who bar has to give to
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo", Group: "Sales"},
+		1: {ID: 1, Name: "bar", GivesTo: "Sales"},
+		2: {ID: 2, Name: "baz"},
+		3: {ID: 3, Name: "qux", Restrictions: []Pid{1, 2}},
+	}
+
+	suggestions := SuggestRelaxations(pm, nil)
+	if len(suggestions) == 0 {
+		t.Fatal("want suggestions")
+	}
+
+	for _, r := range suggestions {
+		for _, p := range r.Restrictions {
+			if p.Giver == 1 {
+				t.Errorf("suggested breaking a group rule: %s", r.Describe(pm))
+			}
+		}
+
+		newPM, newOpts := r.Apply(pm, nil)
+		if _, err := NewGiftExchange(newPM, newOpts); err != nil {
+			t.Errorf("%s: %v", r.Describe(pm), err)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package giftex
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+)
+
+var (
+	ErrInvalidHistory = errors.New("Error: history csv must include year, giver, and recipient columns")
+)
+
+// HistoryRecord is one gift given in a past gift exchange. People are
+// recorded by name because their Pids change from year to year.
+type HistoryRecord struct {
+	Year      int
+	Giver     string
+	Recipient string
+}
+
+// History is a dated record of who gave to who in past gift exchanges.
+// Unlike Participant.Previous, skipping a year or drawing again partway
+// through a year doesn't shift which matches count as recent.
+type History []HistoryRecord
+
+func ReadHistoryFromFile(path string) (History, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading history: %w", err)
+	}
+	defer f.Close()
+
+	return ReadHistory(f)
+}
+
+// ReadHistory reads a CSV of past gift exchanges written by
+// History.WriteCSV. The year, giver, and recipient columns are
+// required and may be in any order.
+func ReadHistory(r io.Reader) (History, error) {
+	return ImportHistory(r, nil)
+}
+
+// ImportHistory works like ReadHistory, but stops with ErrTooLarge or
+// ErrTooManyRows once the CSV is bigger than opts.MaxBytes or
+// opts.MaxRows. The other ImportOptions aren't used.
+func ImportHistory(r io.Reader, opts *ImportOptions) (History, error) {
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+
+	csvReader := csv.NewReader(&countingReader{r: r, max: opts.MaxBytes})
+	csvReader.FieldsPerRecord = -1 // Allow empty columns
+
+	headers, err := csvReader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, ErrInvalidHistory
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Error reading history: %w", err)
+	}
+
+	cols := make(map[string]int, len(headers))
+	for i, v := range headers {
+		cols[trimLower(v)] = i
+	}
+
+	for _, key := range []string{"year", "giver", "recipient"} {
+		if _, ok := cols[key]; !ok {
+			return nil, fmt.Errorf("%w: missing %s column", ErrInvalidHistory, key)
+		}
+	}
+
+	var h History
+	for line := 2; ; line++ {
+		row, err := csvReader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Error reading history: %w", err)
+		}
+
+		if opts.MaxRows > 0 && line-1 > opts.MaxRows {
+			return nil, fmt.Errorf("%w: the limit is %d", ErrTooManyRows, opts.MaxRows)
+		}
+
+		get := func(key string) string {
+			if col := cols[key]; col < len(row) {
+				return trim(row[col])
+			}
+
+			return ""
+		}
+
+		// Skip blank lines
+		if get("year") == "" && get("giver") == "" && get("recipient") == "" {
+			continue
+		}
+
+		year, err := strconv.Atoi(get("year"))
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad year on line %d", ErrInvalidHistory, line)
+		}
+
+		rec := HistoryRecord{Year: year, Giver: get("giver"), Recipient: get("recipient")}
+		if rec.Giver == "" || rec.Recipient == "" {
+			return nil, fmt.Errorf("%w: missing name on line %d", ErrInvalidHistory, line)
+		}
+
+		h = append(h, rec)
+	}
+
+	return h, nil
+}
+
+// WriteCSV writes h as a CSV with year, giver, and recipient columns,
+// sorted by year and then by name.
+func (h History) WriteCSV(w io.Writer) error {
+	sorted := h.sorted()
+
+	b := csv.NewWriter(w)
+	b.Write([]string{"year", "giver", "recipient"})
+	for _, rec := range sorted {
+		b.Write([]string{strconv.Itoa(rec.Year), rec.Giver, rec.Recipient})
+	}
+
+	b.Flush()
+	return b.Error()
+}
+
+// Add returns a copy of h with the results of a gift exchange drawn
+// in year. Anything the same givers were recorded giving earlier in
+// that year is replaced, so drawing again partway through a year
+// doesn't count as another year.
+func (h History) Add(year int, pm ParticipantMap, results Results) History {
+	assignments := results.Multi()
+	givers := make(map[string]bool, len(assignments))
+	for giver := range assignments {
+		givers[trimLower(pm[giver].Name)] = true
+	}
+
+	newH := make(History, 0, len(h)+len(assignments))
+	for _, rec := range h {
+		if rec.Year != year || !givers[trimLower(rec.Giver)] {
+			newH = append(newH, rec)
+		}
+	}
+
+	for giver, recipients := range assignments {
+		for _, recipient := range recipients {
+			newH = append(newH, HistoryRecord{
+				Year:      year,
+				Giver:     pm[giver].Name,
+				Recipient: pm[recipient].Name,
+			})
+		}
+	}
+
+	return newH.sorted()
+}
+
+// HistoryFromPrevious converts everybody's Previous list into dated
+// records, assuming each entry is one year apart and the most recent
+// one is from lastYear. It's meant for moving an old CSV over to
+// History, so it can't know about any years that were skipped.
+func HistoryFromPrevious(pm ParticipantMap, lastYear int) History {
+	var h History
+	for _, p := range pm {
+		for k, id := range p.Previous {
+			if _, ok := pm[id]; !ok {
+				continue
+			}
+
+			h = append(h, HistoryRecord{
+				Year:      lastYear - (len(p.Previous) - 1 - k),
+				Giver:     p.Name,
+				Recipient: pm[id].Name,
+			})
+		}
+	}
+
+	return h.sorted()
+}
+
+// latestYear returns the most recent year in h, or 0 if h is empty.
+func (h History) latestYear() int {
+	var latest int
+	for _, rec := range h {
+		if rec.Year > latest {
+			latest = rec.Year
+		}
+	}
+
+	return latest
+}
+
+// oldestYear returns the earliest year in h, or 0 if h is empty.
+func (h History) oldestYear() int {
+	var oldest int
+	for _, rec := range h {
+		if oldest == 0 || rec.Year < oldest {
+			oldest = rec.Year
+		}
+	}
+
+	return oldest
+}
+
+// before returns the records in h from before year.
+func (h History) before(year int) History {
+	var earlier History
+	for _, rec := range h {
+		if rec.Year < year {
+			earlier = append(earlier, rec)
+		}
+	}
+
+	return earlier
+}
+
+func (h History) sorted() History {
+	sorted := append(History(nil), h...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Year != b.Year {
+			return a.Year < b.Year
+		}
+
+		if a.Giver != b.Giver {
+			return a.Giver < b.Giver
+		}
+
+		return a.Recipient < b.Recipient
+	})
+
+	return sorted
+}
+
+// usesHistory reports whether repeat rules come from History instead
+// of everybody's Previous list.
+func (opts *GiftExchangeOptions) usesHistory() bool {
+	return opts != nil && len(opts.History) > 0
+}
+
+// previous returns p.Previous unless it's replaced by History.
+func (opts *GiftExchangeOptions) previous(p Participant) []Pid {
+	if opts.usesHistory() {
+		return nil
+	}
+
+	return p.Previous
+}
+
+// withoutPrevious returns a copy of pm without anybody's Previous list
+// when it's replaced by History.
+func (opts *GiftExchangeOptions) withoutPrevious(pm ParticipantMap) ParticipantMap {
+	if !opts.usesHistory() {
+		return pm
+	}
+
+	newPM := make(ParticipantMap, len(pm))
+	for id, p := range pm {
+		p.Previous = nil
+		newPM[id] = p
+	}
+
+	return newPM
+}
+
+// year returns the year of the gift exchange being drawn, which is
+// the year after the most recent record in History unless Year is set.
+func (opts *GiftExchangeOptions) year() int {
+	if opts == nil {
+		return 0
+	}
+
+	if opts.Year != 0 {
+		return opts.Year
+	}
+
+	return opts.History.latestYear() + 1
+}
+
+// recentHistory returns the records in History that are too recent
+// to repeat this year.
+func (opts *GiftExchangeOptions) recentHistory() History {
+	if opts == nil {
+		return nil
+	}
+
+	var recent History
+	year := opts.year()
+	for _, rec := range opts.History {
+		ago := year - rec.Year
+		if ago < 1 || (opts.RepeatYears > 0 && ago > opts.RepeatYears) {
+			continue
+		}
+
+		recent = append(recent, rec)
+	}
+
+	return recent
+}
+
+// historyPairs calls fn with the giver and recipient of every record
+// in h between two participants in pm. It's called again with the pair
+// reversed when repeat rules apply both ways.
+func historyPairs(pm ParticipantMap, opts *GiftExchangeOptions, h History, fn func(rec HistoryRecord, giver, recipient Pid)) {
+	byName := make(map[string]Pid, len(pm))
+	for id, p := range pm {
+		byName[trimLower(p.Name)] = id
+	}
+
+	for _, rec := range h {
+		giver, ok := byName[trimLower(rec.Giver)]
+		if !ok {
+			continue
+		}
+
+		recipient, ok := byName[trimLower(rec.Recipient)]
+		if !ok || giver == recipient {
+			continue
+		}
+
+		fn(rec, giver, recipient)
+		if opts.RepeatBothWays {
+			fn(rec, recipient, giver)
+		}
+	}
+}
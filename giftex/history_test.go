@@ -0,0 +1,279 @@
+package giftex
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Example_history() {
+	h, err := ReadHistory(strings.NewReader(`year,giver,recipient
+2021,foo,bar
+2021,bar,baz
+2021,baz,foo
+2023,foo,baz
+2023,baz,bar
+2023,bar,foo
+`))
+	if err != nil {
+		panic(err)
+	}
+
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo"},
+		1: {ID: 1, Name: "bar"},
+		2: {ID: 2, Name: "baz"},
+	}
+
+	// Nobody repeats a match from 2023 or 2022, and skipping 2022
+	// means 2021 can be repeated
+	opts := &GiftExchangeOptions{History: h, RepeatYears: 2}
+	ge, err := NewGiftExchange(pm, opts)
+	if err != nil {
+		panic(err)
+	}
+
+	h = h.Add(opts.year(), pm, ge)
+	h.WriteCSV(os.Stdout)
+
+	// Output:
+	// year,giver,recipient
+	// 2021,bar,baz
+	// 2021,baz,foo
+	// 2021,foo,bar
+	// 2023,bar,foo
+	// 2023,baz,bar
+	// 2023,foo,baz
+	// 2024,bar,baz
+	// 2024,baz,foo
+	// 2024,foo,bar
+}
+
+func TestReadHistory(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+		opts *ImportOptions
+		want string
+		err  error
+	}{
+		{
+			name: "Any column order",
+			csv:  "Recipient,Year,Giver\nbar,2022, foo \n\nfoo,2023,bar\n",
+			want: "[{2022 foo bar} {2023 bar foo}]",
+		},
+		{
+			name: "Missing column",
+			csv:  "year,giver\n2022,foo\n",
+			err:  ErrInvalidHistory,
+		},
+		{
+			name: "Bad year",
+			csv:  "year,giver,recipient\nlast year,foo,bar\n",
+			err:  ErrInvalidHistory,
+		},
+		{
+			name: "Missing name",
+			csv:  "year,giver,recipient\n2022,foo,\n",
+			err:  ErrInvalidHistory,
+		},
+		{
+			name: "Too many rows",
+			csv:  "year,giver,recipient\n2022,foo,bar\n2023,bar,foo\n",
+			opts: &ImportOptions{MaxRows: 1},
+			err:  ErrTooManyRows,
+		},
+		{
+			name: "Too large",
+			csv:  "year,giver,recipient\n2022,foo,bar\n",
+			opts: &ImportOptions{MaxBytes: 30},
+			err:  ErrTooLarge,
+		},
+		{
+			name: "Within limits",
+			csv:  "year,giver,recipient\n2022,foo,bar\n",
+			opts: &ImportOptions{MaxBytes: 34, MaxRows: 1},
+			want: "[{2022 foo bar}]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := ImportHistory(strings.NewReader(tt.csv), tt.opts)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("want error: %v; got: %v", tt.err, err)
+			}
+
+			if got := fmt.Sprint(h); tt.err == nil && tt.want != got {
+				t.Errorf("want: %s; got: %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHistory_Add(t *testing.T) {
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo"},
+		1: {ID: 1, Name: "bar"},
+		2: {ID: 2, Name: "baz"},
+	}
+
+	h := History{{2023, "foo", "bar"}, {2024, "foo", "baz"}, {2024, "qux", "foo"}}
+
+	// Drawing again in 2024 replaces foo's record but not qux's
+	got := fmt.Sprint(h.Add(2024, pm, Assignment{0: 1, 1: 2, 2: 0}))
+	want := "[{2023 foo bar} {2024 bar baz} {2024 baz foo} {2024 foo bar} {2024 qux foo}]"
+	if want != got {
+		t.Errorf("want: %s; got: %s", want, got)
+	}
+}
+
+func TestHistoryFromPrevious(t *testing.T) {
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo", Previous: []Pid{1, 2}}, // 2 isn't participating
+		1: {ID: 1, Name: "bar", Previous: []Pid{0}},
+	}
+
+	got := fmt.Sprint(HistoryFromPrevious(pm, 2023))
+	want := "[{2022 foo bar} {2023 bar foo}]"
+	if want != got {
+		t.Errorf("want: %s; got: %s", want, got)
+	}
+}
+
+func TestSplitConstraints_history(t *testing.T) {
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo", Previous: []Pid{2}}, // Ignored when using history
+		1: {ID: 1, Name: "bar"},
+		2: {ID: 2, Name: "baz"},
+		3: {ID: 3, Name: "qux"},
+	}
+
+	h := History{
+		{2020, "bar", "qux"},
+		{2022, "bar", "foo"},
+		{2023, "qux", "baz"},
+		{2023, "qux", "foo"}, // Drawn again partway through 2023
+		{2024, "baz", "bar"}, // This year's results
+		{2023, "quux", "foo"},
+	}
+
+	tests := []struct {
+		name string
+		opts *GiftExchangeOptions
+		want string
+	}{
+		{
+			name: "Every year",
+			opts: &GiftExchangeOptions{History: h, Year: 2024},
+			want: "map[0:[] 1:[3 0] 2:[] 3:[2 0]]",
+		},
+		{
+			name: "Within 2 years",
+			opts: &GiftExchangeOptions{History: h, Year: 2024, RepeatYears: 2},
+			want: "map[0:[] 1:[0] 2:[] 3:[2 0]]",
+		},
+		{
+			name: "Within 1 year",
+			opts: &GiftExchangeOptions{History: h, Year: 2024, RepeatYears: 1},
+			want: "map[0:[] 1:[] 2:[] 3:[2 0]]",
+		},
+		{
+			name: "Both ways",
+			opts: &GiftExchangeOptions{History: h, Year: 2024, RepeatYears: 1, RepeatBothWays: true},
+			want: "map[0:[3] 1:[] 2:[3] 3:[2 0]]",
+		},
+		{
+			name: "Year after the latest record",
+			opts: &GiftExchangeOptions{History: h, RepeatYears: 1},
+			want: "map[0:[] 1:[] 2:[1] 3:[]]",
+		},
+		{
+			name: "Previous both ways without history",
+			opts: &GiftExchangeOptions{RepeatBothWays: true},
+			want: "map[0:[2] 1:[] 2:[0] 3:[]]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, previous := splitConstraints(pm, tt.opts)
+			if got := fmt.Sprint(previous); tt.want != got {
+				t.Errorf("want: %s; got: %s", tt.want, got)
+			}
+		})
+	}
+
+	if got := fmt.Sprint(pm[0].Previous); got != "[2]" {
+		t.Errorf("Previous was modified: %s", got)
+	}
+}
+
+func TestRelaxation_Apply_history(t *testing.T) {
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo"},
+		1: {ID: 1, Name: "bar"},
+		2: {ID: 2, Name: "baz"},
+		3: {ID: 3, Name: "qux"},
+	}
+
+	// foo can't give to anybody until 2022 is forgotten
+	h := History{{2023, "foo", "bar"}, {2023, "baz", "qux"}, {2022, "foo", "baz"}, {2022, "qux", "foo"}}
+	opts := &GiftExchangeOptions{History: h, Year: 2024, RepeatYears: 2, RepeatBothWays: true}
+	if feasible(pm, opts) {
+		t.Fatal("want infeasible gift exchange")
+	}
+
+	suggestions := SuggestRelaxations(pm, opts)
+	if len(suggestions) == 0 || suggestions[0].MaxPrevious != 1 {
+		t.Fatalf("want waiting 1 year first; got: %v", suggestions)
+	}
+
+	for _, r := range suggestions {
+		newPM, newOpts := r.Apply(pm, opts)
+		if _, err := NewGiftExchange(newPM, newOpts); err != nil {
+			t.Errorf("%s: %v", strings.Join(r.Describe(pm), "; "), err)
+		}
+
+		if len(r.Previous) > 0 && len(newOpts.History) >= len(h) {
+			t.Errorf("want records dropped from history; got: %v", newOpts.History)
+		}
+	}
+
+	if len(opts.History) != len(h) {
+		t.Errorf("History was modified: %v", opts.History)
+	}
+}
+
+func TestCommit_history(t *testing.T) {
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo"},
+		1: {ID: 1, Name: "bar"},
+		2: {ID: 2, Name: "baz"},
+	}
+
+	opts := &GiftExchangeOptions{Seed: 7}
+	if Commit(pm, opts) != Commit(pm, &GiftExchangeOptions{Seed: 7, Year: 2024, RepeatYears: 3}) {
+		t.Error("unused history options changed the commitment")
+	}
+
+	opts = &GiftExchangeOptions{Seed: 7, History: History{{2023, "foo", "bar"}}, Year: 2024}
+	ge, err := NewGiftExchange(pm, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The results can be verified after they're added to the history
+	after := *opts
+	after.History = opts.History.Add(2024, pm, ge)
+	if err := Verify(pm, &after, ge.Commitment, ge); err != nil {
+		t.Error(err)
+	}
+
+	after.History = append(after.History, HistoryRecord{2022, "bar", "baz"})
+	if err := Verify(pm, &after, ge.Commitment, ge); !errors.Is(err, ErrCommitmentMismatch) {
+		t.Errorf("want: %v; got: %v", ErrCommitmentMismatch, err)
+	}
+}
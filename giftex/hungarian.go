@@ -0,0 +1,99 @@
+package giftex
+
+// infCost marks a pair that may never be assigned. It is large enough
+// that no combination of regular costs can add up to it, yet small
+// enough that adding a few of them together can't overflow.
+const infCost int64 = 1 << 50
+
+// minCostAssignment is the Kuhn-Munkres (Hungarian) algorithm. Given
+// a square matrix where cost[i][j] is the cost of giver i giving to
+// recipient j, it finds an assignment with the smallest total cost in
+// O(n³) time. The returned slice maps each giver to their recipient.
+//
+// The total cost will be at least infCost when every assignment
+// includes a forbidden pair.
+func minCostAssignment(cost [][]int64) (assign []int, total int64) {
+	n := len(cost)
+	if n == 0 {
+		return []int{}, 0
+	}
+
+	// We keep a potential for every row (u) and column (v) such that
+	// u[i] + v[j] <= cost[i][j]. A pair where both sides are equal is
+	// "tight" and only tight pairs can be part of the assignment.
+	// Rows and columns are 1-indexed so that index 0 can act as a
+	// virtual column for the row currently being added.
+	u := make([]int64, n+1)
+	v := make([]int64, n+1)
+	p := make([]int, n+1)   // p[j] is the row assigned to column j
+	way := make([]int, n+1) // way[j] is the previous column on the path to j
+
+	minv := make([]int64, n+1)
+	used := make([]bool, n+1)
+
+	for i := 1; i <= n; i++ {
+		p[0] = i
+		j0 := 0
+		for j := range minv {
+			minv[j] = infCost * 4
+			used[j] = false
+		}
+
+		// Grow a tree of tight pairs from row i until it reaches a free
+		// column, adjusting the potentials whenever we get stuck.
+		for {
+			used[j0] = true
+			i0 := p[j0]
+			delta := infCost * 4
+			j1 := 0
+
+			for j := 1; j <= n; j++ {
+				if used[j] {
+					continue
+				}
+
+				if cur := cost[i0-1][j-1] - u[i0] - v[j]; cur < minv[j] {
+					minv[j] = cur
+					way[j] = j0
+				}
+
+				if minv[j] < delta {
+					delta = minv[j]
+					j1 = j
+				}
+			}
+
+			for j := 0; j <= n; j++ {
+				if used[j] {
+					u[p[j]] += delta
+					v[j] -= delta
+				} else {
+					minv[j] -= delta
+				}
+			}
+
+			j0 = j1
+			if p[j0] == 0 {
+				break
+			}
+		}
+
+		// Flip the assignment along the path back to the virtual column
+		for j0 != 0 {
+			j1 := way[j0]
+			p[j0] = p[j1]
+			j0 = j1
+		}
+	}
+
+	assign = make([]int, n)
+	for j := 1; j <= n; j++ {
+		assign[p[j]-1] = j - 1
+	}
+
+	for i, j := range assign {
+		total += cost[i][j]
+	}
+
+	return assign, total
+}
@@ -0,0 +1,76 @@
+package giftex
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestMinCostAssignment(t *testing.T) {
+	tests := []struct {
+		cost [][]int64
+		want int64
+	}{
+		{cost: [][]int64{}, want: 0},
+		{cost: [][]int64{{7}}, want: 7},
+		{cost: [][]int64{{4, 1, 3}, {2, 0, 5}, {3, 2, 2}}, want: 5},
+		{cost: [][]int64{{infCost, 1}, {1, infCost}}, want: 2},
+		{cost: [][]int64{{-5, 0}, {0, -5}}, want: -10},
+	}
+
+	for i, tt := range tests {
+		if _, got := minCostAssignment(tt.cost); tt.want != got {
+			t.Errorf("wrong total for matrix %d: want: %d; got: %d", i, tt.want, got)
+		}
+	}
+}
+
+func TestMinCostAssignment_bruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+
+	for try := 0; try < 500; try++ {
+		n := 1 + r.Intn(6)
+		cost := make([][]int64, n)
+		for i := range cost {
+			cost[i] = make([]int64, n)
+			for j := range cost[i] {
+				if r.Intn(4) == 0 {
+					cost[i][j] = infCost
+				} else {
+					cost[i][j] = r.Int63n(21) - 10
+				}
+			}
+		}
+
+		want := infCost * int64(n)
+		for _, p := range bruteForce(zeroMatrix(n)) {
+			var total int64
+			for i, j := range p {
+				total += cost[i][j]
+			}
+
+			if total < want {
+				want = total
+			}
+		}
+
+		assign, got := minCostAssignment(cost)
+		if want < infCost && want != got {
+			t.Fatalf("want: %d; got: %d (%v) for costs:\n%v", want, got, assign, cost)
+		}
+
+		if want >= infCost && got < infCost {
+			t.Fatalf("found assignment %v costing %d when none should exist:\n%v", assign, got, cost)
+		}
+	}
+}
+
+// zeroMatrix creates an n x n matrix without any constraints, not
+// even the identity, so that bruteForce lists every permutation.
+func zeroMatrix(n int) matrix {
+	m := newMatrix(n)
+	for i := range m {
+		m[i][i] = 0
+	}
+
+	return m
+}
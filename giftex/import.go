@@ -0,0 +1,245 @@
+package giftex
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrTooLarge    = errors.New("Error: file is too large")
+	ErrTooManyRows = errors.New("Error: file has too many rows")
+)
+
+// defaultProgressEvery is how many rows are read between calls to
+// ImportOptions.Progress.
+const defaultProgressEvery = 1000
+
+// RecordReader reads a table one record at a time, with the column
+// headers first, and returns io.EOF after the last record.
+type RecordReader interface {
+	Read() ([]string, error)
+}
+
+// StreamCodec is a Codec that can also read a file one record at a
+// time, so a large file is never held in memory twice. CSVCodec and
+// XLSXCodec are StreamCodecs, while JSONCodec reads the whole file
+// first.
+type StreamCodec interface {
+	Codec
+	NewReader(r io.Reader) (RecordReader, error)
+}
+
+// ImportOptions controls how Import reads a file. The zero value reads
+// a CSV of any size laid out like the ones WriteCSV writes.
+type ImportOptions struct {
+	// Schema describes the layout of the file.
+	Schema Schema
+
+	// Codec reads the file, CSVCodec{} by default.
+	Codec Codec
+
+	// MaxBytes and MaxRows stop the import with ErrTooLarge or
+	// ErrTooManyRows once the file is bigger than this. Rows don't
+	// count the headers. An XLSX workbook can't unpack to more than
+	// MaxBytes either, see XLSXCodec.MaxBytes. There's no limit when
+	// they're 0.
+	MaxBytes int64
+	MaxRows  int
+
+	// MaxProblems is how many errors and how many warnings are listed
+	// in the ValidationReport. The rest are only counted, see
+	// ValidationReport.MoreErrors. There's no limit when it's 0.
+	MaxProblems int
+
+	// Progress is called every ProgressEvery rows, 1000 by default,
+	// and once more when the whole file has been read.
+	Progress      func(Progress)
+	ProgressEvery int
+}
+
+// Progress is how far Import has got through a file.
+type Progress struct {
+	Rows  int   // Rows read so far, not counting the headers
+	Bytes int64 // Bytes read from the file so far
+	Done  bool  // Whether the whole file has been read
+}
+
+// Import works like ReadCSV, but reads the file one record at a time
+// so it can be stopped as soon as it's too large, and reports how far
+// it has got. Mistakes are collected row by row like ReadCSV, and
+// rows of a CSV that can't be parsed, e.g., because of a stray quote,
+// are listed as ErrMalformedRow instead of stopping the import.
+func Import(r io.Reader, opts *ImportOptions) (*GiftExchangeDB, error) {
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+
+	if err := opts.Schema.validate(); err != nil {
+		return nil, err
+	}
+
+	codec := opts.Codec
+	if codec == nil {
+		codec = CSVCodec{}
+	}
+	codec = opts.Schema.codec(codec)
+
+	// Workbooks are zipped, so what they unpack to is limited too
+	if x, ok := codec.(XLSXCodec); ok && x.MaxBytes == 0 {
+		x.MaxBytes = opts.MaxBytes
+		codec = x
+	}
+
+	counter := &countingReader{r: r, max: opts.MaxBytes}
+
+	var records RecordReader
+	if sc, ok := codec.(StreamCodec); ok {
+		rr, err := sc.NewReader(counter)
+		if err != nil {
+			return nil, err
+		}
+		records = rr
+	} else {
+		all, err := codec.Decode(counter)
+		if err != nil {
+			return nil, err
+		}
+		records = &sliceReader{records: all}
+	}
+
+	return opts.read(records, counter)
+}
+
+// read loads a GiftExchangeDB from records.
+func (opts *ImportOptions) read(records RecordReader, counter *countingReader) (*GiftExchangeDB, error) {
+	// The first record contains the column headers, and there has
+	// to be at least one participant after it
+	headers, err := records.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, ErrInvalidCSV
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	first, firstErr := records.Read()
+	if errors.Is(firstErr, io.EOF) {
+		return nil, ErrInvalidCSV
+	}
+
+	l := newLoader(headers, opts.Schema)
+	l.db.Report.max = opts.MaxProblems
+	if len(l.db.Report.Errors) > 0 {
+		return nil, &ValidationError{Report: l.db.Report}
+	}
+
+	every := opts.ProgressEvery
+	if every <= 0 {
+		every = defaultProgressEvery
+	}
+
+	row, rowErr := first, firstErr
+	for rows := 1; ; rows++ {
+		if opts.MaxRows > 0 && rows > opts.MaxRows {
+			return nil, fmt.Errorf("%w: the limit is %d", ErrTooManyRows, opts.MaxRows)
+		}
+
+		var pe *csv.ParseError
+		switch {
+		case rowErr == nil:
+			l.add(row)
+		case errors.As(rowErr, &pe):
+			l.db.Report.fail(Problem{Row: rows + 1, Err: fmt.Errorf("%w: %v", ErrMalformedRow, pe.Err)})
+			l.skip()
+		default:
+			return nil, rowErr
+		}
+
+		row, rowErr = records.Read()
+		done := errors.Is(rowErr, io.EOF)
+		if opts.Progress != nil && (done || rows%every == 0) {
+			opts.Progress(Progress{Rows: rows, Bytes: counter.n, Done: done})
+		}
+
+		if done {
+			break
+		}
+	}
+
+	return l.finish()
+}
+
+// countingReader counts the bytes read from r, and fails with
+// ErrTooLarge after max of them, unless max is 0.
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	max int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	if c.max > 0 {
+		if c.n > c.max {
+			return 0, fmt.Errorf("%w: the limit is %d bytes", ErrTooLarge, c.max)
+		}
+
+		// Read one byte past the limit to tell whether there's more
+		if left := c.max - c.n + 1; int64(len(p)) > left {
+			p = p[:left]
+		}
+	}
+
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if c.max > 0 && c.n > c.max {
+		return 0, fmt.Errorf("%w: the limit is %d bytes", ErrTooLarge, c.max)
+	}
+
+	return n, err
+}
+
+// add counts n more bytes that were read some other way, e.g., cells
+// that take up memory without taking up space in a file.
+func (c *countingReader) add(n int64) error {
+	c.n += n
+	if c.max > 0 && c.n > c.max {
+		return fmt.Errorf("%w: the limit is %d bytes", ErrTooLarge, c.max)
+	}
+
+	return nil
+}
+
+// sliceReader reads records that were already decoded.
+type sliceReader struct {
+	records [][]string
+}
+
+func (s *sliceReader) Read() ([]string, error) {
+	if len(s.records) == 0 {
+		return nil, io.EOF
+	}
+
+	record := s.records[0]
+	s.records = s.records[1:]
+	return record, nil
+}
+
+// readAll reads every record left in rr.
+func readAll(rr RecordReader) ([][]string, error) {
+	var records [][]string
+	for {
+		record, err := rr.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, record)
+	}
+}
@@ -0,0 +1,164 @@
+package giftex
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// bigCSV writes a CSV with n participants who each restrict the
+// person before them.
+func bigCSV(n int) string {
+	var b strings.Builder
+	b.WriteString("name,email,restrictions,previous,participating,has\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "p%d,p%d@example.com,p%d,,yes,\n", i, i, (i+n-1)%n)
+	}
+
+	return b.String()
+}
+
+func Example_import() {
+	opts := &ImportOptions{
+		MaxRows:       10000,
+		ProgressEvery: 2500,
+		Progress: func(p Progress) {
+			fmt.Printf("read %d rows, done: %v\n", p.Rows, p.Done)
+		},
+	}
+
+	db, err := Import(strings.NewReader(bigCSV(5000)), opts)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(len(db.Participants), "participants")
+
+	// Output:
+	// read 2500 rows, done: false
+	// read 5000 rows, done: true
+	// 5000 participants
+}
+
+func TestImport_limits(t *testing.T) {
+	csv := bigCSV(100)
+
+	tests := []struct {
+		name string
+		opts ImportOptions
+		err  error
+	}{
+		{name: "no limits"},
+		{name: "enough rows", opts: ImportOptions{MaxRows: 100}},
+		{name: "too many rows", opts: ImportOptions{MaxRows: 99}, err: ErrTooManyRows},
+		{name: "enough bytes", opts: ImportOptions{MaxBytes: int64(len(csv))}},
+		{name: "too large", opts: ImportOptions{MaxBytes: int64(len(csv)) - 1}, err: ErrTooLarge},
+		{name: "too large xlsx", opts: ImportOptions{MaxBytes: 100, Codec: XLSX.Codec}, err: ErrTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := Import(strings.NewReader(csv), &tt.opts)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("want: %v; got: %v", tt.err, err)
+			}
+
+			if err == nil && len(db.Participants) != 100 {
+				t.Errorf("want: 100 participants; got: %d", len(db.Participants))
+			}
+		})
+	}
+}
+
+func TestImport_malformedRows(t *testing.T) {
+	csv := "name,email,restrictions,previous,participating,has\n" +
+		"foo,foo@example.com,,,yes,\n" +
+		"b\"ar,bar@example.com,,,yes,\n" +
+		"baz,baz@example.com,,,yes,\n" +
+		",,,,yes,\n"
+
+	_, err := Import(strings.NewReader(csv), nil)
+
+	var ve *ValidationError
+	if !errors.As(err, &ve) {
+		t.Fatalf("want: *ValidationError; got: %v", err)
+	}
+
+	// The rows after the malformed row are still counted
+	want := []string{
+		`Error: row can't be read: bare " in non-quoted-field (row 3)`,
+		`Error: participants need a name (row 5, column 1 "name")`,
+	}
+	if got := problemStrings(ve.Report.Errors); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("\nwant: %q\n got: %q", want, got)
+	}
+
+	if !errors.Is(ve.Report.Errors[0], ErrMalformedRow) {
+		t.Errorf("want: %v; got: %v", ErrMalformedRow, ve.Report.Errors[0])
+	}
+}
+
+func TestImport_maxProblems(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("name,email,restrictions,previous,participating,has\n")
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&b, "p%d,not an email,,,yes,\n,,,,yes,\n", i)
+	}
+
+	_, err := Import(strings.NewReader(b.String()), &ImportOptions{MaxProblems: 3})
+
+	var ve *ValidationError
+	if !errors.As(err, &ve) {
+		t.Fatalf("want: *ValidationError; got: %v", err)
+	}
+
+	r := ve.Report
+	if len(r.Errors) != 3 || r.MoreErrors != 7 || len(r.Warnings) != 3 || r.MoreWarnings != 7 {
+		t.Errorf("want: 3 + 7 errors and warnings; got: %d + %d errors, %d + %d warnings",
+			len(r.Errors), r.MoreErrors, len(r.Warnings), r.MoreWarnings)
+	}
+
+	want := `Error: invalid file: participants need a name (row 3, column 1 "name") and 9 more problems`
+	if err.Error() != want {
+		t.Errorf("want: %s; got: %v", want, err)
+	}
+}
+
+// TestImport_sameAsReadCSV checks every format reads the same
+// participants whether it's streamed or decoded all at once.
+func TestImport_sameAsReadCSV(t *testing.T) {
+	want, err := ReadCSVFromFile("testdata/small.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range Formats() {
+		t.Run(f.Name, func(t *testing.T) {
+			var full strings.Builder
+			if err := f.Codec.Encode(&full, append([][]string{want.headers}, want.records...)); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := Import(strings.NewReader(full.String()), &ImportOptions{Codec: f.Codec, MaxRows: 4})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if fmt.Sprint(got.Participants) != fmt.Sprint(want.Participants) {
+				t.Errorf("\nwant: %v\n got: %v", want.Participants, got.Participants)
+			}
+		})
+	}
+}
+
+func BenchmarkImport(b *testing.B) {
+	csv := bigCSV(10000)
+	b.SetBytes(int64(len(csv)))
+
+	for i := 0; i < b.N; i++ {
+		if _, err := Import(strings.NewReader(csv), nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package giftex
+
+import (
+	"math/bits"
+	"math/rand"
+)
+
+// A bipartite graph is the adjacency list representation of a gift
+// exchange. Every giver is a vertex on the left and every recipient
+// is a vertex on the right. An edge from giver i to recipient j means
+// i is allowed to give to j.
+type bipartite [][]int
+
+// bipartite converts the zeros of matrix m into a bipartite graph.
+func (m matrix) bipartite() bipartite {
+	g := make(bipartite, len(m))
+	for i := range m {
+		for j := range m[i] {
+			if m[i][j] == 0 {
+				g[i] = append(g[i], j)
+			}
+		}
+	}
+
+	return g
+}
+
+const unmatched = -1
+
+// maxMatching uses the Hopcroft-Karp algorithm to find a maximum
+// matching of graph g in O(E√V) time. matchG maps each giver to a
+// recipient and matchR maps each recipient back to their giver.
+// Vertices that could not be matched are set to unmatched.
+func (g bipartite) maxMatching() (matchG, matchR []int, size int) {
+	n := len(g)
+	matchG = make([]int, n)
+	matchR = make([]int, n)
+	for i := range matchG {
+		matchG[i] = unmatched
+		matchR[i] = unmatched
+	}
+
+	const inf = int(^uint(0) >> 1)
+	dist := make([]int, n)
+	queue := make([]int, 0, n)
+
+	// bfs layers the graph by the length of the shortest alternating
+	// path starting from a free giver and reports whether any
+	// augmenting path exists at all.
+	bfs := func() bool {
+		queue = queue[:0]
+		for i := range g {
+			if matchG[i] == unmatched {
+				dist[i] = 0
+				queue = append(queue, i)
+			} else {
+				dist[i] = inf
+			}
+		}
+
+		found := false
+		for len(queue) > 0 {
+			i := queue[0]
+			queue = queue[1:]
+
+			for _, j := range g[i] {
+				k := matchR[j]
+				if k == unmatched {
+					found = true
+				} else if dist[k] == inf {
+					dist[k] = dist[i] + 1
+					queue = append(queue, k)
+				}
+			}
+		}
+
+		return found
+	}
+
+	// dfs follows the layers built by bfs looking for vertex disjoint
+	// augmenting paths and flips the matching along any it finds.
+	var dfs func(i int) bool
+	dfs = func(i int) bool {
+		for _, j := range g[i] {
+			k := matchR[j]
+			if k == unmatched || (dist[k] == dist[i]+1 && dfs(k)) {
+				matchG[i] = j
+				matchR[j] = i
+				return true
+			}
+		}
+
+		dist[i] = inf
+		return false
+	}
+
+	for bfs() {
+		for i := range g {
+			if matchG[i] == unmatched && dfs(i) {
+				size++
+			}
+		}
+	}
+
+	return matchG, matchR, size
+}
+
+// allowed builds a constant time lookup for the edges of graph g.
+func (g bipartite) allowed() func(i, j int) bool {
+	edges := make([]map[int]struct{}, len(g))
+	for i := range g {
+		edges[i] = make(map[int]struct{}, len(g[i]))
+		for _, j := range g[i] {
+			edges[i][j] = struct{}{}
+		}
+	}
+
+	return func(i, j int) bool {
+		_, ok := edges[i][j]
+		return ok
+	}
+}
+
+// isPerfect reports whether matchG assigns every giver a recipient
+// using only allowed edges and without sharing any recipients.
+func isPerfect(matchG []int, allowed func(i, j int) bool) bool {
+	taken := make([]bool, len(matchG))
+	for i, j := range matchG {
+		if j < 0 || j >= len(matchG) || taken[j] || !allowed(i, j) {
+			return false
+		}
+
+		taken[j] = true
+	}
+
+	return true
+}
+
+const (
+	// rejectionTries is how many random permutations to try before
+	// switching to a more expensive way of sampling.
+	rejectionTries = 1000
+
+	// exactLimit is the largest exchange we are willing to count
+	// every perfect matching for. The table used for counting has
+	// 2^n entries.
+	exactLimit = 16
+
+	// rejectionBudget caps the random numbers spent on permutations
+	// for exchanges so large that rejectionTries of them would take
+	// longer than the rest of the draw.
+	rejectionBudget = 10000000
+)
+
+// sample draws a perfect matching of graph g at random. Every valid
+// assignment is equally likely to be chosen, except for large and
+// heavily constrained exchanges where we fall back to a Markov chain
+// that is only approximately uniform. The second return value is
+// false when g doesn't have a perfect matching.
+func (g bipartite) sample(r *rand.Rand) ([]int, bool) {
+	n := len(g)
+	allowed := g.allowed()
+
+	// Most gift exchanges have few restrictions, so a random
+	// permutation is very likely to be valid already. Rejecting the
+	// invalid ones leaves us with a perfectly uniform draw.
+	for try := 0; try < rejectionTries; try++ {
+		if p := r.Perm(n); isPerfect(p, allowed) {
+			return p, true
+		}
+	}
+
+	if n <= exactLimit {
+		return g.sampleExact(r)
+	}
+
+	matchG, _, size := g.maxMatching()
+	if size < n {
+		return nil, false
+	}
+
+	return sampleMarkov(r, matchG, allowed, 100*n+10000), true
+}
+
+// sampleExact counts every perfect matching of g and then picks one
+// uniformly at random, one giver at a time.
+func (g bipartite) sampleExact(r *rand.Rand) ([]int, bool) {
+	n := len(g)
+	allowed := make([]uint32, n)
+	for i := range g {
+		for _, j := range g[i] {
+			allowed[i] |= 1 << uint(j)
+		}
+	}
+
+	// ways[mask] counts the number of ways givers |mask|..n-1 can be
+	// matched with the recipients that are not already in mask.
+	full := uint32(1)<<uint(n) - 1
+	ways := make([]uint64, full+1)
+	ways[full] = 1
+	for mask := int64(full) - 1; mask >= 0; mask-- {
+		k := bits.OnesCount32(uint32(mask))
+		free := allowed[k] &^ uint32(mask)
+		for free != 0 {
+			bit := free & -free
+			ways[mask] += ways[uint32(mask)|bit]
+			free &^= bit
+		}
+	}
+
+	if ways[0] == 0 {
+		return nil, false
+	}
+
+	matchG := make([]int, n)
+	var mask uint32
+	for k := 0; k < n; k++ {
+		pick := uint64(r.Int63n(int64(ways[mask])))
+
+		free := allowed[k] &^ mask
+		for free != 0 {
+			bit := free & -free
+			if w := ways[mask|bit]; pick < w {
+				matchG[k] = bits.TrailingZeros32(bit)
+				mask |= bit
+				break
+			} else {
+				pick -= w
+			}
+
+			free &^= bit
+		}
+	}
+
+	return matchG, true
+}
+
+// sampleMarkov shuffles the perfect matching matchG by repeatedly
+// swapping or rotating the recipients of random givers whenever the
+// result is still valid. Each move is as likely as its reverse, so the
+// chain tends towards the uniform distribution over every assignment
+// it can reach as steps grows. Swaps and rotations can't always reach
+// every assignment when the constraints are tight, and there's no
+// bound on how many steps are enough, so the draw is only
+// approximately uniform.
+func sampleMarkov(r *rand.Rand, matchG []int, ok func(i, j int) bool, steps int) []int {
+	n := len(matchG)
+	matchG = append([]int(nil), matchG...)
+	if n < 2 {
+		return matchG
+	}
+
+	for s := 0; s < steps; s++ {
+		a, b, c := r.Intn(n), r.Intn(n), r.Intn(n)
+		if a == b {
+			continue
+		}
+
+		// Rotations are needed to reach assignments that can't be
+		// made with swaps alone, such as flipping a 3-cycle around.
+		if r.Intn(2) == 0 && c != a && c != b {
+			ra, rb, rc := matchG[a], matchG[b], matchG[c]
+			if ok(a, rb) && ok(b, rc) && ok(c, ra) {
+				matchG[a], matchG[b], matchG[c] = rb, rc, ra
+			}
+
+			continue
+		}
+
+		ra, rb := matchG[a], matchG[b]
+		if ok(a, rb) && ok(b, ra) {
+			matchG[a], matchG[b] = rb, ra
+		}
+	}
+
+	return matchG
+}
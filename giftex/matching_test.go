@@ -0,0 +1,248 @@
+package giftex
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// bruteForce lists every valid assignment of matrix m by trying each
+// permutation of the participants. It is only practical for small
+// exchanges but gives us an oracle to compare against.
+func bruteForce(m matrix) [][]int {
+	n := len(m)
+	perm := make([]int, n)
+	used := make([]bool, n)
+
+	var found [][]int
+	var walk func(i int)
+	walk = func(i int) {
+		if i == n {
+			found = append(found, append([]int(nil), perm...))
+			return
+		}
+
+		for j := 0; j < n; j++ {
+			if used[j] || m[i][j] == 1 {
+				continue
+			}
+
+			used[j] = true
+			perm[i] = j
+			walk(i + 1)
+			used[j] = false
+		}
+	}
+
+	walk(0)
+	return found
+}
+
+// randomMatrix creates an n x n identity matrix and then adds a
+// constraint to every other cell with the given probability.
+func randomMatrix(r *rand.Rand, n int, density float64) matrix {
+	m := newMatrix(n)
+	for i := range m {
+		for j := range m[i] {
+			if r.Float64() < density {
+				m[i][j] = 1
+			}
+		}
+	}
+
+	return m
+}
+
+func TestMatrix_CheckConstraints_bruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for n := 1; n <= 7; n++ {
+		for _, density := range []float64{0, 0.2, 0.4, 0.6, 0.8} {
+			for try := 0; try < 50; try++ {
+				m := randomMatrix(r, n, density)
+
+				want := len(bruteForce(m)) > 0
+				if got := m.CheckConstraints(); want != got {
+					t.Fatalf("want: %v; got: %v for matrix:\n%v", want, got, m)
+				}
+
+				if !want {
+					continue
+				}
+
+				a := m.Assign(r)
+				for i, j := range a {
+					if m[i][j] == 1 {
+						t.Fatalf("invalid assignment %d -> %d for matrix:\n%v", i, j, m)
+					}
+				}
+
+				if len(a) != n {
+					t.Fatalf("incomplete assignment:\n%v\nfor matrix:\n%v", a, m)
+				}
+			}
+		}
+	}
+}
+
+func TestBipartite_maxMatching(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+
+	for try := 0; try < 200; try++ {
+		m := randomMatrix(r, 1+r.Intn(7), r.Float64())
+		g := m.bipartite()
+
+		matchG, matchR, size := g.maxMatching()
+
+		count := 0
+		for i, j := range matchG {
+			if j == unmatched {
+				continue
+			}
+
+			count++
+			if m[i][j] == 1 || matchR[j] != i {
+				t.Fatalf("inconsistent matching %v / %v for matrix:\n%v", matchG, matchR, m)
+			}
+		}
+
+		if count != size {
+			t.Fatalf("wrong size: want: %d; got: %d", count, size)
+		}
+
+		if want, got := len(bruteForce(m)) > 0, size == len(m); want != got {
+			t.Fatalf("perfect matching: want: %v; got: %v for matrix:\n%v", want, got, m)
+		}
+	}
+}
+
+// checkUniform draws from sample repeatedly and verifies that every
+// valid assignment in all shows up about equally often.
+func checkUniform(t *testing.T, all [][]int, sample func() []int) {
+	t.Helper()
+
+	counts := make(map[string]int, len(all))
+	for _, p := range all {
+		counts[fmt.Sprint(p)] = 0
+	}
+
+	const perAssignment = 2000
+	draws := perAssignment * len(all)
+	for i := 0; i < draws; i++ {
+		key := fmt.Sprint(sample())
+		if _, ok := counts[key]; !ok {
+			t.Fatalf("drew invalid assignment %s", key)
+		}
+
+		counts[key]++
+	}
+
+	// Allow each count to stray 10% from what we expect, which is
+	// more than 4 standard deviations for this many draws.
+	for key, got := range counts {
+		if diff := got - perAssignment; diff < -perAssignment/10 || diff > perAssignment/10 {
+			t.Errorf("assignment %s drawn %d times; want about %d", key, got, perAssignment)
+		}
+	}
+}
+
+func TestBipartite_sample(t *testing.T) {
+	// Each giver only has 2 or 3 people to choose from, which leaves
+	// few valid assignments and plenty of ways to "choose wrong".
+	m := matrix{
+		{1, 0, 0, 1, 1},
+		{0, 1, 0, 0, 1},
+		{1, 0, 1, 0, 0},
+		{0, 1, 1, 1, 0},
+		{0, 0, 1, 0, 1},
+	}
+
+	g := m.bipartite()
+	r := rand.New(rand.NewSource(3))
+
+	t.Run("sample", func(t *testing.T) {
+		checkUniform(t, bruteForce(m), func() []int {
+			p, _ := g.sample(r)
+			return p
+		})
+	})
+
+	t.Run("sampleExact", func(t *testing.T) {
+		checkUniform(t, bruteForce(m), func() []int {
+			p, _ := g.sampleExact(r)
+			return p
+		})
+	})
+
+	t.Run("sampleMarkov", func(t *testing.T) {
+		matchG, _, _ := g.maxMatching()
+		allowed := g.allowed()
+
+		checkUniform(t, bruteForce(m), func() []int {
+			return sampleMarkov(r, matchG, allowed, 200)
+		})
+	})
+
+	t.Run("derangements", func(t *testing.T) {
+		checkUniform(t, bruteForce(newMatrix(4)), func() []int {
+			p, _ := newMatrix(4).bipartite().sample(r)
+			return p
+		})
+	})
+
+	t.Run("no solution", func(t *testing.T) {
+		m := matrix{{1, 0, 1}, {0, 1, 1}, {0, 0, 1}}
+		if _, ok := m.bipartite().sampleExact(r); ok {
+			t.Error("want no assignment")
+		}
+	})
+}
+
+// TestSampleMarkov_nearExact compares the chance of each pair under
+// sampleMarkov with the exact chance, for a gift exchange just too big
+// to count and too constrained for random permutations.
+func TestSampleMarkov_nearExact(t *testing.T) {
+	const n = exactLimit + 2
+	r := rand.New(rand.NewSource(4))
+
+	var g bipartite
+	for {
+		g = randomMatrix(r, n, 0.7).bipartite()
+		if _, _, size := g.maxMatching(); size == n {
+			break
+		}
+	}
+
+	allowed := g.allowed()
+	for try := 0; try < rejectionTries; try++ {
+		if isPerfect(r.Perm(n), allowed) {
+			t.Fatal("want a gift exchange random permutations can't draw")
+		}
+	}
+
+	counts, total := g.pairCounts()
+
+	const samples = 2000
+	drawn := make([][]int, n)
+	for i := range drawn {
+		drawn[i] = make([]int, n)
+	}
+
+	matchG, _, _ := g.maxMatching()
+	for s := 0; s < samples; s++ {
+		for i, j := range sampleMarkov(r, matchG, allowed, 100*n+10000) {
+			drawn[i][j]++
+		}
+	}
+
+	// 0.05 is more than 4 standard deviations for this many samples
+	for i := range counts {
+		for j, count := range counts[i] {
+			want := float64(count) / float64(total)
+			got := float64(drawn[i][j]) / samples
+			if diff := got - want; diff < -0.05 || diff > 0.05 {
+				t.Errorf("%d -> %d: want: %.3f; got: %.3f", i, j, want, got)
+			}
+		}
+	}
+}
@@ -2,6 +2,7 @@ package giftex
 
 import (
 	"fmt"
+	"math/rand"
 	"testing"
 )
 
@@ -20,7 +21,7 @@ func Example_matrix() {
 	fmt.Printf("Constraints:\n%v\n\n", m)
 
 	if m.CheckConstraints() {
-		a := m.Assign()
+		a := m.Assign(rand.New(rand.NewSource(1)))
 		fmt.Printf("Assignment:\n%v\n\n", a)
 		fmt.Printf("Valid? %v\n", verifyAssignment(a, c))
 	} else {
@@ -172,7 +173,7 @@ func TestMatrix_Assign(t *testing.T) {
 	}
 
 	m.AddConstraints(c)
-	a := m.Assign()
+	a := m.Assign(rand.New(rand.NewSource(1)))
 
 	// Check that we have one assignment for each participant
 	if got := len(a); size != got {
@@ -0,0 +1,410 @@
+package giftex
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// MultiAssignment maps each giver to every recipient they give to.
+type MultiAssignment map[Pid][]Pid
+
+// Results is implemented by both Assignment and MultiAssignment so
+// either can be written out or emailed to participants.
+type Results interface {
+	Multi() MultiAssignment
+}
+
+// Multi converts assignment a into a MultiAssignment.
+func (a Assignment) Multi() MultiAssignment {
+	m := make(MultiAssignment, len(a))
+	for giver, recipient := range a {
+		m[giver] = []Pid{recipient}
+	}
+
+	return m
+}
+
+// Multi returns a itself.
+func (a MultiAssignment) Multi() MultiAssignment {
+	return a
+}
+
+func (a MultiAssignment) String() string {
+	// Sort assignments before printing
+	sortedKeys := make([]Pid, 0, len(a))
+	for p := range a {
+		sortedKeys = append(sortedKeys, p)
+	}
+	sort.SliceStable(sortedKeys, func(i, j int) bool {
+		return sortedKeys[i] < sortedKeys[j]
+	})
+
+	var b strings.Builder
+	for i, k := range sortedKeys {
+		fmt.Fprintf(&b, "%d ->", k)
+		for _, p := range a[k] {
+			fmt.Fprintf(&b, " %d", p)
+		}
+
+		if i < len(sortedKeys)-1 {
+			b.WriteString("\n")
+		}
+	}
+
+	return b.String()
+}
+
+// giftsPerPerson returns how many gifts everyone gives and receives.
+func (opts *GiftExchangeOptions) giftsPerPerson() int {
+	if opts == nil || opts.GiftsPerPerson < 1 {
+		return 1
+	}
+
+	return opts.GiftsPerPerson
+}
+
+// roundTries is how many times we try drawing every round at random
+// before building the rounds from a k-factor instead.
+const roundTries = 20
+
+// assignRounds picks k assignments from matrix m that don't share any
+// pairs, so that everybody gives and receives k gifts without giving
+// to the same person twice. Each round is drawn at random from the
+// pairs that are left over from earlier rounds, which can paint
+// ourselves into a corner. When that keeps happening, we find every
+// round at once using a k-factor of the graph instead.
+func (m matrix) assignRounds(k int, r *rand.Rand) ([]Assignment, bool) {
+	g := m.bipartite()
+
+	for try := 0; try < roundTries; try++ {
+		if rounds, ok := g.sampleRounds(k, r); ok {
+			return rounds, true
+		}
+	}
+
+	factor, ok := g.kFactor(k, r)
+	if !ok {
+		return nil, false
+	}
+
+	// Every k-regular bipartite graph has a perfect matching, and
+	// removing it leaves a (k-1)-regular graph. So we can peel off one
+	// round at a time until there are none left.
+	rounds := make([]Assignment, 0, k)
+	for round := 0; round < k; round++ {
+		matchG, _, _ := factor.maxMatching()
+		rounds = append(rounds, toAssignment(matchG))
+		factor = factor.without(matchG)
+	}
+
+	return rounds, true
+}
+
+// sampleRounds draws k rounds one after another.
+func (g bipartite) sampleRounds(k int, r *rand.Rand) ([]Assignment, bool) {
+	rounds := make([]Assignment, 0, k)
+	for round := 0; round < k; round++ {
+		matchG, ok := g.sample(r)
+		if !ok {
+			return nil, false
+		}
+
+		rounds = append(rounds, toAssignment(matchG))
+		g = g.without(matchG)
+	}
+
+	return rounds, true
+}
+
+// without returns a copy of graph g with the edges in matchG removed.
+func (g bipartite) without(matchG []int) bipartite {
+	h := make(bipartite, len(g))
+	for i := range g {
+		h[i] = make([]int, 0, len(g[i]))
+		for _, j := range g[i] {
+			if matchG[i] != j {
+				h[i] = append(h[i], j)
+			}
+		}
+	}
+
+	return h
+}
+
+// kFactor finds a subgraph of g where every giver has k recipients
+// and every recipient has k givers.
+func (g bipartite) kFactor(k int, r *rand.Rand) (bipartite, bool) {
+	return g.boundedFactor(exactly(len(g), k), atMost(len(g), k), r)
+}
+
+// degreeBounds limits how many pairs each person is part of, from
+// min[i] to max[i] inclusive.
+type degreeBounds struct {
+	min, max []int
+}
+
+func exactly(n, k int) degreeBounds {
+	b := degreeBounds{min: make([]int, n), max: make([]int, n)}
+	for i := 0; i < n; i++ {
+		b.min[i], b.max[i] = k, k
+	}
+
+	return b
+}
+
+func atMost(n, k int) degreeBounds {
+	b := exactly(n, k)
+	b.min = make([]int, n)
+	return b
+}
+
+// permute reorders b to match a matrix permuted by order.
+func (b degreeBounds) permute(order []Pid) degreeBounds {
+	p := degreeBounds{min: make([]int, len(order)), max: make([]int, len(order))}
+	for i, id := range order {
+		p.min[i], p.max[i] = b.min[id], b.max[id]
+	}
+
+	return p
+}
+
+// boundedFactor finds a subgraph of g where every giver i has between
+// gives.min[i] and gives.max[i] recipients, and every recipient j has
+// between receives.min[j] and receives.max[j] givers.
+//
+// People are filled up one pair at a time using augmenting paths:
+// find somebody on the other side with room to spare by alternating
+// between a pair we haven't used and a pair we have, then flip every
+// pair along the path. Everybody in the middle of the path keeps the
+// same number of pairs, and a path can also end by taking a pair from
+// somebody with more than their minimum, so recipients are filled up
+// to their minimum first and then givers are, without undoing any
+// earlier progress.
+func (g bipartite) boundedFactor(gives, receives degreeBounds, r *rand.Rand) (bipartite, bool) {
+	n := len(g)
+
+	// Shuffle so we don't always find the same factor
+	adj := make(bipartite, n)
+	for i := range g {
+		adj[i] = append([]int(nil), g[i]...)
+		r.Shuffle(len(adj[i]), func(a, b int) {
+			adj[i][a], adj[i][b] = adj[i][b], adj[i][a]
+		})
+	}
+
+	giving := make([]map[int]bool, n)    // giving[i][j] if i gives to j
+	receiving := make([]map[int]bool, n) // receiving[j][i] if j receives from i
+	for i := range giving {
+		giving[i] = make(map[int]bool)
+		receiving[i] = make(map[int]bool)
+	}
+
+	// Filling recipients is the same as filling givers with every
+	// pair turned around
+	var needMin bool
+	for j := range receives.min {
+		needMin = needMin || receives.min[j] > 0
+	}
+
+	if needMin {
+		adjR := make(bipartite, n)
+		for i := range adj {
+			for _, j := range adj[i] {
+				adjR[j] = append(adjR[j], i)
+			}
+		}
+
+		for _, j := range r.Perm(n) {
+			for len(receiving[j]) < receives.min[j] {
+				if !augmentFactor(adjR, receiving, giving, receives.min, gives.max, j) {
+					return nil, false
+				}
+			}
+		}
+	}
+
+	for _, i := range r.Perm(n) {
+		for len(giving[i]) < gives.min[i] {
+			if !augmentFactor(adj, giving, receiving, gives.min, receives.max, i) {
+				return nil, false
+			}
+		}
+	}
+
+	factor := make(bipartite, n)
+	for i := range giving {
+		for _, j := range adj[i] {
+			if giving[i][j] {
+				factor[i] = append(factor[i], j)
+			}
+		}
+	}
+
+	return factor, true
+}
+
+// augmentFactor adds one more pair for s by searching for a path from
+// s to somebody on the other side with fewer than max pairs, or to
+// somebody on the same side with more than min pairs who can give one
+// up. The path alternates between pairs in adj that aren't in out and
+// pairs that are, and every pair along it is flipped. out[i] holds the
+// pairs of i and in[j] holds the pairs of j on the other side.
+func augmentFactor(adj bipartite, out, in []map[int]bool, min, max []int, s int) bool {
+	n := len(adj)
+	from := make([]int, n) // from[j] is the person that reached j
+	via := make([]int, n)  // via[i] is who reached i on the other side
+
+	link := func(i, j int) {
+		out[i][j] = true
+		in[j][i] = true
+	}
+
+	unlink := func(i, j int) {
+		delete(out[i], j)
+		delete(in[j], i)
+	}
+
+	// flip links j and every pair on the path back to s
+	flip := func(j int) {
+		for {
+			i := from[j]
+			link(i, j)
+			if i == s {
+				return
+			}
+
+			j = via[i]
+			unlink(i, j)
+		}
+	}
+
+	seenS := make([]bool, n)
+	seenT := make([]bool, n)
+	seenS[s] = true
+
+	queue := []int{s}
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+
+		for _, j := range adj[i] {
+			if out[i][j] || seenT[j] {
+				continue
+			}
+
+			seenT[j] = true
+			from[j] = i
+
+			if len(in[j]) < max[j] {
+				flip(j)
+				return true
+			}
+
+			// Visit in order so seeded draws can be replayed
+			others := make([]int, 0, len(in[j]))
+			for other := range in[j] {
+				others = append(others, other)
+			}
+			sort.Ints(others)
+
+			for _, other := range others {
+				if seenS[other] {
+					continue
+				}
+
+				if len(out[other]) > min[other] {
+					unlink(other, j)
+					flip(j)
+					return true
+				}
+
+				seenS[other] = true
+				via[other] = j
+				queue = append(queue, other)
+			}
+		}
+	}
+
+	return false
+}
+
+// mergeRounds combines rounds into a single MultiAssignment.
+func mergeRounds(rounds []Assignment) MultiAssignment {
+	m := make(MultiAssignment)
+	for _, a := range rounds {
+		for giver, recipient := range a {
+			m[giver] = append(m[giver], recipient)
+		}
+	}
+
+	return m
+}
+
+// verifyBounded checks that every giver in assignment a gives as many
+// gifts as gives allows and every recipient receives as many as
+// receives allows, without anybody giving to the same person twice or
+// to anybody they shouldn't.
+func verifyBounded(a MultiAssignment, s sparse, gives, receives degreeBounds) bool {
+	received := make([]int, len(receives.min))
+	for i := range s.rows {
+		giver := Pid(i)
+		recipients := a[giver]
+		if n := len(recipients); n < gives.min[giver] || n > gives.max[giver] {
+			return false
+		}
+
+		seen := make(map[Pid]bool, len(recipients))
+		for _, p := range recipients {
+			if int(p) >= len(received) || seen[p] || !s.allowsPair(giver, p) {
+				return false
+			}
+
+			seen[p] = true
+			received[p]++
+		}
+	}
+
+	for j, count := range received {
+		if count < receives.min[j] || count > receives.max[j] {
+			return false
+		}
+	}
+
+	return len(a) <= len(s.rows)
+}
+
+// verifyMultiAssignment checks that everybody in s gives k gifts,
+// everybody receives k gifts, and nobody gives to the same person
+// twice or to anybody they shouldn't.
+func verifyMultiAssignment(a MultiAssignment, s sparse, k int) bool {
+	if len(a) < len(s.rows) {
+		return false
+	}
+
+	received := make(map[Pid]int, len(a))
+	for giver, recipients := range a {
+		if len(recipients) != k {
+			return false
+		}
+
+		seen := make(map[Pid]bool, k)
+		for _, p := range recipients {
+			if seen[p] || !s.allowsPair(giver, p) {
+				return false
+			}
+
+			seen[p] = true
+			received[p]++
+		}
+	}
+
+	for _, count := range received {
+		if count != k {
+			return false
+		}
+	}
+
+	return true
+}
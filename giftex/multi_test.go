@@ -0,0 +1,182 @@
+package giftex
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func Example_giftsPerPerson() {
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo"},
+		1: {ID: 1, Name: "bar"},
+		2: {ID: 2, Name: "baz"},
+		3: {ID: 3, Name: "qux", Restrictions: []Pid{0}},
+	}
+
+	ge, err := NewGiftExchange(pm, &GiftExchangeOptions{GiftsPerPerson: 3})
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	// qux can't give 3 gifts without giving one to foo
+	fmt.Println(errors.Is(err, ErrNoSolution))
+
+	pm[3] = Participant{ID: 3, Name: "qux"}
+	ge, err = NewGiftExchange(pm, &GiftExchangeOptions{GiftsPerPerson: 3})
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(ge)
+
+	// Output:
+	// No Solution: an assignment is not possible for this gift exchange when everyone gives 3 gifts
+	// true
+	// Results:
+	// | bar  | baz, foo, qux  |
+	// | baz  | bar, foo, qux  |
+	// | foo  | bar, baz, qux  |
+	// | qux  | bar, baz, foo  |
+}
+
+// disjointMatchings reports whether k assignments from all can be
+// picked without any two sharing a pair.
+func disjointMatchings(all [][]int, k int) bool {
+	var pick func(start, k int, used map[[2]int]bool) bool
+	pick = func(start, k int, used map[[2]int]bool) bool {
+		if k == 0 {
+			return true
+		}
+
+	next:
+		for a := start; a < len(all); a++ {
+			for i, j := range all[a] {
+				if used[[2]int{i, j}] {
+					continue next
+				}
+			}
+
+			for i, j := range all[a] {
+				used[[2]int{i, j}] = true
+			}
+
+			if pick(a+1, k-1, used) {
+				return true
+			}
+
+			for i, j := range all[a] {
+				delete(used, [2]int{i, j})
+			}
+		}
+
+		return false
+	}
+
+	return pick(0, k, make(map[[2]int]bool))
+}
+
+func TestMatrix_assignRounds_bruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+
+	for try := 0; try < 300; try++ {
+		n := 2 + r.Intn(5)
+		m := randomMatrix(r, n, r.Float64()*0.5)
+		k := 2 + r.Intn(n-1)
+
+		want := disjointMatchings(bruteForce(m), k)
+		rounds, got := m.assignRounds(k, r)
+		if want != got {
+			t.Fatalf("wrong result for k = %d:\n%v\nwant: %v; got: %v", k, m, want, got)
+		}
+
+		if !got {
+			continue
+		}
+
+		if a := mergeRounds(rounds); !verifyMultiAssignment(a, sparseOf(m), k) {
+			t.Fatalf("invalid rounds for k = %d:\n%v\n%v", k, m, a)
+		}
+	}
+}
+
+func TestBipartite_kFactor(t *testing.T) {
+	r := rand.New(rand.NewSource(6))
+
+	for try := 0; try < 300; try++ {
+		n := 2 + r.Intn(5)
+		m := randomMatrix(r, n, r.Float64()*0.5)
+		k := 1 + r.Intn(n-1)
+
+		want := disjointMatchings(bruteForce(m), k)
+		factor, got := m.bipartite().kFactor(k, r)
+		if want != got {
+			t.Fatalf("wrong result for k = %d:\n%v\nwant: %v; got: %v", k, m, want, got)
+		}
+
+		if !got {
+			continue
+		}
+
+		receives := make([]int, n)
+		for i := range factor {
+			if len(factor[i]) != k {
+				t.Fatalf("giver %d has %d recipients; want %d", i, len(factor[i]), k)
+			}
+
+			for _, j := range factor[i] {
+				if m[i][j] == 1 {
+					t.Fatalf("giver %d can't give to %d", i, j)
+				}
+				receives[j]++
+			}
+		}
+
+		for j, count := range receives {
+			if count != k {
+				t.Fatalf("recipient %d has %d givers; want %d", j, count, k)
+			}
+		}
+	}
+}
+
+func TestNewGiftExchange_giftsPerPerson(t *testing.T) {
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo"},
+		1: {ID: 1, Name: "bar"},
+		2: {ID: 2, Name: "baz"},
+	}
+
+	t.Run("Conflicting options", func(t *testing.T) {
+		opts := &GiftExchangeOptions{GiftsPerPerson: 2, NoMutualPairs: true}
+		if _, err := NewGiftExchange(pm, opts); !errors.Is(err, ErrConflictingOptions) {
+			t.Errorf("want: %v; got: %v", ErrConflictingOptions, err)
+		}
+	})
+
+	t.Run("Write every recipient", func(t *testing.T) {
+		db, err := ReadCSVFromFile("testdata/small.csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var b strings.Builder
+		results := MultiAssignment{0: {1, 2}, 1: {2, 0}, 2: {0, 1}}
+		if err := db.WriteCSV(&b, results); err != nil {
+			t.Fatal(err)
+		}
+
+		want := `name,email,sms,restrictions,previous,participating,has
+bar,bar@example.com,(555) 222-2222,,"baz,baz,foo",yes,"baz,foo"
+baz,baz@example.com,555.333.3333,,"foo,bar",yes,"foo,bar"
+foo,foo@example.com,555 111 1111,foo,"bar,baz",yes,"bar,baz"
+quux,quux@example.com,5554444444,"foo, bar, baz",,no,
+`
+
+		if got := b.String(); want != got {
+			t.Errorf("Wrong output:\nwant:\n%v\n\ngot:\n%v", want, got)
+		}
+	})
+}
@@ -0,0 +1,200 @@
+package giftex
+
+import (
+	"fmt"
+	"sort"
+)
+
+// hasLimits reports whether participant p is pinned to anybody or
+// only allowed to give to a short list of people.
+func hasLimits(p Participant) bool {
+	return len(p.Pinned) > 0 || len(p.Allowed) > 0
+}
+
+// limitAllows reports whether giver's pins and allow-list let them
+// give to recipient. A giver who is pinned to anybody only gives to
+// the people they're pinned to.
+func limitAllows(giver Participant, recipient Pid) bool {
+	switch {
+	case len(giver.Pinned) > 0:
+		return containsPid(giver.Pinned, recipient)
+	case len(giver.Allowed) > 0:
+		return containsPid(giver.Allowed, recipient)
+	}
+
+	return true
+}
+
+// pinned reports whether giver is pinned to recipient. When everybody
+// swaps gifts, a pin goes both ways.
+func pinned(pm ParticipantMap, opts *GiftExchangeOptions, giver, recipient Pid) bool {
+	return containsPid(pm[giver].Pinned, recipient) ||
+		(opts.swap() && containsPid(pm[recipient].Pinned, giver))
+}
+
+// allowedPairs returns whether pins, allow-lists, groups, group
+// rules, and Rules let giver give to recipient. None of these can be
+// dropped to make a gift exchange possible. Pins take priority over
+// groups and Rules.
+func allowedPairs(pm ParticipantMap, opts *GiftExchangeOptions) func(giver, recipient Pid) bool {
+	groups := groupsAllow(pm, opts)
+	return func(giver, recipient Pid) bool {
+		if pinned(pm, opts, giver, recipient) {
+			return true
+		}
+
+		return limitAllows(pm[giver], recipient) && groups(giver, recipient) &&
+			!judge(opts.rules(), pm[giver], pm[recipient]).Forbidden
+	}
+}
+
+// PinError explains why the pins in a gift exchange can't all be
+// kept, e.g., two people are pinned to somebody who only receives one
+// gift. Removing one of the Pins is the only way to fix it.
+type PinError struct {
+	Pins []Pair
+
+	reason       string
+	participants ParticipantMap
+}
+
+func (e *PinError) Error() string {
+	return "No Solution: " + e.reason
+}
+
+// Is allows errors.Is(err, ErrNoSolution) to match a PinError.
+func (e *PinError) Is(target error) bool {
+	return target == ErrNoSolution
+}
+
+// Name returns the name of participant id.
+func (e *PinError) Name(id Pid) string {
+	return e.participants[id].Name
+}
+
+// checkPins looks for pins that contradict each other or the rest of
+// the gift exchange before looking for an assignment, since they
+// would otherwise be explained as an unrelated shortage of options.
+// Pins take priority over groups and previous matches, but not over
+// anybody's restrictions, allow-list, or role.
+func checkPins(pm ParticipantMap, opts *GiftExchangeOptions) *PinError {
+	hasPins := false
+	for _, p := range pm {
+		if len(p.Pinned) > 0 {
+			hasPins = true
+			break
+		}
+	}
+
+	if !hasPins {
+		return nil
+	}
+
+	gives, receives, err := giftBounds(pm, opts)
+	if err != nil {
+		return nil
+	}
+
+	name := func(id Pid) string { return pm[id].Name }
+	order := drawOrder(pm)
+	pinsTo := make(map[Pid][]Pid)
+	for _, giver := range order {
+		p := pm[giver]
+		pins := make([]Pair, 0, len(p.Pinned))
+		for _, recipient := range p.Pinned {
+			if _, ok := pm[recipient]; ok {
+				pins = append(pins, Pair{Giver: giver, Recipient: recipient})
+				pinsTo[recipient] = append(pinsTo[recipient], giver)
+			}
+		}
+
+		for _, pin := range pins {
+			var reason string
+			switch r := pm[pin.Recipient]; {
+			case pin.Giver == pin.Recipient:
+				reason = fmt.Sprintf("%s is pinned to themselves", name(pin.Giver))
+			case containsPid(p.Restrictions, pin.Recipient):
+				reason = fmt.Sprintf("%s is pinned to %s but can't be matched with them", name(pin.Giver), name(pin.Recipient))
+			case len(p.Allowed) > 0 && !containsPid(p.Allowed, pin.Recipient):
+				reason = fmt.Sprintf("%s is pinned to %s but only gives to %s", name(pin.Giver), name(pin.Recipient), oneOf(pidNames(pm, p.Allowed)))
+			case !p.Role.Gives():
+				reason = fmt.Sprintf("%s is pinned to %s but only receives gifts", name(pin.Giver), name(pin.Recipient))
+			case !r.Role.Receives():
+				reason = fmt.Sprintf("%s is pinned to %s, who only gives gifts", name(pin.Giver), name(pin.Recipient))
+			default:
+				continue
+			}
+
+			return &PinError{Pins: []Pair{pin}, reason: reason, participants: pm}
+		}
+
+		if len(pins) == 0 {
+			continue
+		}
+
+		if n := len(pins); n < gives.min[giver] || n > gives.max[giver] {
+			return &PinError{
+				Pins:         pins,
+				reason:       fmt.Sprintf("%s is pinned to %d %s but gives %s", name(giver), n, peopleOrPerson(n), giftCount(gives.min[giver], gives.max[giver])),
+				participants: pm,
+			}
+		}
+	}
+
+	for _, recipient := range order {
+		givers := pinsTo[recipient]
+		if len(givers) <= receives.max[recipient] {
+			continue
+		}
+
+		pins := make([]Pair, len(givers))
+		for i, giver := range givers {
+			pins[i] = Pair{Giver: giver, Recipient: recipient}
+		}
+
+		return &PinError{
+			Pins:         pins,
+			reason:       fmt.Sprintf("%s are pinned to %s, who only receives %s", joinNames(pidNames(pm, givers)), name(recipient), giftCount(receives.max[recipient], receives.max[recipient])),
+			participants: pm,
+		}
+	}
+
+	return nil
+}
+
+// oneOf lists names as a choice in plain English, e.g., "one of foo
+// and bar".
+func oneOf(names []string) string {
+	if len(names) == 1 {
+		return names[0]
+	}
+
+	return "one of " + joinNames(names)
+}
+
+// giftCount describes between min and max gifts in plain English.
+func giftCount(min, max int) string {
+	gifts := "gifts"
+	if max == 1 {
+		gifts = "gift"
+	}
+
+	if min == max {
+		return fmt.Sprintf("%d %s", max, gifts)
+	}
+
+	return fmt.Sprintf("%d to %d %s", min, max, gifts)
+}
+
+// pidNames lists the names of ids, sorted.
+func pidNames(pm ParticipantMap, ids []Pid) []string {
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if p, ok := pm[id]; ok {
+			names = append(names, p.Name)
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,269 @@
+package giftex
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func Example_pins() {
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "grandma", Group: "Smith", Pinned: []Pid{1}, Previous: []Pid{1}},
+		1: {ID: 1, Name: "baby", Group: "Smith"},
+		2: {ID: 2, Name: "kid", Allowed: []Pid{1, 3}},
+		3: {ID: 3, Name: "tot", Allowed: []Pid{2}},
+		4: {ID: 4, Name: "mom"},
+	}
+
+	// grandma always gives to baby, even though they're both Smiths
+	// and grandma had baby last year. kid only draws other kids, so
+	// kid and tot give to each other.
+	ge, err := NewGiftExchange(pm, &GiftExchangeOptions{MaxPrevious: 1})
+	if err != nil {
+		panic(err)
+	}
+
+	for _, id := range drawOrder(pm) {
+		if id != 1 && id != 4 {
+			fmt.Printf("%s -> %s\n", pm[id].Name, pm[ge.Assignment[id]].Name)
+		}
+	}
+
+	// Output:
+	// grandma -> baby
+	// kid -> tot
+	// tot -> kid
+}
+
+func TestReadCSV_pins(t *testing.T) {
+	db, err := ReadCSVFromFile("testdata/pins.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, id := range drawOrder(db.Participants) {
+		p := db.Participants[id]
+		got = append(got, fmt.Sprintf("%s:%v:%v", p.Name, p.Pinned, p.Allowed))
+	}
+
+	want := "[baby:[]:[] grandma:[1]:[] kid:[]:[1 3] mom:[]:[] tot:[]:[2]]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("want: %s; got: %v", want, got)
+	}
+
+	ge, err := NewGiftExchange(db.Participants, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ge.Assignment[0] != 1 {
+		t.Errorf("grandma should have baby; got: %s", db.Participants[ge.Assignment[0]].Name)
+	}
+}
+
+func TestCheckPins(t *testing.T) {
+	tests := []struct {
+		name string
+		pm   ParticipantMap
+		opts *GiftExchangeOptions
+		want string
+	}{
+		{
+			name: "Fits",
+			pm: ParticipantMap{
+				0: {ID: 0, Name: "foo", Pinned: []Pid{1}},
+				1: {ID: 1, Name: "bar", Pinned: []Pid{0}},
+				2: {ID: 2, Name: "baz"},
+				3: {ID: 3, Name: "qux"},
+			},
+		},
+		{
+			name: "Restricted",
+			pm: ParticipantMap{
+				0: {ID: 0, Name: "foo", Pinned: []Pid{1}, Restrictions: []Pid{1}},
+				1: {ID: 1, Name: "bar"},
+			},
+			want: "No Solution: foo is pinned to bar but can't be matched with them",
+		},
+		{
+			name: "Not allowed",
+			pm: ParticipantMap{
+				0: {ID: 0, Name: "foo", Pinned: []Pid{1}, Allowed: []Pid{2, 3}},
+				1: {ID: 1, Name: "bar"},
+				2: {ID: 2, Name: "baz"},
+				3: {ID: 3, Name: "qux"},
+			},
+			want: "No Solution: foo is pinned to bar but only gives to one of baz and qux",
+		},
+		{
+			name: "Too many pins",
+			pm: ParticipantMap{
+				0: {ID: 0, Name: "foo", Pinned: []Pid{1, 2}},
+				1: {ID: 1, Name: "bar"},
+				2: {ID: 2, Name: "baz"},
+			},
+			want: "No Solution: foo is pinned to 2 people but gives 1 gift",
+		},
+		{
+			name: "Too few pins",
+			pm: ParticipantMap{
+				0: {ID: 0, Name: "foo", Pinned: []Pid{1}},
+				1: {ID: 1, Name: "bar"},
+				2: {ID: 2, Name: "baz"},
+			},
+			opts: &GiftExchangeOptions{GiftsPerPerson: 2},
+			want: "No Solution: foo is pinned to 1 person but gives 2 gifts",
+		},
+		{
+			name: "Same recipient",
+			pm: ParticipantMap{
+				0: {ID: 0, Name: "foo", Pinned: []Pid{2}},
+				1: {ID: 1, Name: "bar", Pinned: []Pid{2}},
+				2: {ID: 2, Name: "baz"},
+			},
+			want: "No Solution: bar and foo are pinned to baz, who only receives 1 gift",
+		},
+		{
+			name: "Recipient only gives",
+			pm: ParticipantMap{
+				0: {ID: 0, Name: "foo", Pinned: []Pid{1}},
+				1: {ID: 1, Name: "grandpa", Role: GivesOnly},
+				2: {ID: 2, Name: "baz"},
+			},
+			want: "No Solution: foo is pinned to grandpa, who only gives gifts",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkPins(tt.pm, tt.opts)
+			if err == nil {
+				if tt.want != "" {
+					t.Fatalf("want: %s; got: nil", tt.want)
+				}
+
+				if _, err := NewGiftExchange(tt.pm, tt.opts); err != nil {
+					t.Error(err)
+				}
+
+				return
+			}
+
+			if got := err.Error(); tt.want != got {
+				t.Errorf("want: %s; got: %s", tt.want, got)
+			}
+
+			// NewGiftExchange explains the same problem
+			_, ngErr := NewGiftExchange(tt.pm, tt.opts)
+			var pe *PinError
+			if !errors.As(ngErr, &pe) || !errors.Is(ngErr, ErrNoSolution) || len(pe.Pins) == 0 {
+				t.Errorf("want PinError; got: %v", ngErr)
+			}
+		})
+	}
+}
+
+func TestNewGiftExchange_pins(t *testing.T) {
+	// foo always gives to bar and baz only gives to foo or bar
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo", Group: "smith", Pinned: []Pid{1}},
+		1: {ID: 1, Name: "bar", Group: "smith"},
+		2: {ID: 2, Name: "baz", Allowed: []Pid{0, 1}},
+		3: {ID: 3, Name: "qux"},
+		4: {ID: 4, Name: "quux", Previous: []Pid{0}},
+	}
+
+	for try := 0; try < 50; try++ {
+		opts := &GiftExchangeOptions{MaxPrevious: 1, Seed: int64(try + 1)}
+		ge, err := NewGiftExchange(pm, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ge.Assignment[0] != 1 {
+			t.Fatalf("foo should have bar; got: %s", pm[ge.Assignment[0]].Name)
+		}
+
+		// bar is taken, so baz has to give to foo
+		if ge.Assignment[2] != 0 {
+			t.Fatalf("baz should have foo; got: %s", pm[ge.Assignment[2]].Name)
+		}
+
+		if err := Verify(pm, opts, ge.Commitment, ge); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if Commit(pm, &GiftExchangeOptions{Seed: 1}) == Commit(withoutPins(pm), &GiftExchangeOptions{Seed: 1}) {
+		t.Error("pins didn't change the commitment")
+	}
+
+	// Pins are pairs when swapping, so foo and bar swap with each
+	// other even though they're in the same group
+	delete(pm, 4)
+	pm[2] = Participant{ID: 2, Name: "baz"}
+	ge, err := NewGiftExchange(pm, &GiftExchangeOptions{Swap: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ge.Assignment[0] != 1 || ge.Assignment[1] != 0 {
+		t.Errorf("foo and bar should swap; got: %v", ge.PairNames())
+	}
+
+	// Pinned pairs were chosen, so they aren't predictable
+	odds, err := PairProbabilities(pm, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range odds.Predictable(LikelyThreshold) {
+		if p.Giver == 0 {
+			t.Errorf("pinned pair is predictable: %s", odds.Describe(p))
+		}
+	}
+}
+
+func withoutPins(pm ParticipantMap) ParticipantMap {
+	newPM := make(ParticipantMap, len(pm))
+	for id, p := range pm {
+		p.Pinned = nil
+		newPM[id] = p
+	}
+
+	return newPM
+}
+
+func TestExplainNoSolution_limited(t *testing.T) {
+	// foo and bar only give to baz
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo", Allowed: []Pid{2}},
+		1: {ID: 1, Name: "bar", Allowed: []Pid{2}, Restrictions: []Pid{3}},
+		2: {ID: 2, Name: "baz"},
+		3: {ID: 3, Name: "qux"},
+	}
+
+	_, err := NewGiftExchange(pm, nil)
+	var nse *NoSolutionError
+	if !errors.As(err, &nse) {
+		t.Fatalf("want NoSolutionError; got: %v", err)
+	}
+
+	if want := "No Solution: foo and bar can only give to baz"; nse.Error() != want {
+		t.Errorf("want: %s; got: %s", want, nse.Error())
+	}
+
+	// bar's restriction doesn't matter while bar only gives to baz
+	if len(nse.Restrictions) > 0 || fmt.Sprint(nse.Limited) != "[0 1]" {
+		t.Errorf("want foo and bar limited; got: %v, %v", nse.Restrictions, nse.Limited)
+	}
+
+	if want, got := "bar only gives to baz", nse.DescribeLimit(1); want != got {
+		t.Errorf("want: %s; got: %s", want, got)
+	}
+
+	if suggestions := SuggestRelaxations(pm, nil); len(suggestions) > 0 {
+		t.Errorf("allow-lists can't be relaxed; got: %v", suggestions)
+	}
+}
@@ -0,0 +1,277 @@
+package giftex
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"sort"
+)
+
+const (
+	// probabilitySamples is how many draws we make to estimate pair
+	// probabilities when there are too many assignments to count.
+	probabilitySamples = 2000
+
+	// LikelyThreshold is the probability above which a pair is
+	// considered predictable enough to warn the organizer about.
+	LikelyThreshold = 0.9
+)
+
+// PairOdds describes how likely each giver is to give to each
+// recipient across every assignment the gift exchange could draw.
+type PairOdds struct {
+	// Probability[giver][recipient] is the chance that giver gives to
+	// recipient. Pairs that can never happen are left out.
+	Probability map[Pid]map[Pid]float64
+
+	// Exact is true when every valid assignment was counted, and
+	// false when the probabilities were estimated by sampling draws.
+	Exact bool
+
+	participants ParticipantMap
+	forced       func(giver, recipient Pid) bool
+}
+
+// PredictablePair is a pair that is forced or almost certain, which
+// lets anybody who knows the constraints guess who has who.
+type PredictablePair struct {
+	Pair
+	Probability float64
+
+	// Forced is true when the gift exchange can't be drawn without it
+	Forced bool
+}
+
+// PairProbabilities works out the chance of each giver giving to each
+// recipient. Small gift exchanges drawn uniformly are counted exactly.
+// Otherwise the probabilities are estimated from many sample draws
+// using the same options as NewGiftExchange.
+func PairProbabilities(pm ParticipantMap, opts *GiftExchangeOptions) (*PairOdds, error) {
+	return PairProbabilitiesContext(context.Background(), pm, opts)
+}
+
+// PairProbabilitiesContext is like PairProbabilities, but gives up with
+// ctx.Err() if ctx is done before the sample draws are finished.
+func PairProbabilitiesContext(ctx context.Context, pm ParticipantMap, opts *GiftExchangeOptions) (*PairOdds, error) {
+	n := len(pm)
+	if err := checkMatrixSize(n, "working out the odds"); err != nil {
+		return nil, err
+	}
+
+	graph, err := constraintGraph(pm, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	m := graph.dense()
+	roles := hasRoles(pm)
+	if roles {
+		forbidRoles(m, pm)
+	}
+
+	g := m.bipartite()
+	k := opts.giftsPerPerson()
+	gives, receives, err := giftBounds(pm, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	odds := &PairOdds{
+		Probability:  make(map[Pid]map[Pid]float64, n),
+		participants: pm,
+		forced: func(giver, recipient Pid) bool {
+			// A pair is forced when the hard constraints can't be met
+			// without it, no matter which options were used
+			if opts.swap() {
+				forbid := append(matrix(nil), m...)
+				forbid[giver] = append([]int(nil), m[giver]...)
+				forbid[giver][recipient] = 1
+				_, ok := forbid.findSwaps(rand.New(rand.NewSource(1)))
+				return !ok
+			}
+
+			h := append(bipartite(nil), g...)
+			h[giver] = removeInt(h[giver], int(recipient))
+			if roles {
+				_, ok := h.boundedFactor(gives, receives, rand.New(rand.NewSource(1)))
+				return !ok
+			}
+
+			if k > 1 {
+				_, ok := h.kFactor(k, rand.New(rand.NewSource(1)))
+				return !ok
+			}
+
+			_, _, size := h.maxMatching()
+			return size < n
+		},
+	}
+
+	set := func(giver, recipient int, p float64) {
+		if p == 0 {
+			return
+		}
+
+		if odds.Probability[Pid(giver)] == nil {
+			odds.Probability[Pid(giver)] = make(map[Pid]float64)
+		}
+
+		odds.Probability[Pid(giver)][Pid(recipient)] = p
+	}
+
+	uniform := k == 1 && !roles && !opts.swap() && opts.minCycleLength(n) <= 2 && (opts == nil || opts.Costs == nil)
+	if uniform && n <= exactLimit {
+		counts, total := g.pairCounts()
+		for i := range counts {
+			for j, count := range counts[i] {
+				set(i, j, float64(count)/float64(total))
+			}
+		}
+
+		odds.Exact = true
+		return odds, nil
+	}
+
+	r := rand.New(rand.NewSource(NewSeed()))
+	counts := make([][]int, n)
+	for i := range counts {
+		counts[i] = make([]int, n)
+	}
+
+	for s := 0; s < probabilitySamples; s++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		rounds, _, err := draw(graph, pm, opts, r)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, a := range rounds {
+			for giver, recipient := range a {
+				counts[giver][recipient]++
+			}
+		}
+	}
+
+	for i := range counts {
+		for j, count := range counts[i] {
+			set(i, j, float64(count)/probabilitySamples)
+		}
+	}
+
+	return odds, nil
+}
+
+// Predictable lists the pairs whose probability is at least threshold,
+// most likely first. Pinned pairs are left out since they were
+// chosen on purpose.
+func (o *PairOdds) Predictable(threshold float64) []PredictablePair {
+	var pairs []PredictablePair
+	for giver, recipients := range o.Probability {
+		for recipient, p := range recipients {
+			if p < threshold || containsPid(o.participants[giver].Pinned, recipient) {
+				continue
+			}
+
+			pairs = append(pairs, PredictablePair{
+				Pair:        Pair{Giver: giver, Recipient: recipient},
+				Probability: p,
+				Forced:      p == 1 && (o.Exact || o.forced(giver, recipient)),
+			})
+		}
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		a, b := pairs[i], pairs[j]
+		if a.Probability != b.Probability {
+			return a.Probability > b.Probability
+		}
+
+		return o.participants[a.Giver].Name < o.participants[b.Giver].Name
+	})
+
+	return pairs
+}
+
+// Describe explains pair p in plain English.
+func (o *PairOdds) Describe(p PredictablePair) string {
+	giver, recipient := o.participants[p.Giver].Name, o.participants[p.Recipient].Name
+	if p.Forced {
+		return fmt.Sprintf("%s always has %s", giver, recipient)
+	}
+
+	return fmt.Sprintf("%s has %s %.0f%% of the time", giver, recipient, p.Probability*100)
+}
+
+// pairCounts counts the perfect matchings of graph g that use each
+// pair, along with the total number of perfect matchings. Givers are
+// matched in order, so forward[mask] counts the ways to match the
+// first k givers to exactly the k recipients in mask, and
+// backward[mask] counts the ways to match the rest of the givers to
+// the recipients outside of mask. This is only practical for small
+// graphs since there are 2^n masks.
+func (g bipartite) pairCounts() (counts [][]uint64, total uint64) {
+	n := len(g)
+	full := 1<<n - 1
+
+	forward := make([]uint64, full+1)
+	backward := make([]uint64, full+1)
+	forward[0] = 1
+	backward[full] = 1
+
+	for mask := 0; mask <= full; mask++ {
+		i := bits.OnesCount(uint(mask))
+		if i == n || forward[mask] == 0 {
+			continue
+		}
+
+		for _, j := range g[i] {
+			if bit := 1 << j; mask&bit == 0 {
+				forward[mask|bit] += forward[mask]
+			}
+		}
+	}
+
+	for mask := full - 1; mask >= 0; mask-- {
+		i := bits.OnesCount(uint(mask))
+		for _, j := range g[i] {
+			if bit := 1 << j; mask&bit == 0 {
+				backward[mask] += backward[mask|bit]
+			}
+		}
+	}
+
+	counts = make([][]uint64, n)
+	for i := range counts {
+		counts[i] = make([]uint64, n)
+	}
+
+	for mask := 0; mask < full; mask++ {
+		if forward[mask] == 0 {
+			continue
+		}
+
+		i := bits.OnesCount(uint(mask))
+		for _, j := range g[i] {
+			if bit := 1 << j; mask&bit == 0 {
+				counts[i][j] += forward[mask] * backward[mask|bit]
+			}
+		}
+	}
+
+	return counts, backward[0]
+}
+
+func removeInt(list []int, x int) []int {
+	kept := make([]int, 0, len(list))
+	for _, v := range list {
+		if v != x {
+			kept = append(kept, v)
+		}
+	}
+
+	return kept
+}
@@ -0,0 +1,101 @@
+package giftex
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func Example_pairProbabilities() {
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "alice"},
+		1: {ID: 1, Name: "bob", Restrictions: []Pid{0}},
+		2: {ID: 2, Name: "carol", Restrictions: []Pid{1}},
+		3: {ID: 3, Name: "dave", Restrictions: []Pid{1}},
+	}
+
+	odds, err := PairProbabilities(pm, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	// Only alice can give to bob
+	for _, p := range odds.Predictable(LikelyThreshold) {
+		fmt.Println(odds.Describe(p))
+	}
+
+	// Output:
+	// alice always has bob
+}
+
+func TestBipartite_pairCounts_bruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(8))
+
+	for try := 0; try < 200; try++ {
+		m := randomMatrix(r, 1+r.Intn(7), r.Float64()*0.6)
+		all := bruteForce(m)
+
+		counts, total := m.bipartite().pairCounts()
+		if want := uint64(len(all)); want != total {
+			t.Fatalf("want %d assignments; got: %d for matrix:\n%v", want, total, m)
+		}
+
+		want := make([][]uint64, len(m))
+		for i := range want {
+			want[i] = make([]uint64, len(m))
+		}
+		for _, p := range all {
+			for i, j := range p {
+				want[i][j]++
+			}
+		}
+
+		if fmt.Sprint(want) != fmt.Sprint(counts) {
+			t.Fatalf("wrong counts for matrix:\n%v\nwant: %v\n got: %v", m, want, counts)
+		}
+	}
+}
+
+func TestPairProbabilities_sampled(t *testing.T) {
+	pm := make(ParticipantMap, 20)
+	for i := 0; i < 20; i++ {
+		pm[Pid(i)] = Participant{ID: Pid(i), Name: fmt.Sprintf("%02d", i)}
+	}
+
+	// Nobody but 00 can give to 01, and 02 can only give to 03 or 04
+	for i := 2; i < 20; i++ {
+		pm[Pid(i)] = Participant{ID: Pid(i), Name: pm[Pid(i)].Name, Restrictions: []Pid{1}}
+	}
+	pm[2] = Participant{ID: 2, Name: "02", Restrictions: restrictAllBut(20, 2, 3, 4)}
+
+	odds, err := PairProbabilities(pm, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if odds.Exact {
+		t.Fatal("20 participants should be sampled")
+	}
+
+	if p := odds.Probability[2][3]; math.Abs(p-0.5) > 0.05 {
+		t.Errorf("02 should have 03 about half the time; got: %v", p)
+	}
+
+	predictable := odds.Predictable(LikelyThreshold)
+	if len(predictable) != 1 || predictable[0].Pair != (Pair{Giver: 0, Recipient: 1}) || !predictable[0].Forced {
+		t.Errorf("want 00 to always have 01; got: %+v", predictable)
+	}
+}
+
+// restrictAllBut restricts a giver from everyone except allowed.
+func restrictAllBut(n int, giver Pid, allowed ...Pid) []Pid {
+	var ids []Pid
+	for i := 0; i < n; i++ {
+		if id := Pid(i); id != giver && !containsPid(allowed, id) {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
@@ -0,0 +1,130 @@
+package giftex
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+	"sort"
+)
+
+// cryptoSource is a rand.Source backed by crypto/rand. It can't be
+// seeded, so draws made with it can never be predicted or replayed.
+// It is safe for concurrent use.
+type cryptoSource struct{}
+
+// NewCryptoSource returns a cryptographically secure rand.Source.
+func NewCryptoSource() rand.Source64 {
+	return cryptoSource{}
+}
+
+func (cryptoSource) Seed(int64) {}
+
+func (s cryptoSource) Int63() int64 {
+	return int64(s.Uint64() & (1<<63 - 1))
+}
+
+func (cryptoSource) Uint64() uint64 {
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		panic("Error reading crypto/rand: " + err.Error())
+	}
+
+	return binary.LittleEndian.Uint64(b[:])
+}
+
+// NewSeed picks a random seed for a draw that can be replayed later
+// by setting GiftExchangeOptions.Seed. It is never 0.
+func NewSeed() int64 {
+	for {
+		if seed := NewCryptoSource().Int63(); seed != 0 {
+			return seed
+		}
+	}
+}
+
+// random returns the random number generator to use for a draw. A
+// new generator is created for every draw unless opts.Rand is set,
+// so draws never share state with each other.
+func (opts *GiftExchangeOptions) random() *rand.Rand {
+	switch {
+	case opts == nil:
+		return rand.New(NewCryptoSource())
+	case opts.Rand != nil:
+		return opts.Rand
+	case opts.seed() != 0:
+		return rand.New(rand.NewSource(opts.seed()))
+	default:
+		return rand.New(NewCryptoSource())
+	}
+}
+
+// seed returns the seed of a draw made with opts, or 0 when it isn't
+// seeded.
+func (opts *GiftExchangeOptions) seed() int64 {
+	switch {
+	case opts == nil:
+		return 0
+	case opts.Reveal != nil:
+		return opts.Reveal.Seed()
+	default:
+		return opts.Seed
+	}
+}
+
+// drawOrder lists the participants in pm sorted by name. Draws are
+// made in this order instead of by Pid, so replaying a seed gives the
+// same assignment no matter what order the participants were read in.
+func drawOrder(pm ParticipantMap) []Pid {
+	order := make([]Pid, 0, len(pm))
+	for id := range pm {
+		order = append(order, id)
+	}
+
+	sort.Slice(order, func(i, j int) bool {
+		a, b := pm[order[i]], pm[order[j]]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+
+		return a.ID < b.ID
+	})
+
+	return order
+}
+
+// permute moves participant order[i] to row and column i of matrix m.
+func (m matrix) permute(order []Pid) matrix {
+	p := make(matrix, len(m))
+	for i := range p {
+		p[i] = make([]int, len(m))
+		for j := range p[i] {
+			p[i][j] = m[order[i]][order[j]]
+		}
+	}
+
+	return p
+}
+
+// permuteCosts moves participant order[i] to row and column i of cost.
+func permuteCosts(cost [][]int64, order []Pid) [][]int64 {
+	p := make([][]int64, len(cost))
+	for i := range p {
+		p[i] = make([]int64, len(cost))
+		for j := range p[i] {
+			p[i][j] = cost[order[i]][order[j]]
+		}
+	}
+
+	return p
+}
+
+// relabel maps an assignment drawn from a permuted matrix back to the
+// participants' own Pids.
+func (a Assignment) relabel(order []Pid) Assignment {
+	b := make(Assignment, len(a))
+	for i, j := range a {
+		b[order[i]] = order[j]
+	}
+
+	return b
+}
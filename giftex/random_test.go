@@ -0,0 +1,79 @@
+package giftex
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestNewGiftExchange_seed(t *testing.T) {
+	pm := make(ParticipantMap, 8)
+	for i := 0; i < 8; i++ {
+		pm[Pid(i)] = Participant{ID: Pid(i), Name: fmt.Sprint(i), Previous: []Pid{Pid((i + 1) % 8)}}
+	}
+
+	tests := []struct {
+		name string
+		opts GiftExchangeOptions
+	}{
+		{name: "Default"},
+		{name: "Costs", opts: GiftExchangeOptions{Costs: &CostOptions{Repeat: 5, Tolerance: 10}}},
+		{name: "Cycles", opts: GiftExchangeOptions{NoMutualPairs: true}},
+		{name: "GiftsPerPerson", opts: GiftExchangeOptions{GiftsPerPerson: 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			draw := func(seed int64) string {
+				opts := tt.opts
+				opts.Seed = seed
+
+				ge, err := NewGiftExchange(pm, &opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if ge.Seed != seed {
+					t.Fatalf("want seed %d; got: %d", seed, ge.Seed)
+				}
+
+				return ge.Recipients.String()
+			}
+
+			seed := NewSeed()
+			want := draw(seed)
+			for try := 0; try < 10; try++ {
+				if got := draw(seed); want != got {
+					t.Fatalf("replay with seed %d doesn't match:\nwant:\n%s\ngot:\n%s", seed, want, got)
+				}
+			}
+
+			// Other seeds should give us something else eventually
+			for try := 0; try < 100; try++ {
+				if draw(NewSeed()) != want {
+					return
+				}
+			}
+
+			t.Errorf("every seed drew the same assignment:\n%s", want)
+		})
+	}
+}
+
+func TestCryptoSource(t *testing.T) {
+	r := rand.New(NewCryptoSource())
+
+	seen := make(map[int]bool)
+	for i := 0; i < 1000; i++ {
+		n := r.Intn(10)
+		if n < 0 || n >= 10 {
+			t.Fatalf("out of range: %d", n)
+		}
+
+		seen[n] = true
+	}
+
+	if len(seen) != 10 {
+		t.Errorf("want every number from 0 to 9; got: %v", seen)
+	}
+}
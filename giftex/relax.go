@@ -0,0 +1,362 @@
+package giftex
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// Relaxation is a change to a gift exchange that makes an assignment
+// possible. Either some Restrictions and Previous entries are dropped,
+// or MaxPrevious is lowered so that fewer previous assignments apply.
+type Relaxation struct {
+	Restrictions []Pair
+	Previous     []Pair
+
+	// MaxPrevious replaces GiftExchangeOptions.MaxPrevious when set,
+	// and lowers RepeatYears to match when History is used.
+	MaxPrevious int
+}
+
+// SuggestRelaxations works out the smallest changes that would make
+// an assignment possible for an infeasible gift exchange. Dropping
+// previous assignments is always preferred over dropping restrictions
+// because restrictions usually exist for a reason. The suggestions
+// are ordered from least to most invasive and are empty when pm
+// already has a solution or can't be fixed at all. There are no
+// suggestions when some participants only give or only receive, or
+// when participants swap gifts. Working out which restrictions and
+// previous entries to drop looks at every pair, so with more than
+// MaxMatrixParticipants only a shorter wait before repeating a match
+// is suggested.
+//
+// The entries to drop are worked out without looking at how long the
+// loops of givers are. With SingleCycle, NoMutualPairs, or
+// MinCycleLength, those suggestions are left out unless
+// NewGiftExchange is sure to draw with them. When the search for loops
+// gives up on pm itself, there are no suggestions at all since there
+// may be nothing to fix; NewGiftExchange returns ErrGaveUp then.
+func SuggestRelaxations(pm ParticipantMap, opts *GiftExchangeOptions) []Relaxation {
+	if hasRoles(pm) || opts.swap() {
+		return nil
+	}
+
+	// Nothing needs to change, or we can't tell
+	if ok, known := checkFeasible(pm, opts); ok || !known {
+		return nil
+	}
+
+	var suggestions []Relaxation
+
+	// Waiting less time before repeating a match doesn't change
+	// anybody's data, so it goes first.
+	if k := largestMaxPrevious(pm, opts); k > 0 {
+		suggestions = append(suggestions, Relaxation{MaxPrevious: k})
+	}
+
+	if checkMatrixSize(len(pm), "dropping restrictions") != nil {
+		return suggestions
+	}
+
+	restrictions, previous := splitConstraints(pm, opts)
+	allow := allowedPairs(pm, opts)
+
+	// Any number of previous entries is cheaper than one restriction
+	k := opts.giftsPerPerson()
+	preferPrevious, ok := minimumDrop(pm, allow, restrictions, previous, int64(len(pm)+1), k)
+	offered := ok && preferPrevious.feasible(pm, opts)
+	if offered {
+		suggestions = append(suggestions, preferPrevious)
+	}
+
+	// Dropping a restriction may avoid having to drop many previous
+	// entries, so offer the fewest entries overall as well.
+	fewest, ok := minimumDrop(pm, allow, restrictions, previous, 1, k)
+	if ok && (!offered || fewest.size() < preferPrevious.size()) && fewest.feasible(pm, opts) {
+		suggestions = append(suggestions, fewest)
+	}
+
+	return suggestions
+}
+
+// feasible reports whether an assignment exists once r is applied.
+func (r Relaxation) feasible(pm ParticipantMap, opts *GiftExchangeOptions) bool {
+	newPM, newOpts := r.Apply(pm, opts)
+	return feasible(newPM, newOpts)
+}
+
+// Apply returns copies of pm and opts with relaxation r applied.
+//
+// When previous entries are dropped, any older than MaxPrevious are
+// removed as well, otherwise they would take the dropped entries' place.
+// Records in History that kept a dropped pair apart are removed from
+// the copy of opts. Restrictions are dropped both ways when they are
+// symmetric, and so are previous entries when RepeatBothWays is set.
+//
+// The copies are only meant for drawing. Save pm and opts rather than
+// the copies, otherwise the older previous entries and the dropped
+// History records are lost for good.
+func (r Relaxation) Apply(pm ParticipantMap, opts *GiftExchangeOptions) (ParticipantMap, *GiftExchangeOptions) {
+	newOpts := &GiftExchangeOptions{}
+	if opts != nil {
+		*newOpts = *opts
+	}
+
+	if r.MaxPrevious > 0 {
+		newOpts.MaxPrevious = r.MaxPrevious
+		if len(newOpts.History) > 0 && (newOpts.RepeatYears == 0 || newOpts.RepeatYears > r.MaxPrevious) {
+			newOpts.RepeatYears = r.MaxPrevious
+		}
+	}
+
+	recent := recentPrevious(pm, newOpts)
+	droppedPrevious := func(giver, recipient Pid) bool {
+		return containsPair(r.Previous, Pair{Giver: giver, Recipient: recipient}) ||
+			(newOpts.RepeatBothWays && containsPair(r.Previous, Pair{Giver: recipient, Recipient: giver}))
+	}
+
+	drop := func(ids []Pid, dropped func(id Pid) bool) []Pid {
+		kept := make([]Pid, 0, len(ids))
+		for _, id := range ids {
+			if !dropped(id) {
+				kept = append(kept, id)
+			}
+		}
+
+		return kept
+	}
+
+	newPM := make(ParticipantMap, len(pm))
+	for giver, p := range pm {
+		p.Restrictions = drop(p.Restrictions, func(id Pid) bool {
+			return containsPair(r.Restrictions, Pair{Giver: giver, Recipient: id}) ||
+				(newOpts.SymmetricRestrictions && containsPair(r.Restrictions, Pair{Giver: id, Recipient: giver}))
+		})
+
+		if len(r.Previous) > 0 && !newOpts.usesHistory() {
+			p.Previous = drop(recent[giver], func(id Pid) bool {
+				return droppedPrevious(giver, id)
+			})
+		}
+
+		newPM[giver] = p
+	}
+
+	if len(r.Previous) > 0 && len(newOpts.History) > 0 {
+		history := newOpts.recentHistory()
+		forget := make(map[HistoryRecord]bool)
+		historyPairs(pm, newOpts, history, func(rec HistoryRecord, giver, recipient Pid) {
+			if droppedPrevious(giver, recipient) {
+				forget[rec] = true
+			}
+		})
+
+		kept := make(History, 0, len(newOpts.History))
+		for _, rec := range newOpts.History {
+			if !forget[rec] {
+				kept = append(kept, rec)
+			}
+		}
+
+		newOpts.History = kept
+	}
+
+	return newPM, newOpts
+}
+
+// Describe lists the changes made by relaxation r in plain English.
+func (r Relaxation) Describe(pm ParticipantMap) []string {
+	var lines []string
+	if r.MaxPrevious > 0 {
+		years := "year"
+		if r.MaxPrevious > 1 {
+			years = "years"
+		}
+
+		lines = append(lines, fmt.Sprintf("Allow repeat matches after %d %s", r.MaxPrevious, years))
+	}
+
+	for _, p := range r.Restrictions {
+		lines = append(lines, fmt.Sprintf("Allow %s to be matched with %s", pm[p.Giver].Name, pm[p.Recipient].Name))
+	}
+
+	for _, p := range r.Previous {
+		lines = append(lines, fmt.Sprintf("Allow %s to have %s again", pm[p.Giver].Name, pm[p.Recipient].Name))
+	}
+
+	return lines
+}
+
+func (r Relaxation) size() int {
+	return len(r.Restrictions) + len(r.Previous)
+}
+
+// feasible reports whether NewGiftExchange can surely draw an
+// assignment for pm. See checkFeasible.
+func feasible(pm ParticipantMap, opts *GiftExchangeOptions) bool {
+	ok, known := checkFeasible(pm, opts)
+	return ok && known
+}
+
+// checkFeasible reports whether NewGiftExchange can draw an assignment
+// for pm, including loops that are long enough and more than one gift
+// each. Searching for loops gives up eventually, and then known is
+// false since there may still be an assignment.
+func checkFeasible(pm ParticipantMap, opts *GiftExchangeOptions) (ok, known bool) {
+	restrictions, previous := splitConstraints(pm, opts)
+	s := newConstraintGraph(pm, opts, restrictions, previous)
+	if !s.CheckConstraints() {
+		return false, true
+	}
+
+	k := opts.giftsPerPerson()
+	minCycle := opts.minCycleLength(len(pm))
+	if k == 1 && minCycle <= 2 {
+		return true, true
+	}
+
+	if checkMatrixSize(len(pm), matrixOption(pm, opts)) != nil {
+		return false, false
+	}
+
+	// Whatever is found or ruled out doesn't depend on the seed
+	r := rand.New(rand.NewSource(1))
+	if k > 1 {
+		_, ok := s.dense().bipartite().kFactor(k, r)
+		return ok, true
+	}
+
+	_, found, exhaustive := s.dense().assignCycles(minCycle, r)
+	return found, found || exhaustive
+}
+
+// largestMaxPrevious finds the longest wait before repeating a match
+// that still allows an assignment, or 0 if there isn't one. The wait
+// applies to both MaxPrevious and RepeatYears.
+func largestMaxPrevious(pm ParticipantMap, opts *GiftExchangeOptions) int {
+	newOpts := &GiftExchangeOptions{}
+	if opts != nil {
+		*newOpts = *opts
+	}
+
+	longest := 0
+	for _, p := range pm {
+		if prev := newOpts.previous(p); len(prev) > longest {
+			longest = len(prev)
+		}
+	}
+
+	// A MaxPrevious of 0 considers every previous assignment
+	maxPrev := newOpts.MaxPrevious
+	if maxPrev == 0 || maxPrev > longest {
+		maxPrev = longest
+	}
+
+	if len(newOpts.History) > 0 {
+		oldest := newOpts.year() - newOpts.History.oldestYear()
+		repeatYears := newOpts.RepeatYears
+		if repeatYears == 0 || repeatYears > oldest {
+			repeatYears = oldest
+		}
+
+		if repeatYears > maxPrev {
+			maxPrev = repeatYears
+		}
+	}
+
+	for k := maxPrev - 1; k > 0; k-- {
+		_, relaxed := Relaxation{MaxPrevious: k}.Apply(pm, newOpts)
+		if feasible(pm, relaxed) {
+			return k
+		}
+	}
+
+	return 0
+}
+
+// minimumDrop finds the fewest restrictions and previous entries to
+// drop with a minimum cost assignment, where a pair costs nothing
+// when it is allowed, 1 for a previous entry, and restrictionCost for
+// a restriction. Nobody may ever be assigned to themselves or to
+// anybody allow rules out because of their group, pins, or allow-list.
+// When everybody gives k gifts, k assignments that share no pairs are
+// found one after another, which may drop a few more entries than
+// strictly needed.
+func minimumDrop(pm ParticipantMap, allow func(giver, recipient Pid) bool, restrictions, previous constraints, restrictionCost int64, k int) (Relaxation, bool) {
+	n := len(pm)
+	cost := make([][]int64, n)
+	for i := range cost {
+		cost[i] = make([]int64, n)
+		giver := Pid(i)
+
+		for j := range cost[i] {
+			recipient := Pid(j)
+
+			switch {
+			case i == j || !allow(giver, recipient):
+				cost[i][j] = infCost
+			case containsPid(restrictions[giver], recipient):
+				cost[i][j] += restrictionCost
+			}
+
+			if cost[i][j] != infCost && containsPid(previous[giver], recipient) {
+				cost[i][j]++
+			}
+		}
+	}
+
+	var r Relaxation
+	for round := 0; round < k; round++ {
+		assign, total := minCostAssignment(cost)
+		if total >= infCost {
+			return Relaxation{}, false
+		}
+
+		for i, j := range assign {
+			giver, recipient := Pid(i), Pid(j)
+			if containsPid(restrictions[giver], recipient) {
+				r.Restrictions = append(r.Restrictions, Pair{Giver: giver, Recipient: recipient})
+			}
+
+			if containsPid(previous[giver], recipient) {
+				r.Previous = append(r.Previous, Pair{Giver: giver, Recipient: recipient})
+			}
+
+			// Nobody gives the same person two gifts
+			cost[i][j] = infCost
+		}
+	}
+
+	return r, true
+}
+
+func containsPid(ids []Pid, id Pid) bool {
+	for _, x := range ids {
+		if x == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// removePid returns ids without id.
+func removePid(ids []Pid, id Pid) []Pid {
+	kept := ids[:0]
+	for _, x := range ids {
+		if x != id {
+			kept = append(kept, x)
+		}
+	}
+
+	return kept
+}
+
+func containsPair(pairs []Pair, p Pair) bool {
+	for _, x := range pairs {
+		if x == p {
+			return true
+		}
+	}
+
+	return false
+}
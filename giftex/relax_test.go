@@ -0,0 +1,202 @@
+package giftex
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func Example_suggestRelaxations() {
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo", Restrictions: []Pid{2}},
+		1: {ID: 1, Name: "bar", Previous: []Pid{2, 3}},
+		2: {ID: 2, Name: "baz", Previous: []Pid{1}},
+		3: {ID: 3, Name: "qux", Restrictions: []Pid{2}, Previous: []Pid{0, 1}},
+	}
+
+	opts := &GiftExchangeOptions{MaxPrevious: 2}
+	for i, r := range SuggestRelaxations(pm, opts) {
+		fmt.Printf("%d. %s\n", i+1, strings.Join(r.Describe(pm), "; "))
+
+		newPM, newOpts := r.Apply(pm, opts)
+		if _, err := NewGiftExchange(newPM, newOpts); err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	// Output:
+	// 1. Allow repeat matches after 1 year
+	// 2. Allow bar to have baz again; Allow qux to have foo again
+	// 3. Allow qux to be matched with baz
+}
+
+// bestRelaxation finds the fewest restrictions and previous entries
+// that an assignment of pm must break by trying every permutation.
+func bestRelaxation(pm ParticipantMap, restrictionCost int) (restrictions, previous int) {
+	m := newMatrix(len(pm))
+	best := -1
+	for _, p := range bruteForce(m) {
+		var r, prev int
+		for i, j := range p {
+			if containsPid(pm[Pid(i)].Restrictions, Pid(j)) {
+				r++
+			}
+
+			if containsPid(pm[Pid(i)].Previous, Pid(j)) {
+				prev++
+			}
+		}
+
+		if cost := r*restrictionCost + prev; best < 0 || cost < best {
+			best = cost
+			restrictions, previous = r, prev
+		}
+	}
+
+	return restrictions, previous
+}
+
+func TestSuggestRelaxations_bruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+
+	randomPids := func(n int, density float64) []Pid {
+		var ids []Pid
+		for j := 0; j < n; j++ {
+			if r.Float64() < density {
+				ids = append(ids, Pid(j))
+			}
+		}
+
+		return ids
+	}
+
+	for try := 0; try < 300; try++ {
+		n := 2 + r.Intn(5)
+		pm := make(ParticipantMap, n)
+		for i := 0; i < n; i++ {
+			pm[Pid(i)] = Participant{
+				ID:           Pid(i),
+				Restrictions: randomPids(n, 0.3),
+				Previous:     randomPids(n, 0.3),
+			}
+		}
+
+		suggestions := SuggestRelaxations(pm, nil)
+		if feasible(pm, nil) {
+			if len(suggestions) > 0 {
+				t.Fatalf("feasible exchange got suggestions: %v", suggestions)
+			}
+
+			continue
+		}
+
+		var dropped []Relaxation
+		for _, s := range suggestions {
+			newPM, newOpts := s.Apply(pm, nil)
+			if _, err := NewGiftExchange(newPM, newOpts); err != nil {
+				t.Fatalf("suggestion %+v failed: %v", s, err)
+			}
+
+			if s.MaxPrevious == 0 {
+				dropped = append(dropped, s)
+			}
+		}
+
+		if len(dropped) == 0 {
+			t.Fatalf("want at least one suggestion for:\n%v", pm)
+		}
+
+		wantR, wantP := bestRelaxation(pm, n+1)
+		if got := dropped[0]; len(got.Restrictions) != wantR || len(got.Previous) != wantP {
+			t.Fatalf("want %d restrictions and %d previous; got: %+v", wantR, wantP, got)
+		}
+
+		wantR, wantP = bestRelaxation(pm, 1)
+		if got := dropped[len(dropped)-1]; got.size() != wantR+wantP {
+			t.Fatalf("want %d entries in total; got: %+v", wantR+wantP, got)
+		}
+	}
+}
+
+func TestSuggestRelaxations_singleCycle(t *testing.T) {
+	// Everybody can only give within their pair, so there's a perfect
+	// matching but no single loop
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo", Previous: []Pid{2, 3}},
+		1: {ID: 1, Name: "bar", Previous: []Pid{2, 3}},
+		2: {ID: 2, Name: "baz", Previous: []Pid{0, 1}},
+		3: {ID: 3, Name: "qux", Previous: []Pid{0, 1}},
+	}
+
+	for _, opts := range []*GiftExchangeOptions{
+		{SingleCycle: true},
+		{NoMutualPairs: true},
+		{GiftsPerPerson: 2},
+	} {
+		if feasible(pm, opts) {
+			t.Fatalf("%+v: want infeasible", *opts)
+		}
+
+		suggestions := SuggestRelaxations(pm, opts)
+		if len(suggestions) == 0 {
+			t.Fatalf("%+v: want suggestions", *opts)
+		}
+
+		for _, s := range suggestions {
+			newPM, newOpts := s.Apply(pm, opts)
+			newOpts.Rand = rand.New(rand.NewSource(1))
+			if _, err := NewGiftExchange(newPM, newOpts); err != nil {
+				t.Errorf("%+v: suggestion %v failed: %v", *opts, s.Describe(pm), err)
+			}
+		}
+	}
+}
+
+func TestRelaxation_Apply_symmetric(t *testing.T) {
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo", Group: "smith"},
+		1: {ID: 1, Name: "bar", Group: "smith"},
+		2: {ID: 2, Name: "baz", Group: "jones", Restrictions: []Pid{0}},
+		3: {ID: 3, Name: "qux", Group: "jones", Restrictions: []Pid{0}},
+	}
+
+	opts := &GiftExchangeOptions{SymmetricRestrictions: true}
+	suggestions := SuggestRelaxations(pm, opts)
+	if len(suggestions) != 1 {
+		t.Fatalf("want 1 suggestion; got: %v", suggestions)
+	}
+
+	// Groups can't be relaxed, so only restrictions on foo may go
+	for _, p := range suggestions[0].Restrictions {
+		if sameGroup(pm[p.Giver], pm[p.Recipient]) {
+			t.Fatalf("suggested dropping a group: %v", suggestions[0].Describe(pm))
+		}
+	}
+
+	newPM, newOpts := suggestions[0].Apply(pm, opts)
+	if _, err := NewGiftExchange(newPM, newOpts); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRelaxation_Apply_previous(t *testing.T) {
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo", Previous: []Pid{1, 2, 3}},
+		1: {ID: 1, Name: "bar"},
+		2: {ID: 2, Name: "baz"},
+		3: {ID: 3, Name: "qux"},
+	}
+
+	opts := &GiftExchangeOptions{MaxPrevious: 2}
+	newPM, _ := Relaxation{Previous: []Pair{{Giver: 0, Recipient: 2}}}.Apply(pm, opts)
+
+	// bar is older than MaxPrevious, so it can't take baz's place
+	if got := fmt.Sprint(newPM[0].Previous); got != "[3]" {
+		t.Errorf("want [3]; got: %s", got)
+	}
+
+	if got := fmt.Sprint(pm[0].Previous); got != "[1 2 3]" {
+		t.Errorf("Previous was modified: %s", got)
+	}
+}
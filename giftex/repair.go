@@ -0,0 +1,101 @@
+package giftex
+
+import (
+	"fmt"
+	"sort"
+)
+
+// RepairGiftExchange updates an existing assignment after people join
+// late or drop out, without reshuffling everybody. old is the
+// assignment that was drawn for the participants in oldPM, and pm is
+// the updated list of participants. Participants are matched between
+// the two by name because their Pids change when the list changes.
+//
+// The new assignment keeps as many of the old pairs as possible,
+// picking at random between the assignments that change the fewest.
+// Everybody must give and receive one gift, so cost, cycle, gifts per
+// person, role, and swap options can't be used.
+// Renotify lists the givers in pm who have a new recipient, including
+// anybody who just joined, so only they need to be told.
+func RepairGiftExchange(oldPM ParticipantMap, old Assignment, pm ParticipantMap, opts *GiftExchangeOptions) (ge *GiftExchange, renotify []Pid, err error) {
+	n := len(pm)
+	if opts.giftsPerPerson() > 1 || opts.minCycleLength(n) > 2 || (opts != nil && opts.Costs != nil) || hasRoles(pm) || opts.swap() {
+		return nil, nil, ErrConflictingOptions
+	}
+
+	if err := checkMatrixSize(n, "repairing a gift exchange"); err != nil {
+		return nil, nil, err
+	}
+
+	s, err := constraintGraph(pm, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m := s.dense()
+
+	byName := make(map[string]Pid, n)
+	for id, p := range pm {
+		byName[trimLower(p.Name)] = id
+	}
+
+	// Find who everybody that's still here had before
+	had := make(map[Pid]Pid, len(old))
+	for giver, recipient := range old {
+		g, ok := byName[trimLower(oldPM[giver].Name)]
+		if !ok {
+			continue
+		}
+
+		if r, ok := byName[trimLower(oldPM[recipient].Name)]; ok {
+			had[g] = r
+		}
+	}
+
+	// Keeping an old pair is free and every change costs 1
+	cost := make([][]int64, n)
+	for i := range cost {
+		cost[i] = make([]int64, n)
+		for j := range cost[i] {
+			switch r, ok := had[Pid(i)]; {
+			case m[i][j] == 1:
+				cost[i][j] = infCost
+			case !ok || r != Pid(j):
+				cost[i][j] = 1
+			}
+		}
+	}
+
+	order := drawOrder(pm)
+	a, _ := assignMinCost(permuteCosts(cost, order), CostOptions{}, opts.random())
+	a = a.relabel(order)
+
+	if !verifyMultiAssignment(a.Multi(), s, 1) {
+		return nil, nil, fmt.Errorf("Error repairing gift exchange: %w", ErrNoSolution)
+	}
+
+	for giver, recipient := range a {
+		if r, ok := had[giver]; !ok || r != recipient {
+			renotify = append(renotify, giver)
+		}
+	}
+
+	sort.Slice(renotify, func(i, j int) bool {
+		return pm[renotify[i]].Name < pm[renotify[j]].Name
+	})
+
+	ge = &GiftExchange{
+		numParticipants: n,
+		participants:    pm,
+		Assignment:      a,
+		Recipients:      a.Multi(),
+	}
+
+	return ge, renotify, nil
+}
+
+// Repair updates gift exchange ge for the new list of participants
+// in pm. See RepairGiftExchange.
+func (ge *GiftExchange) Repair(pm ParticipantMap, opts *GiftExchangeOptions) (*GiftExchange, []Pid, error) {
+	return RepairGiftExchange(ge.participants, ge.Assignment, pm, opts)
+}
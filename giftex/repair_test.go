@@ -0,0 +1,133 @@
+package giftex
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func Example_repair() {
+	oldPM := ParticipantMap{
+		0: {ID: 0, Name: "foo"},
+		1: {ID: 1, Name: "bar"},
+		2: {ID: 2, Name: "baz"},
+		3: {ID: 3, Name: "qux"},
+	}
+	old := Assignment{0: 1, 1: 2, 2: 3, 3: 0}
+
+	// qux drops out and quux joins late
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo"},
+		1: {ID: 1, Name: "bar"},
+		2: {ID: 2, Name: "baz"},
+		3: {ID: 3, Name: "quux"},
+	}
+
+	ge, renotify, err := RepairGiftExchange(oldPM, old, pm, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Print(ge)
+	for _, id := range renotify {
+		fmt.Println("Tell", pm[id].Name)
+	}
+
+	// Output:
+	// Results:
+	// | bar   | baz   |
+	// | baz   | quux  |
+	// | foo   | bar   |
+	// | quux  | foo   |
+	// Tell baz
+	// Tell quux
+}
+
+func TestRepairGiftExchange_bruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(11))
+
+	for try := 0; try < 300; try++ {
+		n := 2 + r.Intn(5)
+		oldPM := make(ParticipantMap, n)
+		for i := 0; i < n; i++ {
+			oldPM[Pid(i)] = Participant{ID: Pid(i), Name: fmt.Sprint("p", i)}
+		}
+
+		perm := r.Perm(n)
+		old := make(Assignment, n)
+		for i, j := range perm {
+			old[Pid(i)] = Pid(j)
+		}
+
+		// Drop somebody, add somebody new, and restrict a few pairs
+		dropped := r.Intn(n)
+		names := []string{fmt.Sprint("p", n)}
+		for i := 0; i < n; i++ {
+			if i != dropped {
+				names = append(names, fmt.Sprint("p", i))
+			}
+		}
+		r.Shuffle(len(names), func(a, b int) { names[a], names[b] = names[b], names[a] })
+
+		pm := make(ParticipantMap, len(names))
+		for i, name := range names {
+			p := Participant{ID: Pid(i), Name: name}
+			for j := range names {
+				if r.Float64() < 0.2 {
+					p.Restrictions = append(p.Restrictions, Pid(j))
+				}
+			}
+
+			pm[Pid(i)] = p
+		}
+
+		m, err := constraintMatrix(pm, nil)
+		ge, renotify, gotErr := RepairGiftExchange(oldPM, old, pm, nil)
+		if (err == nil) != (gotErr == nil) {
+			t.Fatalf("want error: %v; got: %v", err, gotErr)
+		}
+
+		if err != nil {
+			continue
+		}
+
+		// Count the fewest givers that need to change
+		had := func(giver, recipient int) bool {
+			for g, r := range old {
+				if oldPM[g].Name == names[giver] && oldPM[r].Name == names[recipient] {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		fewest := len(names)
+		for _, p := range bruteForce(m) {
+			changes := 0
+			for i, j := range p {
+				if !had(i, j) {
+					changes++
+				}
+			}
+
+			if changes < fewest {
+				fewest = changes
+			}
+		}
+
+		if len(renotify) != fewest {
+			t.Fatalf("want %d changes; got: %d", fewest, len(renotify))
+		}
+
+		for giver, recipient := range ge.Assignment {
+			if m[giver][recipient] == 1 {
+				t.Fatalf("invalid pair %d -> %d", giver, recipient)
+			}
+
+			if changed := containsPid(renotify, giver); changed == had(int(giver), int(recipient)) {
+				t.Fatalf("wrong renotify for %s: %v", pm[giver].Name, renotify)
+			}
+		}
+	}
+}
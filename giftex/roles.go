@@ -0,0 +1,250 @@
+package giftex
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// Role is whether a participant gives gifts, receives them, or both,
+// e.g., young kids may only receive gifts and grandparents may only
+// give them.
+type Role int
+
+const (
+	GivesAndReceives Role = iota
+	GivesOnly
+	ReceivesOnly
+)
+
+// ParseRole reads a role such as "gives", "receives", or "both".
+// Anything it doesn't recognize, including a blank, means both.
+func ParseRole(s string) Role {
+	switch trimLower(s) {
+	case "gives", "give", "giver", "gives only", "give only":
+		return GivesOnly
+	case "receives", "receive", "recipient", "receives only", "receive only":
+		return ReceivesOnly
+	}
+
+	return GivesAndReceives
+}
+
+func (r Role) String() string {
+	switch r {
+	case GivesOnly:
+		return "gives"
+	case ReceivesOnly:
+		return "receives"
+	}
+
+	return "both"
+}
+
+// Gives reports whether somebody with role r gives gifts.
+func (r Role) Gives() bool {
+	return r != ReceivesOnly
+}
+
+// Receives reports whether somebody with role r receives gifts.
+func (r Role) Receives() bool {
+	return r != GivesOnly
+}
+
+// hasRoles reports whether anybody in pm only gives or only receives.
+func hasRoles(pm ParticipantMap) bool {
+	for _, p := range pm {
+		if p.Role != GivesAndReceives {
+			return true
+		}
+	}
+
+	return false
+}
+
+// giftBounds works out how many gifts everybody in pm gives and
+// receives. Without roles, that's GiftsPerPerson for everyone.
+//
+// When there are more recipients than givers, every recipient still
+// gets GiftsPerPerson gifts and some givers give one more gift than
+// others to make up the difference. When there are more givers than
+// recipients, every giver gives GiftsPerPerson gifts and the extra
+// gifts are spread evenly across the recipients. With ExtraGifts set,
+// the extra gifts only go to people who receive without giving, and
+// none of them gets more than ExtraGifts extra.
+func giftBounds(pm ParticipantMap, opts *GiftExchangeOptions) (gives, receives degreeBounds, err error) {
+	n := len(pm)
+	k := opts.giftsPerPerson()
+	if !hasRoles(pm) {
+		return exactly(n, k), exactly(n, k), nil
+	}
+
+	var numGivers, numRecipients, receiveOnly int
+	for _, p := range pm {
+		if p.Role.Gives() {
+			numGivers++
+		}
+
+		if p.Role.Receives() {
+			numRecipients++
+		}
+
+		if p.Role == ReceivesOnly {
+			receiveOnly++
+		}
+	}
+
+	if numGivers == 0 || numRecipients == 0 {
+		return gives, receives, fmt.Errorf("%w without anybody to give and receive gifts", ErrNoSolution)
+	}
+
+	var extra int
+	if opts != nil {
+		extra = opts.ExtraGifts
+	}
+
+	total := k * numGivers
+	if numRecipients > numGivers {
+		total = k * numRecipients
+	}
+
+	if surplus := total - k*numRecipients; extra > 0 && surplus > extra*receiveOnly {
+		return gives, receives, fmt.Errorf("%w when %d extra gifts only go to people who don't give", ErrNoSolution, surplus)
+	}
+
+	// spread splits total gifts as evenly as possible between count people
+	spread := func(total, count int) (min, max int) {
+		return total / count, (total + count - 1) / count
+	}
+
+	gives = degreeBounds{min: make([]int, n), max: make([]int, n)}
+	receives = degreeBounds{min: make([]int, n), max: make([]int, n)}
+	for id, p := range pm {
+		if p.Role.Gives() {
+			gives.min[id], gives.max[id] = spread(total, numGivers)
+		}
+
+		if !p.Role.Receives() {
+			continue
+		}
+
+		switch {
+		case extra > 0 && p.Role == ReceivesOnly:
+			receives.min[id], receives.max[id] = k, k+extra
+		case extra > 0:
+			receives.min[id], receives.max[id] = k, k
+		default:
+			receives.min[id], receives.max[id] = spread(total, numRecipients)
+		}
+	}
+
+	return gives, receives, nil
+}
+
+// checkRoles removes every pair from matrix m that goes to or from
+// somebody in the wrong role, and then checks that everybody can give
+// and receive as many gifts as they should.
+func checkRoles(m matrix, pm ParticipantMap, opts *GiftExchangeOptions) error {
+	forbidRoles(m, pm)
+
+	gives, receives, err := giftBounds(pm, opts)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := m.bipartite().boundedFactor(gives, receives, rand.New(rand.NewSource(1))); !ok {
+		return ErrNoSolution
+	}
+
+	return nil
+}
+
+// forbidRoles removes every pair from matrix m that goes to or from
+// somebody in the wrong role.
+func forbidRoles(m matrix, pm ParticipantMap) {
+	for i := range m {
+		for j := range m[i] {
+			if !pm[Pid(i)].Role.Gives() || !pm[Pid(j)].Role.Receives() {
+				m[i][j] = 1
+			}
+		}
+	}
+}
+
+// assignBounded draws recipients for every giver in matrix m so that
+// everybody gives and receives as many gifts as gives and receives
+// allow. Each giver's first recipient is in the first round, their
+// second recipient in the second round, and so on.
+func (m matrix) assignBounded(gives, receives degreeBounds, r *rand.Rand) ([]Assignment, bool) {
+	g := m.bipartite()
+	factor, ok := g.boundedFactor(gives, receives, r)
+	if !ok {
+		return nil, false
+	}
+
+	var pairs int
+	for i := range factor {
+		pairs += len(factor[i])
+	}
+
+	factor = shuffleFactor(r, factor, g.allowed(), 100*pairs+10000)
+
+	var rounds []Assignment
+	for i, recipients := range factor {
+		for round, j := range recipients {
+			if round == len(rounds) {
+				rounds = append(rounds, make(Assignment))
+			}
+
+			rounds[round][Pid(i)] = Pid(j)
+		}
+	}
+
+	return rounds, true
+}
+
+// shuffleFactor mixes up the pairs in factor by repeatedly picking two
+// pairs and swapping their recipients whenever the result is still
+// allowed and doesn't repeat a pair. Everybody keeps the same number
+// of pairs, and like sampleMarkov, each swap is as likely as its
+// reverse.
+func shuffleFactor(r *rand.Rand, factor bipartite, ok func(i, j int) bool, steps int) bipartite {
+	type pair struct{ i, j int }
+
+	var pairs []pair
+	used := make(map[pair]bool)
+	for i := range factor {
+		for _, j := range factor[i] {
+			pairs = append(pairs, pair{i, j})
+			used[pair{i, j}] = true
+		}
+	}
+
+	if len(pairs) < 2 {
+		return factor
+	}
+
+	for s := 0; s < steps; s++ {
+		a, b := r.Intn(len(pairs)), r.Intn(len(pairs))
+		p, q := pairs[a], pairs[b]
+		if p.i == q.i || p.j == q.j {
+			continue
+		}
+
+		x, y := pair{p.i, q.j}, pair{q.i, p.j}
+		if used[x] || used[y] || !ok(x.i, x.j) || !ok(y.i, y.j) {
+			continue
+		}
+
+		delete(used, p)
+		delete(used, q)
+		used[x], used[y] = true, true
+		pairs[a], pairs[b] = x, y
+	}
+
+	shuffled := make(bipartite, len(factor))
+	for _, p := range pairs {
+		shuffled[p.i] = append(shuffled[p.i], p.j)
+	}
+
+	return shuffled
+}
@@ -0,0 +1,317 @@
+package giftex
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func Example_roles() {
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo"},
+		1: {ID: 1, Name: "bar"},
+		2: {ID: 2, Name: "kid", Role: ReceivesOnly},
+		3: {ID: 3, Name: "grandma", Role: GivesOnly},
+		4: {ID: 4, Name: "grandpa", Role: GivesOnly},
+	}
+
+	// There are more givers than recipients, so the kid gets an
+	// extra gift
+	ge, err := NewGiftExchange(pm, &GiftExchangeOptions{ExtraGifts: 1})
+	if err != nil {
+		panic(err)
+	}
+
+	received := make(map[string]int)
+	for _, recipients := range ge.Recipients {
+		for _, id := range recipients {
+			received[pm[id].Name]++
+		}
+	}
+
+	for _, id := range drawOrder(pm) {
+		p := pm[id]
+		fmt.Printf("%s gives %d and receives %d\n", p.Name, len(ge.Recipients[id]), received[p.Name])
+	}
+
+	// Output:
+	// bar gives 1 and receives 1
+	// foo gives 1 and receives 1
+	// grandma gives 1 and receives 0
+	// grandpa gives 1 and receives 0
+	// kid gives 0 and receives 2
+}
+
+func TestParseRole(t *testing.T) {
+	tests := map[string]Role{
+		"":             GivesAndReceives,
+		"both":         GivesAndReceives,
+		"Gives":        GivesOnly,
+		" give only ":  GivesOnly,
+		"RECEIVES":     ReceivesOnly,
+		"receive only": ReceivesOnly,
+	}
+
+	for s, want := range tests {
+		if got := ParseRole(s); want != got {
+			t.Errorf("%q: want: %s; got: %s", s, want, got)
+		}
+	}
+}
+
+func TestReadCSV_roles(t *testing.T) {
+	db, err := ReadCSVFromFile("testdata/roles.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, p := range db.Participants {
+		got = append(got, p.Name+" "+p.Role.String())
+	}
+	sort.Strings(got)
+
+	want := "[bar both foo both grandma gives kid receives]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("want: %s; got: %v", want, got)
+	}
+}
+
+func TestGiftBounds(t *testing.T) {
+	tests := []struct {
+		name     string
+		roles    []Role
+		opts     *GiftExchangeOptions
+		gives    string
+		receives string
+		err      error
+	}{
+		{
+			name:     "Everybody gives and receives",
+			roles:    []Role{GivesAndReceives, GivesAndReceives, GivesAndReceives},
+			opts:     &GiftExchangeOptions{GiftsPerPerson: 2},
+			gives:    "{[2 2 2] [2 2 2]}",
+			receives: "{[2 2 2] [2 2 2]}",
+		},
+		{
+			name:     "More recipients",
+			roles:    []Role{GivesAndReceives, GivesAndReceives, ReceivesOnly, ReceivesOnly, ReceivesOnly},
+			gives:    "{[2 2 0 0 0] [3 3 0 0 0]}",
+			receives: "{[1 1 1 1 1] [1 1 1 1 1]}",
+		},
+		{
+			name:     "More givers",
+			roles:    []Role{GivesAndReceives, GivesOnly, GivesOnly, ReceivesOnly},
+			gives:    "{[1 1 1 0] [1 1 1 0]}",
+			receives: "{[1 0 0 1] [2 0 0 2]}",
+		},
+		{
+			name:     "Extra gifts",
+			roles:    []Role{GivesAndReceives, GivesOnly, GivesOnly, ReceivesOnly},
+			opts:     &GiftExchangeOptions{ExtraGifts: 2},
+			gives:    "{[1 1 1 0] [1 1 1 0]}",
+			receives: "{[1 0 0 1] [1 0 0 3]}",
+		},
+		{
+			name:  "Too many extra gifts",
+			roles: []Role{GivesAndReceives, GivesOnly, GivesOnly, GivesOnly, ReceivesOnly},
+			opts:  &GiftExchangeOptions{ExtraGifts: 1},
+			err:   ErrNoSolution,
+		},
+		{
+			name:  "Nobody receives",
+			roles: []Role{GivesOnly, GivesOnly},
+			err:   ErrNoSolution,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm := make(ParticipantMap, len(tt.roles))
+			for i, role := range tt.roles {
+				pm[Pid(i)] = Participant{ID: Pid(i), Role: role}
+			}
+
+			gives, receives, err := giftBounds(pm, tt.opts)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("want error: %v; got: %v", tt.err, err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if got := fmt.Sprint(gives); tt.gives != got {
+				t.Errorf("gives want: %s; got: %s", tt.gives, got)
+			}
+
+			if got := fmt.Sprint(receives); tt.receives != got {
+				t.Errorf("receives want: %s; got: %s", tt.receives, got)
+			}
+		})
+	}
+}
+
+// boundedFactorExists checks every subset of the edges of g for one
+// that fits the bounds.
+func boundedFactorExists(g bipartite, gives, receives degreeBounds) bool {
+	type edge struct{ i, j int }
+	var edges []edge
+	for i := range g {
+		for _, j := range g[i] {
+			edges = append(edges, edge{i, j})
+		}
+	}
+
+	n := len(g)
+	for mask := 0; mask < 1<<len(edges); mask++ {
+		out, in := make([]int, n), make([]int, n)
+		for k, e := range edges {
+			if mask&(1<<k) != 0 {
+				out[e.i]++
+				in[e.j]++
+			}
+		}
+
+		ok := true
+		for i := 0; i < n && ok; i++ {
+			ok = out[i] >= gives.min[i] && out[i] <= gives.max[i] &&
+				in[i] >= receives.min[i] && in[i] <= receives.max[i]
+		}
+
+		if ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestBipartite_boundedFactor_bruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(14))
+
+	randomBounds := func(n int) degreeBounds {
+		b := degreeBounds{min: make([]int, n), max: make([]int, n)}
+		for i := range b.min {
+			b.min[i] = r.Intn(3)
+			b.max[i] = b.min[i] + r.Intn(2)
+		}
+
+		return b
+	}
+
+	for try := 0; try < 500; try++ {
+		n := 1 + r.Intn(4)
+		g := randomMatrix(r, n, r.Float64()*0.5).bipartite()
+		gives, receives := randomBounds(n), randomBounds(n)
+
+		want := boundedFactorExists(g, gives, receives)
+		factor, got := g.boundedFactor(gives, receives, r)
+		if want != got {
+			t.Fatalf("want: %v; got: %v\ngraph: %v gives: %v receives: %v", want, got, g, gives, receives)
+		}
+
+		if !got {
+			continue
+		}
+
+		allowed := g.allowed()
+		in := make([]int, n)
+		for i := range factor {
+			if len(factor[i]) < gives.min[i] || len(factor[i]) > gives.max[i] {
+				t.Fatalf("giver %d has %d pairs; want %d to %d", i, len(factor[i]), gives.min[i], gives.max[i])
+			}
+
+			for _, j := range factor[i] {
+				if !allowed(i, j) {
+					t.Fatalf("pair %d -> %d isn't in the graph", i, j)
+				}
+				in[j]++
+			}
+		}
+
+		for j, count := range in {
+			if count < receives.min[j] || count > receives.max[j] {
+				t.Fatalf("recipient %d has %d pairs; want %d to %d", j, count, receives.min[j], receives.max[j])
+			}
+		}
+	}
+}
+
+func TestNewGiftExchange_roles(t *testing.T) {
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo", Restrictions: []Pid{2}},
+		1: {ID: 1, Name: "bar"},
+		2: {ID: 2, Name: "kid", Role: ReceivesOnly},
+		3: {ID: 3, Name: "baby", Role: ReceivesOnly},
+		4: {ID: 4, Name: "grandma", Role: GivesOnly, Restrictions: []Pid{3}},
+	}
+
+	for try := 0; try < 50; try++ {
+		ge, err := NewGiftExchange(pm, &GiftExchangeOptions{Seed: int64(try + 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 3 givers share 4 gifts
+		var total int
+		for giver, recipients := range ge.Recipients {
+			if n := len(recipients); n < 1 || n > 2 || !pm[giver].Role.Gives() {
+				t.Fatalf("%s gives %d gifts", pm[giver].Name, n)
+			}
+
+			for _, id := range recipients {
+				if !pm[id].Role.Receives() {
+					t.Fatalf("%s receives a gift", pm[id].Name)
+				}
+			}
+			total += len(recipients)
+		}
+
+		if total != 4 {
+			t.Fatalf("want 4 gifts; got: %d", total)
+		}
+
+		if err := Verify(pm, &GiftExchangeOptions{Seed: int64(try + 1)}, ge.Commitment, ge); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only foo can give to baby and only grandma can give to kid
+	pm[1] = Participant{ID: 1, Name: "bar", Restrictions: []Pid{2, 3}}
+	pm[4] = Participant{ID: 4, Name: "grandma", Role: GivesOnly, Restrictions: []Pid{0, 1, 3}}
+	pm[0] = Participant{ID: 0, Name: "foo", Restrictions: []Pid{2}}
+	if _, err := NewGiftExchange(pm, nil); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := NewGiftExchange(pm, &GiftExchangeOptions{SingleCycle: true}); !errors.Is(err, ErrConflictingOptions) {
+		t.Errorf("want: %v; got: %v", ErrConflictingOptions, err)
+	}
+
+	pm[0] = Participant{ID: 0, Name: "foo", Restrictions: []Pid{2, 3}}
+	if _, err := NewGiftExchange(pm, nil); !errors.Is(err, ErrNoSolution) {
+		t.Errorf("want: %v; got: %v", ErrNoSolution, err)
+	}
+}
+
+func TestGiftExchange_CycleNames_roles(t *testing.T) {
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "kid", Role: ReceivesOnly},
+		1: {ID: 1, Name: "mom"},
+		2: {ID: 2, Name: "grandma", Role: GivesOnly, Restrictions: []Pid{0}},
+	}
+
+	ge, err := NewGiftExchange(pm, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The chain starts with grandma since nobody gives to her
+	want := "[grandma -> mom -> kid]"
+	if got := fmt.Sprint(ge.CycleNames()); want != got {
+		t.Errorf("want: %s; got: %s", want, got)
+	}
+}
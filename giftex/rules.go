@@ -0,0 +1,326 @@
+package giftex
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnknownRule  = errors.New("Error: unknown rule")
+	ErrInvalidRule  = errors.New(`Error: rules look like "name", "name=forbid", or "name:argument=5"`)
+	ErrNoAttribute  = errors.New(`Error: this rule needs an attribute, e.g., "same:city"`)
+	ErrCostTooLarge = fmt.Errorf("Error: rules can cost at most %d either way", MaxCost)
+)
+
+// Verdict is what a Rule decides about a giver and a recipient. The
+// zero Verdict allows the pair at no cost.
+type Verdict struct {
+	Forbidden bool
+
+	// Cost is added to the cost of the pair when using CostOptions,
+	// and may be negative to prefer the pair instead
+	Cost int64
+}
+
+var (
+	Allow  = Verdict{}
+	Forbid = Verdict{Forbidden: true}
+)
+
+// Cost is a Verdict that allows a pair at a cost. Costs beyond
+// MaxCost either way count as MaxCost.
+func Cost(c int64) Verdict {
+	return Verdict{Cost: c}
+}
+
+// ParseVerdict reads a verdict written as "forbid", "allow", or a
+// cost such as "5". Costs can be at most MaxCost either way.
+func ParseVerdict(s string) (Verdict, error) {
+	switch s = trimLower(s); s {
+	case "forbid", "forbidden", "no":
+		return Forbid, nil
+	case "allow", "allowed", "yes":
+		return Allow, nil
+	}
+
+	c, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return Verdict{}, ErrInvalidRule
+	}
+
+	if c > MaxCost || c < -MaxCost {
+		return Verdict{}, ErrCostTooLarge
+	}
+
+	return Cost(c), nil
+}
+
+func (v Verdict) String() string {
+	switch {
+	case v.Forbidden:
+		return "forbid"
+	case v.Cost != 0:
+		return strconv.FormatInt(v.Cost, 10)
+	}
+
+	return "allow"
+}
+
+// Rule decides whether giver may give to recipient, and at what cost.
+// Rules only ever look at one pair at a time, so they can be combined
+// freely and checked before anything is drawn. Forbidden pairs are
+// hard constraints just like restrictions, but pins take priority
+// over them. Costs only count when GiftExchangeOptions.Costs is set.
+//
+// Rules that implement fmt.Stringer are part of the commitment of a
+// seeded draw, so rules parsed by ParseRule can be verified later.
+type Rule interface {
+	Judge(giver, recipient Participant) Verdict
+}
+
+// RuleFunc lets an ordinary function be used as a Rule.
+type RuleFunc func(giver, recipient Participant) Verdict
+
+func (f RuleFunc) Judge(giver, recipient Participant) Verdict {
+	return f(giver, recipient)
+}
+
+// Combine makes one rule out of several. A pair is forbidden if any
+// of rules forbids it, and costs the sum of what they charge.
+func Combine(rules ...Rule) Rule {
+	return RuleFunc(func(giver, recipient Participant) Verdict {
+		return judge(rules, giver, recipient)
+	})
+}
+
+func judge(rules []Rule, giver, recipient Participant) Verdict {
+	var v Verdict
+	for _, r := range rules {
+		got := r.Judge(giver, recipient)
+		v.Forbidden = v.Forbidden || got.Forbidden
+		v.Cost = addCost(v.Cost, got.Cost)
+	}
+
+	return v
+}
+
+// When returns a rule that gives verdict v to every pair for which
+// match is true, and allows every other pair.
+func When(match func(giver, recipient Participant) bool, v Verdict) Rule {
+	return RuleFunc(func(giver, recipient Participant) Verdict {
+		if match(giver, recipient) {
+			return v
+		}
+
+		return Allow
+	})
+}
+
+// SameValue returns a rule that gives verdict v to every pair with
+// the same value for key, ignoring case. Blank values never match.
+func SameValue(key func(Participant) string, v Verdict) Rule {
+	return When(func(giver, recipient Participant) bool {
+		k := trimLower(key(giver))
+		return k != "" && k == trimLower(key(recipient))
+	}, v)
+}
+
+// DifferentValue returns a rule that gives verdict v to every pair
+// with different values for key, ignoring case. Pairs where either
+// value is blank are allowed, since nothing is known about them.
+func DifferentValue(key func(Participant) string, v Verdict) Rule {
+	return When(func(giver, recipient Participant) bool {
+		a, b := trimLower(key(giver)), trimLower(key(recipient))
+		return a != "" && b != "" && a != b
+	}, v)
+}
+
+// LastName is everything after the last space in p's name.
+func LastName(p Participant) string {
+	name := trim(p.Name)
+	if i := strings.LastIndex(name, " "); i >= 0 {
+		return name[i+1:]
+	}
+
+	return ""
+}
+
+// EmailDomain is everything after the @ in p's email address.
+func EmailDomain(p Participant) string {
+	if i := strings.LastIndex(p.Email, "@"); i >= 0 {
+		return p.Email[i+1:]
+	}
+
+	return ""
+}
+
+// RuleFactory makes a rule from the argument and verdict of a rule
+// spec. See ParseRule.
+type RuleFactory func(arg string, v Verdict) (Rule, error)
+
+var (
+	rulesMu  sync.RWMutex
+	registry = make(map[string]RuleFactory)
+)
+
+func init() {
+	RegisterRule("same-last-name", func(arg string, v Verdict) (Rule, error) {
+		return SameValue(LastName, v), nil
+	})
+
+	RegisterRule("same-email-domain", func(arg string, v Verdict) (Rule, error) {
+		return SameValue(EmailDomain, v), nil
+	})
+
+	// Somebody who restricted the giver probably doesn't want a gift
+	// from them either, which SymmetricRestrictions forbids outright
+	RegisterRule("restricted-by-recipient", func(arg string, v Verdict) (Rule, error) {
+		return When(func(giver, recipient Participant) bool {
+			return containsPid(recipient.Restrictions, giver.ID)
+		}, v), nil
+	})
+
+	// Attributes are compared by name, e.g., "same:city" or
+	// "different:shirt size=2"
+	RegisterRule("same", func(arg string, v Verdict) (Rule, error) {
+		if arg == "" {
+			return nil, ErrNoAttribute
+		}
+
+		return SameValue(AttributeKey(arg), v), nil
+	})
+
+	RegisterRule("different", func(arg string, v Verdict) (Rule, error) {
+		if arg == "" {
+			return nil, ErrNoAttribute
+		}
+
+		return DifferentValue(AttributeKey(arg), v), nil
+	})
+}
+
+// RegisterRule makes a rule available to ParseRule by name, so it can
+// be configured from the command line or the website like the
+// built-in rules. It panics if name is blank or already registered.
+func RegisterRule(name string, newRule RuleFactory) {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+
+	name = trimLower(name)
+	if name == "" || newRule == nil {
+		panic("giftex: RegisterRule needs a name and a factory")
+	}
+
+	if _, dup := registry[name]; dup {
+		panic("giftex: RegisterRule called twice for rule " + name)
+	}
+
+	registry[name] = newRule
+}
+
+// RuleNames lists every registered rule, sorted.
+func RuleNames() []string {
+	rulesMu.RLock()
+	defer rulesMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// ParseRule makes a registered rule from a spec written as
+// "name:argument=verdict", e.g., "same-last-name=5" charges 5 for
+// giving to somebody with the same last name. The argument is
+// optional and the verdict defaults to forbid.
+func ParseRule(spec string) (Rule, error) {
+	name, verdict := trim(spec), "forbid"
+	if i := strings.LastIndex(name, "="); i >= 0 {
+		name, verdict = trim(name[:i]), name[i+1:]
+	}
+
+	var arg string
+	if i := strings.Index(name, ":"); i >= 0 {
+		name, arg = trim(name[:i]), trim(name[i+1:])
+	}
+
+	name = trimLower(name)
+	if name == "" {
+		return nil, ErrInvalidRule
+	}
+
+	v, err := ParseVerdict(verdict)
+	if err != nil {
+		return nil, err
+	}
+
+	rulesMu.RLock()
+	newRule, ok := registry[name]
+	rulesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownRule, name)
+	}
+
+	r, err := newRule(arg, v)
+	if err != nil {
+		return nil, err
+	}
+
+	spec = name
+	if arg != "" {
+		spec += ":" + arg
+	}
+
+	return namedRule{Rule: r, spec: spec + "=" + v.String()}, nil
+}
+
+// namedRule remembers the spec a rule was parsed from.
+type namedRule struct {
+	Rule
+	spec string
+}
+
+func (r namedRule) String() string {
+	return r.spec
+}
+
+// ruleName names rule r for the commitment. Rules that don't
+// implement fmt.Stringer are named by their type alone.
+func ruleName(r Rule) string {
+	if s, ok := r.(fmt.Stringer); ok {
+		return s.String()
+	}
+
+	return fmt.Sprintf("%T", r)
+}
+
+// ruleCosts adds the cost every rule in opts charges for each pair to
+// cost. Forbidden pairs already cost infCost.
+func ruleCosts(cost [][]int64, pm ParticipantMap, opts *GiftExchangeOptions) {
+	if len(opts.rules()) == 0 {
+		return
+	}
+
+	for i := range cost {
+		for j := range cost[i] {
+			if i != j && cost[i][j] != infCost {
+				cost[i][j] = addCost(cost[i][j], judge(opts.rules(), pm[Pid(i)], pm[Pid(j)]).Cost)
+			}
+		}
+	}
+}
+
+func (opts *GiftExchangeOptions) rules() []Rule {
+	if opts == nil {
+		return nil
+	}
+
+	return opts.Rules
+}
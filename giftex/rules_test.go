@@ -0,0 +1,186 @@
+package giftex
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func init() {
+	// Library users register their own rules the same way
+	RegisterRule("same-initial", func(arg string, v Verdict) (Rule, error) {
+		return SameValue(func(p Participant) string { return p.Name[:1] }, v), nil
+	})
+}
+
+func Example_rules() {
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "Ann Smith"},
+		1: {ID: 1, Name: "Bob Smith"},
+		2: {ID: 2, Name: "Cat Jones"},
+		3: {ID: 3, Name: "Dan Jones"},
+	}
+
+	sameLastName, err := ParseRule("same-last-name")
+	if err != nil {
+		panic(err)
+	}
+
+	// Ann never gives to Cat
+	notCat := When(func(giver, recipient Participant) bool {
+		return giver.Name == "Ann Smith" && recipient.Name == "Cat Jones"
+	}, Forbid)
+
+	ge, err := NewGiftExchange(pm, &GiftExchangeOptions{Rules: []Rule{sameLastName, notCat}})
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("Ann Smith ->", pm[ge.Assignment[0]].Name)
+
+	// Output:
+	// Ann Smith -> Dan Jones
+}
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+		err  error
+	}{
+		{spec: "same-last-name", want: "same-last-name=forbid"},
+		{spec: " Same-Email-Domain = 5 ", want: "same-email-domain=5"},
+		{spec: "same-initial=-3", want: "same-initial=-3"},
+		{spec: "same-last-name=allow", want: "same-last-name=allow"},
+		{spec: "restricted-by-recipient:ignored=no", want: "restricted-by-recipient:ignored=forbid"},
+		{spec: "same:Shirt Size=2", want: "same:Shirt Size=2"},
+		{spec: "different : city", want: "different:city=forbid"},
+		{spec: "same", err: ErrNoAttribute},
+		{spec: "same-city", err: ErrUnknownRule},
+		{spec: "same-last-name=maybe", err: ErrInvalidRule},
+		{spec: "=5", err: ErrInvalidRule},
+	}
+
+	for _, tt := range tests {
+		r, err := ParseRule(tt.spec)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%q: want: %v; got: %v", tt.spec, tt.err, err)
+			continue
+		}
+
+		if err == nil && fmt.Sprint(r) != tt.want {
+			t.Errorf("%q: want: %s; got: %v", tt.spec, tt.want, r)
+		}
+	}
+
+	names := strings.Join(RuleNames(), " ")
+	if want := "different restricted-by-recipient same same-email-domain same-initial same-last-name"; names != want {
+		t.Errorf("want: %s; got: %s", want, names)
+	}
+}
+
+func TestCombine(t *testing.T) {
+	a := Participant{ID: 0, Name: "Ann Smith", Email: "ann@smith.example"}
+	b := Participant{ID: 1, Name: "Bob Smith", Email: "bob@SMITH.example", Restrictions: []Pid{0}}
+	c := Participant{ID: 2, Name: "Cat", Email: "cat@example.com"}
+
+	rule := Combine(
+		SameValue(LastName, Cost(3)),
+		SameValue(EmailDomain, Cost(4)),
+		When(func(giver, recipient Participant) bool { return containsPid(recipient.Restrictions, giver.ID) }, Forbid),
+	)
+
+	tests := []struct {
+		giver, recipient Participant
+		want             Verdict
+	}{
+		{giver: a, recipient: b, want: Verdict{Forbidden: true, Cost: 7}},
+		{giver: b, recipient: a, want: Cost(7)},
+		{giver: a, recipient: c, want: Allow},
+		{giver: c, recipient: a, want: Allow}, // Cat has no last name
+	}
+
+	for _, tt := range tests {
+		if got := rule.Judge(tt.giver, tt.recipient); got != tt.want {
+			t.Errorf("%s -> %s: want: %+v; got: %+v", tt.giver.Name, tt.recipient.Name, tt.want, got)
+		}
+	}
+}
+
+func TestNewGiftExchange_rules(t *testing.T) {
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "Ann Smith"},
+		1: {ID: 1, Name: "Bob Smith"},
+		2: {ID: 2, Name: "Cat Jones"},
+		3: {ID: 3, Name: "Dan Jones"},
+		4: {ID: 4, Name: "Eve Brown"},
+		5: {ID: 5, Name: "Fay Brown"},
+	}
+
+	forbid, _ := ParseRule("same-last-name")
+	for try := 0; try < 50; try++ {
+		opts := &GiftExchangeOptions{Rules: []Rule{forbid}, Seed: int64(try + 1)}
+		ge, err := NewGiftExchange(pm, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for giver, recipient := range ge.Assignment {
+			if LastName(pm[giver]) == LastName(pm[recipient]) {
+				t.Fatalf("%s can't give to %s", pm[giver].Name, pm[recipient].Name)
+			}
+		}
+
+		if err := Verify(pm, opts, ge.Commitment, ge); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if Commit(pm, &GiftExchangeOptions{Seed: 1}) == Commit(pm, &GiftExchangeOptions{Seed: 1, Rules: []Rule{forbid}}) {
+		t.Error("rules didn't change the commitment")
+	}
+
+	// A cost steers everybody away from their own family when it can
+	cost, _ := ParseRule("same-last-name=10")
+	ge, err := NewGiftExchange(pm, &GiftExchangeOptions{Rules: []Rule{cost}, Costs: &CostOptions{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ge.Cost != 0 {
+		t.Errorf("want a cost of 0; got: %d for %v", ge.Cost, ge.Assignment)
+	}
+
+	// Pins take priority over rules
+	pm[0] = Participant{ID: 0, Name: "Ann Smith", Pinned: []Pid{1}}
+	ge, err = NewGiftExchange(pm, &GiftExchangeOptions{Rules: []Rule{forbid}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ge.Assignment[0] != 1 {
+		t.Errorf("Ann should have Bob; got: %s", pm[ge.Assignment[0]].Name)
+	}
+
+	// Forbidden pairs aren't suggested as restrictions to drop
+	pm[0] = Participant{ID: 0, Name: "Ann Smith", Restrictions: []Pid{2, 3, 4, 5}}
+	opts := &GiftExchangeOptions{Rules: []Rule{forbid}}
+	suggestions := SuggestRelaxations(pm, opts)
+	if len(suggestions) == 0 {
+		t.Fatal("want suggestions")
+	}
+
+	for _, r := range suggestions {
+		for _, p := range r.Restrictions {
+			if p.Giver == 0 && p.Recipient == 1 {
+				t.Errorf("suggested breaking a rule: %s", r.Describe(pm))
+			}
+		}
+
+		newPM, newOpts := r.Apply(pm, opts)
+		if _, err := NewGiftExchange(newPM, newOpts); err != nil {
+			t.Errorf("%s: %v", r.Describe(pm), err)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package giftex
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+var (
+	ErrNoRounds    = errors.New("Error: a schedule needs at least one round")
+	ErrRoundLabels = errors.New("Error: a schedule needs one label for every round")
+)
+
+// Schedule plans several assignments in a row for the same group,
+// either one per year or several rounds within one event. Nobody
+// gives to the same person twice over the whole schedule.
+type Schedule struct {
+	// Rounds holds one assignment for every year or round, in order
+	Rounds []Assignment
+
+	participants ParticipantMap
+}
+
+// NewSchedule plans rounds assignments for the participants in pm.
+// Every constraint applies to every round, so restrictions are always
+// respected and matches from Previous or History are never repeated.
+//
+// Planning a schedule is the same as drawing a gift exchange where
+// everybody gives one gift per round, so every round is drawn at
+// random and falls back to splitting up a k-factor when drawing the
+// rounds one after another gets stuck. Costs and cycle options can't
+// be used with more than one round, and neither can GiftsPerPerson or
+// participants that only give or only receive.
+func NewSchedule(pm ParticipantMap, rounds int, opts *GiftExchangeOptions) (*Schedule, error) {
+	if rounds < 1 {
+		return nil, ErrNoRounds
+	}
+
+	if opts.giftsPerPerson() > 1 || hasRoles(pm) {
+		return nil, ErrConflictingOptions
+	}
+
+	s, err := constraintGraph(pm, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	newOpts := &GiftExchangeOptions{}
+	if opts != nil {
+		*newOpts = *opts
+	}
+	newOpts.GiftsPerPerson = rounds
+	if err := checkMatrixSize(len(pm), matrixOption(pm, newOpts)); err != nil {
+		return nil, err
+	}
+
+	all, _, err := draw(s, pm, newOpts, opts.random())
+	if rounds > 1 && errors.Is(err, ErrNoSolution) {
+		return nil, fmt.Errorf("%w over %d rounds (at most %d are possible)", ErrNoSolution, rounds, s.dense().maxRounds())
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !verifyMultiAssignment(mergeRounds(all), s, rounds) {
+		return nil, ErrNoSolution
+	}
+
+	return &Schedule{Rounds: all, participants: pm}, nil
+}
+
+// MaxRounds returns the most rounds that can be planned for the
+// participants in pm without anybody giving to the same person twice.
+func MaxRounds(pm ParticipantMap, opts *GiftExchangeOptions) (int, error) {
+	if err := checkMatrixSize(len(pm), "counting rounds"); err != nil {
+		return 0, err
+	}
+
+	m, err := constraintMatrix(pm, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	return m.maxRounds(), nil
+}
+
+// maxRounds finds the largest k for which the graph of matrix m has a
+// k-factor. Taking a perfect matching out of a k-factor leaves a
+// (k-1)-factor, so we can binary search for it. Nobody can give more
+// gifts than the number of people they're allowed to give to.
+func (m matrix) maxRounds() int {
+	g := m.bipartite()
+	if len(g) == 0 {
+		return 0
+	}
+
+	hi := len(g)
+	for _, edges := range g {
+		if len(edges) < hi {
+			hi = len(edges)
+		}
+	}
+
+	r := rand.New(rand.NewSource(1))
+	lo := 0
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if _, ok := g.kFactor(mid, r); ok {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	return lo
+}
+
+// History returns the schedule as dated records, one year per round
+// starting with firstYear, so it can be used by later gift exchanges.
+func (s *Schedule) History(firstYear int) History {
+	var h History
+	for i, a := range s.Rounds {
+		h = h.Add(firstYear+i, s.participants, a)
+	}
+
+	return h
+}
+
+// WriteCSV writes the whole schedule as one CSV with a row for every
+// giver and a column for every round. Labels name the columns, e.g.,
+// years, and default to "round 1", "round 2", and so on.
+func (s *Schedule) WriteCSV(w io.Writer, labels []string) error {
+	if labels == nil {
+		for i := range s.Rounds {
+			labels = append(labels, "round "+strconv.Itoa(i+1))
+		}
+	}
+
+	if len(labels) != len(s.Rounds) {
+		return ErrRoundLabels
+	}
+
+	b := csv.NewWriter(w)
+	b.Write(append([]string{"name"}, labels...))
+	for _, row := range s.rows() {
+		b.Write(row)
+	}
+
+	b.Flush()
+	return b.Error()
+}
+
+func (s *Schedule) String() string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 1, 1, 1, ' ', 0)
+
+	fmt.Fprintln(w, "Schedule:")
+	for _, row := range s.rows() {
+		fmt.Fprintf(w, "| %s\t |", row[0])
+		for _, name := range row[1:] {
+			fmt.Fprintf(w, " %s\t |", name)
+		}
+		fmt.Fprintln(w)
+	}
+
+	w.Flush()
+	return b.String()
+}
+
+// rows lists every giver's name followed by who they have in each
+// round, sorted by name.
+func (s *Schedule) rows() [][]string {
+	rows := make([][]string, 0, len(s.participants))
+	for _, id := range drawOrder(s.participants) {
+		row := []string{s.participants[id].Name}
+		for _, a := range s.Rounds {
+			row = append(row, s.participants[a[id]].Name)
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows
+}
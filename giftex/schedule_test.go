@@ -0,0 +1,111 @@
+package giftex
+
+import (
+	"errors"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func Example_schedule() {
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo", Group: "Smith"},
+		1: {ID: 1, Name: "bar", Group: "Smith"},
+		2: {ID: 2, Name: "baz"},
+		3: {ID: 3, Name: "qux"},
+	}
+
+	// foo and bar are in the same household, so they can only ever
+	// give to baz and qux
+	max, err := MaxRounds(pm, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	s, err := NewSchedule(pm, max, &GiftExchangeOptions{Seed: 3})
+	if err != nil {
+		panic(err)
+	}
+
+	s.WriteCSV(os.Stdout, []string{"2025", "2026"})
+
+	// Output:
+	// name,2025,2026
+	// bar,baz,qux
+	// baz,bar,foo
+	// foo,qux,baz
+	// qux,foo,bar
+}
+
+func TestMatrix_maxRounds_bruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(13))
+
+	for try := 0; try < 200; try++ {
+		n := 1 + r.Intn(5)
+		m := randomMatrix(r, n, r.Float64()*0.5)
+		all := bruteForce(m)
+
+		want := 0
+		for want < n && disjointMatchings(all, want+1) {
+			want++
+		}
+
+		if got := m.maxRounds(); want != got {
+			t.Fatalf("want: %d; got: %d\n%s", want, got, m)
+		}
+	}
+}
+
+func TestNewSchedule(t *testing.T) {
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo", Restrictions: []Pid{1}},
+		1: {ID: 1, Name: "bar"},
+		2: {ID: 2, Name: "baz"},
+		3: {ID: 3, Name: "qux"},
+		4: {ID: 4, Name: "quux", Previous: []Pid{0}},
+	}
+
+	max, err := MaxRounds(pm, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if max != 3 {
+		t.Fatalf("want 3 rounds; got: %d", max)
+	}
+
+	for rounds := 1; rounds <= max; rounds++ {
+		s, err := NewSchedule(pm, rounds, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(s.Rounds) != rounds {
+			t.Fatalf("want %d rounds; got: %d", rounds, len(s.Rounds))
+		}
+
+		seen := make(map[Pair]bool)
+		for _, a := range s.Rounds {
+			for giver, recipient := range a {
+				p := Pair{Giver: giver, Recipient: recipient}
+				if seen[p] || giver == recipient || (giver == 0 && recipient == 1) || (giver == 4 && recipient == 0) {
+					t.Fatalf("invalid pair %v in schedule:\n%s", p, s)
+				}
+
+				seen[p] = true
+			}
+		}
+	}
+
+	if _, err := NewSchedule(pm, max+1, nil); !errors.Is(err, ErrNoSolution) {
+		t.Errorf("want: %v; got: %v", ErrNoSolution, err)
+	}
+
+	if _, err := NewSchedule(pm, 0, nil); !errors.Is(err, ErrNoRounds) {
+		t.Errorf("want: %v; got: %v", ErrNoRounds, err)
+	}
+
+	if _, err := NewSchedule(pm, 2, &GiftExchangeOptions{SingleCycle: true}); !errors.Is(err, ErrConflictingOptions) {
+		t.Errorf("want: %v; got: %v", ErrConflictingOptions, err)
+	}
+}
@@ -0,0 +1,369 @@
+package giftex
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	ErrUnknownColumn = errors.New("Error: giftex doesn't read a column with this name")
+	ErrInvalidSchema = errors.New(`Error: schema lines look like "name: Full Name", "required: name, email", "delimiter: ;", or "truthy: yes, x"`)
+)
+
+// columns lists every column ReadCSV reads itself. Any other column is
+// kept as an attribute of each participant.
+var columns = map[string]bool{
+	"name": true, "email": true, "sms": true,
+	"restrictions": true, "previous": true, "participating": true, "has": true,
+	"wishes": true, "role": true, "commitment": true,
+	"group": true, "gives to": true, "pinned": true, "allowed": true,
+}
+
+// builtinAliases are the other headers every Schema accepts for a
+// column.
+var builtinAliases = map[string]string{
+	"household":       "group",
+	"department":      "group",
+	"team":            "group",
+	"gives_to":        "gives to",
+	"always gives to": "pinned",
+	"only gives to":   "allowed",
+}
+
+// Schema describes the layout of a CSV, so files exported from other
+// programs can be read without editing them first. The zero Schema
+// describes the CSVs written by WriteCSV.
+type Schema struct {
+	// Aliases lists other headers for the columns ReadCSV reads, e.g.,
+	// "name": {"Full Name"}. Headers are matched ignoring case. The
+	// usual name of a column takes priority over its aliases, and
+	// otherwise the first matching column wins.
+	Aliases map[string][]string
+
+	// Required lists the columns that have to be in the CSV. When it's
+	// nil, name, email, restrictions, previous, participating, and has
+	// are required. Name is always required. Without a participating
+	// column everybody participates, and WriteCSV adds previous and has
+	// columns when they're missing.
+	Required []string
+
+	// Comma is the delimiter between fields, ',' by default.
+	Comma rune
+
+	// Truthy lists the values of the participating column that mean a
+	// participant is taking part, ignoring case. Only "yes" counts by
+	// default.
+	Truthy []string
+}
+
+// ParseSchema reads a schema written one setting per line, e.g.,
+//
+//   name: Full Name
+//   email: E-mail, Email Address
+//   participating: Opted In
+//   required: name, email
+//   delimiter: ;
+//   truthy: yes, y, true, x
+//
+// A column followed by a list of headers adds aliases for it. The
+// delimiter may also be written as "tab". Blank lines and lines
+// starting with # are ignored.
+func ParseSchema(text string) (Schema, error) {
+	var s Schema
+	for _, line := range strings.Split(text, "\n") {
+		line = trim(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		i := strings.Index(line, ":")
+		if i < 0 {
+			return Schema{}, fmt.Errorf("%w: %q", ErrInvalidSchema, line)
+		}
+
+		key, value := trimLower(line[:i]), trim(line[i+1:])
+		switch key {
+		case "required":
+			s.Required = splitList(value)
+		case "truthy":
+			s.Truthy = splitList(value)
+		case "delimiter":
+			comma, err := parseDelimiter(value)
+			if err != nil {
+				return Schema{}, err
+			}
+			s.Comma = comma
+		default:
+			if !columns[key] {
+				return Schema{}, fmt.Errorf("%w: %q", ErrUnknownColumn, key)
+			}
+
+			if s.Aliases == nil {
+				s.Aliases = make(map[string][]string)
+			}
+			s.Aliases[key] = append(s.Aliases[key], splitList(value)...)
+		}
+	}
+
+	return s, s.validate()
+}
+
+// ReadSchemaFromFile reads a schema written like ParseSchema expects.
+func ReadSchemaFromFile(path string) (Schema, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Schema{}, fmt.Errorf("Error reading schema: %w", err)
+	}
+
+	return ParseSchema(string(b))
+}
+
+func parseDelimiter(value string) (rune, error) {
+	switch trimLower(value) {
+	case "tab", `\t`:
+		return '\t', nil
+	case "comma":
+		return ',', nil
+	case "semicolon":
+		return ';', nil
+	}
+
+	if utf8.RuneCountInString(value) != 1 {
+		return 0, fmt.Errorf("%w: delimiter %q", ErrInvalidSchema, value)
+	}
+
+	r, _ := utf8.DecodeRuneInString(value)
+	return r, nil
+}
+
+// splitList splits a comma separated list, dropping blank entries.
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = trim(v); v != "" {
+			list = append(list, v)
+		}
+	}
+
+	return list
+}
+
+// String writes s the way ParseSchema reads it.
+func (s Schema) String() string {
+	var lines []string
+	for _, col := range sortedKeys(s.Aliases) {
+		lines = append(lines, col+": "+strings.Join(s.Aliases[col], ", "))
+	}
+
+	if s.Required != nil {
+		lines = append(lines, "required: "+strings.Join(s.Required, ", "))
+	}
+
+	switch s.Comma {
+	case 0, ',':
+	case '\t':
+		lines = append(lines, "delimiter: tab")
+	default:
+		lines = append(lines, "delimiter: "+string(s.Comma))
+	}
+
+	if len(s.Truthy) > 0 {
+		lines = append(lines, "truthy: "+strings.Join(s.Truthy, ", "))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// validate checks that s only mentions columns ReadCSV reads.
+func (s Schema) validate() error {
+	for col := range s.Aliases {
+		if !columns[trimLower(col)] {
+			return fmt.Errorf("%w: %q", ErrUnknownColumn, col)
+		}
+	}
+
+	for _, col := range s.Required {
+		if !columns[trimLower(col)] {
+			return fmt.Errorf("%w: %q", ErrUnknownColumn, col)
+		}
+	}
+
+	return nil
+}
+
+// Column returns the column ReadCSV reads from header, e.g., "group"
+// for "Household", or false when header is an attribute.
+func (s Schema) Column(header string) (string, bool) {
+	h := trimLower(header)
+	if columns[h] {
+		return h, true
+	}
+
+	if col, ok := builtinAliases[h]; ok {
+		return col, true
+	}
+
+	for col, aliases := range s.Aliases {
+		for _, a := range aliases {
+			if trimLower(a) == h {
+				return trimLower(col), true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// Columns maps every column ReadCSV reads to its position in headers,
+// e.g., "group" to the position of "Household".
+func (s Schema) Columns(headers []string) map[string]int {
+	cols := make(map[string]int, len(headers))
+	for i, header := range headers {
+		col, ok := s.Column(header)
+		if !ok {
+			continue
+		}
+
+		// The usual name of a column beats its aliases
+		if j, seen := cols[col]; seen && (trimLower(headers[j]) == col || trimLower(header) != col) {
+			continue
+		}
+
+		cols[col] = i
+	}
+
+	return cols
+}
+
+// required lists the columns that have to be in the CSV.
+func (s Schema) required() []string {
+	if s.Required == nil {
+		return requiredColumns
+	}
+
+	list := []string{"name"}
+	for _, col := range s.Required {
+		if col = trimLower(col); col != "name" {
+			list = append(list, col)
+		}
+	}
+
+	return list
+}
+
+// comma returns the delimiter between fields.
+func (s Schema) comma() rune {
+	if s.Comma == 0 {
+		return ','
+	}
+
+	return s.Comma
+}
+
+// Participating reports whether value, read from the participating
+// column, means a participant is taking part.
+func (s Schema) Participating(value string) bool {
+	value = trimLower(value)
+	if len(s.Truthy) == 0 {
+		return value == "yes"
+	}
+
+	for _, t := range s.Truthy {
+		if trimLower(t) == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ReadCSVFromFile reads the CSV at path laid out as described by s.
+func (s Schema) ReadCSVFromFile(path string) (*GiftExchangeDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading csv: %w", err)
+	}
+	defer f.Close()
+
+	return s.ReadCSV(f)
+}
+
+// ReadCSV works like the ReadCSV function, but reads a CSV laid out
+// as described by s. WriteCSV keeps the headers and delimiter of the
+// original CSV.
+func (s Schema) ReadCSV(r io.Reader) (*GiftExchangeDB, error) {
+	return s.Read(r, CSVCodec{})
+}
+
+// ReadFile reads the file at path in the format DetectFormat guesses
+// from its name or contents, laid out as described by s.
+func (s Schema) ReadFile(path string) (*GiftExchangeDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading file: %w", err)
+	}
+	defer f.Close()
+
+	// Only the start of the file is needed to guess its format
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, fmt.Errorf("Error reading file: %w", err)
+	}
+
+	r := io.MultiReader(bytes.NewReader(head[:n]), f)
+	return s.Read(r, DetectFormat(path, head[:n]).Codec)
+}
+
+// Read works like ReadCSV, but reads a file with codec, e.g.,
+// XLSX.Codec.
+func (s Schema) Read(r io.Reader, codec Codec) (*GiftExchangeDB, error) {
+	return Import(r, &ImportOptions{Schema: s, Codec: codec})
+}
+
+// Decode reads the table in a file with codec. CSVs are separated by
+// the delimiter of s unless codec has its own.
+func (s Schema) Decode(r io.Reader, codec Codec) ([][]string, error) {
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+
+	return s.codec(codec).Decode(r)
+}
+
+// ReadRecords works like ReadCSV, but reads a table that was already
+// decoded, with the headers in the first record. The GiftExchangeDB
+// keeps records, so they shouldn't be changed afterwards.
+func (s Schema) ReadRecords(records [][]string) (*GiftExchangeDB, error) {
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+
+	opts := &ImportOptions{Schema: s}
+	return opts.read(&sliceReader{records: records}, &countingReader{})
+}
+
+// codec gives CSVCodecs without a delimiter the delimiter of s.
+func (s Schema) codec(c Codec) Codec {
+	if csvCodec, ok := c.(CSVCodec); ok && csvCodec.Comma == 0 {
+		return CSVCodec{Comma: s.comma()}
+	}
+
+	return c
+}
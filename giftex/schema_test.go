@@ -0,0 +1,106 @@
+package giftex
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Example_schema() {
+	schema, err := ParseSchema(`
+name: Full Name
+email: E-mail
+participating: Opted In
+restrictions: Do Not Match
+required: name, email
+delimiter: ;
+truthy: y, true, x
+`)
+	if err != nil {
+		panic(err)
+	}
+
+	db, err := schema.ReadCSVFromFile("testdata/hr.csv")
+	if err != nil {
+		panic(err)
+	}
+
+	// Previous and has columns are added to the results
+	if err := db.WriteCSV(os.Stdout, Assignment{0: 2, 1: 3, 2: 0, 3: 1}); err != nil {
+		panic(err)
+	}
+
+	// Output:
+	// Full Name;E-mail;Department;Opted In;Do Not Match;previous;has
+	// Ann Lee;ann@example.com;Sales;x;;Dan Fox;Dan Fox
+	// Bob Ray;bob@example.com;Sales;y;;Eve Orr;Eve Orr
+	// Cat Kim;cat@example.com;Support;;;;
+	// Dan Fox;dan@example.com;Support;TRUE;Eve Orr;Ann Lee;Ann Lee
+	// Eve Orr;eve@example.com;Legal;true;;Bob Ray;Bob Ray
+}
+
+func TestParseSchema(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+		err  error
+	}{
+		{text: "", want: ""},
+		{text: "# HR export\nName: Full Name, Employee\n\ndelimiter: tab", want: "name: Full Name, Employee\ndelimiter: tab"},
+		{text: "required: name, email\ntruthy: Y, x", want: "required: name, email\ntruthy: Y, x"},
+		{text: "delimiter: |", want: "delimiter: |"},
+		{text: "delimiter: ,;", err: ErrInvalidSchema},
+		{text: "Full Name", err: ErrInvalidSchema},
+		{text: "shirt size: Size", err: ErrUnknownColumn},
+		{text: "required: name, shirt size", err: ErrUnknownColumn},
+	}
+
+	for _, tt := range tests {
+		s, err := ParseSchema(tt.text)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%q: want: %v; got: %v", tt.text, tt.err, err)
+			continue
+		}
+
+		if err == nil && s.String() != tt.want {
+			t.Errorf("%q: want: %q; got: %q", tt.text, tt.want, s.String())
+		}
+	}
+}
+
+func TestSchema_ReadCSV(t *testing.T) {
+	schema := Schema{Aliases: map[string][]string{"name": {"Full Name"}}, Required: []string{}}
+
+	// The usual name of a column beats its aliases, and otherwise the
+	// first matching column wins
+	db, err := schema.ReadCSV(strings.NewReader("Household,Full Name,Group,Team,Name\nSmith,Ann,Blue,Red,Annie\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p := db.Participants[0]; p.Name != "Annie" || p.Group != "Blue" || p.Attributes != nil {
+		t.Errorf("wrong participant: %+v", p)
+	}
+
+	// Name is always required
+	_, err = schema.ReadCSV(strings.NewReader("email\nann@example.com\n"))
+	if !errors.Is(err, ErrInvalidCSV) || !strings.Contains(err.Error(), `"name"`) {
+		t.Errorf("want missing name column; got: %v", err)
+	}
+
+	// Only yes counts by default
+	db, err = ReadCSV(strings.NewReader("name,email,restrictions,previous,participating,has\nAnn,,,,Yes,\nBob,,,,y,\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := fmt.Sprint(pidNames(db.Participants, drawOrder(db.Participants))); got != "[Ann]" {
+		t.Errorf("want: [Ann]; got: %s", got)
+	}
+
+	if _, err := (Schema{Aliases: map[string][]string{"size": {"Shirt"}}}).ReadCSV(strings.NewReader("name\nAnn\n")); !errors.Is(err, ErrUnknownColumn) {
+		t.Errorf("want: %v; got: %v", ErrUnknownColumn, err)
+	}
+}
@@ -0,0 +1,587 @@
+package giftex
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// A sparse matrix holds the same constraints as a matrix without
+// listing every pair. Rows list the restrictions, previous matches,
+// and rules of each giver, sorted, leaving out the diagonal. Groups,
+// group targets, pins, and allow-lists are looked up for each pair
+// instead, since a big group would otherwise rule out every pair of
+// its members. Most participants only have a few restrictions, so it
+// takes O(n + c) memory for c listed constraints instead of O(n²),
+// which lets a gift exchange grow to many thousands of people.
+type sparse struct {
+	rows [][]int
+
+	// group is the group of each participant, or noGroup, and target
+	// is the group they have to give to, or noGroup. Both are nil
+	// when nobody is in a group.
+	group, target []int
+	groups        int
+
+	// only lists the sorted recipients a pinned giver or a giver
+	// with an allow-list can give to, and pinned lists the recipients
+	// a giver can have even in their own group. Both are nil when
+	// nobody is pinned or limited.
+	only, pinned [][]int
+}
+
+// noGroup stands in for the group of somebody who isn't in one.
+const noGroup = -1
+
+// newSparse creates a sparse matrix of dimension n with constraints c.
+// Like AddConstraints, it will panic if c contains entries outside of
+// the matrix.
+func newSparse(n int, c constraints) sparse {
+	s := sparse{rows: make([][]int, n)}
+	for i := range s.rows {
+		exclusions := c[Pid(i)]
+		if len(exclusions) == 0 {
+			continue
+		}
+
+		row := make([]int, 0, len(exclusions))
+		for _, p := range exclusions {
+			if int(p) < 0 || int(p) >= n {
+				panic("giftex: constraint outside of the matrix")
+			}
+
+			if int(p) != i {
+				row = append(row, int(p))
+			}
+		}
+
+		s.rows[i] = sortedSet(row)
+	}
+
+	return s
+}
+
+// addGroups fills in the groups, group targets, pins, and allow-lists
+// of the participants in pm. Pins take priority over groups, but not
+// over anybody's restrictions or allow-list.
+func (s *sparse) addGroups(pm ParticipantMap, opts *GiftExchangeOptions) {
+	n := len(s.rows)
+	targets := groupTargets(pm, opts)
+	index := make(map[string]int)
+	groupOf := func(name string) int {
+		if name == "" {
+			return noGroup
+		}
+
+		k, ok := index[name]
+		if !ok {
+			k = len(index)
+			index[name] = k
+		}
+
+		return k
+	}
+
+	inRange := func(ids []Pid) []int {
+		list := make([]int, 0, len(ids))
+		for _, id := range ids {
+			if int(id) >= 0 && int(id) < n {
+				list = append(list, int(id))
+			}
+		}
+
+		return sortedSet(list)
+	}
+
+	// Go through everybody in order so the groups are always numbered
+	// the same way
+	for i := 0; i < n; i++ {
+		p := pm[Pid(i)]
+		to, hasTarget := targets[Pid(i)]
+		if trimLower(p.Group) != "" || hasTarget {
+			if s.group == nil {
+				s.group, s.target = make([]int, n), make([]int, n)
+				for k := range s.group {
+					s.group[k], s.target[k] = noGroup, noGroup
+				}
+			}
+
+			s.group[i] = groupOf(trimLower(p.Group))
+			if hasTarget {
+				s.target[i] = groupOf(to)
+			}
+		}
+
+		if !hasLimits(p) {
+			continue
+		}
+
+		if s.only == nil {
+			s.only, s.pinned = make([][]int, n), make([][]int, n)
+		}
+
+		if len(p.Pinned) > 0 {
+			s.only[i] = inRange(p.Pinned)
+		} else {
+			s.only[i] = inRange(p.Allowed)
+		}
+	}
+	s.groups = len(index)
+
+	// Pinned pairs are allowed even if they're in the same group, and
+	// when everybody swaps gifts, so are the pairs pinned the other way
+	for i := 0; i < n; i++ {
+		for _, j := range pm[Pid(i)].Pinned {
+			if int(j) < 0 || int(j) >= n || int(j) == i {
+				continue
+			}
+
+			s.pinned[i] = append(s.pinned[i], int(j))
+			if opts.swap() {
+				s.pinned[j] = append(s.pinned[j], i)
+			}
+		}
+	}
+
+	for i := range s.pinned {
+		if s.pinned[i] != nil {
+			s.pinned[i] = sortedSet(s.pinned[i])
+		}
+	}
+}
+
+// sortedSet sorts list and removes any duplicates.
+func sortedSet(list []int) []int {
+	sort.Ints(list)
+
+	set := list[:0]
+	for k, x := range list {
+		if k == 0 || x != list[k-1] {
+			set = append(set, x)
+		}
+	}
+
+	return set
+}
+
+// inSorted reports whether x is in the sorted list.
+func inSorted(list []int, x int) bool {
+	k := sort.SearchInts(list, x)
+	return k < len(list) && list[k] == x
+}
+
+// allows reports whether giver i can give to recipient j.
+func (s sparse) allows(i, j int) bool {
+	switch {
+	case i == j, inSorted(s.rows[i], j):
+		return false
+	case s.only != nil && s.only[i] != nil && !inSorted(s.only[i], j):
+		return false
+	case s.pinned != nil && inSorted(s.pinned[i], j):
+		return true
+	}
+
+	return s.groupAllows(i, j)
+}
+
+// allowsPair works like allows for a giver and recipient who might
+// not be in s at all.
+func (s sparse) allowsPair(giver, recipient Pid) bool {
+	n := Pid(len(s.rows))
+	return giver >= 0 && giver < n && recipient >= 0 && recipient < n && s.allows(int(giver), int(recipient))
+}
+
+// groupAllows reports whether the groups of giver i and recipient j
+// let them be matched. Nobody can give to somebody in their own group,
+// or to anybody outside the group they have to give to.
+func (s sparse) groupAllows(i, j int) bool {
+	if s.group == nil {
+		return true
+	}
+
+	if g := s.group[i]; g != noGroup && g == s.group[j] {
+		return false
+	}
+
+	return s.target[i] == noGroup || s.target[i] == s.group[j]
+}
+
+// dense converts s into a matrix, for the options that need to look at
+// every pair.
+func (s sparse) dense() matrix {
+	n := len(s.rows)
+	m := newMatrix(n)
+	for i := range m {
+		for j := range m[i] {
+			if !s.allows(i, j) {
+				m[i][j] = 1
+			}
+		}
+	}
+
+	return m
+}
+
+// permute moves participant order[i] to row and column i of s. Groups
+// are numbered again in the new order, so s is drawn from the same way
+// no matter how the participants were numbered.
+func (s sparse) permute(order []Pid) sparse {
+	index := make([]int, len(order))
+	for i, id := range order {
+		index[id] = i
+	}
+
+	relabel := func(list []int) []int {
+		if list == nil {
+			return nil
+		}
+
+		row := make([]int, len(list))
+		for k, j := range list {
+			row[k] = index[j]
+		}
+
+		sort.Ints(row)
+		return row
+	}
+
+	p := sparse{rows: make([][]int, len(s.rows)), groups: s.groups}
+	for i, id := range order {
+		if len(s.rows[id]) > 0 {
+			p.rows[i] = relabel(s.rows[id])
+		}
+	}
+
+	if s.group != nil {
+		renumber := make(map[int]int, s.groups)
+		groupOf := func(g int) int {
+			if g == noGroup {
+				return noGroup
+			}
+
+			k, ok := renumber[g]
+			if !ok {
+				k = len(renumber)
+				renumber[g] = k
+			}
+
+			return k
+		}
+
+		p.group, p.target = make([]int, len(order)), make([]int, len(order))
+		for i, id := range order {
+			p.group[i] = groupOf(s.group[id])
+		}
+
+		// Groups only somebody gives to come after everybody's own
+		for i, id := range order {
+			p.target[i] = groupOf(s.target[id])
+		}
+	}
+
+	if s.only != nil {
+		p.only, p.pinned = make([][]int, len(order)), make([][]int, len(order))
+		for i, id := range order {
+			p.only[i] = relabel(s.only[id])
+			p.pinned[i] = relabel(s.pinned[id])
+		}
+	}
+
+	return p
+}
+
+// CheckConstraints determines whether an assignment exists that can
+// satisfy all constraints, like matrix.CheckConstraints.
+func (s sparse) CheckConstraints() bool {
+	_, _, size := s.maxMatching()
+	return size == len(s.rows)
+}
+
+// maxMatching finds a maximum matching between givers and recipients
+// without ever listing the pairs that are allowed, since nearly all of
+// them are. Everybody first takes the first free recipient they're
+// allowed to give to, and then anybody left over looks for an
+// augmenting path one at a time.
+//
+// Free recipients are kept in a pool by group, so a giver skips their
+// own group at once, and any other recipient they skip is forbidden to
+// them. The greedy pass takes O(n + c) time, as does each search, and
+// it's rare for more than a handful of givers to be left over.
+func (s sparse) maxMatching() (matchG, matchR []int, size int) {
+	n := len(s.rows)
+	matchG = make([]int, n)
+	matchR = make([]int, n)
+	for i := range matchG {
+		matchG[i] = unmatched
+		matchR[i] = unmatched
+	}
+
+	free := s.newPool()
+	var left []int
+	for i := 0; i < n; i++ {
+		j := unmatched
+		free.each(s, i, func(k int) bool {
+			if !s.allows(i, k) {
+				return true
+			}
+
+			j = k
+			return false
+		})
+
+		if j == unmatched {
+			left = append(left, i)
+			continue
+		}
+
+		free.take(j)
+		matchG[i], matchR[j] = j, i
+		size++
+	}
+
+	parent := make([]int, n)
+	for _, root := range left {
+		j, ok := s.augmentingPath(root, matchR, parent)
+		if !ok {
+			// Nobody who fails to find a path now ever will later
+			continue
+		}
+
+		// Flip the matching along the path back to root
+		for {
+			i := parent[j]
+			next := matchG[i]
+			matchG[i], matchR[j] = j, i
+			if i == root {
+				break
+			}
+
+			j = next
+		}
+
+		size++
+	}
+
+	return matchG, matchR, size
+}
+
+// augmentingPath searches breadth first for a free recipient that
+// giver root can reach by an alternating path, and returns it. parent
+// records the giver each recipient on the path was reached from.
+func (s sparse) augmentingPath(root int, matchR, parent []int) (int, bool) {
+	found := unmatched
+	s.alternate(root, true, matchR, func(i, j int) bool {
+		parent[j] = i
+		if matchR[j] == unmatched {
+			found = j
+			return false
+		}
+
+		return true
+	})
+
+	return found, found != unmatched
+}
+
+// alternate walks from root along the allowed pairs, from a to every
+// b that hasn't been reached yet, and then back along match, calling
+// visit for each pair until it returns false. It walks from givers to
+// recipients when forward is set, and from recipients to givers
+// otherwise. Each step looks at the participants who haven't been
+// reached yet outside of a giver's own group, and skipping one means
+// a constraint rules it out, so the walk takes O(n + c) time.
+func (s sparse) alternate(root int, forward bool, match []int, visit func(a, b int) bool) {
+	allowed := s.allows
+	giver := func(a int) int { return a }
+	if !forward {
+		allowed = func(a, b int) bool { return s.allows(b, a) }
+		giver = func(int) int { return unmatched }
+	}
+
+	unreached := s.newPool()
+	queue := []int{root}
+	for len(queue) > 0 {
+		a := queue[0]
+		queue = queue[1:]
+
+		done := false
+		unreached.each(s, giver(a), func(b int) bool {
+			if !allowed(a, b) {
+				return true
+			}
+
+			unreached.take(b)
+			if !visit(a, b) {
+				done = true
+				return false
+			}
+
+			if next := match[b]; next != unmatched {
+				queue = append(queue, next)
+			}
+
+			return true
+		})
+
+		if done {
+			return
+		}
+	}
+}
+
+// A pool holds the participants who haven't been matched or reached
+// yet during a search, kept by group, so a giver can pass over their
+// own group or go straight to the group they give to.
+type pool struct {
+	buckets [][]int // Members of each group, with everybody in no group last
+	of      []int   // The bucket of each participant
+	pos     []int   // Where each member is in their bucket, or -1 once taken
+	open    []int   // Buckets that aren't empty
+	openPos []int   // Where each bucket is in open, or -1 once it's empty
+}
+
+// newPool puts everybody in s into a pool.
+func (s sparse) newPool() *pool {
+	n := len(s.rows)
+	p := &pool{
+		buckets: make([][]int, s.groups+1),
+		of:      make([]int, n),
+		pos:     make([]int, n),
+		openPos: make([]int, s.groups+1),
+	}
+
+	for j := 0; j < n; j++ {
+		b := s.groups
+		if s.group != nil && s.group[j] != noGroup {
+			b = s.group[j]
+		}
+
+		p.of[j], p.pos[j] = b, len(p.buckets[b])
+		p.buckets[b] = append(p.buckets[b], j)
+	}
+
+	for b, members := range p.buckets {
+		p.openPos[b] = -1
+		if len(members) > 0 {
+			p.openPos[b] = len(p.open)
+			p.open = append(p.open, b)
+		}
+	}
+
+	return p
+}
+
+// take removes participant j from the pool.
+func (p *pool) take(j int) {
+	b := p.of[j]
+	members := p.buckets[b]
+	k, last := p.pos[j], members[len(members)-1]
+	members[k], p.pos[last] = last, k
+	p.buckets[b] = members[:len(members)-1]
+	p.pos[j] = -1
+
+	if len(p.buckets[b]) == 0 {
+		k, last := p.openPos[b], p.open[len(p.open)-1]
+		p.open[k], p.openPos[last] = last, k
+		p.open = p.open[:len(p.open)-1]
+		p.openPos[b] = -1
+	}
+}
+
+// each calls f with the members of the pool until f returns false.
+// Unless giver is unmatched, it passes over anybody outside their
+// allow-list, in their own group, or outside the group they give to,
+// without looking at them one at a time. f may take the member it's
+// called with, but nobody else.
+func (p *pool) each(s sparse, giver int, f func(j int) bool) {
+	if giver != unmatched && s.only != nil && s.only[giver] != nil {
+		for _, j := range s.only[giver] {
+			if p.pos[j] >= 0 && !f(j) {
+				return
+			}
+		}
+
+		return
+	}
+
+	// Pins can allow somebody in the giver's own group
+	skip, target := noGroup, noGroup
+	if giver != unmatched && s.group != nil && (s.pinned == nil || s.pinned[giver] == nil) {
+		skip, target = s.group[giver], s.target[giver]
+	}
+
+	if target != noGroup {
+		p.eachIn(target, f)
+		return
+	}
+
+	// Going backwards means taking a member only moves ones that have
+	// already been seen
+	for k := len(p.open) - 1; k >= 0; k-- {
+		if b := p.open[k]; b != skip && !p.eachIn(b, f) {
+			return
+		}
+	}
+}
+
+// eachIn calls f with the members of bucket b like each, and reports
+// whether f never returned false.
+func (p *pool) eachIn(b int, f func(j int) bool) bool {
+	members := p.buckets[b]
+	for k := len(members) - 1; k >= 0; k-- {
+		if !f(members[k]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Assign picks an assignment at random from every assignment that
+// satisfies the constraints of s, like matrix.Assign, and draws the
+// same assignment as matrix.Assign for the same random numbers
+// whenever a random permutation or exact count is used.
+func (s sparse) Assign(r *rand.Rand) Assignment {
+	n := len(s.rows)
+	matchG, ok := s.sample(r)
+	if !ok {
+		matchG, _, _ = s.maxMatching()
+	}
+
+	a := make(Assignment, n)
+	for i, j := range matchG {
+		if j != unmatched {
+			a[Pid(i)] = Pid(j)
+		}
+	}
+
+	return a
+}
+
+// sample draws a perfect matching of s at random the same way as
+// bipartite.sample, but for large exchanges the number of random
+// permutations it tries is limited to rejectionBudget numbers.
+func (s sparse) sample(r *rand.Rand) ([]int, bool) {
+	n := len(s.rows)
+
+	tries := rejectionTries
+	if n > 0 && rejectionBudget/n < tries {
+		tries = rejectionBudget / n
+	}
+
+	for try := 0; try < tries; try++ {
+		if p := r.Perm(n); isPerfect(p, s.allows) {
+			return p, true
+		}
+	}
+
+	if n <= exactLimit {
+		return s.dense().bipartite().sampleExact(r)
+	}
+
+	matchG, _, size := s.maxMatching()
+	if size < n {
+		return nil, false
+	}
+
+	return sampleMarkov(r, matchG, s.allows, 100*n+10000), true
+}
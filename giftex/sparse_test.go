@@ -0,0 +1,361 @@
+package giftex
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// sparseOf lists the constraints of matrix m as a sparse matrix.
+func sparseOf(m matrix) sparse {
+	c := make(constraints, len(m))
+	for i := range m {
+		for j := range m[i] {
+			if m[i][j] == 1 {
+				c[Pid(i)] = append(c[Pid(i)], Pid(j))
+			}
+		}
+	}
+
+	return newSparse(len(m), c)
+}
+
+func TestSparse_dense(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for try := 0; try < 50; try++ {
+		m := randomMatrix(r, 1+r.Intn(10), r.Float64())
+		s := sparseOf(m)
+		cmpMatrix(t, m, s.dense())
+
+		order := make([]Pid, len(m))
+		for i, j := range r.Perm(len(m)) {
+			order[i] = Pid(j)
+		}
+		cmpMatrix(t, m.permute(order), s.permute(order).dense())
+	}
+}
+
+func TestSparse_maxMatching(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+
+	for try := 0; try < 500; try++ {
+		m := randomMatrix(r, 1+r.Intn(40), r.Float64())
+		s := sparseOf(m)
+
+		matchG, matchR, size := s.maxMatching()
+
+		count := 0
+		for i, j := range matchG {
+			if j == unmatched {
+				continue
+			}
+
+			count++
+			if m[i][j] == 1 || matchR[j] != i {
+				t.Fatalf("inconsistent matching %v / %v for matrix:\n%v", matchG, matchR, m)
+			}
+		}
+
+		if count != size {
+			t.Fatalf("wrong size: want: %d; got: %d", count, size)
+		}
+
+		if _, _, want := m.bipartite().maxMatching(); size != want {
+			t.Fatalf("want: %d; got: %d for matrix:\n%v", want, size, m)
+		}
+	}
+}
+
+// TestSparse_Assign checks a sparse matrix draws the same assignment
+// as a matrix for the same seed, so seeds can still be replayed.
+func TestSparse_Assign(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+
+	for try := 0; try < 200; try++ {
+		m := randomMatrix(r, 1+r.Intn(exactLimit), 0.6*r.Float64())
+		s := sparseOf(m)
+		if !m.CheckConstraints() {
+			continue
+		}
+
+		seed := r.Int63()
+		want := m.Assign(rand.New(rand.NewSource(seed)))
+		got := s.Assign(rand.New(rand.NewSource(seed)))
+		if want.String() != got.String() {
+			t.Fatalf("\nwant:\n%v\n\ngot:\n%v\n\nfor matrix:\n%v", want, got, m)
+		}
+	}
+}
+
+// randomGroups creates n participants in a few groups, some of whom
+// give to another group, are pinned, or have an allow-list.
+func randomGroups(r *rand.Rand, n int) ParticipantMap {
+	groups := []string{"", "a", "A ", "b", "c"}
+	randomIDs := func(k int) []Pid {
+		ids := make([]Pid, k)
+		for i := range ids {
+			ids[i] = Pid(r.Intn(n))
+		}
+
+		return ids
+	}
+
+	pm := make(ParticipantMap, n)
+	for i := 0; i < n; i++ {
+		p := Participant{ID: Pid(i), Name: fmt.Sprint("p", i), Group: groups[r.Intn(len(groups))]}
+		switch r.Intn(8) {
+		case 0:
+			p.GivesTo = groups[1+r.Intn(len(groups)-1)]
+		case 1:
+			p.Pinned = randomIDs(1)
+		case 2:
+			p.Allowed = randomIDs(1 + r.Intn(3))
+		}
+
+		if r.Intn(3) == 0 {
+			p.Restrictions = randomIDs(1)
+		}
+
+		pm[Pid(i)] = p
+	}
+
+	return pm
+}
+
+// TestSparse_groups checks that groups, group targets, pins, and
+// allow-lists rule out the same pairs in a sparse matrix as listing
+// every pair would, and that matchings are still maximum.
+func TestSparse_groups(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+
+	for try := 0; try < 300; try++ {
+		n := 1 + r.Intn(20)
+		pm := randomGroups(r, n)
+		opts := &GiftExchangeOptions{GroupRules: []GroupRule{{Giver: "b", Recipient: "c"}}}
+
+		restrictions, previous := splitConstraints(pm, opts)
+		allow := allowedPairs(pm, opts)
+		want := newMatrix(n)
+		for i := range want {
+			for j := range want[i] {
+				giver, recipient := Pid(i), Pid(j)
+				if containsPid(restrictions[giver], recipient) || containsPid(previous[giver], recipient) || !allow(giver, recipient) {
+					want[i][j] = 1
+				}
+			}
+		}
+
+		s := newConstraintGraph(pm, opts, restrictions, previous)
+		cmpMatrix(t, want, s.dense())
+
+		order := drawOrder(pm)
+		cmpMatrix(t, want.permute(order), s.permute(order).dense())
+
+		_, _, size := s.maxMatching()
+		if _, _, wantSize := want.bipartite().maxMatching(); size != wantSize {
+			t.Fatalf("want: %d; got: %d for matrix:\n%v", wantSize, size, want)
+		}
+	}
+}
+
+// bigExchange creates a gift exchange with n participants who live in
+// households of 4, each restricting somebody from another household
+// and remembering who they had last year.
+func bigExchange(n int) ParticipantMap {
+	pm := make(ParticipantMap, n)
+	for i := 0; i < n; i++ {
+		pm[Pid(i)] = Participant{
+			ID:           Pid(i),
+			Name:         fmt.Sprintf("p%d", i),
+			Restrictions: []Pid{Pid((i + 7) % n)},
+			Previous:     []Pid{Pid((i + 13) % n)},
+			Group:        fmt.Sprintf("household %d", i/4),
+		}
+	}
+
+	return pm
+}
+
+// TestNewGiftExchange_tooManyParticipants checks the options that
+// need the full matrix refuse big exchanges instead of running out of
+// memory, while the default draw doesn't mind.
+func TestNewGiftExchange_tooManyParticipants(t *testing.T) {
+	pm := bigExchange(MaxMatrixParticipants + 1)
+	if _, err := NewGiftExchange(pm, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]func() error{
+		"swap": func() error {
+			_, err := NewGiftExchange(pm, &GiftExchangeOptions{Swap: true})
+			return err
+		},
+		"costs": func() error {
+			_, err := NewGiftExchange(pm, &GiftExchangeOptions{Costs: &CostOptions{}})
+			return err
+		},
+		"no mutual pairs": func() error {
+			_, err := NewGiftExchange(pm, &GiftExchangeOptions{NoMutualPairs: true})
+			return err
+		},
+		"gifts per person": func() error {
+			_, err := NewGiftExchange(pm, &GiftExchangeOptions{GiftsPerPerson: 2})
+			return err
+		},
+		"rules": func() error {
+			rule := SameValue(func(p Participant) string { return p.Group }, Forbid)
+			_, err := NewGiftExchange(pm, &GiftExchangeOptions{Rules: []Rule{rule}})
+			return err
+		},
+		"schedule": func() error {
+			_, err := NewSchedule(pm, 2, nil)
+			return err
+		},
+		"max rounds": func() error {
+			_, err := MaxRounds(pm, nil)
+			return err
+		},
+		"repair": func() error {
+			_, _, err := RepairGiftExchange(pm, Assignment{}, pm, nil)
+			return err
+		},
+		"odds": func() error {
+			_, err := PairProbabilities(pm, nil)
+			return err
+		},
+	}
+
+	for name, f := range tests {
+		if err := f(); !errors.Is(err, ErrTooManyParticipants) {
+			t.Errorf("%s: want: %v; got: %v", name, ErrTooManyParticipants, err)
+		}
+	}
+
+	// Nobody can give to p0, and the fix is to drop a restriction,
+	// which is only worked out for smaller exchanges
+	blocked := func(n int) ParticipantMap {
+		pm := bigExchange(n)
+		for id, p := range pm {
+			if id != 0 {
+				p.Restrictions = append(p.Restrictions, 0)
+				pm[id] = p
+			}
+		}
+
+		return pm
+	}
+
+	if r := SuggestRelaxations(blocked(100), nil); len(r) == 0 {
+		t.Error("relax: want suggestions for 100 people")
+	}
+
+	if r := SuggestRelaxations(blocked(MaxMatrixParticipants+1), nil); len(r) != 0 {
+		t.Errorf("relax: want no suggestions; got: %+v", r)
+	}
+
+	// One less is fine
+	pm = bigExchange(MaxMatrixParticipants)
+	if _, err := NewGiftExchange(pm, &GiftExchangeOptions{NoMutualPairs: true}); err != nil {
+		t.Error(err)
+	}
+}
+
+func BenchmarkNewGiftExchange(b *testing.B) {
+	for _, n := range []int{1000, 10000, 100000} {
+		pm := bigExchange(n)
+
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				opts := &GiftExchangeOptions{Rand: rand.New(rand.NewSource(int64(i)))}
+				if _, err := NewGiftExchange(pm, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkNewGiftExchange_groups draws from exchanges where everybody
+// is in one of two groups, or in departments of 1000 people, some of
+// which give to another department.
+func BenchmarkNewGiftExchange_groups(b *testing.B) {
+	departments := func(n, size int) ParticipantMap {
+		pm := bigExchange(n)
+		for id, p := range pm {
+			p.Group = fmt.Sprint("department ", int(id)/size)
+			if int(id)%size == 0 {
+				p.GivesTo = fmt.Sprint("department ", (int(id)/size+1)%(n/size))
+			}
+			pm[id] = p
+		}
+
+		return pm
+	}
+
+	for _, n := range []int{4000, 100000} {
+		pm := departments(n, n/2)
+		b.Run(fmt.Sprint("halves/", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				opts := &GiftExchangeOptions{Rand: rand.New(rand.NewSource(int64(i)))}
+				if _, err := NewGiftExchange(pm, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+
+	for _, n := range []int{20000, 100000} {
+		pm := departments(n, 1000)
+		b.Run(fmt.Sprint("departments/", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				opts := &GiftExchangeOptions{Rand: rand.New(rand.NewSource(int64(i)))}
+				if _, err := NewGiftExchange(pm, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkNewGiftExchange_constrained draws from exchanges where a
+// random permutation is almost never valid.
+func BenchmarkNewGiftExchange_constrained(b *testing.B) {
+	for _, n := range []int{1000, 10000, 100000} {
+		pm := bigExchange(n)
+		for id, p := range pm {
+			for k := 1; k <= 20; k++ {
+				p.Restrictions = append(p.Restrictions, Pid((int(id)+100*k)%n))
+			}
+			pm[id] = p
+		}
+
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				opts := &GiftExchangeOptions{Rand: rand.New(rand.NewSource(int64(i)))}
+				if _, err := NewGiftExchange(pm, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkNewGiftExchange_noMutualPairs draws with an option that
+// needs the full matrix, up to the most participants it allows.
+func BenchmarkNewGiftExchange_noMutualPairs(b *testing.B) {
+	for _, n := range []int{100, MaxMatrixParticipants} {
+		pm := bigExchange(n)
+
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				opts := &GiftExchangeOptions{NoMutualPairs: true, Rand: rand.New(rand.NewSource(int64(i)))}
+				if _, err := NewGiftExchange(pm, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,703 @@
+package giftex
+
+import (
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"strings"
+)
+
+// A general graph is the adjacency list of people who can swap gifts
+// with each other. Unlike a bipartite graph, everybody is on the same
+// side: an edge between i and j means neither one is kept from giving
+// to the other.
+type general [][]int
+
+// swapGraph converts the zeros of matrix m into a general graph,
+// keeping only the pairs that are allowed both ways.
+func (m matrix) swapGraph() general {
+	g := make(general, len(m))
+	for i := range m {
+		for j := i + 1; j < len(m); j++ {
+			if m[i][j] == 0 && m[j][i] == 0 {
+				g[i] = append(g[i], j)
+				g[j] = append(g[j], i)
+			}
+		}
+	}
+
+	return g
+}
+
+// swap reports whether participants are paired up to swap gifts.
+func (opts *GiftExchangeOptions) swap() bool {
+	return opts != nil && opts.Swap
+}
+
+// blossom finds maximum matchings of a general graph using Edmonds'
+// blossom algorithm. An augmenting path can run around an odd cycle,
+// which a bipartite graph doesn't have, so every odd cycle found while
+// searching is shrunk down to its base and searched through as one
+// vertex. People in removed are left out of the graph.
+type blossom struct {
+	g       general
+	match   []int
+	removed []bool
+
+	parent, base []int
+	used         []bool
+	queue        []int
+}
+
+func newBlossom(g general) *blossom {
+	n := len(g)
+	b := &blossom{
+		g:       g,
+		match:   make([]int, n),
+		removed: make([]bool, n),
+		parent:  make([]int, n),
+		base:    make([]int, n),
+		used:    make([]bool, n),
+	}
+
+	for i := range b.match {
+		b.match[i] = unmatched
+	}
+
+	return b
+}
+
+// clone copies b so people can be removed without changing b.
+func (b *blossom) clone() *blossom {
+	c := newBlossom(b.g)
+	copy(c.match, b.match)
+	copy(c.removed, b.removed)
+	return c
+}
+
+// remove takes v out of the graph, leaving whoever v was matched with
+// unmatched.
+func (b *blossom) remove(v int) {
+	if w := b.match[v]; w != unmatched {
+		b.match[w] = unmatched
+	}
+
+	b.match[v] = unmatched
+	b.removed[v] = true
+}
+
+// missable marks everybody some maximum matching leaves unmatched,
+// which, by the Gallai–Edmonds decomposition, is everybody an even
+// alternating path reaches from somebody unmatched now. b.match has
+// to be a maximum matching already.
+func (b *blossom) missable() []bool {
+	d := make([]bool, len(b.g))
+	for v, w := range b.match {
+		if b.removed[v] || w != unmatched {
+			continue
+		}
+
+		// There's no augmenting path, so the search marks every
+		// outer vertex it reaches before giving up
+		b.findPath(v)
+		for u, used := range b.used {
+			if used {
+				d[u] = true
+			}
+		}
+	}
+
+	return d
+}
+
+// closers marks everybody v who can be left out so that everybody
+// else pairs up, except for one person in with who goes with v
+// instead. Adding somebody new who can only pair up with the people
+// in with turns this into missable. b.match has to be a maximum
+// matching already.
+func (b *blossom) closers(with func(v int) bool) []bool {
+	n := len(b.g)
+	g := make(general, n+1)
+	for v, edges := range b.g {
+		g[v] = edges
+		if !b.removed[v] && with(v) {
+			g[v] = append(edges[:len(edges):len(edges)], n)
+			g[n] = append(g[n], v)
+		}
+	}
+
+	c := newBlossom(g)
+	copy(c.match, b.match)
+	copy(c.removed, b.removed)
+	c.maxMatching()
+
+	var left int
+	for v, w := range c.match {
+		if !c.removed[v] && w == unmatched {
+			left++
+		}
+	}
+
+	if left != 1 {
+		return make([]bool, n)
+	}
+
+	return c.missable()[:n]
+}
+
+// maxMatching grows the matching one augmenting path at a time and
+// returns how many pairs it has.
+func (b *blossom) maxMatching() int {
+	for v := range b.g {
+		if !b.removed[v] && b.match[v] == unmatched {
+			b.augment(v)
+		}
+	}
+
+	var size int
+	for v, w := range b.match {
+		if w != unmatched && v < w {
+			size++
+		}
+	}
+
+	return size
+}
+
+// perfect reports whether everybody left in the graph can be matched,
+// growing the matching as it goes. Once there is no augmenting path
+// from somebody, there never will be, so we can stop right away.
+func (b *blossom) perfect() bool {
+	for v := range b.g {
+		if !b.removed[v] && b.match[v] == unmatched && !b.augment(v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// augment searches for an augmenting path from root and flips the
+// matching along it.
+func (b *blossom) augment(root int) bool {
+	v := b.findPath(root)
+	if v == unmatched {
+		return false
+	}
+
+	for v != unmatched {
+		pv := b.parent[v]
+		next := b.match[pv]
+		b.match[v], b.match[pv] = pv, v
+		v = next
+	}
+
+	return true
+}
+
+// findPath searches outward from root for somebody unmatched. Matched
+// pairs are always followed together, so each vertex is either an
+// outer vertex at an even distance from root or an inner one. An edge
+// between two outer vertices closes an odd cycle, which becomes a
+// blossom with every vertex in it counted as outer.
+func (b *blossom) findPath(root int) int {
+	for i := range b.g {
+		b.used[i] = false
+		b.parent[i] = unmatched
+		b.base[i] = i
+	}
+
+	b.used[root] = true
+	b.queue = append(b.queue[:0], root)
+	for len(b.queue) > 0 {
+		v := b.queue[0]
+		b.queue = b.queue[1:]
+
+		for _, to := range b.g[v] {
+			if b.removed[to] || b.base[v] == b.base[to] || b.match[v] == to {
+				continue
+			}
+
+			if to == root || (b.match[to] != unmatched && b.parent[b.match[to]] != unmatched) {
+				b.shrink(v, to)
+				continue
+			}
+
+			if b.parent[to] == unmatched {
+				b.parent[to] = v
+				if b.match[to] == unmatched {
+					return to
+				}
+
+				b.used[b.match[to]] = true
+				b.queue = append(b.queue, b.match[to])
+			}
+		}
+	}
+
+	return unmatched
+}
+
+// shrink turns the odd cycle closed by the edge between outer
+// vertices v and to into a blossom.
+func (b *blossom) shrink(v, to int) {
+	stem := b.commonBase(v, to)
+	inBlossom := make([]bool, len(b.g))
+	b.markPath(v, stem, to, inBlossom)
+	b.markPath(to, stem, v, inBlossom)
+
+	for i := range b.g {
+		if inBlossom[b.base[i]] {
+			b.base[i] = stem
+			if !b.used[i] {
+				b.used[i] = true
+				b.queue = append(b.queue, i)
+			}
+		}
+	}
+}
+
+// commonBase finds where the paths from a and c back to the root meet.
+func (b *blossom) commonBase(a, c int) int {
+	seen := make([]bool, len(b.g))
+	for {
+		a = b.base[a]
+		seen[a] = true
+		if b.match[a] == unmatched {
+			break
+		}
+
+		a = b.parent[b.match[a]]
+	}
+
+	for {
+		c = b.base[c]
+		if seen[c] {
+			return c
+		}
+
+		c = b.parent[b.match[c]]
+	}
+}
+
+// markPath marks the blossoms on the path from v down to stem and
+// points them back at child so the path can be flipped later.
+func (b *blossom) markPath(v, stem, child int, inBlossom []bool) {
+	for b.base[v] != stem {
+		inBlossom[b.base[v]] = true
+		inBlossom[b.base[b.match[v]]] = true
+		b.parent[v] = child
+		child = b.match[v]
+		v = b.parent[b.match[v]]
+	}
+}
+
+// assignSwaps pairs up everybody in matrix m to swap gifts. With an
+// odd number of people, three of them give to each other in a loop
+// instead. The assignment is drawn the same way as Assign: rejection
+// first, then counting every way to pair people up for small
+// exchanges, and a Markov chain for large ones.
+func (m matrix) assignSwaps(r *rand.Rand) (Assignment, bool) {
+	n := len(m)
+	if n < 2 {
+		return nil, false
+	}
+
+	ok := func(i, j int) bool { return m[i][j] == 0 }
+
+	// Pairing up people in a random order is a uniform draw, with the
+	// first three forming the loop when there's an odd number of them
+	for try := 0; try < rejectionTries; try++ {
+		if matchG := swapsFromOrder(r.Perm(n)); isSwaps(matchG, ok) {
+			return toAssignment(matchG), true
+		}
+	}
+
+	if n <= exactLimit {
+		matchG, found := m.sampleSwapsExact(r)
+		if !found {
+			return nil, false
+		}
+
+		return toAssignment(matchG), true
+	}
+
+	matchG, found := m.findSwaps(r)
+	if !found {
+		return nil, false
+	}
+
+	return toAssignment(sampleSwapsMarkov(r, matchG, ok, 100*n+10000)), true
+}
+
+// swapsFromOrder pairs up people next to each other in order. With an
+// odd number of people, the first three give to each other in a loop.
+func swapsFromOrder(order []int) []int {
+	matchG := make([]int, len(order))
+	start := 0
+	if len(order)%2 == 1 && len(order) >= 3 {
+		a, b, c := order[0], order[1], order[2]
+		matchG[a], matchG[b], matchG[c] = b, c, a
+		start = 3
+	}
+
+	for k := start; k+1 < len(order); k += 2 {
+		a, b := order[k], order[k+1]
+		matchG[a], matchG[b] = b, a
+	}
+
+	return matchG
+}
+
+// isSwaps reports whether matchG pairs up everybody using only
+// allowed pairs, except for at most one loop of three people when
+// there's an odd number of them.
+func isSwaps(matchG []int, ok func(i, j int) bool) bool {
+	n := len(matchG)
+	if n < 2 || !isPerfect(matchG, ok) {
+		return false
+	}
+
+	var loops int
+	for i, j := range matchG {
+		if matchG[j] == i {
+			continue
+		}
+
+		// Everybody in a loop of three is counted once
+		if matchG[matchG[j]] != i {
+			return false
+		}
+		loops++
+	}
+
+	return loops == 3*(n%2)
+}
+
+// isSwapAssignment reports whether assignment a pairs up everybody in
+// matrix m the way isSwaps does.
+func (m matrix) isSwapAssignment(a Assignment) bool {
+	matchG := make([]int, len(m))
+	for i := range matchG {
+		j, ok := a[Pid(i)]
+		if !ok {
+			return false
+		}
+
+		matchG[i] = int(j)
+	}
+
+	return isSwaps(matchG, func(i, j int) bool { return m[i][j] == 0 })
+}
+
+// sampleSwapsExact counts every way to pair up the people in matrix m
+// and picks one uniformly at random. With an odd number of people,
+// every loop of three is weighted by the number of ways to pair up
+// everybody else.
+func (m matrix) sampleSwapsExact(r *rand.Rand) ([]int, bool) {
+	n := len(m)
+	ok := func(i, j int) bool { return m[i][j] == 0 }
+
+	adj := make([]uint32, n)
+	for i, edges := range m.swapGraph() {
+		for _, j := range edges {
+			adj[i] |= 1 << uint(j)
+		}
+	}
+
+	// ways[mask] counts the ways everybody in mask can be paired up,
+	// always pairing the person with the smallest index first
+	full := uint32(1)<<uint(n) - 1
+	ways := make([]uint64, full+1)
+	ways[0] = 1
+	for mask := uint32(1); mask <= full; mask++ {
+		if bits.OnesCount32(mask)%2 == 1 {
+			continue
+		}
+
+		low := uint32(1) << uint(bits.TrailingZeros32(mask))
+		free := adj[bits.TrailingZeros32(mask)] & mask
+		for free != 0 {
+			bit := free & -free
+			ways[mask] += ways[mask&^low&^bit]
+			free &^= bit
+		}
+	}
+
+	matchG := make([]int, n)
+	mask := full
+	if n%2 == 1 {
+		// Every loop is listed once, starting with its smallest index
+		type loop struct{ a, b, c int }
+		var loops []loop
+		var weights []uint64
+		var total uint64
+		for a := 0; a < n; a++ {
+			for b := a + 1; b < n; b++ {
+				for c := a + 1; c < n; c++ {
+					if b == c || !ok(a, b) || !ok(b, c) || !ok(c, a) {
+						continue
+					}
+
+					w := ways[full&^(1<<uint(a)|1<<uint(b)|1<<uint(c))]
+					if w > 0 {
+						loops = append(loops, loop{a, b, c})
+						weights = append(weights, w)
+						total += w
+					}
+				}
+			}
+		}
+
+		if total == 0 {
+			return nil, false
+		}
+
+		pick := uint64(r.Int63n(int64(total)))
+		for k, w := range weights {
+			if pick < w {
+				l := loops[k]
+				matchG[l.a], matchG[l.b], matchG[l.c] = l.b, l.c, l.a
+				mask &^= 1<<uint(l.a) | 1<<uint(l.b) | 1<<uint(l.c)
+				break
+			}
+
+			pick -= w
+		}
+	}
+
+	if ways[mask] == 0 {
+		return nil, false
+	}
+
+	for mask != 0 {
+		pick := uint64(r.Int63n(int64(ways[mask])))
+		i := bits.TrailingZeros32(mask)
+		low := uint32(1) << uint(i)
+
+		free := adj[i] & mask
+		for free != 0 {
+			bit := free & -free
+			if w := ways[mask&^low&^bit]; pick < w {
+				j := bits.TrailingZeros32(bit)
+				matchG[i], matchG[j] = j, i
+				mask &^= low | bit
+				break
+			} else {
+				pick -= w
+			}
+
+			free &^= bit
+		}
+	}
+
+	return matchG, true
+}
+
+// findSwaps finds any way to pair up the people in matrix m with the
+// blossom algorithm, searching the graph in a random order. With an
+// odd number of people, it looks for a loop of three that leaves
+// everybody else able to pair up.
+//
+// Trying every loop takes O(n³) matchings, which is far too slow when
+// there isn't one. Instead, a pairing of everybody outside the loop is
+// one augmenting path away from a maximum matching of the whole graph,
+// so somebody in the loop, a, is always left out by some maximum
+// matching. For each such a and each x that a can give to, the people
+// who can close the loop are exactly the ones left out by some maximum
+// matching once a and x are gone, which a single search finds. A
+// couple of searches for each a first rule out most of the people who
+// could be x, so it's O(n²) searches at most, and usually O(n).
+func (m matrix) findSwaps(r *rand.Rand) ([]int, bool) {
+	n := len(m)
+	if n < 2 {
+		return nil, false
+	}
+
+	// Relabel everybody at random so the blossom algorithm doesn't
+	// always find the same matching
+	order := r.Perm(n)
+	p := make(matrix, n)
+	for i := range p {
+		p[i] = make([]int, n)
+		for j := range p[i] {
+			p[i][j] = m[order[i]][order[j]]
+		}
+	}
+
+	b := newBlossom(p.swapGraph())
+	size := b.maxMatching()
+
+	relabel := func(match []int) []int {
+		matchG := make([]int, n)
+		for i, j := range match {
+			matchG[order[i]] = order[j]
+		}
+
+		return matchG
+	}
+
+	if n%2 == 0 {
+		if 2*size < n {
+			return nil, false
+		}
+
+		return relabel(b.match), true
+	}
+
+	// Taking out three people can't make the rest easier to pair up
+	if 2*size < n-3 {
+		return nil, false
+	}
+
+	ok := func(i, j int) bool { return p[i][j] == 0 }
+	for a, missable := range b.missable() {
+		if !missable {
+			continue
+		}
+
+		withoutA := b.clone()
+		withoutA.remove(a)
+		if 2*withoutA.maxMatching() < n-3 {
+			continue
+		}
+
+		// Ignoring whether x can give to y, rule out everybody who
+		// can't be in the loop with a at all, so only the few people
+		// left need a search of their own
+		from := func(v int) bool { return ok(a, v) }
+		to := func(v int) bool { return ok(v, a) }
+		if !anyOf(withoutA.closers(from), to) {
+			continue
+		}
+
+		xs := withoutA.closers(to)
+		for x := 0; x < n; x++ {
+			if !xs[x] || !ok(a, x) {
+				continue
+			}
+
+			rest := withoutA.clone()
+			rest.remove(x)
+			if 2*rest.maxMatching() < n-3 {
+				continue
+			}
+
+			for y, missable := range rest.missable() {
+				if !missable || !ok(x, y) || !ok(y, a) {
+					continue
+				}
+
+				pairs := rest.clone()
+				pairs.remove(y)
+				if !pairs.perfect() {
+					continue
+				}
+
+				pairs.match[a], pairs.match[x], pairs.match[y] = x, y, a
+				return relabel(pairs.match), true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// anyOf reports whether f is true for anybody marked.
+func anyOf(marked []bool, f func(v int) bool) bool {
+	for v, ok := range marked {
+		if ok && f(v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sampleSwapsMarkov shuffles the swaps in matchG by repeatedly picking
+// two people and trading their partners, trading somebody in the loop
+// of three for somebody in a pair, or turning the loop around,
+// whenever the result is still valid. Like sampleMarkov, each move is
+// as likely as its reverse.
+func sampleSwapsMarkov(r *rand.Rand, matchG []int, ok func(i, j int) bool, steps int) []int {
+	n := len(matchG)
+	matchG = append([]int(nil), matchG...)
+	if n < 4 {
+		return matchG
+	}
+
+	both := func(i, j int) bool { return ok(i, j) && ok(j, i) }
+	paired := func(i int) bool { return matchG[matchG[i]] == i }
+
+	for s := 0; s < steps; s++ {
+		a, c := r.Intn(n), r.Intn(n)
+		if a == c || matchG[a] == c {
+			continue
+		}
+
+		if paired(a) && !paired(c) {
+			a, c = c, a
+		}
+
+		switch {
+		case paired(a) && paired(c):
+			// Trade partners: a-b and c-d become a-c and b-d
+			b, d := matchG[a], matchG[c]
+			if both(a, c) && both(b, d) {
+				matchG[a], matchG[c] = c, a
+				matchG[b], matchG[d] = d, b
+			}
+
+		case paired(c):
+			// Put c in the loop in place of a, and pair a up with
+			// c's old partner
+			next, prev, d := matchG[a], matchG[matchG[a]], matchG[c]
+			if ok(prev, c) && ok(c, next) && both(a, d) {
+				matchG[prev], matchG[c] = c, next
+				matchG[a], matchG[d] = d, a
+			}
+
+		default:
+			// a and c are both in the loop, so turn it around
+			next, prev := matchG[a], matchG[matchG[a]]
+			if ok(a, prev) && ok(prev, next) && ok(next, a) {
+				matchG[a], matchG[prev], matchG[next] = prev, next, a
+			}
+		}
+	}
+
+	return matchG
+}
+
+// checkSwaps reports why nobody can be paired up in matrix m, if so.
+func checkSwaps(m matrix) error {
+	if _, ok := m.findSwaps(rand.New(rand.NewSource(1))); !ok {
+		return fmt.Errorf("%w when everybody swaps gifts with a partner", ErrNoSolution)
+	}
+
+	return nil
+}
+
+// PairNames describes who swaps gifts with who, e.g., "bar <-> foo".
+// A loop of three is described in the order gifts are passed, like
+// CycleNames does.
+func (ge *GiftExchange) PairNames() []string {
+	cycles := ge.Assignment.Cycles()
+	names := make([]string, 0, len(cycles))
+	for _, cycle := range cycles {
+		list := make([]string, 0, len(cycle)+1)
+		for _, p := range cycle {
+			list = append(list, ge.participants[p].Name)
+		}
+
+		if len(cycle) == 2 {
+			names = append(names, strings.Join(list, " <-> "))
+			continue
+		}
+
+		list = append(list, list[0])
+		names = append(names, strings.Join(list, " -> "))
+	}
+
+	return names
+}
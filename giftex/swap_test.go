@@ -0,0 +1,330 @@
+package giftex
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func Example_swap() {
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo", Restrictions: []Pid{2}},
+		1: {ID: 1, Name: "bar"},
+		2: {ID: 2, Name: "baz"},
+		3: {ID: 3, Name: "qux", Restrictions: []Pid{0}},
+	}
+
+	// Restrictions go both ways when swapping, so foo can only swap
+	// with bar
+	ge, err := NewGiftExchange(pm, &GiftExchangeOptions{Swap: true})
+	if err != nil {
+		panic(err)
+	}
+
+	for _, pair := range ge.PairNames() {
+		fmt.Println(pair)
+	}
+
+	// Output:
+	// foo <-> bar
+	// baz <-> qux
+}
+
+// bruteForceSwaps lists every way to pair up everybody in matrix m.
+func bruteForceSwaps(m matrix) [][]int {
+	ok := func(i, j int) bool { return m[i][j] == 0 }
+
+	var found [][]int
+	for _, p := range bruteForce(m) {
+		if isSwaps(p, ok) {
+			found = append(found, p)
+		}
+	}
+
+	return found
+}
+
+// bruteForceMatching finds the size of a maximum matching of g by
+// trying to either match or skip every vertex.
+func bruteForceMatching(g general) int {
+	matched := make([]bool, len(g))
+
+	var walk func(v int) int
+	walk = func(v int) int {
+		if v == len(g) {
+			return 0
+		}
+
+		if matched[v] {
+			return walk(v + 1)
+		}
+
+		best := walk(v + 1)
+		matched[v] = true
+		for _, w := range g[v] {
+			if w > v && !matched[w] {
+				matched[w] = true
+				if size := 1 + walk(v+1); size > best {
+					best = size
+				}
+				matched[w] = false
+			}
+		}
+		matched[v] = false
+
+		return best
+	}
+
+	return walk(0)
+}
+
+func TestBlossom_maxMatching_bruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(15))
+
+	for n := 1; n <= 10; n++ {
+		for try := 0; try < 200; try++ {
+			g := randomMatrix(r, n, r.Float64()).swapGraph()
+			b := newBlossom(g)
+
+			want := bruteForceMatching(g)
+			if got := b.maxMatching(); want != got {
+				t.Fatalf("want: %d; got: %d for graph: %v", want, got, g)
+			}
+
+			for v, w := range b.match {
+				if w != unmatched && (b.match[w] != v || !containsInt(g[v], w)) {
+					t.Fatalf("invalid matching %v for graph: %v", b.match, g)
+				}
+			}
+		}
+	}
+}
+
+func containsInt(list []int, x int) bool {
+	for _, y := range list {
+		if x == y {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestMatrix_assignSwaps_bruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(15))
+
+	for n := 1; n <= 8; n++ {
+		for _, density := range []float64{0.1, 0.3, 0.5} {
+			for try := 0; try < 50; try++ {
+				m := randomMatrix(r, n, density)
+				ok := func(i, j int) bool { return m[i][j] == 0 }
+
+				want := len(bruteForceSwaps(m)) > 0
+				if got := checkSwaps(m) == nil; want != got {
+					t.Fatalf("want: %v; got: %v for matrix:\n%v", want, got, m)
+				}
+
+				if matchG, found := m.findSwaps(r); found != want || (found && !isSwaps(matchG, ok)) {
+					t.Fatalf("findSwaps found %v: %v for matrix:\n%v", found, matchG, m)
+				}
+
+				a, found := m.assignSwaps(r)
+				if found != want || (found && !m.isSwapAssignment(a)) {
+					t.Fatalf("assignSwaps found %v: %v for matrix:\n%v", found, a, m)
+				}
+			}
+		}
+	}
+}
+
+func TestMatrix_assignSwaps_uniform(t *testing.T) {
+	r := rand.New(rand.NewSource(15))
+
+	tests := map[string]matrix{
+		"even": {
+			{1, 0, 0, 1, 0, 0},
+			{0, 1, 0, 0, 0, 1},
+			{0, 0, 1, 0, 1, 0},
+			{0, 0, 0, 1, 0, 0},
+			{0, 0, 0, 0, 1, 0},
+			{0, 0, 0, 0, 1, 1},
+		},
+		"odd": {
+			{1, 0, 0, 1, 0},
+			{0, 1, 0, 0, 0},
+			{0, 1, 1, 0, 0},
+			{0, 0, 0, 1, 0},
+			{0, 0, 0, 0, 1},
+		},
+	}
+
+	for name, m := range tests {
+		ok := func(i, j int) bool { return m[i][j] == 0 }
+		all := bruteForceSwaps(m)
+
+		t.Run(name+"/assignSwaps", func(t *testing.T) {
+			checkUniform(t, all, func() []int {
+				a, _ := m.assignSwaps(r)
+				p := make([]int, len(a))
+				for i, j := range a {
+					p[i] = int(j)
+				}
+
+				return p
+			})
+		})
+
+		t.Run(name+"/sampleSwapsExact", func(t *testing.T) {
+			checkUniform(t, all, func() []int {
+				p, _ := m.sampleSwapsExact(r)
+				return p
+			})
+		})
+
+		t.Run(name+"/sampleSwapsMarkov", func(t *testing.T) {
+			matchG, _ := m.findSwaps(r)
+			checkUniform(t, all, func() []int {
+				return sampleSwapsMarkov(r, matchG, ok, 500)
+			})
+		})
+	}
+}
+
+func TestNewGiftExchange_swap(t *testing.T) {
+	pm := ParticipantMap{
+		0: {ID: 0, Name: "foo", Group: "smith"},
+		1: {ID: 1, Name: "bar", Group: "smith"},
+		2: {ID: 2, Name: "baz"},
+		3: {ID: 3, Name: "qux"},
+		4: {ID: 4, Name: "quux"},
+	}
+
+	// quux had foo last year, so they can't swap this year
+	h := History{{2023, "quux", "foo"}}
+
+	for try := 0; try < 50; try++ {
+		opts := &GiftExchangeOptions{Swap: true, History: h, Seed: int64(try + 1)}
+		ge, err := NewGiftExchange(pm, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var loops int
+		for giver, recipient := range ge.Assignment {
+			if back := ge.Assignment[recipient]; back != giver {
+				loops++
+			}
+
+			if sameGroup(pm[giver], pm[recipient]) || (pm[giver].Name == "quux" && pm[recipient].Name == "foo") {
+				t.Fatalf("%s can't give to %s", pm[giver].Name, pm[recipient].Name)
+			}
+		}
+
+		if loops != 3 || !ge.Swap {
+			t.Fatalf("want one loop of 3 people; got: %v", ge.PairNames())
+		}
+
+		if err := Verify(pm, opts, ge.Commitment, ge); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if Commit(pm, &GiftExchangeOptions{Seed: 1}) == Commit(pm, &GiftExchangeOptions{Seed: 1, Swap: true}) {
+		t.Error("swapping didn't change the commitment")
+	}
+
+	// Neither baz nor qux can swap with foo or bar
+	pm[2] = Participant{ID: 2, Name: "baz", Restrictions: []Pid{0, 1}}
+	pm[3] = Participant{ID: 3, Name: "qux", Group: "smith"}
+	if _, err := NewGiftExchange(pm, &GiftExchangeOptions{Swap: true}); !errors.Is(err, ErrNoSolution) {
+		t.Errorf("want: %v; got: %v", ErrNoSolution, err)
+	}
+
+	// foo has to swap with qux and bar with baz
+	delete(pm, 4)
+	pm[2] = Participant{ID: 2, Name: "baz", Restrictions: []Pid{0}}
+	pm[3] = Participant{ID: 3, Name: "qux"}
+	if _, err := NewGiftExchange(pm, &GiftExchangeOptions{Swap: true}); err != nil {
+		t.Error(err)
+	}
+
+	for _, opts := range []*GiftExchangeOptions{
+		{Swap: true, NoMutualPairs: true},
+		{Swap: true, GiftsPerPerson: 2},
+		{Swap: true, Costs: &CostOptions{}},
+	} {
+		if _, err := NewGiftExchange(pm, opts); !errors.Is(err, ErrConflictingOptions) {
+			t.Errorf("%+v: want: %v; got: %v", opts, ErrConflictingOptions, err)
+		}
+	}
+}
+
+// TestMatrix_findSwaps_exact checks findSwaps against counting every
+// way to pair people up, for more people than brute force can handle.
+func TestMatrix_findSwaps_exact(t *testing.T) {
+	r := rand.New(rand.NewSource(15))
+
+	for try := 0; try < 300; try++ {
+		n := 9 + r.Intn(exactLimit-8)
+		m := randomMatrix(r, n, 0.5+0.4*r.Float64())
+		ok := func(i, j int) bool { return m[i][j] == 0 }
+
+		_, want := m.sampleSwapsExact(r)
+		if matchG, found := m.findSwaps(r); found != want || (found && !isSwaps(matchG, ok)) {
+			t.Fatalf("findSwaps found %v: %v for matrix:\n%v", found, matchG, m)
+		}
+	}
+}
+
+// householdSwaps creates a gift exchange where nobody in a household
+// of the given size can swap with each other, so they all need
+// somebody from outside it.
+func householdSwaps(household, others int) ParticipantMap {
+	pm := make(ParticipantMap)
+	for i := 0; i < household+others; i++ {
+		p := Participant{ID: Pid(i), Name: fmt.Sprintf("p%d", i)}
+		if i < household {
+			p.Group = "household"
+		}
+		pm[p.ID] = p
+	}
+
+	return pm
+}
+
+// TestNewGiftExchange_swapOdd checks an odd number of people is ruled
+// out when nobody can be left over for a loop of three. See
+// BenchmarkNewGiftExchange_swapOdd for how long it takes.
+func TestNewGiftExchange_swapOdd(t *testing.T) {
+	tests := []struct {
+		household, others int
+		want              error
+	}{
+		{household: 150, others: 151, want: nil},
+		{household: 151, others: 150, want: ErrNoSolution},
+		{household: 152, others: 149, want: ErrNoSolution},
+	}
+
+	for _, tt := range tests {
+		name := fmt.Sprintf("%d+%d", tt.household, tt.others)
+		t.Run(name, func(t *testing.T) {
+			pm := householdSwaps(tt.household, tt.others)
+			if _, err := NewGiftExchange(pm, &GiftExchangeOptions{Swap: true, Seed: 1}); !errors.Is(err, tt.want) {
+				t.Fatalf("want: %v; got: %v", tt.want, err)
+			}
+		})
+	}
+}
+
+// BenchmarkNewGiftExchange_swapOdd rules out an odd number of people
+// who can't leave anybody over for a loop of three, which used to take
+// minutes when every loop was tried.
+func BenchmarkNewGiftExchange_swapOdd(b *testing.B) {
+	pm := householdSwaps(151, 150)
+	for i := 0; i < b.N; i++ {
+		if _, err := NewGiftExchange(pm, &GiftExchangeOptions{Swap: true, Seed: 1}); !errors.Is(err, ErrNoSolution) {
+			b.Fatal(err)
+		}
+	}
+}
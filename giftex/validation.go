@@ -0,0 +1,138 @@
+package giftex
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrMissingColumn   = errors.New("Error: missing required column")
+	ErrShortRow        = errors.New("Error: row has fewer columns than the header")
+	ErrUnknownName     = errors.New("Error: nobody in the csv has this name")
+	ErrInvalidEmail    = errors.New("Error: invalid email address")
+	ErrSelfRestriction = errors.New("Error: participants are never matched with themselves anyway")
+	ErrMalformedRow    = errors.New("Error: row can't be read")
+)
+
+// requiredColumns have to be in every CSV read by ReadCSV.
+var requiredColumns = []string{"name", "email", "restrictions", "previous", "participating", "has"}
+
+// Problem is a mistake in a CSV. Row and Column count from 1 like a
+// spreadsheet, so the header is row 1. Column is 0 when the whole row
+// is at fault, and Header is the name of the column, if any. Use
+// errors.Is to check what went wrong, e.g., ErrUnknownName.
+type Problem struct {
+	Row, Column int
+	Header      string
+	Value       string
+	Err         error
+}
+
+func (p Problem) Error() string {
+	var b strings.Builder
+	b.WriteString(p.Err.Error())
+	if p.Value != "" {
+		fmt.Fprintf(&b, ": %q", p.Value)
+	}
+
+	switch {
+	case p.Column > 0:
+		fmt.Fprintf(&b, " (row %d, column %d %q)", p.Row, p.Column, p.Header)
+	case p.Row > 1:
+		fmt.Fprintf(&b, " (row %d)", p.Row)
+	}
+
+	return b.String()
+}
+
+func (p Problem) Unwrap() error {
+	return p.Err
+}
+
+// ValidationReport lists everything wrong with a CSV read by ReadCSV.
+// Errors keep the CSV from being read at all. Warnings are ignored,
+// e.g., an unknown name in somebody's restrictions, but are probably
+// mistakes worth fixing. Both are sorted by row and column.
+type ValidationReport struct {
+	Errors, Warnings []Problem
+
+	// MoreErrors and MoreWarnings count the problems left out once
+	// ImportOptions.MaxProblems were listed.
+	MoreErrors, MoreWarnings int
+
+	max int
+}
+
+func (r *ValidationReport) fail(p Problem) {
+	if r.max > 0 && len(r.Errors) >= r.max {
+		r.MoreErrors++
+		return
+	}
+
+	r.Errors = append(r.Errors, p)
+}
+
+func (r *ValidationReport) warn(p Problem) {
+	if r.max > 0 && len(r.Warnings) >= r.max {
+		r.MoreWarnings++
+		return
+	}
+
+	r.Warnings = append(r.Warnings, p)
+}
+
+func (r *ValidationReport) sort() {
+	for _, list := range [][]Problem{r.Errors, r.Warnings} {
+		sort.SliceStable(list, func(i, j int) bool {
+			a, b := list[i], list[j]
+			if a.Row != b.Row {
+				return a.Row < b.Row
+			}
+
+			return a.Column < b.Column
+		})
+	}
+}
+
+// ValidationError is returned by ReadCSV when the file has any errors.
+// It matches ErrInvalidCSV with errors.Is.
+type ValidationError struct {
+	Report ValidationReport
+}
+
+func (e *ValidationError) Error() string {
+	errs := e.Report.Errors
+	if len(errs) == 0 {
+		return ErrInvalidCSV.Error()
+	}
+
+	msg := "Error: invalid file: " + strings.TrimPrefix(errs[0].Error(), "Error: ")
+	if n := len(errs) - 1 + e.Report.MoreErrors; n > 0 {
+		msg += fmt.Sprintf(" and %d more %s", n, problemOrProblems(n))
+	}
+
+	return msg
+}
+
+// Is allows errors.Is(err, ErrInvalidCSV) to match a ValidationError.
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrInvalidCSV
+}
+
+func problemOrProblems(n int) string {
+	if n == 1 {
+		return "problem"
+	}
+
+	return "problems"
+}
+
+// validEmail reports whether addr is a bare email address, such as
+// "foo@example.com".
+func validEmail(addr string) bool {
+	a, err := mail.ParseAddress(addr)
+	return err == nil && a.Address == addr
+}
@@ -0,0 +1,116 @@
+package giftex
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestReadCSV_report(t *testing.T) {
+	const header = "name,email,restrictions,previous,participating,has\n"
+
+	tests := []struct {
+		name     string
+		csv      string
+		msg      string
+		errors   []string
+		warnings []string
+	}{
+		{
+			name: "valid",
+			csv:  header + "foo,foo@example.com,bar,,yes,\nbar,bar@example.com,,foo,yes,\n",
+		},
+		{
+			name: "missing columns",
+			csv:  "name,email,restrictions\nfoo,foo@example.com,\n",
+			msg:  `Error: invalid file: missing required column: "previous" and 2 more problems`,
+			errors: []string{
+				`Error: missing required column: "previous"`,
+				`Error: missing required column: "participating"`,
+				`Error: missing required column: "has"`,
+			},
+		},
+		{
+			name: "names",
+			csv:  header + "foo,,,,yes,\nFOO ,,,,yes,\n,,,,yes,\nbar,,,,no,\n",
+			msg:  `Error: invalid file: a participant with this name was already added: "FOO" (row 3, column 1 "name") and 1 more problem`,
+			errors: []string{
+				`Error: a participant with this name was already added: "FOO" (row 3, column 1 "name")`,
+				`Error: participants need a name (row 4, column 1 "name")`,
+			},
+		},
+		{
+			name: "warnings",
+			csv: header +
+				"foo,foo at example.com,\"foo, zed\",qux,yes,\n" +
+				"bar,bar@example.com\n" +
+				"baz,baz@example.com,,\"foo,Zed\",yes,\n" +
+				"qux,qux@example.com,,,no,\n",
+			warnings: []string{
+				`Error: invalid email address: "foo at example.com" (row 2, column 2 "email")`,
+				`Error: nobody in the csv has this name: "zed" (row 2, column 3 "restrictions")`,
+				`Error: participants are never matched with themselves anyway: "foo" (row 2, column 3 "restrictions")`,
+				`Error: row has fewer columns than the header (row 3)`,
+				`Error: nobody in the csv has this name: "Zed" (row 4, column 4 "previous")`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := ReadCSV(strings.NewReader(tt.csv))
+
+			var report ValidationReport
+			var ve *ValidationError
+			switch {
+			case errors.As(err, &ve):
+				report = ve.Report
+				if db != nil || !errors.Is(err, ErrInvalidCSV) {
+					t.Errorf("want: %v; got: %v", ErrInvalidCSV, err)
+				}
+
+				if err.Error() != tt.msg {
+					t.Errorf("want: %s; got: %v", tt.msg, err)
+				}
+			case err != nil:
+				t.Fatal(err)
+			default:
+				report = db.Report
+			}
+
+			for _, check := range []struct {
+				kind       string
+				want, list []string
+			}{
+				{"errors", tt.errors, problemStrings(report.Errors)},
+				{"warnings", tt.warnings, problemStrings(report.Warnings)},
+			} {
+				if fmt.Sprint(check.want) != fmt.Sprint(check.list) {
+					t.Errorf("wrong %s:\nwant: %q\n got: %q", check.kind, check.want, check.list)
+				}
+			}
+		})
+	}
+}
+
+func problemStrings(problems []Problem) []string {
+	var list []string
+	for _, p := range problems {
+		list = append(list, p.Error())
+	}
+
+	return list
+}
+
+func TestReadCSV_optionalColumns(t *testing.T) {
+	db, err := ReadCSV(strings.NewReader("name,email,restrictions,previous,participating,has\nAgent 007,,,,yes,\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A missing sms column used to be read from the name column
+	if p := db.Participants[0]; p.SMS != "" {
+		t.Errorf("want no SMS; got: %q", p.SMS)
+	}
+}
@@ -0,0 +1,440 @@
+package giftex
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidXLSX = errors.New("Error: invalid xlsx")
+
+// XLSXCodec reads the first sheet of an Excel workbook, and writes a
+// workbook with a single sheet where every cell is text. Formulas are
+// read as their last calculated value, and dates as the number Excel
+// stores for them.
+type XLSXCodec struct {
+	// MaxBytes stops reading with ErrTooLarge once this many bytes
+	// have been unpacked from the workbook altogether, since a small
+	// zip file can unpack to gigabytes. Empty cells in each row and
+	// shared strings count toward it every time they're used. There's
+	// no limit when it's 0.
+	MaxBytes int64
+}
+
+const (
+	xlsxMain = "http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+	xlsxRels = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+)
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		ID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+// xlsxText is a cell's text, which may be split into runs of
+// different fonts.
+type xlsxText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	s := t.T
+	for _, r := range t.Runs {
+		s += r.T
+	}
+
+	return s
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxText `xml:"si"`
+}
+
+type xlsxRow struct {
+	Cells []struct {
+		Ref    string   `xml:"r,attr"`
+		Type   string   `xml:"t,attr"`
+		Value  string   `xml:"v"`
+		Inline xlsxText `xml:"is"`
+	} `xml:"c"`
+}
+
+func (c XLSXCodec) Decode(r io.Reader) ([][]string, error) {
+	rr, err := c.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return readAll(rr)
+}
+
+// NewReader reads the first sheet one row at a time. The whole
+// workbook is read into memory first, since it's a zip file, but the
+// rows are only unpacked as they're read.
+func (c XLSXCodec) NewReader(r io.Reader) (RecordReader, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading xlsx: %w", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidXLSX, err)
+	}
+
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	unpacked := &countingReader{max: c.MaxBytes}
+	open := func(f *zip.File) (io.ReadCloser, error) {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidXLSX, err)
+		}
+
+		return &xlsxFile{ReadCloser: rc, unpacked: unpacked}, nil
+	}
+
+	readXML := func(name string, v interface{}) error {
+		f, ok := files[name]
+		if !ok {
+			return fmt.Errorf("%w: missing %s", ErrInvalidXLSX, name)
+		}
+
+		rc, err := open(f)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+
+		if err := xml.NewDecoder(rc).Decode(v); err != nil {
+			if errors.Is(err, ErrTooLarge) {
+				return err
+			}
+
+			return fmt.Errorf("%w: %s: %v", ErrInvalidXLSX, name, err)
+		}
+
+		return nil
+	}
+
+	// Shared strings are optional, and only used by cells of type "s"
+	var shared xlsxSharedStrings
+	if _, ok := files["xl/sharedStrings.xml"]; ok {
+		if err := readXML("xl/sharedStrings.xml", &shared); err != nil {
+			return nil, err
+		}
+	}
+
+	name := firstSheet(files, readXML)
+	f, ok := files[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: missing %s", ErrInvalidXLSX, name)
+	}
+
+	rc, err := open(f)
+	if err != nil {
+		return nil, err
+	}
+
+	strs := make([]string, len(shared.Items))
+	for i, item := range shared.Items {
+		strs[i] = item.String()
+	}
+
+	return &xlsxRecordReader{dec: xml.NewDecoder(rc), sheet: rc, shared: strs, unpacked: unpacked}, nil
+}
+
+// xlsxFile reads a file in a workbook, counting the bytes unpacked
+// from it together with every other file in the workbook.
+type xlsxFile struct {
+	io.ReadCloser
+	unpacked *countingReader
+}
+
+func (f *xlsxFile) Read(p []byte) (int, error) {
+	f.unpacked.r = f.ReadCloser
+	return f.unpacked.Read(p)
+}
+
+type xlsxRecordReader struct {
+	dec      *xml.Decoder
+	sheet    io.Closer
+	shared   []string
+	width    int // Width of the header row
+	unpacked *countingReader
+}
+
+// xlsxCellSize is what an empty cell takes up in memory, which is
+// counted against XLSXCodec.MaxBytes for every empty cell in a row,
+// since the sheet doesn't spell them out.
+const xlsxCellSize = 16
+
+func (x *xlsxRecordReader) Read() ([]string, error) {
+	for {
+		tok, err := x.dec.Token()
+		if errors.Is(err, io.EOF) {
+			x.sheet.Close()
+			return nil, io.EOF
+		}
+
+		if err != nil {
+			return nil, xlsxError(err)
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "row" {
+			continue
+		}
+
+		var row xlsxRow
+		if err := x.dec.DecodeElement(&row, &start); err != nil {
+			return nil, xlsxError(err)
+		}
+
+		record, err := x.record(row)
+		if err != nil {
+			return nil, err
+		}
+
+		if blank(record) {
+			continue // Skip blank rows like encoding/csv does
+		}
+
+		// Rows leave out empty cells at the end, so pad them to the
+		// width of the headers
+		if x.width == 0 {
+			x.width = len(record)
+		}
+
+		for len(record) < x.width {
+			record = append(record, "")
+		}
+
+		empty := 0
+		for _, v := range record {
+			if v == "" {
+				empty++
+			}
+		}
+
+		if err := x.unpacked.add(int64(empty) * xlsxCellSize); err != nil {
+			return nil, err
+		}
+
+		return record, nil
+	}
+}
+
+// xlsxError reports an error reading a sheet as ErrInvalidXLSX,
+// unless it unpacked to more than XLSXCodec.MaxBytes.
+func xlsxError(err error) error {
+	if errors.Is(err, ErrTooLarge) {
+		return err
+	}
+
+	return fmt.Errorf("%w: %v", ErrInvalidXLSX, err)
+}
+
+// blank reports whether every value in record is blank.
+func blank(record []string) bool {
+	for _, v := range record {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// record reads the value of every cell in row. Cells past the width
+// of the header row are dropped, and so are empty cells at the end.
+// Shared strings are counted against XLSXCodec.MaxBytes, since a short
+// cell can refer to a long one.
+func (x *xlsxRecordReader) record(row xlsxRow) ([]string, error) {
+	var record []string
+	next := 0
+	for _, c := range row.Cells {
+		// Empty cells are usually left out, so each cell says which
+		// column it's in
+		col := next
+		if c.Ref != "" {
+			var err error
+			if col, err = xlsxColumn(c.Ref); err != nil {
+				return nil, err
+			}
+		}
+		next = col + 1
+
+		var value string
+		switch c.Type {
+		case "s":
+			i, err := strconv.Atoi(c.Value)
+			if err != nil || i < 0 || i >= len(x.shared) {
+				return nil, fmt.Errorf("%w: unknown shared string %q in cell %s", ErrInvalidXLSX, c.Value, c.Ref)
+			}
+			value = x.shared[i]
+
+			if err := x.unpacked.add(int64(len(value))); err != nil {
+				return nil, err
+			}
+		case "inlineStr":
+			value = c.Inline.String()
+		case "b":
+			value = "FALSE"
+			if c.Value == "1" {
+				value = "TRUE"
+			}
+		default:
+			value = c.Value
+		}
+
+		// Nothing else can be in a column without a header, and padding
+		// out to a cell far to the right could take up a lot of memory
+		if value == "" || (x.width > 0 && col >= x.width) {
+			continue
+		}
+
+		for len(record) <= col {
+			record = append(record, "")
+		}
+		record[col] = value
+	}
+
+	return record, nil
+}
+
+// firstSheet finds the file with the first sheet of the workbook.
+func firstSheet(files map[string]*zip.File, readXML func(string, interface{}) error) string {
+	const fallback = "xl/worksheets/sheet1.xml"
+
+	var wb xlsxWorkbook
+	var rels xlsxRelationships
+	if readXML("xl/workbook.xml", &wb) != nil || len(wb.Sheets) == 0 ||
+		readXML("xl/_rels/workbook.xml.rels", &rels) != nil {
+		return fallback
+	}
+
+	for _, rel := range rels.Relationships {
+		if rel.ID != wb.Sheets[0].ID {
+			continue
+		}
+
+		// Targets are relative to xl/ unless they start with a slash
+		if strings.HasPrefix(rel.Target, "/") {
+			return strings.TrimPrefix(rel.Target, "/")
+		}
+
+		return path.Join("xl", rel.Target)
+	}
+
+	return fallback
+}
+
+// xlsxColumn reads the column of a cell reference such as "B3",
+// counting from 0.
+func xlsxColumn(ref string) (int, error) {
+	col := 0
+	i := 0
+	for ; i < len(ref) && ref[i] >= 'A' && ref[i] <= 'Z'; i++ {
+		// Stop before a long reference overflows
+		if col = col*26 + int(ref[i]-'A') + 1; col > 1<<14 {
+			return 0, fmt.Errorf("%w: cell %q", ErrInvalidXLSX, ref)
+		}
+	}
+
+	if i == 0 {
+		return 0, fmt.Errorf("%w: cell %q", ErrInvalidXLSX, ref)
+	}
+
+	return col - 1, nil
+}
+
+// xlsxRef writes the reference of a cell, e.g., "B3", counting rows
+// and columns from 0.
+func xlsxRef(row, col int) string {
+	var name []byte
+	for col++; col > 0; col = (col - 1) / 26 {
+		name = append([]byte{byte('A' + (col-1)%26)}, name...)
+	}
+
+	return string(name) + strconv.Itoa(row+1)
+}
+
+func (XLSXCodec) Encode(w io.Writer, records [][]string) error {
+	var sheet bytes.Buffer
+	sheet.WriteString(xml.Header)
+	sheet.WriteString(`<worksheet xmlns="` + xlsxMain + `"><sheetData>`)
+	for i, record := range records {
+		fmt.Fprintf(&sheet, `<row r="%d">`, i+1)
+		for j, value := range record {
+			if value == "" {
+				continue
+			}
+
+			fmt.Fprintf(&sheet, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">`, xlsxRef(i, j))
+			xml.EscapeText(&sheet, []byte(value))
+			sheet.WriteString(`</t></is></c>`)
+		}
+		sheet.WriteString(`</row>`)
+	}
+	sheet.WriteString(`</sheetData></worksheet>`)
+
+	parts := []struct {
+		name, body string
+	}{
+		{"[Content_Types].xml", xml.Header +
+			`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+			`</Types>`},
+		{"_rels/.rels", xml.Header +
+			`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="` + xlsxRels + `/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", xml.Header +
+			`<workbook xmlns="` + xlsxMain + `" xmlns:r="` + xlsxRels + `">` +
+			`<sheets><sheet name="Sheet1" sheetId="1" r:id="rId1"/></sheets>` +
+			`</workbook>`},
+		{"xl/_rels/workbook.xml.rels", xml.Header +
+			`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="` + xlsxRels + `/worksheet" Target="worksheets/sheet1.xml"/>` +
+			`</Relationships>`},
+		{"xl/worksheets/sheet1.xml", sheet.String()},
+	}
+
+	zw := zip.NewWriter(w)
+	for _, part := range parts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.WriteString(f, part.body); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
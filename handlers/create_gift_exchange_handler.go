@@ -7,7 +7,9 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/anschwa/giftopotamus/giftex"
 	"github.com/anschwa/giftopotamus/logger"
@@ -49,14 +51,127 @@ func CreateGiftExchange(sm *middleware.SessionManager) http.Handler {
 				return
 			}
 
+			opts := getOptions(sess)
+
 			var tableRows []GiftexTableRow
-			tableJSON := r.PostFormValue("participants")
-			if err := json.Unmarshal([]byte(tableJSON), &tableRows); err != nil {
-				logger.Error(reqID, err)
-				errorPage(w, http.StatusInternalServerError)
-				return
+			var committed *CommittedDraw // Set when revealing a committed draw
+			var commit bool              // Set when committing to a draw
+			var forget []giftex.Pair     // Previous matches left out of this draw only
+			if relax := r.PostFormValue("relax"); relax != "" {
+				// Apply one of the suggestions we made after the last attempt
+				choice, ok := getRelaxationChoice(sess, relax)
+				if !ok {
+					errorPage(w, http.StatusBadRequest)
+					return
+				}
+
+				tableRows = choice.TableRows
+				sess.Set(middleware.SessionTableRows, tableRows)
+
+				if choice.MaxPrevious > 0 {
+					opts.MaxPrevious = choice.MaxPrevious
+				}
+
+				opts.RepeatYears = choice.RepeatYears
+				forget = choice.Previous
+			} else if r.PostFormValue("reveal") != "" {
+				// Draw exactly what was committed to earlier, even if the
+				// table has been changed since
+				if committed = getCommittedDraw(sess); committed == nil {
+					errorPage(w, http.StatusBadRequest)
+					return
+				}
+
+				entropy := strings.TrimSpace(r.PostFormValue("entropy"))
+				if entropy == "" {
+					sess.Set(middleware.SessionErrorMsg, fmt.Sprintf("Oops! Please enter the entropy from %s before revealing the draw.", committed.Source))
+					http.Redirect(w, r, "/", http.StatusFound)
+					return
+				}
+
+				tableRows = committed.TableRows
+				*opts = committed.Options
+				opts.Reveal = &giftex.Reveal{Secret: committed.secret, Source: committed.Source, Entropy: entropy}
+			} else {
+				tableJSON := r.PostFormValue("participants")
+				if err := json.Unmarshal([]byte(tableJSON), &tableRows); err != nil {
+					logger.Error(reqID, err)
+					errorPage(w, http.StatusInternalServerError)
+					return
+				}
+
+				commit = r.PostFormValue("commit") != ""
+				if commit && strings.TrimSpace(r.PostFormValue("entropy_source")) == "" {
+					sess.Set(middleware.SessionTableRows, tableRows)
+					sess.Set(middleware.SessionErrorMsg, "Oops! Please say where the entropy for your committed draw will come from, e.g., Saturday's lottery numbers.")
+					http.Redirect(w, r, "/", http.StatusFound)
+					return
+				}
+
+				opts.SingleCycle = r.PostFormValue("single_cycle") != ""
+				opts.NoMutualPairs = r.PostFormValue("no_mutual_pairs") != ""
+				opts.SymmetricRestrictions = r.PostFormValue("symmetric_restrictions") != ""
+				opts.RepeatBothWays = r.PostFormValue("repeat_both_ways") != ""
+				opts.Swap = r.PostFormValue("swap") != ""
+
+				opts.GiftsPerPerson = 1
+				if k, err := strconv.Atoi(r.PostFormValue("gifts_per_person")); err == nil && k > 0 {
+					opts.GiftsPerPerson = k
+				}
+
+				opts.ExtraGifts = 0
+				if k, err := strconv.Atoi(r.PostFormValue("extra_gifts")); err == nil && k > 0 {
+					opts.ExtraGifts = k
+				}
+
+				// Group rules are written one per line
+				opts.GroupRules = nil
+				for _, line := range strings.Split(r.PostFormValue("group_rules"), "\n") {
+					if strings.TrimSpace(line) == "" {
+						continue
+					}
+
+					rule, err := giftex.ParseGroupRule(line)
+					if err != nil {
+						sess.Set(middleware.SessionTableRows, tableRows)
+						sess.Set(middleware.SessionErrorMsg, fmt.Sprintf(`Oops! %q isn't a group rule. Group rules look like "Team A -> Team B".`, strings.TrimSpace(line)))
+						http.Redirect(w, r, "/", http.StatusFound)
+						return
+					}
+
+					opts.GroupRules = append(opts.GroupRules, rule)
+				}
+
+				// So are rules
+				opts.Rules = nil
+				for _, line := range strings.Split(r.PostFormValue("rules"), "\n") {
+					if strings.TrimSpace(line) == "" {
+						continue
+					}
+
+					rule, err := giftex.ParseRule(line)
+					if err != nil {
+						msg := fmt.Sprintf("Oops! %q isn't a rule we know. Try one of: %s.", strings.TrimSpace(line), strings.Join(giftex.RuleNames(), ", "))
+						if errors.Is(err, giftex.ErrCostTooLarge) {
+							msg = fmt.Sprintf("Oops! %q costs too much. Rules can cost at most %d either way.", strings.TrimSpace(line), giftex.MaxCost)
+						}
+
+						sess.Set(middleware.SessionTableRows, tableRows)
+						sess.Set(middleware.SessionErrorMsg, msg)
+						http.Redirect(w, r, "/", http.StatusFound)
+						return
+					}
+
+					opts.Rules = append(opts.Rules, rule)
+				}
 			}
 
+			// The secret of a committed draw is only kept with the draw
+			saved := *opts
+			saved.Reveal = nil
+			sess.Set(middleware.SessionOptions, &saved)
+			sess.Delete(middleware.SessionRelaxations)
+
 			if len(tableRows) == 0 {
 				sess.Set(middleware.SessionErrorMsg, "Oops! Your gift exchange is empty. Please add some participants and try again.")
 				http.Redirect(w, r, "/", http.StatusFound)
@@ -64,17 +179,104 @@ func CreateGiftExchange(sm *middleware.SessionManager) http.Handler {
 			}
 
 			db, err := tableRowsToGiftExchangeDB(tableRows)
-			if err := json.Unmarshal([]byte(tableJSON), &tableRows); err != nil {
+			if err != nil {
+				// e.g., two people with the same name in different case
+				var ve *giftex.ValidationError
+				if errors.As(err, &ve) {
+					sess.Set(middleware.SessionTableRows, tableRows)
+					sess.Set(middleware.SessionImportReport, newImportReport("your gift exchange", ve.Report))
+					sess.Set(middleware.SessionErrorMsg, "Oops! Please fix the problems with your gift exchange and try again.")
+					http.Redirect(w, r, "/", http.StatusFound)
+					return
+				}
+
 				logger.Error(reqID, err)
 				errorPage(w, http.StatusInternalServerError)
 				return
 			}
 
-			ge, err := giftex.NewGiftExchange(db.Participants, &giftex.GiftExchangeOptions{MaxPrevious: 2})
+			// Draws use a cryptographically secure source of randomness,
+			// so nobody can predict or replay them, unless they reveal
+			// a committed draw
+			opts.Seed = 0
+			if len(opts.History) > 0 && committed == nil {
+				opts.Year = time.Now().Year()
+			}
+
+			// Dropped previous matches still happened, so they stay in the
+			// table and the history and are only left out of the draw
+			drawPM, drawOpts := db.Participants, opts
+			if len(forget) > 0 {
+				drawPM, drawOpts = giftex.Relaxation{Previous: forget}.Apply(db.Participants, opts)
+			}
+
+			ge, err := giftex.NewGiftExchange(drawPM, drawOpts)
+			if errors.Is(err, giftex.ErrConflictingOptions) {
+				sess.Set(middleware.SessionTableRows, tableRows)
+				sess.Set(middleware.SessionErrorMsg, "Oops! Some of your gift passing options can't be used together. "+
+					"Swapping gifts can't be combined with any other gift passing option, "+
+					"and passing gifts in a single loop or without mutual pairs doesn't work with more than one gift each "+
+					"or with people who only give or only receive.")
+				http.Redirect(w, r, "/", http.StatusFound)
+				return
+			}
+
+			if errors.Is(err, giftex.ErrGaveUp) {
+				sess.Set(middleware.SessionTableRows, tableRows)
+				sess.Set(middleware.SessionErrorMsg, "Oops! We couldn't find loops that long in time, but there may still be a way. "+
+					"Please try again, or pass gifts in shorter loops.")
+				http.Redirect(w, r, "/", http.StatusFound)
+				return
+			}
+
+			if errors.Is(err, giftex.ErrTooManyParticipants) {
+				sess.Set(middleware.SessionTableRows, tableRows)
+				sess.Set(middleware.SessionErrorMsg, fmt.Sprintf("Oops! Swapping gifts, passing gifts in a single loop or without mutual pairs, giving more than one gift each, "+
+					"people who only give or only receive, and rules work with at most %d participants. "+
+					"Please turn off those options, or split your gift exchange up, and try again.", giftex.MaxMatrixParticipants))
+				http.Redirect(w, r, "/", http.StatusFound)
+				return
+			}
+
 			if err != nil {
 				if errors.Is(err, giftex.ErrNoSolution) {
+					msg := "Oops! An assignment isn't possible with your current gift exchange. Please adjust your restrictions and try again."
+					if opts.SingleCycle || opts.NoMutualPairs || opts.MinCycleLength > 2 || opts.GiftsPerPerson > 1 || len(opts.GroupRules) > 0 || len(opts.Rules) > 0 {
+						msg = "Oops! An assignment isn't possible with your current gift exchange. Please adjust your restrictions or gift passing options and try again."
+					}
+
 					sess.Set(middleware.SessionTableRows, tableRows)
-					sess.Set(middleware.SessionErrorMsg, "Oops! An assignment isn't possible with your current gift exchange. Please adjust your restrictions and try again.")
+					sess.Set(middleware.SessionErrorMsg, msg)
+
+					var nse *giftex.NoSolutionError
+					var gce *giftex.GroupCapacityError
+					var pe *giftex.PinError
+					switch {
+					case errors.As(err, &nse):
+						details := newNoSolutionDetails(nse)
+						details.Suggestions = newRelaxationChoices(db, opts, tableRows)
+						sess.Set(middleware.SessionNoSolution, details)
+						sess.Set(middleware.SessionRelaxations, details.Suggestions)
+					case errors.As(err, &gce):
+						// Too many people in one group isn't fixed by
+						// dropping a restriction
+						sess.Set(middleware.SessionNoSolution, &NoSolutionDetails{
+							Summary: strings.TrimPrefix(gce.Error(), "No Solution: "),
+						})
+					case errors.As(err, &pe):
+						sess.Set(middleware.SessionNoSolution, newPinDetails(pe))
+					default:
+						// Loops and extra gifts are only checked while
+						// drawing, so there's nothing more to explain
+						if suggestions := newRelaxationChoices(db, opts, tableRows); len(suggestions) > 0 {
+							sess.Set(middleware.SessionNoSolution, &NoSolutionDetails{
+								Summary:     strings.TrimPrefix(err.Error(), "No Solution: "),
+								Suggestions: suggestions,
+							})
+							sess.Set(middleware.SessionRelaxations, suggestions)
+						}
+					}
+
 					http.Redirect(w, r, "/", http.StatusFound)
 					return
 				}
@@ -84,6 +286,37 @@ func CreateGiftExchange(sm *middleware.SessionManager) http.Handler {
 				return
 			}
 
+			if commit {
+				// The draw above only checked that the gift exchange is
+				// possible, and is thrown away without showing anybody
+				c := &CommittedDraw{
+					TableRows: tableRows,
+					Options:   *opts,
+					Source:    strings.TrimSpace(r.PostFormValue("entropy_source")),
+					secret:    giftex.NewSecret(),
+				}
+
+				withReveal := *opts
+				withReveal.Reveal = &giftex.Reveal{Secret: c.secret, Source: c.Source}
+				c.Commitment = giftex.Commit(db.Participants, &withReveal)
+
+				sess.Set(middleware.SessionCommittedDraw, c)
+				sess.Set(middleware.SessionTableRows, tableRows)
+				sess.Set(middleware.SessionSuccessMsg, "Draw committed! Share the commitment with everyone now, and reveal the draw once the entropy is known.")
+				http.Redirect(w, r, "/", http.StatusFound)
+				return
+			}
+
+			if committed != nil {
+				if ge.Commitment != committed.Commitment {
+					logger.Error(reqID, "revealed draw doesn't match its commitment")
+					errorPage(w, http.StatusInternalServerError)
+					return
+				}
+
+				sess.Delete(middleware.SessionCommittedDraw)
+			}
+
 			resultsTable, resultsCSV, err := giftExchangeToTableRows(db, ge)
 			if err != nil {
 				logger.Error(reqID, err)
@@ -94,14 +327,42 @@ func CreateGiftExchange(sm *middleware.SessionManager) http.Handler {
 			// Save CSV on session for download
 			sess.Set(middleware.SessionResultsCSV, resultsCSV)
 
+			var historyCSV bytes.Buffer
+			if len(opts.History) > 0 {
+				h := opts.History.Add(opts.Year, db.Participants, ge)
+				if err := h.WriteCSV(&historyCSV); err != nil {
+					logger.Error(reqID, err)
+					errorPage(w, http.StatusInternalServerError)
+					return
+				}
+
+				sess.Set(middleware.SessionHistoryCSV, historyCSV.Bytes())
+			}
+			logger.Info(reqID, "Created gift exchange")
+
 			// Display results
+			var cycles, pairs []string
+			if ge.Swap {
+				pairs = ge.PairNames()
+			} else {
+				cycles = ge.CycleNames()
+			}
+
 			pd := &PageData{
 				Title:      "Giftopotamus.com",
 				Username:   username,
 				SuccessMsg: "Gift exchange created!",
 
-				TableRows:  resultsTable,
-				ResultsCSV: resultsCSV,
+				TableRows:      resultsTable,
+				AttributeNames: attributeNames(resultsTable),
+				ResultsCSV:     resultsCSV,
+				Cycles:         cycles,
+				Pairs:          pairs,
+				Seed:           ge.Seed,
+				Commitment:     ge.Commitment,
+				Reveal:         opts.Reveal,
+				Options:        opts,
+				Formats:        giftex.Formats(),
 			}
 
 			tryRenderPage(w, r, PageResults, pd)
@@ -114,20 +375,229 @@ func CreateGiftExchange(sm *middleware.SessionManager) http.Handler {
 	})
 }
 
+// CommittedDraw is a gift exchange the organizer has committed to
+// drawing before anybody knows its seed. See giftex.Reveal.
+type CommittedDraw struct {
+	Commitment string
+	Source     string // Where the entropy will come from
+	TableRows  []GiftexTableRow
+	Options    giftex.GiftExchangeOptions
+
+	// secret is only revealed with the results
+	secret string
+}
+
+func getCommittedDraw(sess *middleware.Session) *CommittedDraw {
+	if v, err := sess.Get(middleware.SessionCommittedDraw); err == nil && v != nil {
+		if vv, ok := v.(*CommittedDraw); ok {
+			return vv
+		}
+	}
+
+	return nil
+}
+
+// NoSolutionDetails explains to the user why their gift exchange
+// isn't possible so they know which restrictions to change.
+type NoSolutionDetails struct {
+	Summary      string
+	Restrictions []string
+	Groups       []string // Groups and rules that get in the way
+	Previous     []string
+	Limits       []string // Pins and allow-lists that get in the way
+	Suggestions  []RelaxationChoice
+}
+
+func newNoSolutionDetails(err *giftex.NoSolutionError) *NoSolutionDetails {
+	describe := func(pairs []giftex.Pair, format string) []string {
+		lines := make([]string, 0, len(pairs))
+		for _, p := range pairs {
+			lines = append(lines, fmt.Sprintf(format, err.Name(p.Giver), err.Name(p.Recipient)))
+		}
+
+		return lines
+	}
+
+	var limits []string
+	for _, id := range err.Limited {
+		limits = append(limits, err.DescribeLimit(id))
+	}
+
+	// Everybody who gives to one group is blocked from many people for
+	// the same reason, so each reason is listed once
+	var groups []string
+	seen := make(map[string]bool)
+	add := func(line string) {
+		if !seen[line] {
+			seen[line] = true
+			groups = append(groups, line)
+		}
+	}
+
+	for _, p := range err.Groups {
+		add(err.DescribeGroup(p))
+	}
+
+	for _, p := range err.Rules {
+		add(err.DescribeRule(p))
+	}
+
+	return &NoSolutionDetails{
+		Summary:      strings.TrimPrefix(err.Error(), "No Solution: "),
+		Restrictions: describe(err.Restrictions, "%s can't be matched with %s"),
+		Groups:       groups,
+		Previous:     describe(err.Previous, "%s had %s recently"),
+		Limits:       limits,
+	}
+}
+
+func newPinDetails(err *giftex.PinError) *NoSolutionDetails {
+	pins := make([]string, 0, len(err.Pins))
+	for _, p := range err.Pins {
+		pins = append(pins, fmt.Sprintf("%s always gives to %s", err.Name(p.Giver), err.Name(p.Recipient)))
+	}
+
+	return &NoSolutionDetails{
+		Summary: strings.TrimPrefix(err.Error(), "No Solution: "),
+		Limits:  pins,
+	}
+}
+
+// defaultMaxPrevious is how many years participants wait before they
+// can be matched with someone they had before.
+const defaultMaxPrevious = 2
+
+// getOptions returns a copy of the options used for the last gift
+// exchange created during this session.
+func getOptions(sess *middleware.Session) *giftex.GiftExchangeOptions {
+	opts := &giftex.GiftExchangeOptions{MaxPrevious: defaultMaxPrevious}
+	if v, err := sess.Get(middleware.SessionOptions); err == nil {
+		if vv, ok := v.(*giftex.GiftExchangeOptions); ok {
+			*opts = *vv
+		}
+	}
+
+	return opts
+}
+
+// RelaxationChoice is a suggested change to the gift exchange that
+// the user can apply before trying again.
+type RelaxationChoice struct {
+	Description []string
+	TableRows   []GiftexTableRow
+	MaxPrevious int
+	RepeatYears int
+
+	// Previous matches to leave out of the retry. They aren't removed
+	// from TableRows or the history since they still happened.
+	Previous []giftex.Pair
+}
+
+func newRelaxationChoices(db *giftex.GiftExchangeDB, opts *giftex.GiftExchangeOptions, rows []GiftexTableRow) []RelaxationChoice {
+	// Participant IDs don't match table rows, so look rows up by name
+	rowIndex := make(map[string]int, len(rows))
+	for i, row := range rows {
+		rowIndex[strings.ToLower(strings.TrimSpace(row.Name))] = i
+	}
+
+	names := func(pm giftex.ParticipantMap, ids []giftex.Pid) string {
+		list := make([]string, len(ids))
+		for i, id := range ids {
+			list[i] = pm[id].Name
+		}
+
+		return strings.Join(list, ", ")
+	}
+
+	suggestions := giftex.SuggestRelaxations(db.Participants, opts)
+	choices := make([]RelaxationChoice, 0, len(suggestions))
+	for _, relax := range suggestions {
+		newPM, newOpts := relax.Apply(db.Participants, opts)
+
+		// Only rewrite rows that were changed so we don't lose any
+		// names that giftex didn't recognize
+		changed := make(map[giftex.Pid]bool)
+		for _, p := range relax.Restrictions {
+			changed[p.Giver] = true
+		}
+
+		// Symmetric restrictions are dropped from both participants
+		if opts.SymmetricRestrictions {
+			for _, p := range relax.Restrictions {
+				changed[p.Recipient] = true
+			}
+		}
+
+		newRows := append([]GiftexTableRow(nil), rows...)
+		for id := range changed {
+			p := newPM[id]
+			i, ok := rowIndex[strings.ToLower(p.Name)]
+			if !ok {
+				continue
+			}
+
+			newRows[i].Restrictions = names(newPM, p.Restrictions)
+		}
+
+		choices = append(choices, RelaxationChoice{
+			Description: relax.Describe(db.Participants),
+			TableRows:   newRows,
+			MaxPrevious: relax.MaxPrevious,
+			RepeatYears: newOpts.RepeatYears,
+			Previous:    relax.Previous,
+		})
+	}
+
+	return choices
+}
+
+func getRelaxationChoice(sess *middleware.Session, index string) (RelaxationChoice, bool) {
+	v, err := sess.Get(middleware.SessionRelaxations)
+	if err != nil {
+		return RelaxationChoice{}, false
+	}
+
+	choices, ok := v.([]RelaxationChoice)
+	if !ok {
+		return RelaxationChoice{}, false
+	}
+
+	i, err := strconv.Atoi(index)
+	if err != nil || i < 0 || i >= len(choices) {
+		return RelaxationChoice{}, false
+	}
+
+	return choices[i], true
+}
+
 func tableRowsToCSV(rows []GiftexTableRow) ([]byte, error) {
 	// Construct CSV from rows
 	var buf bytes.Buffer
 	w := csv.NewWriter(&buf)
-	w.Write([]string{"name", "email", "restrictions", "previous", "participating", "has"})
+
+	// Attributes go after the columns giftex reads itself
+	attrs := attributeNames(rows)
+	w.Write(append([]string{"name", "email", "group", "gives to", "role", "restrictions", "pinned", "allowed", "previous", "participating", "has"}, attrs...))
 	for _, p := range rows {
-		w.Write([]string{
+		record := []string{
 			strings.TrimSpace(p.Name),
 			strings.TrimSpace(p.Email),
+			strings.TrimSpace(p.Group),
+			strings.TrimSpace(p.GivesTo),
+			strings.TrimSpace(p.Role),
 			strings.TrimSpace(p.Restrictions),
+			strings.TrimSpace(p.Pinned),
+			strings.TrimSpace(p.Allowed),
 			strings.TrimSpace(p.Previous),
 			"yes", // Everyone is participating
 			"",    // The Has column is required for writing out the results later
-		})
+		}
+
+		for _, name := range attrs {
+			record = append(record, p.Attribute(name))
+		}
+
+		w.Write(record)
 	}
 
 	w.Flush()
@@ -150,33 +620,50 @@ func tableRowsToGiftExchangeDB(rows []GiftexTableRow) (*giftex.GiftExchangeDB, e
 
 func giftExchangeToTableRows(db *giftex.GiftExchangeDB, ge *giftex.GiftExchange) (resultsTable []GiftexTableRow, resultsCSV []byte, err error) {
 	var resultsBuf, tmpBuf bytes.Buffer
-	if err := db.WriteCSV(&resultsBuf, ge.Assignment); err != nil {
+	if err := db.WriteCSV(&resultsBuf, ge); err != nil {
 		return nil, nil, err
 	}
 
+	// Participants are numbered differently once the results are sorted
+	// by name, so look up everybody's recipients by name instead.
+	hasByName := make(map[string]string, len(ge.Recipients))
+	for giver, recipients := range ge.Recipients {
+		names := make([]string, 0, len(recipients))
+		for _, pid := range recipients {
+			names = append(names, db.Participants[pid].Name)
+		}
+
+		hasByName[db.Participants[giver].Name] = strings.Join(names, ", ")
+	}
+
 	tmpBuf.Write(resultsBuf.Bytes()) // Get copy of results
 	tmpDB, err := giftex.ReadCSV(&tmpBuf)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	for _, p := range tmpDB.Participants {
-		restrictions := make([]string, 0, len(p.Restrictions))
-		for _, pid := range p.Restrictions {
-			restrictions = append(restrictions, tmpDB.Participants[pid].Name)
+	names := func(ids []giftex.Pid) string {
+		list := make([]string, 0, len(ids))
+		for _, pid := range ids {
+			list = append(list, tmpDB.Participants[pid].Name)
 		}
 
-		previous := make([]string, 0, len(p.Previous))
-		for _, pid := range p.Previous {
-			previous = append(previous, tmpDB.Participants[pid].Name)
-		}
+		return strings.Join(list, ", ")
+	}
 
+	for _, p := range tmpDB.Participants {
 		resultsTable = append(resultsTable, GiftexTableRow{
 			Name:         p.Name,
 			Email:        p.Email,
-			Restrictions: strings.Join(restrictions, ", "),
-			Previous:     strings.Join(previous, ", "),
-			Has:          tmpDB.Participants[ge.Assignment[p.ID]].Name,
+			Group:        p.Group,
+			GivesTo:      p.GivesTo,
+			Role:         p.Role.String(),
+			Restrictions: names(p.Restrictions),
+			Pinned:       names(p.Pinned),
+			Allowed:      names(p.Allowed),
+			Previous:     names(p.Previous),
+			Has:          hasByName[p.Name],
+			Attributes:   p.Attributes,
 		})
 	}
 
@@ -1,8 +1,11 @@
 package handlers
 
 import (
+	"bytes"
+	"mime"
 	"net/http"
 
+	"github.com/anschwa/giftopotamus/giftex"
 	"github.com/anschwa/giftopotamus/logger"
 	"github.com/anschwa/giftopotamus/middleware"
 )
@@ -17,8 +20,28 @@ func DownloadGiftExchange(sm *middleware.SessionManager) http.Handler {
 		reqID := middleware.GetReqID(r)
 		sess := sm.Start(w, r)
 
-		// Get results from session
-		resultsCSV, err := sess.Get(middleware.SessionResultsCSV)
+		// Get results from session, or the history of past gift
+		// exchanges including the results
+		key := middleware.SessionResultsCSV
+		filename := "gift-exchange-results"
+		if r.URL.Query().Get("file") == "history" {
+			key = middleware.SessionHistoryCSV
+			filename = "gift-exchange-history"
+		}
+
+		// Files are kept as CSVs, and converted to other formats, such
+		// as XLSX, when they're downloaded
+		format := giftex.CSV
+		if name := r.URL.Query().Get("format"); name != "" {
+			f, err := giftex.FormatByName(name)
+			if err != nil {
+				errorPage(w, http.StatusBadRequest)
+				return
+			}
+			format = f
+		}
+
+		resultsCSV, err := sess.Get(key)
 		if err != nil {
 			errorPage(w, http.StatusBadRequest)
 			return
@@ -31,8 +54,28 @@ func DownloadGiftExchange(sm *middleware.SessionManager) http.Handler {
 			return
 		}
 
+		if format.Name != giftex.CSV.Name {
+			records, err := giftex.CSV.Codec.Decode(bytes.NewReader(file))
+			if err != nil {
+				logger.Error(reqID, err)
+				errorPage(w, http.StatusInternalServerError)
+				return
+			}
+
+			var b bytes.Buffer
+			if err := format.Codec.Encode(&b, records); err != nil {
+				logger.Error(reqID, err)
+				errorPage(w, http.StatusInternalServerError)
+				return
+			}
+			file = b.Bytes()
+		}
+
 		// Write file to client
-		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Type", format.ContentType)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": filename + format.Extension,
+		}))
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		w.WriteHeader(http.StatusOK)
 		w.Write(file)
@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/anschwa/giftopotamus/giftex"
 	"github.com/anschwa/giftopotamus/logger"
 	"github.com/anschwa/giftopotamus/middleware"
 )
@@ -77,10 +78,23 @@ func EditGiftExchange(sm *middleware.SessionManager) http.Handler {
 				return
 			}
 
+			attrs, err := parseAttributes(r.PostFormValue("attributes"))
+			if err != nil {
+				sess.Set(middleware.SessionErrorMsg, err.Error())
+				http.Redirect(w, r, "/", http.StatusFound)
+				return
+			}
+
 			row := GiftexTableRow{
 				Name:         participantName,
 				Email:        r.PostFormValue("email"),
+				Group:        r.PostFormValue("group"),
+				GivesTo:      r.PostFormValue("gives_to"),
+				Role:         giftex.ParseRole(r.PostFormValue("role")).String(),
 				Restrictions: r.PostFormValue("restrictions"),
+				Pinned:       r.PostFormValue("pinned"),
+				Allowed:      r.PostFormValue("allowed"),
+				Attributes:   attrs,
 			}
 
 			// Update existing participant or insert new row into table
@@ -1,23 +1,50 @@
 package handlers
 
 import (
-	"encoding/csv"
+	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/anschwa/giftopotamus/giftex"
 	"github.com/anschwa/giftopotamus/logger"
 	"github.com/anschwa/giftopotamus/middleware"
 )
 
-const maxFileSize = 1 << 20 // 1 MiB
+const (
+	maxImportSize     = 32 << 20 // 32 MiB
+	maxImportRows     = 100000
+	maxImportProblems = 50
+
+	// The token and schema sent with a file can be at most this big
+	maxFormMemory = 1 << 20 // 1 MiB
+)
+
+// ImportProgress is how far the last import has got, which the page
+// polls for while a large file is uploaded.
+type ImportProgress struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	Rows     int    `json:"rows"`
+	Bytes    int64  `json:"bytes"`
+	Done     bool   `json:"done"`
+}
 
 func ImportGiftExchange(sm *middleware.SessionManager) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		reqID := middleware.GetReqID(r)
 
+		if r.Method == "GET" {
+			importProgress(sm.Start(w, r), w)
+			return
+		}
+
 		if r.Method != "POST" {
 			errorPage(w, http.StatusMethodNotAllowed)
 			return
@@ -32,100 +59,356 @@ func ImportGiftExchange(sm *middleware.SessionManager) http.Handler {
 
 		// Remove token from session to prevent duplicate submissions
 		sess.Delete(middleware.SessionFormToken)
+		sess.Delete(middleware.SessionImportProgress)
 
-		// Ignore submissions with invalid tokens
-		if formToken := r.PostFormValue("token"); sessToken != formToken {
-			errorPage(w, http.StatusBadRequest)
+		if r.ContentLength > maxImportSize+maxFormMemory {
+			fileTooLarge(sess)
+			http.Redirect(w, r, "/", http.StatusFound)
 			return
 		}
 
-		if err := r.ParseMultipartForm(maxFileSize); err != nil {
-			logger.Error(reqID, err)
-			errorPage(w, http.StatusInternalServerError)
+		// The file is read straight from the request so the progress
+		// covers the upload too. The forms send the token and schema
+		// before the file so they're known by the time it arrives.
+		r.Body = limitBody(w, r.Body, maxImportSize+maxFormMemory)
+		mr, err := r.MultipartReader()
+		if err != nil {
+			errorPage(w, http.StatusBadRequest)
 			return
 		}
 
-		file, header, err := r.FormFile("csv")
-		if err != nil {
-			logger.Error(reqID, err)
-			errorPage(w, http.StatusInternalServerError)
-			return
+		var formToken, schemaText string
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				// There was no file
+				errorPage(w, http.StatusBadRequest)
+				return
+			}
+
+			if err == nil {
+				switch part.FormName() {
+				case "token":
+					formToken, err = readField(part)
+				case "schema":
+					schemaText, err = readField(part)
+				case "csv", "history":
+					// Ignore submissions with invalid tokens
+					if sessToken != formToken {
+						errorPage(w, http.StatusBadRequest)
+						return
+					}
+
+					sess.Set(middleware.SessionFormToken, csrfToken())
+
+					// Past gift exchanges are imported separately from the table
+					if part.FormName() == "history" {
+						importHistory(sess, part, part.FileName())
+					} else {
+						importTable(reqID, sess, part, part.FileName(), schemaText, r.ContentLength)
+					}
+
+					http.Redirect(w, r, "/", http.StatusFound)
+					return
+				}
+			}
+
+			if tooLarge(err) {
+				fileTooLarge(sess)
+				http.Redirect(w, r, "/", http.StatusFound)
+				return
+			}
+
+			if err != nil {
+				logger.Error(reqID, err)
+				errorPage(w, http.StatusInternalServerError)
+				return
+			}
 		}
-		defer file.Close()
+	})
+}
 
-		tableRows, err := csvToRows(file)
-		if err != nil {
-			logger.Error(reqID, err)
-			errorPage(w, http.StatusInternalServerError)
+// importTable reads a file of participants and fills in the table with
+// them. size is roughly how big the file is, for the progress.
+func importTable(reqID string, sess *middleware.Session, r io.Reader, filename, schemaText string, size int64) {
+	// The schema describes CSVs with other headers or delimiters,
+	// and is remembered for the next import
+	schema, err := giftex.ParseSchema(schemaText)
+	if err != nil {
+		sess.Set(middleware.SessionErrorMsg, fmt.Sprintf("Oops! Unable to read the layout of %s: %v", filename, err))
+		return
+	}
+	sess.Set(middleware.SessionSchema, schemaText)
+
+	// Files may be CSV, TSV, JSON, or XLSX, which is guessed from
+	// the name of the file or else its contents
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(512)
+
+	// giftex checks the file as it's read so mistakes can be fixed
+	// before the table is filled in
+	db, err := giftex.Import(br, &giftex.ImportOptions{
+		Schema:      schema,
+		Codec:       giftex.DetectFormat(filename, head).Codec,
+		MaxBytes:    maxImportSize,
+		MaxRows:     maxImportRows,
+		MaxProblems: maxImportProblems,
+		Progress: func(p giftex.Progress) {
+			sess.Set(middleware.SessionImportProgress, ImportProgress{
+				Filename: filename,
+				Size:     size,
+				Rows:     p.Rows,
+				Bytes:    p.Bytes,
+				Done:     p.Done,
+			})
+		},
+	})
+	if err != nil {
+		if tooLarge(err) {
+			fileTooLarge(sess)
 			return
 		}
 
-		// Update session data
-		sess.Set(middleware.SessionFormToken, csrfToken())
-		sess.Set(middleware.SessionSuccessMsg, fmt.Sprintf("Imported %s", header.Filename))
-		sess.Set(middleware.SessionTableRows, tableRows)
+		var ve *giftex.ValidationError
+		if errors.As(err, &ve) {
+			sess.Set(middleware.SessionImportReport, newImportReport(filename, ve.Report))
+		}
 
-		http.Redirect(w, r, "/", http.StatusFound)
-	})
+		sess.Set(middleware.SessionErrorMsg, fmt.Sprintf("Unable to import %s: %v", filename, err))
+		return
+	}
+
+	tableRows := dbToRows(db)
+	logger.Info(reqID, "Imported", len(tableRows), "participants from", filename)
+
+	// Update session data
+	if len(db.Report.Warnings) > 0 {
+		sess.Set(middleware.SessionImportReport, newImportReport(filename, db.Report))
+	}
+
+	sess.Set(middleware.SessionSuccessMsg, fmt.Sprintf("Imported %s", filename))
+	sess.Set(middleware.SessionTableRows, tableRows)
+}
+
+// readField reads a form value that isn't a file.
+func readField(part *multipart.Part) (string, error) {
+	b, err := io.ReadAll(io.LimitReader(part, maxFormMemory))
+	return string(b), err
+}
+
+// errBodyTooLarge is returned by reading more of the request than
+// limitBody allows.
+var errBodyTooLarge = errors.New("http: request body too large")
+
+// bodyLimit is a request body from http.MaxBytesReader that tells
+// running out of room apart from other errors.
+type bodyLimit struct {
+	io.ReadCloser
+	left int64
+}
+
+// limitBody works like http.MaxBytesReader, but reading past n fails
+// with errBodyTooLarge.
+func limitBody(w http.ResponseWriter, body io.ReadCloser, n int64) io.ReadCloser {
+	return &bodyLimit{ReadCloser: http.MaxBytesReader(w, body, n), left: n}
+}
+
+func (b *bodyLimit) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.left -= int64(n)
+
+	// http.MaxBytesReader only fails once every byte it allows is read
+	if err != nil && err != io.EOF && b.left <= 0 {
+		err = errBodyTooLarge
+	}
+
+	return n, err
+}
+
+// tooLarge reports whether err is from reading more of the request
+// than limitBody allows.
+func tooLarge(err error) bool {
+	return errors.Is(err, errBodyTooLarge)
+}
+
+// fileTooLarge tells the user how big a file can be. Every request
+// needs a new token since the last one was used up.
+func fileTooLarge(sess *middleware.Session) {
+	sess.Set(middleware.SessionFormToken, csrfToken())
+	sess.Set(middleware.SessionErrorMsg, fmt.Sprintf("Unable to import: files can be at most %d MiB", maxImportSize>>20))
+}
+
+// importProgress writes how far the last import has got as JSON.
+func importProgress(sess *middleware.Session, w http.ResponseWriter) {
+	var progress ImportProgress
+	if v, err := sess.Get(middleware.SessionImportProgress); err == nil {
+		progress, _ = v.(ImportProgress)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(progress)
+}
+
+// importHistory reads a CSV of who gave to who in past years and
+// saves it with the gift exchange options for the next draw.
+func importHistory(sess *middleware.Session, r io.Reader, filename string) {
+	h, err := giftex.ImportHistory(r, &giftex.ImportOptions{MaxBytes: maxImportSize, MaxRows: maxImportRows})
+	if tooLarge(err) {
+		fileTooLarge(sess)
+		return
+	}
+
+	if err != nil {
+		sess.Set(middleware.SessionErrorMsg, fmt.Sprintf("Unable to import %s: %v", filename, err))
+		return
+	}
+
+	opts := getOptions(sess)
+	opts.History = h
+	opts.Year = time.Now().Year()
+	if opts.RepeatYears == 0 {
+		opts.RepeatYears = defaultMaxPrevious
+	}
+
+	sess.Set(middleware.SessionOptions, opts)
+	sess.Set(middleware.SessionSuccessMsg, fmt.Sprintf("Imported %d past matches from %s", len(h), filename))
+}
+
+// ImportReport lists the mistakes giftex found in an imported CSV,
+// see giftex.ValidationReport.
+type ImportReport struct {
+	Filename         string
+	Errors, Warnings []string
+}
+
+func newImportReport(filename string, report giftex.ValidationReport) *ImportReport {
+	describe := func(problems []giftex.Problem) []string {
+		lines := make([]string, 0, len(problems))
+		for _, p := range problems {
+			msg := strings.TrimPrefix(p.Err.Error(), "Error: ")
+			if p.Value != "" {
+				msg += fmt.Sprintf(": %q", p.Value)
+			}
+
+			switch {
+			case p.Column > 0:
+				msg = fmt.Sprintf("Row %d, %s: %s", p.Row, p.Header, msg)
+			case p.Row > 1:
+				msg = fmt.Sprintf("Row %d: %s", p.Row, msg)
+			}
+
+			lines = append(lines, msg)
+		}
+
+		return lines
+	}
+
+	more := func(lines []string, n int) []string {
+		if n > 0 {
+			lines = append(lines, fmt.Sprintf("…and %d more", n))
+		}
+
+		return lines
+	}
+
+	return &ImportReport{
+		Filename: filename,
+		Errors:   more(describe(report.Errors), report.MoreErrors),
+		Warnings: more(describe(report.Warnings), report.MoreWarnings),
+	}
 }
 
 type GiftexTableRow struct {
 	Name         string
 	Email        string
+	Group        string
+	GivesTo      string
+	Role         string
 	Restrictions string
+	Pinned       string
+	Allowed      string
 	Previous     string
 	Has          string
+
+	// Attributes are the other columns of the CSV, such as shirt
+	// sizes, see giftex.Participant.Attributes
+	Attributes map[string]string
 }
 
-// csvToRows reads all rows in a given CSV for displaying as a table.
-// We want to preserve all the data given by the user without making
-// assumptions about the validity. For example, giftex.ReadCSV
-// incorrectly ignore column data that include participants who have
-// not been entered into the table yet.
-func csvToRows(r io.Reader) ([]GiftexTableRow, error) {
-	csvReader := csv.NewReader(r)
-	csvReader.FieldsPerRecord = -1 // Allow empty columns
-	csvReader.Comma = ','
+// Attribute returns the value of the attribute called name, ignoring
+// case.
+func (row GiftexTableRow) Attribute(name string) string {
+	return giftex.Participant{Attributes: row.Attributes}.Attribute(name)
+}
 
-	records, err := csvReader.ReadAll()
-	if err != nil {
-		return nil, fmt.Errorf("Error reading csv: %w", err)
+// attributeNames lists every attribute used in rows for the table
+// columns, sorted and ignoring case.
+func attributeNames(rows []GiftexTableRow) []string {
+	pm := make(giftex.ParticipantMap, len(rows))
+	for i, row := range rows {
+		pm[giftex.Pid(i)] = giftex.Participant{ID: giftex.Pid(i), Name: row.Name, Attributes: row.Attributes}
 	}
 
-	numRecords := len(records)
-	if numRecords < 2 {
-		return nil, fmt.Errorf("csv must include headers and at least one entry")
-	}
+	return giftex.AttributeNames(pm)
+}
 
-	trimLower := func(s string) string { return strings.TrimSpace(strings.ToLower(s)) }
+// parseAttributes reads attributes written one per line as
+// "name: value". Blank values are dropped.
+func parseAttributes(s string) (map[string]string, error) {
+	attrs := make(map[string]string)
+	for _, line := range strings.Split(s, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
 
-	// The first record contains the column headers
-	cols := make(map[string]int, len(records[0]))
-	for i, v := range records[0] {
-		cols[trimLower(v)] = i
-	}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("Oops! %q isn't an attribute. Try something like \"Shirt Size: M\"", strings.TrimSpace(line))
+		}
 
-	tableRows := make([]GiftexTableRow, 0, numRecords)
-	for i := 0; i < numRecords; i++ {
-		row := records[i]
+		name, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if !giftex.IsAttributeColumn(name) {
+			return nil, fmt.Errorf("Oops! %q can't be used as an attribute name", name)
+		}
 
-		// Skip non-participants
-		if participating := trimLower(row[cols["participating"]]) == "yes"; !participating {
-			continue
+		if value != "" {
+			attrs[name] = value
 		}
+	}
+
+	if len(attrs) == 0 {
+		return nil, nil
+	}
 
+	return attrs, nil
+}
+
+// dbToRows lists everybody in db for displaying as a table. We want
+// to preserve all the data given by the user without making
+// assumptions about the validity. For example, giftex.ReadCSV
+// incorrectly ignore column data that include participants who have
+// not been entered into the table yet, so those columns are copied
+// as they were written.
+func dbToRows(db *giftex.GiftExchangeDB) []GiftexTableRow {
+	tableRows := make([]GiftexTableRow, 0, len(db.Participants))
+	for id, p := range db.Participants {
 		getCol := func(key string) string {
-			return strings.TrimSpace(row[cols[key]])
+			return strings.TrimSpace(db.Cell(id, key))
 		}
 
-		tr := GiftexTableRow{
-			Name:         getCol("name"),
+		tableRows = append(tableRows, GiftexTableRow{
+			Name:         p.Name,
 			Email:        getCol("email"),
+			Group:        p.Group,
+			GivesTo:      p.GivesTo,
+			Role:         p.Role.String(),
 			Restrictions: getCol("restrictions"),
+			Pinned:       getCol("pinned"),
+			Allowed:      getCol("allowed"),
 			Previous:     getCol("previous"),
-		}
-
-		tableRows = append(tableRows, tr)
+			Attributes:   p.Attributes,
+		})
 	}
 
 	// Sort rows by name
@@ -133,5 +416,5 @@ func csvToRows(r io.Reader) ([]GiftexTableRow, error) {
 		return tableRows[i].Name < tableRows[j].Name
 	})
 
-	return tableRows, nil
+	return tableRows
 }
@@ -1,8 +1,12 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
+	"sync"
+	"time"
 
+	"github.com/anschwa/giftopotamus/giftex"
 	"github.com/anschwa/giftopotamus/middleware"
 )
 
@@ -30,17 +34,50 @@ func Index(sm *middleware.SessionManager) http.Handler {
 			}
 		}
 
+		var noSolution *NoSolutionDetails
+		if v, err := sess.Get(middleware.SessionNoSolution); err == nil && v != nil {
+			if vv, ok := v.(*NoSolutionDetails); ok {
+				noSolution = vv
+			}
+		}
+
+		var importReport *ImportReport
+		if v, err := sess.Get(middleware.SessionImportReport); err == nil && v != nil {
+			if vv, ok := v.(*ImportReport); ok {
+				importReport = vv
+			}
+		}
+
+		opts := getOptions(sess)
+
+		// Warn about predictable matches before anything is drawn
+		var predictable []string
+		var checking bool
+		if noSolution == nil {
+			predictable, checking = predictablePairs(sess, rows, opts)
+		}
+
 		// Set csrf token
 		token := csrfToken()
 		sess.Set(middleware.SessionFormToken, token)
 
 		pd := &PageData{
-			Title:      "Giftopotamus.com",
-			Username:   username,
-			Token:      token,
-			SuccessMsg: sucMsg,
-			ErrorMsg:   errMsg,
-			TableRows:  rows,
+			Title:          "Giftopotamus.com",
+			Username:       username,
+			Token:          token,
+			SuccessMsg:     sucMsg,
+			ErrorMsg:       errMsg,
+			TableRows:      rows,
+			NoSolution:     noSolution,
+			ImportReport:   importReport,
+			Schema:         sess.GetString(middleware.SessionSchema),
+			Options:        opts,
+			Predictable:    predictable,
+			Checking:       checking,
+			RuleNames:      giftex.RuleNames(),
+			AttributeNames: attributeNames(rows),
+			Formats:        giftex.Formats(),
+			Committed:      getCommittedDraw(sess),
 		}
 
 		tryRenderPage(w, r, PageGiftex, pd)
@@ -48,5 +85,205 @@ func Index(sm *middleware.SessionManager) http.Handler {
 		// Clear status after showing once
 		sess.Delete(middleware.SessionSuccessMsg)
 		sess.Delete(middleware.SessionErrorMsg)
+		sess.Delete(middleware.SessionNoSolution)
+		sess.Delete(middleware.SessionImportReport)
 	})
 }
+
+// maxPredictableRows is the largest table predictablePairs checks.
+const maxPredictableRows = 500
+
+// predictableWorkers limits how many gift exchanges are checked for
+// predictable pairs at once, across every session.
+var predictableWorkers = make(chan struct{}, 2)
+
+// predictableQueueTimeout is how long a check that hasn't started yet
+// is shared. Sessions that expire never stop waiting on their checks,
+// so checks nobody has asked about in that time are forgotten.
+const predictableQueueTimeout = 10 * time.Minute
+
+// predictableJobs holds the check for each commitment that a session
+// is still waiting on, so sessions with the same table share one.
+var predictableJobs = struct {
+	sync.Mutex
+	m map[string]*predictableCache
+}{m: make(map[string]*predictableCache)}
+
+// predictableCache holds the lines predictablePairs found for the
+// gift exchange with commitment Key, once they've been worked out.
+type predictableCache struct {
+	Key string
+
+	mu       sync.Mutex
+	watchers int
+	asked    time.Time // When a session last tried to start the check
+	running  bool
+	cancel   context.CancelFunc
+	done     bool
+	lines    []string
+}
+
+// watchPredictable returns the check for key, sharing it with any
+// other session that's still waiting on it.
+func watchPredictable(key string) *predictableCache {
+	predictableJobs.Lock()
+	defer predictableJobs.Unlock()
+
+	for k, c := range predictableJobs.m {
+		c.mu.Lock()
+		if !c.running && time.Since(c.asked) > predictableQueueTimeout {
+			delete(predictableJobs.m, k)
+		}
+		c.mu.Unlock()
+	}
+
+	c, ok := predictableJobs.m[key]
+	if !ok {
+		c = &predictableCache{Key: key, asked: time.Now()}
+		predictableJobs.m[key] = c
+	}
+
+	c.mu.Lock()
+	c.watchers++
+	c.mu.Unlock()
+
+	return c
+}
+
+// unwatch stops waiting on the check, and cancels it once nobody is
+// waiting any more.
+func (c *predictableCache) unwatch() {
+	predictableJobs.Lock()
+	defer predictableJobs.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.watchers--; c.watchers > 0 {
+		return
+	}
+
+	if c.cancel != nil {
+		c.cancel()
+	}
+
+	if predictableJobs.m[c.Key] == c {
+		delete(predictableJobs.m, c.Key)
+	}
+}
+
+func (c *predictableCache) result() ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lines, c.done
+}
+
+// start works out the predictable pairs in the background, unless
+// that's already happening. When every worker is busy the check is
+// dropped, and the next page load tries again.
+func (c *predictableCache) start(pm giftex.ParticipantMap, opts giftex.GiftExchangeOptions) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.running || c.done || c.watchers == 0 {
+		return
+	}
+
+	c.asked = time.Now()
+	select {
+	case predictableWorkers <- struct{}{}:
+	default:
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.running, c.cancel = true, cancel
+	go c.check(ctx, pm, opts)
+}
+
+// check works out the predictable pairs, unless ctx is cancelled
+// because the table or options changed first.
+func (c *predictableCache) check(ctx context.Context, pm giftex.ParticipantMap, opts giftex.GiftExchangeOptions) {
+	var lines []string
+	odds, err := giftex.PairProbabilitiesContext(ctx, pm, &opts)
+	if err == nil {
+		for _, p := range odds.Predictable(giftex.LikelyThreshold) {
+			lines = append(lines, odds.Describe(p))
+		}
+	}
+
+	<-predictableWorkers
+
+	predictableJobs.Lock()
+	defer predictableJobs.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.running = false
+	c.cancel()
+	if ctx.Err() != nil {
+		return
+	}
+
+	c.lines, c.done = lines, true
+	if predictableJobs.m[c.Key] == c {
+		delete(predictableJobs.m, c.Key)
+	}
+}
+
+// predictablePairs describes every match in the table that is forced
+// or almost certain, since anybody who knows the restrictions could
+// guess it. Gift exchanges that can't be drawn are explained when the
+// organizer tries to create them instead.
+//
+// Working out the odds takes thousands of draws, so it's done in the
+// background, and checking is true until the answer is ready. The
+// answer is kept in the session until the table or options change.
+func predictablePairs(sess *middleware.Session, rows []GiftexTableRow, opts *giftex.GiftExchangeOptions) (lines []string, checking bool) {
+	var cache *predictableCache
+	if v, err := sess.Get(middleware.SessionPredictable); err == nil && v != nil {
+		cache, _ = v.(*predictableCache)
+	}
+
+	// Stop waiting on the last check if the table no longer needs it
+	forget := func() {
+		if cache != nil {
+			cache.unwatch()
+			sess.Delete(middleware.SessionPredictable)
+		}
+	}
+
+	// Working out the odds takes time and memory for every pair of
+	// participants, and nobody can guess their match in a big
+	// exchange anyway. Loops and swaps are far slower to sample.
+	if len(rows) == 0 || len(rows) > maxPredictableRows {
+		forget()
+		return nil, false
+	}
+
+	if opts.SingleCycle || opts.NoMutualPairs || opts.MinCycleLength > 2 || opts.Swap {
+		forget()
+		return nil, false
+	}
+
+	db, err := tableRowsToGiftExchangeDB(rows)
+	if err != nil {
+		forget()
+		return nil, false
+	}
+
+	// The commitment changes whenever anything that changes the draw
+	// does
+	key := giftex.Commit(db.Participants, opts)
+	if cache == nil || cache.Key != key {
+		forget()
+		cache = watchPredictable(key)
+		sess.Set(middleware.SessionPredictable, cache)
+	}
+
+	cache.start(db.Participants, *opts)
+	lines, done := cache.result()
+	return lines, !done
+}
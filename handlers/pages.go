@@ -3,12 +3,13 @@ package handlers
 import (
 	"crypto/md5"
 	"fmt"
+	"html/template"
 	"net/http"
 	"os"
 	"strconv"
-	"text/template"
 	"time"
 
+	"github.com/anschwa/giftopotamus/giftex"
 	"github.com/anschwa/giftopotamus/logger"
 	"github.com/anschwa/giftopotamus/middleware"
 )
@@ -41,6 +42,39 @@ type PageData struct {
 
 	TableRows  []GiftexTableRow
 	ResultsCSV []byte
+	NoSolution *NoSolutionDetails
+	Options    *giftex.GiftExchangeOptions
+	Cycles     []string
+	Pairs      []string
+	Seed       int64
+	Commitment string
+
+	// Reveal is how a committed draw was revealed, and Committed is
+	// a draw that's waiting to be revealed
+	Reveal    *giftex.Reveal
+	Committed *CommittedDraw
+
+	// Predictable describes matches that are forced or almost certain,
+	// and Checking is true while they're still being worked out
+	Predictable []string
+	Checking    bool
+
+	// ImportReport lists mistakes in the last CSV that was imported
+	ImportReport *ImportReport
+
+	// Schema describes the layout of imported CSVs, see
+	// giftex.ParseSchema
+	Schema string
+
+	// AttributeNames are the extra columns of the table, see
+	// giftex.Participant.Attributes
+	AttributeNames []string
+
+	// RuleNames lists the rules that can be used, see giftex.ParseRule
+	RuleNames []string
+
+	// Formats are the file formats results can be downloaded in
+	Formats []giftex.Format
 }
 
 func parseTemplates(pages ...string) *template.Template {
@@ -11,12 +11,22 @@ import (
 )
 
 const (
-	SessionUsername   = "username"
-	SessionFormToken  = "form_token"
-	SessionSuccessMsg = "success_msg"
-	SessionErrorMsg   = "error_msg"
-	SessionTableRows  = "table_rows"
-	SessionResultsCSV = "results_csv"
+	SessionUsername       = "username"
+	SessionFormToken      = "form_token"
+	SessionSuccessMsg     = "success_msg"
+	SessionErrorMsg       = "error_msg"
+	SessionTableRows      = "table_rows"
+	SessionResultsCSV     = "results_csv"
+	SessionHistoryCSV     = "history_csv"
+	SessionNoSolution     = "no_solution"
+	SessionImportReport   = "import_report"
+	SessionImportProgress = "import_progress"
+	SessionSchema         = "schema"
+
+	SessionOptions       = "giftex_options"
+	SessionRelaxations   = "relaxations"
+	SessionCommittedDraw = "committed_draw"
+	SessionPredictable   = "predictable"
 )
 
 // SessionManager manages all active sessions on the web server.